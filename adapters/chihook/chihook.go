@@ -0,0 +1,30 @@
+// Package chihook mounts a TwitchWebhookHandler's callbacks on a chi router.
+package chihook
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/go-chi/chi/v5"
+)
+
+// DefaultParam is the url parameter carrying the subscription id
+const DefaultParam = "subscriptionID"
+
+// Mount serves confirmations and notifications for callback urls under
+// prefix, prefix should be the path of the handler's CallbackBaseURL
+func Mount(r chi.Router, prefix string, h *twitchhook.TwitchWebhookHandler) {
+	pattern := strings.TrimSuffix(prefix, "/") + "/{" + DefaultParam + "}"
+	handler := Handler(h, DefaultParam)
+	r.Get(pattern, handler)
+	r.Post(pattern, handler)
+}
+
+// Handler serves callbacks on routes whose param url parameter is the
+// subscription id
+func Handler(h *twitchhook.TwitchWebhookHandler, param string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ServeCallback(w, r, twitchhook.SubscriptionID(chi.URLParam(r, param)))
+	}
+}
@@ -0,0 +1,111 @@
+// Package cloudrun runs a TwitchWebhookHandler on Cloud Run or Cloud
+// Functions, detecting the callback base url from the platform.
+package cloudrun
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/bsdlp/twitchhook"
+)
+
+// DefaultPort is listened on when PORT isn't set
+const DefaultPort = "8080"
+
+// ShutdownTimeout bounds draining requests after ctx is done, Cloud Run
+// kills instances ten seconds after SIGTERM
+const ShutdownTimeout = 8 * time.Second
+
+// ErrNotCloudRun is returned by ServiceURL outside of Cloud Run, where
+// K_SERVICE isn't set
+var ErrNotCloudRun = errors.New("cloudrun: K_SERVICE is not set")
+
+// ServiceURL returns the deterministic https url of the running service,
+// built from K_SERVICE and the project number and region reported by the
+// metadata server
+func ServiceURL(ctx context.Context) (string, error) {
+	service := os.Getenv("K_SERVICE")
+	if service == "" {
+		return "", ErrNotCloudRun
+	}
+
+	project, err := metadata.NumericProjectIDWithContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	// the region is reported as projects/<number>/regions/<region>
+	region, err := metadata.GetWithContext(ctx, "instance/region")
+	if err != nil {
+		return "", err
+	}
+	region = region[strings.LastIndexByte(region, '/')+1:]
+
+	return "https://" + service + "-" + project + "." + region + ".run.app", nil
+}
+
+// CallbackBaseURL returns TWITCHHOOK_CALLBACK_BASE_URL when it's set, for
+// services behind a custom domain, otherwise the ServiceURL joined with path
+func CallbackBaseURL(ctx context.Context, path string) (string, error) {
+	if base := os.Getenv("TWITCHHOOK_CALLBACK_BASE_URL"); base != "" {
+		return base, nil
+	}
+	base, err := ServiceURL(ctx)
+	if err != nil {
+		return "", err
+	}
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return base + path, nil
+}
+
+// HTTPFunction adapts h for the Cloud Functions framework:
+//
+//	functions.HTTP("twitchhook", cloudrun.HTTPFunction(h))
+func HTTPFunction(h *twitchhook.TwitchWebhookHandler) func(http.ResponseWriter, *http.Request) {
+	return h.SubscriptionCallbackHandler()
+}
+
+// Listen listens on PORT
+func Listen() (net.Listener, error) {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = DefaultPort
+	}
+	return net.Listen("tcp", ":"+port)
+}
+
+// ListenAndServe serves handler on PORT until ctx is done
+func ListenAndServe(ctx context.Context, handler http.Handler) error {
+	ln, err := Listen()
+	if err != nil {
+		return err
+	}
+	return Serve(ctx, ln, handler)
+}
+
+// Serve serves handler on ln until ctx is done, then drains requests for up
+// to ShutdownTimeout
+func Serve(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	srv := &http.Server{Handler: handler}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdown)
+}
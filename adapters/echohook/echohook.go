@@ -0,0 +1,37 @@
+// Package echohook mounts a TwitchWebhookHandler's callbacks on an echo
+// router.
+package echohook
+
+import (
+	"strings"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultParam is the url parameter carrying the subscription id
+const DefaultParam = "subscriptionID"
+
+// Router is the part of *echo.Echo and *echo.Group used by Mount
+type Router interface {
+	GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+}
+
+// Mount serves confirmations and notifications for callback urls under
+// prefix, prefix should be the path of the handler's CallbackBaseURL
+func Mount(r Router, prefix string, h *twitchhook.TwitchWebhookHandler) {
+	pattern := strings.TrimSuffix(prefix, "/") + "/:" + DefaultParam
+	handler := Handler(h, DefaultParam)
+	r.GET(pattern, handler)
+	r.POST(pattern, handler)
+}
+
+// Handler serves callbacks on routes whose param url parameter is the
+// subscription id
+func Handler(h *twitchhook.TwitchWebhookHandler, param string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		h.ServeCallback(c.Response(), c.Request(), twitchhook.SubscriptionID(c.Param(param)))
+		return nil
+	}
+}
@@ -0,0 +1,29 @@
+// Package ginhook mounts a TwitchWebhookHandler's callbacks on a gin router.
+package ginhook
+
+import (
+	"strings"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultParam is the url parameter carrying the subscription id
+const DefaultParam = "subscriptionID"
+
+// Mount serves confirmations and notifications for callback urls under
+// prefix, prefix should be the path of the handler's CallbackBaseURL
+func Mount(r gin.IRoutes, prefix string, h *twitchhook.TwitchWebhookHandler) {
+	pattern := strings.TrimSuffix(prefix, "/") + "/:" + DefaultParam
+	handler := Handler(h, DefaultParam)
+	r.GET(pattern, handler)
+	r.POST(pattern, handler)
+}
+
+// Handler serves callbacks on routes whose param url parameter is the
+// subscription id
+func Handler(h *twitchhook.TwitchWebhookHandler, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h.ServeCallback(c.Writer, c.Request, twitchhook.SubscriptionID(c.Param(param)))
+	}
+}
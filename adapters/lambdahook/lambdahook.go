@@ -0,0 +1,304 @@
+// Package lambdahook serves a TwitchWebhookHandler's callbacks from AWS
+// Lambda behind an API Gateway REST (payload v1) or HTTP (payload v2) api.
+package lambdahook
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/bsdlp/twitchhook"
+)
+
+// DefaultRenewWindow is how close to expiry a scheduled invocation renews
+// subscriptions when RenewWindow isn't set
+const DefaultRenewWindow = time.Hour
+
+// Adapter translates API Gateway events into callback requests.
+//
+// Lambda freezes instances between invocations, so the lease timers of an
+// in-memory Manager can't be relied on. Use a Manager backed by a shared
+// store and invoke the function on a schedule, scheduled events renew every
+// subscription expiring within RenewWindow.
+type Adapter struct {
+	// Handler serves the callbacks, when nil it's built by New
+	Handler *twitchhook.TwitchWebhookHandler
+
+	// New builds the handler on the first invocation instead of at init, so
+	// a cold start that can't reach the Manager's store fails that
+	// invocation and is tried again by the next
+	New func(ctx context.Context) (*twitchhook.TwitchWebhookHandler, error)
+
+	// Param is the path parameter carrying the subscription id, when empty
+	// the handler's CallbackURLBuilder extracts it from the path
+	Param string
+
+	// RenewWindow defaults to DefaultRenewWindow
+	RenewWindow time.Duration
+
+	m sync.Mutex
+}
+
+// ErrNoHandler is returned when neither Handler nor New is set
+var ErrNoHandler = errors.New("lambdahook: Handler or New is required")
+
+// Start runs the adapter as the lambda function
+func (a *Adapter) Start() {
+	lambda.Start(a)
+}
+
+func (a *Adapter) handler(ctx context.Context) (*twitchhook.TwitchWebhookHandler, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if a.Handler != nil {
+		return a.Handler, nil
+	}
+	if a.New == nil {
+		return nil, ErrNoHandler
+	}
+	h, err := a.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a.Handler = h
+	return h, nil
+}
+
+// Invoke implements lambda.Handler, picking the payload format from the
+// event. Scheduled events renew expiring subscriptions.
+func (a *Adapter) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	var probe struct {
+		Version    string `json:"version"`
+		Source     string `json:"source"`
+		HTTPMethod string `json:"httpMethod"`
+	}
+	err := json.Unmarshal(payload, &probe)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case probe.Source == "aws.events" || probe.Source == "aws.scheduler":
+		return nil, a.RenewExpiring(ctx)
+	case probe.Version == "2.0":
+		var req events.APIGatewayV2HTTPRequest
+		err = json.Unmarshal(payload, &req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := a.HandleV2(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(resp)
+	default:
+		var req events.APIGatewayProxyRequest
+		err = json.Unmarshal(payload, &req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := a.HandleV1(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(resp)
+	}
+}
+
+// HandleV1 serves an API Gateway REST api event
+func (a *Adapter) HandleV1(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	header := http.Header{}
+	for k, v := range event.Headers {
+		header.Set(k, v)
+	}
+	for k, vs := range event.MultiValueHeaders {
+		header.Del(k)
+		for _, v := range vs {
+			header.Add(k, v)
+		}
+	}
+
+	query := url.Values{}
+	for k, v := range event.QueryStringParameters {
+		query.Set(k, v)
+	}
+	for k, vs := range event.MultiValueQueryStringParameters {
+		query[k] = vs
+	}
+
+	w, err := a.serve(ctx, request{
+		method:     event.HTTPMethod,
+		path:       event.Path,
+		rawQuery:   query.Encode(),
+		header:     header,
+		body:       event.Body,
+		base64:     event.IsBase64Encoded,
+		sourceIP:   event.RequestContext.Identity.SourceIP,
+		pathParams: event.PathParameters,
+	})
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	body, isBase64 := w.encodedBody()
+	return events.APIGatewayProxyResponse{
+		StatusCode:        w.status,
+		MultiValueHeaders: w.header,
+		Body:              body,
+		IsBase64Encoded:   isBase64,
+	}, nil
+}
+
+// HandleV2 serves an API Gateway HTTP api event
+func (a *Adapter) HandleV2(ctx context.Context, event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	header := http.Header{}
+	for k, v := range event.Headers {
+		header.Set(k, v)
+	}
+	if len(event.Cookies) > 0 {
+		header.Set("Cookie", strings.Join(event.Cookies, "; "))
+	}
+
+	w, err := a.serve(ctx, request{
+		method:     event.RequestContext.HTTP.Method,
+		path:       event.RawPath,
+		rawQuery:   event.RawQueryString,
+		header:     header,
+		body:       event.Body,
+		base64:     event.IsBase64Encoded,
+		sourceIP:   event.RequestContext.HTTP.SourceIP,
+		pathParams: event.PathParameters,
+	})
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{}, err
+	}
+
+	headers := make(map[string]string, len(w.header))
+	for k, vs := range w.header {
+		headers[k] = strings.Join(vs, ",")
+	}
+	body, isBase64 := w.encodedBody()
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode:      w.status,
+		Headers:         headers,
+		Body:            body,
+		IsBase64Encoded: isBase64,
+	}, nil
+}
+
+// RenewExpiring renews every subscription expiring within RenewWindow, the
+// Manager must be a SubscriptionLister
+func (a *Adapter) RenewExpiring(ctx context.Context) error {
+	h, err := a.handler(ctx)
+	if err != nil {
+		return err
+	}
+	lister, ok := h.Manager.(twitchhook.SubscriptionLister)
+	if !ok {
+		return twitchhook.ErrNotSupported
+	}
+	subs, err := lister.List()
+	if err != nil {
+		return err
+	}
+
+	window := a.RenewWindow
+	if window <= 0 {
+		window = DefaultRenewWindow
+	}
+	deadline := time.Now().Add(window)
+
+	var errs []error
+	for _, sub := range subs {
+		if sub.ExpiresAt.IsZero() || sub.ExpiresAt.After(deadline) {
+			continue
+		}
+		errs = append(errs, h.RenewContext(ctx, sub.Topic))
+	}
+	return errors.Join(errs...)
+}
+
+type request struct {
+	method, path, rawQuery string
+	header                 http.Header
+	body                   string
+	base64                 bool
+	sourceIP               string
+	pathParams             map[string]string
+}
+
+func (a *Adapter) serve(ctx context.Context, req request) (*responseWriter, error) {
+	h, err := a.handler(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body := []byte(req.body)
+	if req.base64 {
+		body, err = base64.StdEncoding.DecodeString(req.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := http.NewRequestWithContext(ctx, req.method, req.path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.URL.RawQuery = req.rawQuery
+	r.Header = req.header
+	r.Host = req.header.Get("Host")
+	r.RemoteAddr = net.JoinHostPort(req.sourceIP, "0")
+
+	w := &responseWriter{header: http.Header{}}
+	if a.Param != "" {
+		h.ServeCallback(w, r, twitchhook.SubscriptionID(req.pathParams[a.Param]))
+	} else {
+		h.SubscriptionCallbackHandler()(w, r)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w, nil
+}
+
+// responseWriter buffers the handler's response for the lambda result
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+func (w *responseWriter) encodedBody() (string, bool) {
+	if utf8.Valid(w.body.Bytes()) {
+		return w.body.String(), false
+	}
+	return base64.StdEncoding.EncodeToString(w.body.Bytes()), true
+}
@@ -0,0 +1,231 @@
+package twitchhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SubscriptionInfo describes a subscription for the admin endpoints
+type SubscriptionInfo struct {
+	Topic              string            `json:"topic"`
+	CallbackURL        string            `json:"callback_url"`
+	State              SubscriptionState `json:"state"`
+	LeaseSeconds       int64             `json:"lease_seconds"`
+	ExpiresAt          *time.Time        `json:"expires_at,omitempty"`
+	LastNotificationAt *time.Time        `json:"last_notification_at,omitempty"`
+	Stats              *TopicStats       `json:"stats,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+}
+
+// AdminHandler serves endpoints for operating the handler:
+//
+//	GET    /subscriptions                lists subscriptions
+//	GET    /stats                        lists per topic delivery stats
+//	POST   /subscriptions/{topic}/renew  renews a subscription
+//	DELETE /subscriptions/{topic}        unsubscribes
+//	GET    /dead-letters                 lists dead letters
+//	POST   /dead-letters/redrive         redrives every dead letter
+//	POST   /dead-letters/{id}/redrive    redrives a dead letter
+//	DELETE /dead-letters/{id}            discards a dead letter
+//
+// where {topic} is path escaped. The dead letter endpoints require
+// DeadLetters. Listing is filtered by metadata with
+// metadata.{key}={value} query parameters. Every request must pass authorize, a nil
+// authorize rejects everything. Listing requires the Manager to implement
+// SubscriptionLister. Mount it with http.StripPrefix to serve it under a
+// prefix.
+func (m *TwitchWebhookHandler) AdminHandler(authorize func(r *http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize == nil || !authorize(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		p := r.URL.EscapedPath()
+		if p == "/stats" {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			m.writeJSON(w, m.Stats())
+			return
+		}
+
+		if p == "/subscriptions" {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			m.adminListHandler(w, r.URL.Query())
+			return
+		}
+
+		if p == "/dead-letters" || strings.HasPrefix(p, "/dead-letters/") {
+			m.adminDeadLettersHandler(w, r, strings.TrimPrefix(strings.TrimPrefix(p, "/dead-letters"), "/"))
+			return
+		}
+
+		if !strings.HasPrefix(p, "/subscriptions/") {
+			http.NotFound(w, r)
+			return
+		}
+		escaped := strings.TrimPrefix(p, "/subscriptions/")
+
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(escaped, "/renew"):
+			topic, err := url.PathUnescape(strings.TrimSuffix(escaped, "/renew"))
+			if err != nil {
+				http.Error(w, "invalid topic", http.StatusBadRequest)
+				return
+			}
+			m.adminRenewHandler(w, topic)
+		case r.Method == http.MethodDelete && !strings.Contains(escaped, "/"):
+			topic, err := url.PathUnescape(escaped)
+			if err != nil {
+				http.Error(w, "invalid topic", http.StatusBadRequest)
+				return
+			}
+			m.adminDeleteHandler(w, topic)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func (m *TwitchWebhookHandler) adminListHandler(w http.ResponseWriter, query url.Values) {
+	lister, ok := m.Manager.(SubscriptionLister)
+	if !ok {
+		http.Error(w, "subscription manager can't list subscriptions", http.StatusNotImplemented)
+		return
+	}
+
+	match := make(map[string]string)
+	for key := range query {
+		if name, ok := strings.CutPrefix(key, "metadata."); ok {
+			match[name] = query.Get(key)
+		}
+	}
+	subs, err := ListByMetadata(lister, match)
+	if err != nil {
+		m.logger().Error("error listing subscriptions", zap.Error(err))
+		http.Error(w, "error listing subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	now := clockOrDefault(m.Clock).Now()
+	infos := make([]SubscriptionInfo, 0, len(subs))
+	for _, sub := range subs {
+		info := SubscriptionInfo{
+			Topic:        sub.Topic,
+			CallbackURL:  sub.CallbackURL,
+			State:        sub.State(now),
+			LeaseSeconds: int64(sub.Lease / time.Second),
+			Metadata:     sub.Metadata,
+		}
+		if !sub.ExpiresAt.IsZero() {
+			expiresAt := sub.ExpiresAt
+			info.ExpiresAt = &expiresAt
+		}
+		if stats, ok := m.TopicStats(sub.Topic); ok {
+			info.Stats = &stats
+			if !stats.LastDeliveryAt.IsZero() {
+				info.LastNotificationAt = &stats.LastDeliveryAt
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	m.writeJSON(w, infos)
+}
+
+func (m *TwitchWebhookHandler) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		m.logger().Info("error writing admin response", zap.Error(err))
+	}
+}
+
+func (m *TwitchWebhookHandler) adminRenewHandler(w http.ResponseWriter, topic string) {
+	err := m.Renew(topic)
+	if err != nil {
+		m.logger().Error("error renewing subscription", zap.String("topic", topic), zap.Error(err))
+		http.Error(w, "error renewing subscription: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (m *TwitchWebhookHandler) adminDeleteHandler(w http.ResponseWriter, topic string) {
+	err := m.Unsubscribe(topic)
+	if err != nil {
+		m.logger().Error("error unsubscribing", zap.String("topic", topic), zap.Error(err))
+		http.Error(w, "error unsubscribing: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *TwitchWebhookHandler) adminDeadLettersHandler(w http.ResponseWriter, r *http.Request, p string) {
+	if m.DeadLetters == nil {
+		http.Error(w, "handler has no dead letter store", http.StatusNotImplemented)
+		return
+	}
+
+	switch {
+	case p == "" && r.Method == http.MethodGet:
+		letters, err := m.DeadLetters.List(r.Context())
+		if err != nil {
+			m.logger().Error("error listing dead letters", zap.Error(err))
+			http.Error(w, "error listing dead letters", http.StatusInternalServerError)
+			return
+		}
+		if letters == nil {
+			letters = []DeadLetter{}
+		}
+		m.writeJSON(w, letters)
+	case p == "redrive" && r.Method == http.MethodPost:
+		redriven, err := m.RedriveAll(r.Context())
+		if err != nil {
+			m.logger().Error("error redriving dead letters", zap.Int("redriven", redriven), zap.Error(err))
+			http.Error(w, fmt.Sprintf("redrove %d dead letters: %v", redriven, err), http.StatusBadGateway)
+			return
+		}
+		m.writeJSON(w, map[string]int{"redriven": redriven})
+	case strings.HasSuffix(p, "/redrive") && r.Method == http.MethodPost:
+		m.adminRedriveHandler(w, r, strings.TrimSuffix(p, "/redrive"))
+	case p != "" && !strings.Contains(p, "/") && r.Method == http.MethodDelete:
+		err := m.DeadLetters.Remove(r.Context(), p)
+		if err != nil {
+			m.logger().Error("error removing dead letter", zap.String("id", p), zap.Error(err))
+			http.Error(w, "error removing dead letter", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *TwitchWebhookHandler) adminRedriveHandler(w http.ResponseWriter, r *http.Request, id string) {
+	err := m.Redrive(r.Context(), id)
+	if errors.Is(err, ErrDeadLetterNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		m.logger().Error("error redriving dead letter", zap.String("id", id), zap.Error(err))
+		http.Error(w, "error redriving dead letter: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
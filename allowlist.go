@@ -0,0 +1,130 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrSourceNotAllowed is returned when a notification comes from an address
+// outside the allowlist
+var ErrSourceNotAllowed = errors.New("notification source address is not allowed")
+
+// IPAllowlist filters requests by source address. When the immediate peer is
+// a trusted proxy the client address is taken from X-Forwarded-For, skipping
+// any further trusted proxies from the right.
+type IPAllowlist struct {
+	Allowed        []*net.IPNet
+	TrustedProxies []*net.IPNet
+}
+
+// NewIPAllowlist parses cidrs and trusted proxy cidrs, bare addresses are
+// treated as single host networks
+func NewIPAllowlist(cidrs, trustedProxies []string) (*IPAllowlist, error) {
+	allowed, err := parseNetworks(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := parseNetworks(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &IPAllowlist{Allowed: allowed, TrustedProxies: proxies}, nil
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client address of a request from remoteAddr and
+// X-Forwarded-For in header, or nil if it can't be parsed
+func (a *IPAllowlist) ClientIP(remoteAddr string, header http.Header) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(a.TrustedProxies, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, v := range header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return nil
+		}
+		ip = hop
+		if !containsIP(a.TrustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// Allow reports whether a request from remoteAddr with header is allowed
+func (a *IPAllowlist) Allow(remoteAddr string, header http.Header) bool {
+	ip := a.ClientIP(remoteAddr, header)
+	return ip != nil && containsIP(a.Allowed, ip)
+}
+
+// Middleware returns notification middleware rejecting notifications from
+// addresses outside the allowlist with ErrSourceNotAllowed
+func (a *IPAllowlist) Middleware() Middleware {
+	return func(next NotificationHandler) NotificationHandler {
+		return NotificationHandlerFunc(func(ctx context.Context, n *Notification) error {
+			if !a.Allow(n.RemoteAddr, n.Header) {
+				return ErrSourceNotAllowed
+			}
+			return next.HandleNotification(ctx, n)
+		})
+	}
+}
+
+// Handler wraps next, responding 403 to requests from addresses outside the
+// allowlist
+func (a *IPAllowlist) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Allow(r.RemoteAddr, r.Header) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
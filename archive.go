@@ -0,0 +1,50 @@
+package twitchhook
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RawNotification is a verified notification as it was received
+type RawNotification struct {
+	Topic      string
+	ReceivedAt time.Time
+	Header     http.Header
+	Body       []byte
+}
+
+// Archiver stores raw notifications, see the archive package for object
+// storage implementations
+type Archiver interface {
+	Archive(ctx context.Context, n *RawNotification) error
+}
+
+func (m *TwitchWebhookHandler) archive(ctx context.Context, n *Notification) {
+	if m.Archiver == nil {
+		return
+	}
+
+	err := m.Archiver.Archive(ctx, &RawNotification{
+		Topic:      n.Topic,
+		ReceivedAt: n.ReceivedAt,
+		Header:     n.Header.Clone(),
+		Body:       n.Body,
+	})
+	if err != nil {
+		m.logger().Error("error archiving notification", zap.String("topic", n.Topic), zap.Error(err))
+		m.hookError("archive", err)
+	}
+}
+
+// nopLogger is shared by everything without a Logger, zap.NewNop allocates
+var nopLogger = zap.NewNop()
+
+func (m *TwitchWebhookHandler) logger() *zap.Logger {
+	if m.Logger == nil {
+		return nopLogger
+	}
+	return m.Logger
+}
@@ -0,0 +1,71 @@
+// Package archive writes verified raw notifications to object storage so they
+// can be audited and reprocessed later.
+//
+// Notifications are stored as JSON documents keyed by
+//
+//	<prefix>/<yyyy>/<mm>/<dd>/<escaped topic>/<received unix nanos>-<notification id>.json
+//
+// which keeps each day's deliveries for a topic under a common prefix.
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// Store is an object store
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// List returns every key starting with prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Record is the stored form of a notification
+type Record struct {
+	Topic      string      `json:"topic"`
+	ReceivedAt time.Time   `json:"received_at"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// Archiver is a twitchhook.Archiver writing to a Store
+type Archiver struct {
+	Store Store
+
+	// Prefix is prepended to every key
+	Prefix string
+}
+
+// Archive stores the notification
+func (a *Archiver) Archive(ctx context.Context, n *twitchhook.RawNotification) error {
+	bs, err := json.Marshal(Record{
+		Topic:      n.Topic,
+		ReceivedAt: n.ReceivedAt.UTC(),
+		Header:     n.Header,
+		Body:       n.Body,
+	})
+	if err != nil {
+		return err
+	}
+	return a.Store.Put(ctx, Key(a.Prefix, n), bs)
+}
+
+// Key returns the key a notification is archived under
+func Key(prefix string, n *twitchhook.RawNotification) string {
+	t := n.ReceivedAt.UTC()
+	id := n.Header.Get(twitchhook.HeaderNotificationID)
+	if id == "" {
+		id = n.Header.Get(twitchhook.HeaderEventSubMessageID)
+	}
+	name := fmt.Sprintf("%d-%s.json", t.UnixNano(), url.PathEscape(id))
+	return path.Join(prefix, t.Format("2006/01/02"), url.PathEscape(n.Topic), name)
+}
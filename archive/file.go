@@ -0,0 +1,55 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore is a Store writing to a local directory
+type FileStore struct {
+	Dir string
+}
+
+// Put writes data to key under Dir
+func (s *FileStore) Put(ctx context.Context, key string, data []byte) error {
+	p := filepath.Join(s.Dir, filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, data, 0644)
+}
+
+// Get reads key from Dir
+func (s *FileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(key)))
+}
+
+// List returns every key under Dir starting with prefix
+func (s *FileStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(s.Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
@@ -0,0 +1,53 @@
+// Package gcsstore implements archive.Store on Google Cloud Storage.
+package gcsstore
+
+import (
+	"context"
+	"io/ioutil"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Store is an archive.Store writing objects to a GCS bucket
+type Store struct {
+	Bucket *storage.BucketHandle
+}
+
+// Put writes data to key in the bucket
+func (s *Store) Put(ctx context.Context, key string, data []byte) error {
+	w := s.Bucket.Object(key).NewWriter(ctx)
+	w.ContentType = "application/json"
+	_, err := w.Write(data)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Get reads key from the bucket
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.Bucket.Object(key).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// List returns every key in the bucket starting with prefix
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	it := s.Bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, attrs.Name)
+	}
+}
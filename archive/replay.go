@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// ReplayOptions selects the archived notifications to replay
+type ReplayOptions struct {
+	// From and To bound the receipt time of replayed notifications, both are
+	// required and To is exclusive
+	From time.Time
+	To   time.Time
+
+	// Topic limits the replay to a single topic when set
+	Topic string
+}
+
+// Replayer feeds archived notifications back through a handler, usually
+// twitchhook.NotificationHandlerFunc(handler.Dispatch)
+type Replayer struct {
+	Store   Store
+	Prefix  string
+	Handler twitchhook.NotificationHandler
+}
+
+// Replay redelivers archived notifications in the order they were received
+// with Replay set, returning the number delivered. It stops at the first
+// handler error.
+func (r *Replayer) Replay(ctx context.Context, opts ReplayOptions) (int, error) {
+	keys, err := r.keys(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var delivered int
+	for _, key := range keys {
+		err = ctx.Err()
+		if err != nil {
+			return delivered, err
+		}
+
+		bs, err := r.Store.Get(ctx, key)
+		if err != nil {
+			return delivered, err
+		}
+
+		var rec Record
+		err = json.Unmarshal(bs, &rec)
+		if err != nil {
+			return delivered, err
+		}
+		if rec.ReceivedAt.Before(opts.From) || !rec.ReceivedAt.Before(opts.To) {
+			continue
+		}
+
+		n := &twitchhook.Notification{
+			Topic:      rec.Topic,
+			ReceivedAt: rec.ReceivedAt,
+			Body:       rec.Body,
+			Replay:     true,
+		}
+		n.ReadHeader(rec.Header)
+
+		err = r.Handler.HandleNotification(ctx, n)
+		if err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// keys lists the keys for every day in the range, sorted by receipt time
+func (r *Replayer) keys(ctx context.Context, opts ReplayOptions) ([]string, error) {
+	var keys []string
+	from := opts.From.UTC().Truncate(24 * time.Hour)
+	for day := from; day.Before(opts.To); day = day.Add(24 * time.Hour) {
+		prefix := path.Join(r.Prefix, day.Format("2006/01/02")) + "/"
+		if opts.Topic != "" {
+			prefix += url.PathEscape(opts.Topic) + "/"
+		}
+
+		dayKeys, err := r.Store.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, dayKeys...)
+	}
+
+	// names start with the unix nanosecond receipt time, which has the same
+	// width for any date this century
+	sort.SliceStable(keys, func(i, j int) bool {
+		return path.Base(keys[i]) < path.Base(keys[j])
+	})
+	return keys, nil
+}
@@ -0,0 +1,220 @@
+package twitchhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// AuditKind is the kind of request an AuditEvent records
+type AuditKind string
+
+// Audit kinds
+const (
+	AuditConfirmation     AuditKind = "confirmation"
+	AuditDenial           AuditKind = "denial"
+	AuditNotification     AuditKind = "notification"
+	AuditSignatureFailure AuditKind = "signature_failure"
+)
+
+// AuditOutcome summarizes how a request was answered
+type AuditOutcome string
+
+// Audit outcomes
+const (
+	AuditAccepted  AuditOutcome = "accepted"
+	AuditDuplicate AuditOutcome = "duplicate"
+	AuditRejected  AuditOutcome = "rejected"
+	AuditError     AuditOutcome = "error"
+)
+
+// AuditEvent describes a request handled by the SubscriptionCallbackHandler
+type AuditEvent struct {
+	Time           time.Time     `json:"time"`
+	Kind           AuditKind     `json:"kind"`
+	Mode           string        `json:"mode,omitempty"`
+	Topic          string        `json:"topic,omitempty"`
+	NotificationID string        `json:"notification_id,omitempty"`
+	Retry          int           `json:"retry,omitempty"`
+	RequestID      string        `json:"request_id,omitempty"`
+	RemoteIP       string        `json:"remote_ip"`
+	Status         int           `json:"status"`
+	Outcome        AuditOutcome  `json:"outcome"`
+	Error          string        `json:"error,omitempty"`
+	Latency        time.Duration `json:"latency"`
+}
+
+// AuditSink receives an AuditEvent for every confirmation, denial,
+// notification and signature failure. Audit is called synchronously once the
+// response has been written.
+type AuditSink interface {
+	Audit(ctx context.Context, e *AuditEvent)
+}
+
+type auditKey struct{}
+
+// annotateAudit lets handlers fill in the request's audit event
+func annotateAudit(ctx context.Context, f func(e *AuditEvent)) {
+	if e, ok := ctx.Value(auditKey{}).(*AuditEvent); ok {
+		f(e)
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// audited wraps h, sending an AuditEvent to the AuditSink once h returns
+func (m *TwitchWebhookHandler) audited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.AuditSink == nil {
+			h(w, r)
+			return
+		}
+
+		clock := clockOrDefault(m.Clock)
+		e := &AuditEvent{Time: clock.Now(), RemoteIP: m.remoteIP(r), RequestID: RequestIDFromContext(r.Context())}
+		sw := &statusWriter{ResponseWriter: w}
+		h(sw, r.WithContext(context.WithValue(r.Context(), auditKey{}, e)))
+
+		// probes and malformed requests are never annotated
+		if e.Kind == "" {
+			return
+		}
+		e.Latency = clock.Now().Sub(e.Time)
+		e.Status = sw.status
+		if e.Status == 0 {
+			e.Status = http.StatusOK
+		}
+		if e.Outcome == "" {
+			switch {
+			case e.Status < 300:
+				e.Outcome = AuditAccepted
+			case e.Status < 500:
+				e.Outcome = AuditRejected
+			default:
+				e.Outcome = AuditError
+			}
+		}
+		m.AuditSink.Audit(r.Context(), e)
+	}
+}
+
+func (m *TwitchWebhookHandler) remoteIP(r *http.Request) string {
+	if m.SourceAllowlist != nil {
+		if ip := m.SourceAllowlist.ClientIP(r.RemoteAddr, r.Header); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ZapAuditSink logs audit events with zap
+type ZapAuditSink struct {
+	Logger *zap.Logger
+}
+
+// Audit implements AuditSink
+func (s *ZapAuditSink) Audit(ctx context.Context, e *AuditEvent) {
+	s.Logger.Info("twitchhook audit",
+		zap.Time("time", e.Time),
+		zap.String("kind", string(e.Kind)),
+		zap.String("mode", e.Mode),
+		zap.String("topic", e.Topic),
+		zap.String("notification_id", e.NotificationID),
+		zap.String("remote_ip", e.RemoteIP),
+		zap.Int("status", e.Status),
+		zap.String("outcome", string(e.Outcome)),
+		zap.String("error", e.Error),
+		zap.Duration("latency", e.Latency),
+	)
+}
+
+// SlogAuditSink logs audit events with log/slog, Logger defaults to
+// slog.Default
+type SlogAuditSink struct {
+	Logger *slog.Logger
+}
+
+// Audit implements AuditSink
+func (s *SlogAuditSink) Audit(ctx context.Context, e *AuditEvent) {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.LogAttrs(ctx, slog.LevelInfo, "twitchhook audit",
+		slog.Time("time", e.Time),
+		slog.String("kind", string(e.Kind)),
+		slog.String("mode", e.Mode),
+		slog.String("topic", e.Topic),
+		slog.String("notification_id", e.NotificationID),
+		slog.String("remote_ip", e.RemoteIP),
+		slog.Int("status", e.Status),
+		slog.String("outcome", string(e.Outcome)),
+		slog.String("error", e.Error),
+		slog.Duration("latency", e.Latency),
+	)
+}
+
+// JSONAuditSink writes audit events to W as newline delimited json
+type JSONAuditSink struct {
+	W io.Writer
+
+	m sync.Mutex
+}
+
+// OpenJSONAuditFile opens path for appending audit events, the caller
+// should Close the sink when done
+func OpenJSONAuditFile(path string) (*JSONAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONAuditSink{W: f}, nil
+}
+
+// Audit implements AuditSink, write errors are dropped
+func (s *JSONAuditSink) Audit(ctx context.Context, e *AuditEvent) {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.W.Write(append(bs, '\n'))
+}
+
+// Close closes W if it's an io.Closer
+func (s *JSONAuditSink) Close() error {
+	if c, ok := s.W.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package twitchhook
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Batch defaults
+const (
+	DefaultBatchSize = 100
+	DefaultBatchWait = time.Second
+)
+
+// BatchHandler handles notifications in batches, see Batcher
+type BatchHandler interface {
+	HandleBatch(ctx context.Context, batch []*Notification) error
+}
+
+// BatchHandlerFunc adapts a function to a BatchHandler
+type BatchHandlerFunc func(ctx context.Context, batch []*Notification) error
+
+// HandleBatch calls f
+func (f BatchHandlerFunc) HandleBatch(ctx context.Context, batch []*Notification) error {
+	return f(ctx, batch)
+}
+
+// BatchError is returned by BatchHandlers when only some notifications of a
+// batch failed. Errs is aligned with the batch, nil entries succeeded.
+type BatchError struct {
+	Errs []error
+}
+
+func (e *BatchError) Error() string {
+	var failed int
+	for _, err := range e.Errs {
+		if err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d notifications failed", failed, len(e.Errs))
+}
+
+// Batcher is a NotificationHandler grouping notifications into batches for
+// its Handler, such as for bulk inserts. A batch is handled once it holds
+// MaxSize notifications or MaxWait after its first one arrived.
+//
+// HandleNotification waits for the notification's batch to be handled and
+// returns the batch's error, or the notification's own error when it's a
+// *BatchError, so failures are redelivered by the hub. Keep MaxWait well
+// under the hub's timeout, or set the handler's HandlerTimeout.
+type Batcher struct {
+	Handler BatchHandler
+
+	// MaxSize defaults to DefaultBatchSize
+	MaxSize int
+
+	// MaxWait defaults to DefaultBatchWait
+	MaxWait time.Duration
+
+	// Clock defaults to SystemClock
+	Clock Clock
+
+	m   sync.Mutex
+	cur *pendingBatch
+}
+
+type pendingBatch struct {
+	notifications []*Notification
+	timer         Timer
+	done          chan struct{}
+	err           error
+}
+
+// HandleNotification implements NotificationHandler
+func (b *Batcher) HandleNotification(ctx context.Context, n *Notification) error {
+	maxSize := b.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultBatchSize
+	}
+
+	b.m.Lock()
+	batch := b.cur
+	if batch == nil {
+		batch = &pendingBatch{done: make(chan struct{})}
+		maxWait := b.MaxWait
+		if maxWait <= 0 {
+			maxWait = DefaultBatchWait
+		}
+		batch.timer = clockOrDefault(b.Clock).AfterFunc(maxWait, func() {
+			b.flush(batch)
+		})
+		b.cur = batch
+	}
+	i := len(batch.notifications)
+	batch.notifications = append(batch.notifications, n)
+	full := len(batch.notifications) >= maxSize
+	b.m.Unlock()
+
+	if full {
+		b.flush(batch)
+	}
+
+	select {
+	case <-batch.done:
+	case <-ctx.Done():
+		// the notification is still handled with its batch
+		return ctx.Err()
+	}
+	if bErr, ok := batch.err.(*BatchError); ok {
+		if i < len(bErr.Errs) {
+			return bErr.Errs[i]
+		}
+		return nil
+	}
+	return batch.err
+}
+
+// Flush handles the pending batch now, such as before shutting down
+func (b *Batcher) Flush() error {
+	b.m.Lock()
+	batch := b.cur
+	b.m.Unlock()
+	if batch == nil {
+		return nil
+	}
+	b.flush(batch)
+	<-batch.done
+	return batch.err
+}
+
+// flush hands batch to the Handler unless it's already been taken
+func (b *Batcher) flush(batch *pendingBatch) {
+	b.m.Lock()
+	if b.cur != batch {
+		b.m.Unlock()
+		return
+	}
+	b.cur = nil
+	b.m.Unlock()
+	batch.timer.Stop()
+
+	defer close(batch.done)
+	defer func() {
+		if v := recover(); v != nil {
+			batch.err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	// batches outlive the requests of their notifications
+	batch.err = b.Handler.HandleBatch(context.Background(), batch.notifications)
+}
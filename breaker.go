@@ -0,0 +1,206 @@
+package twitchhook
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Circuit breaker defaults
+const (
+	DefaultBreakerFailureThreshold = 5
+	DefaultBreakerOpenTimeout      = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned for requests made while the circuit breaker is
+// open
+var ErrCircuitOpen = errors.New("circuit breaker is open, twitch requests are paused")
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState string
+
+// Breaker states
+const (
+	// BreakerClosed passes requests through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails requests with ErrCircuitOpen
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a single probe request through
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// CircuitBreaker is an http.RoundTripper that stops sending requests after
+// FailureThreshold consecutive failures, transport errors, 429s and 5xx
+// responses. After OpenTimeout a single probe request is let through, closing
+// the circuit if it succeeds and reopening it otherwise.
+type CircuitBreaker struct {
+	// Base defaults to http.DefaultTransport
+	Base http.RoundTripper
+
+	FailureThreshold int
+	OpenTimeout      time.Duration
+
+	// Clock defaults to SystemClock
+	Clock Clock
+
+	// OnStateChange is called with the new state whenever it changes
+	OnStateChange func(state BreakerState)
+
+	m        sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// State returns the breaker's current state
+func (b *CircuitBreaker) State() BreakerState {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.state == "" {
+		return BreakerClosed
+	}
+	return b.state
+}
+
+// RoundTrip implements http.RoundTripper
+func (b *CircuitBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	return b.roundTrip(req, b.Base, nil)
+}
+
+// roundTrip sends req to base through the breaker, calling changed before
+// OnStateChange when the state changes
+func (b *CircuitBreaker) roundTrip(req *http.Request, base http.RoundTripper, changed func(BreakerState)) (*http.Response, error) {
+	probe, state, err := b.allow()
+	b.notify(state, changed)
+	if err != nil {
+		return nil, err
+	}
+
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	b.notify(b.record(probe, failed), changed)
+	return resp, err
+}
+
+func (b *CircuitBreaker) allow() (probe bool, changed BreakerState, err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		timeout := b.OpenTimeout
+		if timeout <= 0 {
+			timeout = DefaultBreakerOpenTimeout
+		}
+		if clockOrDefault(b.Clock).Now().Sub(b.openedAt) < timeout {
+			return false, "", ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true, BreakerHalfOpen, nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, "", ErrCircuitOpen
+		}
+		b.probing = true
+		return true, "", nil
+	}
+	return false, "", nil
+}
+
+// record counts the outcome of a request, returning the new state if it
+// changed
+func (b *CircuitBreaker) record(probe, failed bool) BreakerState {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	if !failed {
+		b.failures = 0
+		if b.state != BreakerClosed && b.state != "" {
+			b.state = BreakerClosed
+			return BreakerClosed
+		}
+		return ""
+	}
+
+	b.failures++
+	threshold := b.FailureThreshold
+	if threshold <= 0 {
+		threshold = DefaultBreakerFailureThreshold
+	}
+	if probe || b.failures >= threshold {
+		b.openedAt = clockOrDefault(b.Clock).Now()
+		if b.state != BreakerOpen {
+			b.state = BreakerOpen
+			return BreakerOpen
+		}
+	}
+	return ""
+}
+
+func (b *CircuitBreaker) notify(state BreakerState, changed func(BreakerState)) {
+	if state == "" {
+		return
+	}
+	if changed != nil {
+		changed(state)
+	}
+	if b.OnStateChange != nil {
+		b.OnStateChange(state)
+	}
+}
+
+// breakerTransport wraps base with the handler's CircuitBreaker, reporting
+// state changes to Metrics. The CircuitBreaker itself isn't modified, so it
+// can be shared by handlers.
+func (m *TwitchWebhookHandler) breakerTransport(base http.RoundTripper) http.RoundTripper {
+	if m.CircuitBreaker == nil {
+		return base
+	}
+	m.reportBreakerState(BreakerClosed)
+	return &breakerRoundTripper{breaker: m.CircuitBreaker, base: base, handler: m}
+}
+
+// breakerRoundTripper sends a handler's requests through its CircuitBreaker
+type breakerRoundTripper struct {
+	breaker *CircuitBreaker
+	base    http.RoundTripper
+	handler *TwitchWebhookHandler
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *breakerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.breaker.roundTrip(req, t.base, t.handler.breakerStateChanged)
+}
+
+func (m *TwitchWebhookHandler) breakerStateChanged(state BreakerState) {
+	m.logger().Warn("twitch circuit breaker state changed", zap.String("state", string(state)))
+	m.reportBreakerState(state)
+}
+
+func (m *TwitchWebhookHandler) reportBreakerState(current BreakerState) {
+	for _, state := range []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+		var v float64
+		if state == current {
+			v = 1
+		}
+		m.metrics().SetGauge("twitchhook_circuit_breaker_state", v, "state", string(state))
+	}
+}
+
+func (m *TwitchWebhookHandler) checkBreaker() error {
+	if m.CircuitBreaker != nil && m.CircuitBreaker.State() == BreakerOpen {
+		return ErrCircuitOpen
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package twitchhook_test
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// breakerGauge records the current state of twitchhook_circuit_breaker_state
+type breakerGauge struct {
+	m     sync.Mutex
+	state string
+	sets  int
+}
+
+func (g *breakerGauge) IncCounter(name string, labels ...string) {}
+
+func (g *breakerGauge) SetGauge(name string, value float64, labels ...string) {
+	if name != "twitchhook_circuit_breaker_state" || value != 1 {
+		return
+	}
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.state = labels[1]
+	g.sets++
+}
+
+func (g *breakerGauge) current() (string, int) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	return g.state, g.sets
+}
+
+func TestCircuitBreakerSharedByHandlers(t *testing.T) {
+	h1, hub1, clock := newHubHandler(t)
+	h2, _, _ := newHubHandler(t)
+	h2.Clock = clock
+
+	var changes []twitchhook.BreakerState
+	b := &twitchhook.CircuitBreaker{
+		FailureThreshold: 1,
+		Clock:            clock,
+		OnStateChange:    func(state twitchhook.BreakerState) { changes = append(changes, state) },
+	}
+	g1, g2 := &breakerGauge{}, &breakerGauge{}
+	h1.CircuitBreaker, h1.Metrics = b, g1
+	h2.CircuitBreaker, h2.Metrics = b, g2
+
+	// both handlers set up their transports
+	var wg sync.WaitGroup
+	for i, h := range []*twitchhook.TwitchWebhookHandler{h1, h2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.Subscribe(twitchhook.SubscriptionRequest{Topic: testTopic + string(rune('0'+i))}, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if b.Base != nil {
+		t.Fatal("a handler replaced the breaker's Base")
+	}
+
+	hub1.m.Lock()
+	hub1.status = http.StatusServiceUnavailable
+	hub1.m.Unlock()
+	if err := h1.Subscribe(twitchhook.SubscriptionRequest{Topic: testTopic}, nil); err == nil {
+		t.Fatal("Subscribe succeeded with the hub failing")
+	}
+	if b.State() != twitchhook.BreakerOpen {
+		t.Fatalf("breaker is %s, want it opened by the failure", b.State())
+	}
+	if !reflect.DeepEqual(changes, []twitchhook.BreakerState{twitchhook.BreakerOpen}) {
+		t.Fatalf("OnStateChange called with %v, want once per change", changes)
+	}
+	if state, sets := g1.current(); state != "open" || sets != 2 {
+		t.Fatalf("failing handler's gauge = %s after %d sets", state, sets)
+	}
+	if state, sets := g2.current(); state != "closed" || sets != 1 {
+		t.Fatalf("other handler's gauge = %s after %d sets, want it untouched", state, sets)
+	}
+	if err := h2.Subscribe(twitchhook.SubscriptionRequest{Topic: testTopic}, nil); err == nil {
+		t.Fatal("Subscribe succeeded with the shared breaker open")
+	}
+}
@@ -0,0 +1,57 @@
+package twitchhook
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultHandlerDeadline bounds handlers that are still running when
+// HandlerTimeout runs out
+const DefaultHandlerDeadline = time.Minute
+
+// dispatchWithin dispatches n, acknowledging it once HandlerTimeout runs out
+// while the handler finishes in the background
+func (m *TwitchWebhookHandler) dispatchWithin(ctx context.Context, n *Notification) error {
+	if m.HandlerTimeout <= 0 {
+		return m.Dispatch(ctx, n)
+	}
+
+	deadline := m.HandlerDeadline
+	if deadline <= 0 {
+		deadline = DefaultHandlerDeadline
+	}
+	// the handler outlives the request once the budget runs out
+	hctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deadline)
+	done := make(chan error, 1)
+	go func() {
+		defer cancel()
+		done <- m.Dispatch(hctx, n)
+	}()
+
+	expired := make(chan struct{})
+	timer := clockOrDefault(m.Clock).AfterFunc(m.HandlerTimeout, func() {
+		close(expired)
+	})
+	select {
+	case err := <-done:
+		timer.Stop()
+		return err
+	case <-expired:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+
+	m.metrics().IncCounter("twitchhook_handler_budget_exceeded_total", "topic", n.Topic)
+	m.logger().Warn("notification handler exceeded its budget, finishing in the background", zap.String("topic", n.Topic), zap.Duration("budget", m.HandlerTimeout))
+	go func() {
+		err := <-done
+		if err != nil {
+			m.logger().Error("error handling notification", zap.String("topic", n.Topic), zap.Error(err))
+			m.hookError("notification handler", err)
+			m.deadLetter(hctx, n, err)
+		}
+	}()
+	return nil
+}
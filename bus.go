@@ -0,0 +1,525 @@
+package twitchhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Bus sink retry defaults
+const (
+	DefaultSinkAttempts   = 1
+	DefaultSinkBackoff    = 500 * time.Millisecond
+	DefaultSinkMaxBackoff = 5 * time.Second
+)
+
+// Bus is a NotificationHandler routing notifications to named sinks, such as
+// a relay for internal services and a Discord webhook for stream alerts.
+// Every Route matching a notification adds its sinks, each sink gets a
+// notification once however many routes select it. Sinks are called
+// concurrently and retried by their RetryPolicy.
+//
+// When a sink other than a BestEffort one still fails the hub is asked to
+// deliver the notification again, which hands it to every routed sink again,
+// unless every failed sink rejected it.
+//
+// Sinks and Routes are set before the Bus is used, Configure and Reload
+// replace them while it handles notifications.
+type Bus struct {
+	Sinks  map[string]*BusSink
+	Routes []Route
+
+	// Clock defaults to SystemClock
+	Clock Clock
+
+	// Logger defaults to a no-op logger
+	Logger *zap.Logger
+
+	Metrics Metrics
+
+	m sync.RWMutex
+	// reload serializes Reloads
+	reload sync.Mutex
+	// configs are the configs of sinks opened by Reload
+	configs map[string]BusSinkConfig
+}
+
+// BusSink is a sink of a Bus
+type BusSink struct {
+	Handler NotificationHandler
+	Retry   RetryPolicy
+
+	// BestEffort sinks have their failures logged without asking the hub to
+	// deliver the notification again
+	BestEffort bool
+}
+
+// RetryPolicy retries a failed sink with exponential backoff. Sinks are
+// retried when they fail with a 5xx NotificationResponse, waiting at least
+// its RetryAfter, and on errors that aren't NotificationResponses, which are
+// logged and acknowledged once the attempts run out. Responses that
+// acknowledge or reject a notification aren't retried.
+type RetryPolicy struct {
+	// Attempts is how many times a sink is tried, defaults to
+	// DefaultSinkAttempts
+	Attempts int `json:"attempts" yaml:"attempts" toml:"attempts"`
+
+	// Backoff is the delay before the second attempt, doubled for every
+	// attempt up to MaxBackoff. They default to DefaultSinkBackoff and
+	// DefaultSinkMaxBackoff.
+	Backoff    Duration `json:"backoff" yaml:"backoff" toml:"backoff"`
+	MaxBackoff Duration `json:"max_backoff" yaml:"max_backoff" toml:"max_backoff"`
+}
+
+// Route selects the sinks of notifications
+type Route struct {
+	// Topics are path.Match patterns matched against the notification's
+	// TopicType, such as helix.streams or helix.users.*. Every topic matches
+	// when it's empty.
+	Topics []string
+
+	// Match further filters notifications when it's set
+	Match func(n *Notification) bool
+
+	// Sinks names the route's sinks in the Bus's Sinks
+	Sinks []string
+}
+
+// SinkError is returned when a bus sink failed
+type SinkError struct {
+	Sink string
+	Err  error
+}
+
+func (e *SinkError) Error() string {
+	return fmt.Sprintf("sink %s: %v", e.Sink, e.Err)
+}
+
+func (e *SinkError) Unwrap() error {
+	return e.Err
+}
+
+// Check reports routes to sinks the Bus doesn't have and invalid topic
+// patterns
+func (b *Bus) Check() error {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	return checkRoutes(b.Sinks, b.Routes)
+}
+
+func checkRoutes(sinks map[string]*BusSink, routes []Route) error {
+	var errs []error
+	for i, r := range routes {
+		for _, pattern := range r.Topics {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("route %d: topic %q: %w", i, pattern, err))
+			}
+		}
+		for _, name := range r.Sinks {
+			if s, ok := sinks[name]; !ok || s == nil || s.Handler == nil {
+				errs = append(errs, fmt.Errorf("route %d: unknown sink %q", i, name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Configure replaces the Bus's sinks and routes when they pass Check.
+// Notifications being handled finish with the old ones.
+func (b *Bus) Configure(sinks map[string]*BusSink, routes []Route) error {
+	if err := checkRoutes(sinks, routes); err != nil {
+		return err
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.Sinks = sinks
+	b.Routes = routes
+	b.configs = nil
+	return nil
+}
+
+// Route returns the names of the sinks n is routed to, ordered by name
+func (b *Bus) Route(n *Notification) []string {
+	b.m.RLock()
+	routes := b.Routes
+	b.m.RUnlock()
+	return route(routes, n)
+}
+
+func route(routes []Route, n *Notification) []string {
+	topicType := TopicType(n.Topic)
+	seen := make(map[string]bool)
+	var names []string
+	for _, r := range routes {
+		if !r.matches(topicType, n) {
+			continue
+		}
+		for _, name := range r.Sinks {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Route) matches(topicType string, n *Notification) bool {
+	if r.Match != nil && !r.Match(n) {
+		return false
+	}
+	if len(r.Topics) == 0 {
+		return true
+	}
+	for _, pattern := range r.Topics {
+		if ok, _ := path.Match(pattern, topicType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleNotification implements NotificationHandler
+func (b *Bus) HandleNotification(ctx context.Context, n *Notification) error {
+	b.m.RLock()
+	sinks, routes := b.Sinks, b.Routes
+	b.m.RUnlock()
+
+	names := route(routes, n)
+	if len(names) == 0 {
+		b.metrics().IncCounter("twitchhook_bus_unrouted_total", "topic", TopicType(n.Topic))
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(names))
+	for i, name := range names {
+		s, ok := sinks[name]
+		if !ok || s == nil || s.Handler == nil {
+			errs[i] = &SinkError{Sink: name, Err: errors.New("unknown sink")}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = b.deliver(ctx, name, s, n)
+		}()
+	}
+	wg.Wait()
+
+	var (
+		failed     []error
+		retry      bool
+		retryAfter time.Duration
+	)
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		name := names[i]
+		if s := sinks[name]; s != nil && s.BestEffort {
+			b.logger().Warn("best effort sink failed", zap.String("sink", name), zap.String("topic", n.Topic), zap.String("request_id", n.RequestID), zap.Error(err))
+			continue
+		}
+		failed = append(failed, err)
+		var resp *NotificationResponse
+		if !errors.As(err, &resp) || resp.StatusCode < 400 || resp.StatusCode >= 500 {
+			retry = true
+		}
+		if resp != nil && resp.RetryAfter > retryAfter {
+			retryAfter = resp.RetryAfter
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	err := errors.Join(failed...)
+	if retry {
+		return RetryLater(retryAfter, err)
+	}
+	// every failed sink rejected the notification, redelivering won't help
+	return Reject(err)
+}
+
+// deliver hands n to s until it succeeds, fails permanently or runs out of
+// attempts
+func (b *Bus) deliver(ctx context.Context, name string, s *BusSink, n *Notification) error {
+	attempts := s.Retry.Attempts
+	if attempts <= 0 {
+		attempts = DefaultSinkAttempts
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = s.Handler.HandleNotification(ctx, n)
+		retryable, retryAfter := sinkRetryable(err)
+		if !retryable {
+			break
+		}
+		b.metrics().IncCounter("twitchhook_bus_sink_failures_total", "sink", name)
+		if attempt >= attempts {
+			break
+		}
+
+		delay := s.Retry.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		elapsed := make(chan struct{})
+		timer := clockOrDefault(b.Clock).AfterFunc(delay, func() { close(elapsed) })
+		select {
+		case <-elapsed:
+		case <-ctx.Done():
+			timer.Stop()
+			return &SinkError{Sink: name, Err: ctx.Err()}
+		}
+	}
+
+	var resp *NotificationResponse
+	switch {
+	case err == nil:
+	case !errors.As(err, &resp):
+		// logged and acknowledged like the errors of the handler's
+		// NotificationHandler
+		b.logger().Warn("sink error", zap.String("sink", name), zap.String("topic", n.Topic), zap.String("request_id", n.RequestID), zap.Error(err))
+		err = nil
+	case resp.StatusCode < 300:
+		if resp.Err != nil {
+			b.logger().Warn("sink error", zap.String("sink", name), zap.String("topic", n.Topic), zap.String("request_id", n.RequestID), zap.Error(resp.Err))
+		}
+		err = nil
+	}
+	if err != nil {
+		return &SinkError{Sink: name, Err: err}
+	}
+	b.metrics().IncCounter("twitchhook_bus_deliveries_total", "sink", name)
+	return nil
+}
+
+// sinkRetryable reports whether a sink's err is retried and the least delay
+// it asks for
+func sinkRetryable(err error) (bool, time.Duration) {
+	if err == nil {
+		return false, 0
+	}
+	var resp *NotificationResponse
+	if !errors.As(err, &resp) {
+		return true, 0
+	}
+	if resp.StatusCode >= 500 {
+		return true, resp.RetryAfter
+	}
+	return false, 0
+}
+
+// backoff is the delay after a failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := time.Duration(p.Backoff)
+	if delay <= 0 {
+		delay = DefaultSinkBackoff
+	}
+	limit := time.Duration(p.MaxBackoff)
+	if limit <= 0 {
+		limit = DefaultSinkMaxBackoff
+	}
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+func (b *Bus) logger() *zap.Logger {
+	if b.Logger == nil {
+		return nopLogger
+	}
+	return b.Logger
+}
+
+func (b *Bus) metrics() Metrics {
+	if b.Metrics == nil {
+		return nopMetrics{}
+	}
+	return b.Metrics
+}
+
+// BusConfig configures a Bus from a config file
+type BusConfig struct {
+	Sinks  map[string]BusSinkConfig `json:"sinks" yaml:"sinks" toml:"sinks"`
+	Routes []RouteConfig            `json:"routes" yaml:"routes" toml:"routes"`
+}
+
+// BusSinkConfig configures a sink of a Bus
+type BusSinkConfig struct {
+	// URL is opened by the SinkFactory registered for its scheme, see
+	// RegisterSink
+	URL        string      `json:"url" yaml:"url" toml:"url"`
+	Retry      RetryPolicy `json:"retry" yaml:"retry" toml:"retry"`
+	BestEffort bool        `json:"best_effort" yaml:"best_effort" toml:"best_effort"`
+}
+
+// RouteConfig configures a Route
+type RouteConfig struct {
+	Topics []string `json:"topics" yaml:"topics" toml:"topics"`
+	Sinks  []string `json:"sinks" yaml:"sinks" toml:"sinks"`
+
+	// Where sets the route's Match to MatchFields
+	Where map[string][]string `json:"where" yaml:"where" toml:"where"`
+}
+
+// MatchFields returns a Route Match passing notifications with an event whose
+// fields have one of the values listed for them, such as
+// {"game_id": ["509658"]}. Events are the elements of the body's data array,
+// or the body's event object for EventSub notifications. Values are compared
+// as JSON strings, or as the JSON text of other values such as numbers.
+func MatchFields(fields map[string][]string) func(n *Notification) bool {
+	return func(n *Notification) bool {
+		for _, event := range bodyEvents(n.Body) {
+			if eventMatches(event, fields) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func bodyEvents(body []byte) []map[string]json.RawMessage {
+	var doc map[string]json.RawMessage
+	if json.Unmarshal(body, &doc) != nil {
+		return nil
+	}
+	if data, ok := doc["data"]; ok {
+		var events []map[string]json.RawMessage
+		json.Unmarshal(data, &events)
+		return events
+	}
+	if event, ok := doc["event"]; ok {
+		var e map[string]json.RawMessage
+		if json.Unmarshal(event, &e) != nil {
+			return nil
+		}
+		return []map[string]json.RawMessage{e}
+	}
+	return []map[string]json.RawMessage{doc}
+}
+
+func eventMatches(event map[string]json.RawMessage, fields map[string][]string) bool {
+	for field, values := range fields {
+		raw, ok := event[field]
+		if !ok {
+			return false
+		}
+		v := string(raw)
+		var str string
+		if json.Unmarshal(raw, &str) == nil {
+			v = str
+		}
+		found := false
+		for _, want := range values {
+			if v == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SinkFactory opens a sink from a url
+type SinkFactory func(rawURL string) (NotificationHandler, error)
+
+var (
+	sinkFactories   = map[string]SinkFactory{}
+	sinkFactoriesMu sync.RWMutex
+)
+
+// RegisterSink makes a sink available to bus configs with the given url
+// scheme. Sink packages register themselves when imported.
+func RegisterSink(scheme string, factory SinkFactory) {
+	sinkFactoriesMu.Lock()
+	defer sinkFactoriesMu.Unlock()
+	sinkFactories[scheme] = factory
+}
+
+// OpenSink opens a sink url with the SinkFactory registered for its scheme
+func OpenSink(rawURL string) (NotificationHandler, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink url: %w", err)
+	}
+
+	sinkFactoriesMu.RLock()
+	factory, ok := sinkFactories[u.Scheme]
+	sinkFactoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no sink registered for %q", u.Scheme)
+	}
+	return factory(rawURL)
+}
+
+// OpenBus builds a Bus from cfg, opening every sink with OpenSink
+func OpenBus(cfg BusConfig, logger *zap.Logger) (*Bus, error) {
+	b := &Bus{Logger: logger}
+	if err := b.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reload replaces the Bus's sinks and routes with those of cfg. Sinks whose
+// config didn't change since the last Reload are kept, along with their
+// state such as alert limits, the others are opened with OpenSink. The
+// current sinks and routes are kept when cfg can't be applied.
+func (b *Bus) Reload(cfg BusConfig) error {
+	b.reload.Lock()
+	defer b.reload.Unlock()
+
+	b.m.RLock()
+	current, configs := b.Sinks, b.configs
+	b.m.RUnlock()
+
+	sinks := make(map[string]*BusSink, len(cfg.Sinks))
+	for name, sc := range cfg.Sinks {
+		if old, ok := configs[name]; ok && old.URL == sc.URL && current[name] != nil {
+			sinks[name] = &BusSink{Handler: current[name].Handler, Retry: sc.Retry, BestEffort: sc.BestEffort}
+			continue
+		}
+		h, err := OpenSink(sc.URL)
+		if err != nil {
+			return fmt.Errorf("sink %s: %w", name, err)
+		}
+		sinks[name] = &BusSink{Handler: h, Retry: sc.Retry, BestEffort: sc.BestEffort}
+	}
+	routes := make([]Route, len(cfg.Routes))
+	for i, rc := range cfg.Routes {
+		routes[i] = Route{Topics: rc.Topics, Sinks: rc.Sinks}
+		if len(rc.Where) > 0 {
+			routes[i].Match = MatchFields(rc.Where)
+		}
+	}
+	if err := b.Configure(sinks, routes); err != nil {
+		return err
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.configs = make(map[string]BusSinkConfig, len(cfg.Sinks))
+	for name, sc := range cfg.Sinks {
+		b.configs[name] = sc
+	}
+	return nil
+}
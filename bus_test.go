@@ -0,0 +1,353 @@
+package twitchhook_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+)
+
+// sink returns a bus sink failing with the errors in order, then succeeding
+func sink(calls *atomic.Int32, errs ...error) *twitchhook.BusSink {
+	return &twitchhook.BusSink{Handler: twitchhook.NotificationHandlerFunc(func(context.Context, *twitchhook.Notification) error {
+		i := int(calls.Add(1)) - 1
+		if i < len(errs) {
+			return errs[i]
+		}
+		return nil
+	})}
+}
+
+func ok() *twitchhook.BusSink {
+	return sink(new(atomic.Int32))
+}
+
+func TestBusRoute(t *testing.T) {
+	sinks := map[string]*twitchhook.BusSink{"relay": ok(), "discord": ok(), "audit": ok(), "live": ok()}
+	routes := []twitchhook.Route{
+		{Sinks: []string{"audit"}},
+		{Topics: []string{"helix.streams"}, Sinks: []string{"relay", "discord"}},
+		{Topics: []string{"helix.users.*"}, Sinks: []string{"relay"}},
+		{Topics: []string{"helix.streams"}, Match: twitchhook.MatchFields(map[string][]string{"type": {"live"}}), Sinks: []string{"live"}},
+	}
+	bus := &twitchhook.Bus{}
+	if err := bus.Configure(sinks, routes); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		topic string
+		body  string
+		want  []string
+	}{
+		{topic: testTopic, body: `{"data":[]}`, want: []string{"audit", "discord", "relay"}},
+		{topic: testTopic, body: `{"data":[{"type":"live"}]}`, want: []string{"audit", "discord", "live", "relay"}},
+		{topic: "https://api.twitch.tv/helix/users/follows?to_id=1", want: []string{"audit", "relay"}},
+		// patterns match whole path segments
+		{topic: "https://api.twitch.tv/helix/users?id=1", want: []string{"audit"}},
+		{topic: "https://api.twitch.tv/helix/streams/extra", want: []string{"audit"}},
+	}
+	for _, tt := range tests {
+		got := bus.Route(&twitchhook.Notification{Topic: tt.topic, Body: []byte(tt.body)})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Route(%s, %s) = %v, want %v", tt.topic, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestBusCheck(t *testing.T) {
+	sinks := map[string]*twitchhook.BusSink{"relay": ok()}
+	bus := &twitchhook.Bus{}
+	if err := bus.Configure(sinks, []twitchhook.Route{{Sinks: []string{"relay"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, routes := range [][]twitchhook.Route{
+		{{Sinks: []string{"missing"}}},
+		{{Topics: []string{"helix.[streams"}, Sinks: []string{"relay"}}},
+	} {
+		if err := bus.Configure(sinks, routes); err == nil {
+			t.Errorf("Configure(%+v) succeeded", routes)
+		}
+	}
+	if got := bus.Route(&twitchhook.Notification{Topic: testTopic}); !reflect.DeepEqual(got, []string{"relay"}) {
+		t.Fatalf("Route after failed Configures = %v, want the routes that passed Check", got)
+	}
+	if err := bus.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBusResponse(t *testing.T) {
+	fail := errors.New("sink failed")
+	tests := []struct {
+		name       string
+		errs       map[string]error
+		bestEffort string
+		status     int
+		retryAfter time.Duration
+	}{
+		{name: "delivered"},
+		{name: "acknowledged", errs: map[string]error{"a": twitchhook.Ack(fail)}},
+		{name: "handler errors are acknowledged", errs: map[string]error{"a": fail}},
+		{name: "rejected", errs: map[string]error{"a": twitchhook.Reject(fail)}, status: http.StatusUnprocessableEntity},
+		{name: "every failed sink rejected", errs: map[string]error{"a": twitchhook.Reject(fail), "b": twitchhook.Reject(fail)}, status: http.StatusUnprocessableEntity},
+		{name: "retried", errs: map[string]error{"a": twitchhook.RetryLater(time.Second, fail)}, status: http.StatusServiceUnavailable, retryAfter: time.Second},
+		{
+			name:       "a retry wins over rejections",
+			errs:       map[string]error{"a": twitchhook.Reject(fail), "b": twitchhook.RetryLater(time.Second, fail)},
+			status:     http.StatusServiceUnavailable,
+			retryAfter: time.Second,
+		},
+		{
+			name:       "the longest retry after",
+			errs:       map[string]error{"a": twitchhook.RetryLater(time.Second, fail), "b": twitchhook.RetryLater(time.Minute, fail)},
+			status:     http.StatusServiceUnavailable,
+			retryAfter: time.Minute,
+		},
+		{name: "best effort failures are logged", errs: map[string]error{"a": twitchhook.RetryLater(time.Second, fail)}, bestEffort: "a"},
+		{
+			name:       "best effort failures don't count",
+			errs:       map[string]error{"a": twitchhook.RetryLater(time.Second, fail), "b": twitchhook.Reject(fail)},
+			bestEffort: "a",
+			status:     http.StatusUnprocessableEntity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := map[string]*atomic.Int32{}
+			sinks := map[string]*twitchhook.BusSink{}
+			for _, name := range []string{"a", "b", "c"} {
+				calls[name] = new(atomic.Int32)
+				sinks[name] = sink(calls[name], tt.errs[name])
+				sinks[name].BestEffort = name == tt.bestEffort
+			}
+			bus := &twitchhook.Bus{}
+			if err := bus.Configure(sinks, []twitchhook.Route{{Sinks: []string{"a", "b", "c"}}}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := bus.HandleNotification(context.Background(), &twitchhook.Notification{Topic: testTopic})
+			for name, n := range calls {
+				if n.Load() != 1 {
+					t.Errorf("sink %s called %d times, want once", name, n.Load())
+				}
+			}
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("HandleNotification = %v, want the notification acknowledged", err)
+				}
+				return
+			}
+			var resp *twitchhook.NotificationResponse
+			if !errors.As(err, &resp) || resp.StatusCode != tt.status || resp.RetryAfter != tt.retryAfter {
+				t.Fatalf("HandleNotification = %v, want a %d retrying after %s", err, tt.status, tt.retryAfter)
+			}
+			var sinkErr *twitchhook.SinkError
+			if !errors.As(err, &sinkErr) || !errors.Is(err, fail) {
+				t.Fatalf("HandleNotification = %v, want the sinks' errors", err)
+			}
+		})
+	}
+}
+
+func TestBusUnrouted(t *testing.T) {
+	bus := &twitchhook.Bus{}
+	if err := bus.Configure(map[string]*twitchhook.BusSink{"a": ok()}, []twitchhook.Route{{Topics: []string{"helix.users"}, Sinks: []string{"a"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.HandleNotification(context.Background(), &twitchhook.Notification{Topic: testTopic}); err != nil {
+		t.Fatalf("HandleNotification of an unrouted notification = %v", err)
+	}
+}
+
+// waitPending waits for the sinks to schedule n retries
+func waitPending(t *testing.T, clock *clocktest.Clock, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); clock.Pending() != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d retries scheduled, want %d", clock.Pending(), n)
+		}
+	}
+}
+
+func TestBusRetryBackoff(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	fail := twitchhook.RetryLater(0, errors.New("unavailable"))
+	var calls atomic.Int32
+	s := sink(&calls, fail, fail, errors.New("timeout"), twitchhook.RetryLater(10*time.Second, errors.New("unavailable")), fail)
+	s.Retry = twitchhook.RetryPolicy{Attempts: 6, Backoff: twitchhook.Duration(time.Second), MaxBackoff: twitchhook.Duration(3 * time.Second)}
+	bus := &twitchhook.Bus{Clock: clock}
+	if err := bus.Configure(map[string]*twitchhook.BusSink{"a": s}, []twitchhook.Route{{Sinks: []string{"a"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bus.HandleNotification(context.Background(), &twitchhook.Notification{Topic: testTopic})
+	}()
+
+	// doubling up to MaxBackoff, or the sink's longer retry after
+	for i, delay := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 10 * time.Second, 3 * time.Second} {
+		waitPending(t, clock, 1)
+		if n := calls.Load(); n != int32(i+1) {
+			t.Fatalf("sink called %d times before retry %d", n, i+1)
+		}
+		clock.Advance(delay - time.Millisecond)
+		if calls.Load() != int32(i+1) || clock.Pending() != 1 {
+			t.Fatalf("retry %d ran before its %s backoff", i+1, delay)
+		}
+		clock.Advance(time.Millisecond)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("HandleNotification = %v, want the last attempt delivered", err)
+	}
+	if calls.Load() != 6 {
+		t.Fatalf("sink called %d times, want 6", calls.Load())
+	}
+}
+
+func TestBusRetryAttempts(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	fail := twitchhook.RetryLater(0, errors.New("unavailable"))
+	var calls atomic.Int32
+	s := sink(&calls, fail, fail, fail)
+	s.Retry = twitchhook.RetryPolicy{Attempts: 2}
+	bus := &twitchhook.Bus{Clock: clock}
+	if err := bus.Configure(map[string]*twitchhook.BusSink{"a": s}, []twitchhook.Route{{Sinks: []string{"a"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bus.HandleNotification(context.Background(), &twitchhook.Notification{Topic: testTopic})
+	}()
+	waitPending(t, clock, 1)
+	clock.Advance(twitchhook.DefaultSinkBackoff)
+	err := <-done
+	var resp *twitchhook.NotificationResponse
+	if !errors.As(err, &resp) || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("HandleNotification = %v, want a retry once the attempts ran out", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("sink called %d times, want Attempts", calls.Load())
+	}
+
+	// responses that acknowledge or reject aren't retried
+	calls.Store(0)
+	s.Handler = sink(&calls, twitchhook.Reject(errors.New("bad"))).Handler
+	err = bus.HandleNotification(context.Background(), &twitchhook.Notification{Topic: testTopic})
+	if !errors.As(err, &resp) || resp.StatusCode != http.StatusUnprocessableEntity || calls.Load() != 1 {
+		t.Fatalf("HandleNotification = %v after %d calls, want a rejection without retries", err, calls.Load())
+	}
+}
+
+func TestBusRetryCanceled(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	var calls atomic.Int32
+	s := sink(&calls, twitchhook.RetryLater(0, errors.New("unavailable")))
+	s.Retry = twitchhook.RetryPolicy{Attempts: 2}
+	bus := &twitchhook.Bus{Clock: clock}
+	if err := bus.Configure(map[string]*twitchhook.BusSink{"a": s}, []twitchhook.Route{{Sinks: []string{"a"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- bus.HandleNotification(ctx, &twitchhook.Notification{Topic: testTopic})
+	}()
+	waitPending(t, clock, 1)
+	cancel()
+	err := <-done
+	var resp *twitchhook.NotificationResponse
+	if !errors.Is(err, context.Canceled) || !errors.As(err, &resp) || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("HandleNotification = %v, want a retry for the canceled delivery", err)
+	}
+	if clock.Pending() != 0 {
+		t.Fatal("the canceled retry's timer is still scheduled")
+	}
+}
+
+// openedSinks counts the sinks opened from bustest:// urls
+var openedSinks = struct {
+	sync.Mutex
+	urls map[string]int
+}{urls: map[string]int{}}
+
+func init() {
+	twitchhook.RegisterSink("bustest", func(rawURL string) (twitchhook.NotificationHandler, error) {
+		openedSinks.Lock()
+		defer openedSinks.Unlock()
+		openedSinks.urls[rawURL]++
+		return twitchhook.NotificationHandlerFunc(func(context.Context, *twitchhook.Notification) error { return nil }), nil
+	})
+}
+
+func opened(url string) int {
+	openedSinks.Lock()
+	defer openedSinks.Unlock()
+	return openedSinks.urls[url]
+}
+
+func TestBusReload(t *testing.T) {
+	cfg := twitchhook.BusConfig{
+		Sinks: map[string]twitchhook.BusSinkConfig{
+			"kept":    {URL: "bustest://reload/kept"},
+			"changed": {URL: "bustest://reload/changed"},
+		},
+		Routes: []twitchhook.RouteConfig{{Sinks: []string{"kept", "changed"}}},
+	}
+	keptOpened, changedOpened, addedOpened := opened("bustest://reload/kept"), opened("bustest://reload/changed?v=2"), opened("bustest://reload/added")
+	bus, err := twitchhook.OpenBus(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := bus.Sinks["kept"].Handler
+
+	cfg.Sinks = map[string]twitchhook.BusSinkConfig{
+		"kept":    {URL: "bustest://reload/kept", BestEffort: true, Retry: twitchhook.RetryPolicy{Attempts: 3}},
+		"changed": {URL: "bustest://reload/changed?v=2"},
+		"added":   {URL: "bustest://reload/added"},
+	}
+	cfg.Routes = []twitchhook.RouteConfig{{Sinks: []string{"kept", "changed", "added"}, Where: map[string][]string{"type": {"live"}}}}
+	if err := bus.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if opened("bustest://reload/kept") != keptOpened+1 {
+		t.Fatal("Reload reopened a sink whose url didn't change")
+	}
+	if opened("bustest://reload/changed?v=2") != changedOpened+1 || opened("bustest://reload/added") != addedOpened+1 {
+		t.Fatal("Reload didn't open changed and added sinks")
+	}
+	s := bus.Sinks["kept"]
+	if reflect.ValueOf(s.Handler).Pointer() != reflect.ValueOf(kept).Pointer() || !s.BestEffort || s.Retry.Attempts != 3 {
+		t.Fatalf("kept sink = %+v, want its handler with the new retry policy", s)
+	}
+	if got := bus.Route(&twitchhook.Notification{Topic: testTopic, Body: []byte(`{"data":[{"type":"offline"}]}`)}); len(got) != 0 {
+		t.Fatalf("Route = %v, want the reloaded route's Where applied", got)
+	}
+
+	// failed reloads keep the current sinks and routes
+	bad := cfg
+	bad.Sinks = map[string]twitchhook.BusSinkConfig{"kept": {URL: "unregistered://sink"}}
+	if err := bus.Reload(bad); err == nil {
+		t.Fatal("Reload opened a sink with an unregistered scheme")
+	}
+	bad.Sinks = map[string]twitchhook.BusSinkConfig{"kept": {URL: "bustest://reload/kept"}}
+	bad.Routes = []twitchhook.RouteConfig{{Sinks: []string{"missing"}}}
+	if err := bus.Reload(bad); err == nil {
+		t.Fatal("Reload accepted a route to a missing sink")
+	}
+	if len(bus.Sinks) != 3 {
+		t.Fatalf("sinks after failed reloads = %v, want the last applied config's", bus.Sinks)
+	}
+
+}
@@ -1,21 +1,155 @@
 package twitchhook
 
 import (
+	"container/list"
+	"errors"
 	"sync"
 	"time"
 )
 
+// ErrSubscriptionNotFound is returned by SubscriptionManagers when there's no
+// subscription for a topic
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
 // Subscription is a cache item
 type Subscription struct {
-	Topic          string
-	CallbackURL    string
-	Lease          time.Duration
-	Secret         string
-	DenialCallback func(reason string)
-	Renew          func()
+	ID              SubscriptionID
+	Topic           string
+	CallbackBaseURL string
+	CallbackURL     string
+	Lease           time.Duration
+	Secret          string
+	DenialCallback  func(reason string)
+	Renew           func()
+
+	// SecretRef references the secret in a SecretProvider, Secret is empty
+	// when it's set
+	SecretRef string
+
+	// Namespace is the tenant of subscriptions saved through a
+	// NamespacedManager
+	Namespace string
+
+	// ExpiresAt is when the confirmed lease runs out, it's zero until the hub
+	// confirms the subscription
+	ExpiresAt time.Time
+
+	// Metadata is the SubscriptionRequest's metadata
+	Metadata map[string]string
+
+	// PreviousSecret and PreviousSecretRef are the secret a renewal rotated
+	// out, notifications signed with it still verify until
+	// PreviousSecretExpiresAt so deliveries made before the hub switched
+	// secrets aren't rejected
+	PreviousSecret          string
+	PreviousSecretRef       string
+	PreviousSecretExpiresAt time.Time
+}
+
+// SubscriptionRecord holds the fields of a Subscription that stores persist,
+// the funcs are kept by the process that saved it and the handler's
+// CallbackRegistry
+type SubscriptionRecord struct {
+	ID              SubscriptionID    `json:"id"`
+	Topic           string            `json:"topic"`
+	CallbackBaseURL string            `json:"callback_base_url"`
+	CallbackURL     string            `json:"callback_url"`
+	Lease           time.Duration     `json:"lease"`
+	Secret          string            `json:"secret,omitempty"`
+	SecretRef       string            `json:"secret_ref,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ExpiresAt       time.Time         `json:"expires_at,omitzero"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+
+	PreviousSecret          string    `json:"previous_secret,omitempty"`
+	PreviousSecretRef       string    `json:"previous_secret_ref,omitempty"`
+	PreviousSecretExpiresAt time.Time `json:"previous_secret_expires_at,omitzero"`
+}
+
+// Record returns the persisted fields of s
+func (s *Subscription) Record() SubscriptionRecord {
+	return SubscriptionRecord{
+		ID:              s.ID,
+		Topic:           s.Topic,
+		CallbackBaseURL: s.CallbackBaseURL,
+		CallbackURL:     s.CallbackURL,
+		Lease:           s.Lease,
+		Secret:          s.Secret,
+		SecretRef:       s.SecretRef,
+		Namespace:       s.Namespace,
+		ExpiresAt:       s.ExpiresAt,
+		Metadata:        s.Metadata,
+
+		PreviousSecret:          s.PreviousSecret,
+		PreviousSecretRef:       s.PreviousSecretRef,
+		PreviousSecretExpiresAt: s.PreviousSecretExpiresAt,
+	}
+}
+
+// Subscription returns a Subscription without funcs from r
+func (r *SubscriptionRecord) Subscription() *Subscription {
+	return &Subscription{
+		ID:              r.ID,
+		Topic:           r.Topic,
+		CallbackBaseURL: r.CallbackBaseURL,
+		CallbackURL:     r.CallbackURL,
+		Lease:           r.Lease,
+		Secret:          r.Secret,
+		SecretRef:       r.SecretRef,
+		Namespace:       r.Namespace,
+		ExpiresAt:       r.ExpiresAt,
+		Metadata:        r.Metadata,
+
+		PreviousSecret:          r.PreviousSecret,
+		PreviousSecretRef:       r.PreviousSecretRef,
+		PreviousSecretExpiresAt: r.PreviousSecretExpiresAt,
+	}
+}
+
+// SubscriptionState describes where a subscription is in its lifecycle
+type SubscriptionState string
+
+// Subscription states
+const (
+	// SubscriptionPending subscriptions are waiting on hub confirmation
+	SubscriptionPending SubscriptionState = "pending"
+	// SubscriptionActive subscriptions are confirmed and within their lease
+	SubscriptionActive SubscriptionState = "active"
+	// SubscriptionExpired subscriptions are past their lease
+	SubscriptionExpired SubscriptionState = "expired"
+)
+
+// State returns the state of the subscription at now
+func (s *Subscription) State(now time.Time) SubscriptionState {
+	switch {
+	case s.ExpiresAt.IsZero():
+		return SubscriptionPending
+	case now.Before(s.ExpiresAt):
+		return SubscriptionActive
+	default:
+		return SubscriptionExpired
+	}
+}
+
+// previousSecretAccepted reports whether notifications signed with the
+// previous secret still verify at now
+func (s *Subscription) previousSecretAccepted(now time.Time) bool {
+	return (s.PreviousSecret != "" || s.PreviousSecretRef != "") && now.Before(s.PreviousSecretExpiresAt)
+}
+
+// renewalAt returns when a saved subscription is due for renewal, its expiry
+// once confirmed and a lease from now while it's pending
+func (s *Subscription) renewalAt(now time.Time) time.Time {
+	if s.ExpiresAt.IsZero() {
+		return now.Add(s.Lease)
+	}
+	return s.ExpiresAt
 }
 
-// SubscriptionManager manages subscription state
+// SubscriptionManager manages subscription state. InMemoryCache is the
+// reference implementation: Get returns ErrSubscriptionNotFound for unknown
+// topics, Delete of an unknown topic is a no-op and SetSubscriptionLease
+// reports whether the topic exists.
 type SubscriptionManager interface {
 	Delete(topic string) error
 	Get(topic string) (*Subscription, error)
@@ -23,36 +157,216 @@ type SubscriptionManager interface {
 	SetSubscriptionLease(topic string, lease time.Duration) (exists bool, err error)
 }
 
+// SubscriptionLister is implemented by SubscriptionManagers that can list
+// every subscription they hold
+type SubscriptionLister interface {
+	List() ([]*Subscription, error)
+}
+
+// EvictionNotifier is implemented by SubscriptionManagers that evict
+// subscriptions on their own. TwitchWebhookHandler registers with its Manager
+// to unsubscribe evicted subscriptions that are still active.
+type EvictionNotifier interface {
+	NotifyEviction(f func(sub *Subscription))
+}
+
+// RenewalScheduler is implemented by SubscriptionManagers that schedule
+// renewals, RenewalAt reports when topic's renewal will run and is zero when
+// none is scheduled
+type RenewalScheduler interface {
+	RenewalAt(topic string) (time.Time, error)
+}
+
 type cacheItem struct {
-	sub   *Subscription
-	timer *time.Timer
+	topic   string
+	sub     *Subscription
+	timer   Timer
+	renewAt time.Time
+	elem    *list.Element
 }
 
-// InMemoryCache caches subscriptions
+// InMemoryCache caches subscriptions, the zero value is ready to use
 type InMemoryCache struct {
-	c map[string]*cacheItem
-	m sync.RWMutex
+	// Clock schedules lease renewals, defaults to SystemClock
+	Clock Clock
+
+	// MaxSize caps the number of cached subscriptions, saving past it evicts
+	// the least recently used subscription. Zero means no limit.
+	MaxSize int
+
+	// OnEvict is called with subscriptions evicted to stay within MaxSize
+	OnEvict func(sub *Subscription)
+
+	c      map[string]*cacheItem
+	ids    map[SubscriptionID]string
+	lru    list.List
+	notify []func(sub *Subscription)
+	m      sync.RWMutex
+}
+
+// NewInMemoryCache returns an empty InMemoryCache scheduling renewals with
+// clock, a nil clock uses SystemClock
+func NewInMemoryCache(clock Clock) *InMemoryCache {
+	return &InMemoryCache{
+		Clock: clock,
+		c:     make(map[string]*cacheItem),
+		ids:   make(map[SubscriptionID]string),
+	}
+}
+
+// NewLRUCache returns an empty InMemoryCache holding at most size
+// subscriptions, onEvict may be nil
+func NewLRUCache(clock Clock, size int, onEvict func(sub *Subscription)) *InMemoryCache {
+	c := NewInMemoryCache(clock)
+	c.MaxSize = size
+	c.OnEvict = onEvict
+	return c
 }
 
-// Get retrieves a subscription
+// NotifyEviction implements EvictionNotifier, f is called alongside OnEvict
+func (c *InMemoryCache) NotifyEviction(f func(sub *Subscription)) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.notify = append(c.notify, f)
+}
+
+// Get retrieves a subscription, returning ErrSubscriptionNotFound if there
+// isn't one for topic
 func (c *InMemoryCache) Get(topic string) (*Subscription, error) {
+	defer c.unlockRead(c.lockRead())
+
+	item, ok := c.c[topic]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+	c.touch(item)
+	return item.sub, nil
+}
+
+// GetByID retrieves a subscription by its id
+func (c *InMemoryCache) GetByID(id SubscriptionID) (*Subscription, error) {
+	defer c.unlockRead(c.lockRead())
+
+	item, ok := c.c[c.ids[id]]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+	c.touch(item)
+	return item.sub, nil
+}
+
+// lockRead locks the cache for a lookup, reporting whether the lock is
+// exclusive for unlockRead. Lookups only share the lock when there's no
+// MaxSize, otherwise they update the recency list.
+func (c *InMemoryCache) lockRead() bool {
+	if c.MaxSize > 0 {
+		c.m.Lock()
+		return true
+	}
+	c.m.RLock()
+	return false
+}
+
+func (c *InMemoryCache) unlockRead(exclusive bool) {
+	if exclusive {
+		c.m.Unlock()
+		return
+	}
+	c.m.RUnlock()
+}
+
+func (c *InMemoryCache) touch(item *cacheItem) {
+	if c.MaxSize > 0 {
+		c.lru.MoveToFront(item.elem)
+	}
+}
+
+// Len returns the number of cached subscriptions
+func (c *InMemoryCache) Len() int {
 	c.m.RLock()
 	defer c.m.RUnlock()
 
-	item := c.c[topic]
-	return item.sub, nil
+	return len(c.c)
+}
+
+// List returns every cached subscription
+func (c *InMemoryCache) List() ([]*Subscription, error) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	subs := make([]*Subscription, 0, len(c.c))
+	for _, item := range c.c {
+		subs = append(subs, item.sub)
+	}
+	return subs, nil
 }
 
-// Save caches a subscription, returns an error if a subscription already exists
+// Save caches a subscription, replacing any existing subscription to topic.
+// Its renewal runs when the lease expires, or after Lease if it's pending.
+// Past MaxSize the least recently used subscriptions are evicted.
 func (c *InMemoryCache) Save(topic string, sub *Subscription) error {
+	evicted, notify := c.save(topic, sub)
+	for _, sub := range evicted {
+		for _, f := range notify {
+			f(sub)
+		}
+	}
+	return nil
+}
+
+func (c *InMemoryCache) save(topic string, sub *Subscription) (evicted []*Subscription, notify []func(sub *Subscription)) {
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	c.c[topic] = &cacheItem{
-		sub:   sub,
-		timer: time.AfterFunc(sub.Lease, sub.Renew),
+	if c.c == nil {
+		c.c = make(map[string]*cacheItem)
 	}
-	return nil
+	if c.ids == nil {
+		c.ids = make(map[SubscriptionID]string)
+	}
+	if item, ok := c.c[topic]; ok {
+		c.remove(item)
+	}
+	if sub.ID != "" {
+		c.ids[sub.ID] = topic
+	}
+
+	clock := clockOrDefault(c.Clock)
+	now := clock.Now()
+	renewAt := sub.renewalAt(now)
+	item := &cacheItem{
+		topic:   topic,
+		sub:     sub,
+		timer:   clock.AfterFunc(renewAt.Sub(now), c.renew(topic, sub)),
+		renewAt: renewAt,
+	}
+	item.elem = c.lru.PushFront(item)
+	c.c[topic] = item
+
+	for c.MaxSize > 0 && len(c.c) > c.MaxSize {
+		oldest := c.lru.Back().Value.(*cacheItem)
+		c.remove(oldest)
+		evicted = append(evicted, oldest.sub)
+	}
+	if len(evicted) == 0 {
+		return nil, nil
+	}
+
+	if c.OnEvict != nil {
+		notify = append(notify, c.OnEvict)
+	}
+	return evicted, append(notify, c.notify...)
+}
+
+// remove drops item, the caller holds c.m
+func (c *InMemoryCache) remove(item *cacheItem) {
+	item.timer.Stop()
+	c.lru.Remove(item.elem)
+	if c.ids[item.sub.ID] == item.topic {
+		delete(c.ids, item.sub.ID)
+	}
+	delete(c.c, item.topic)
 }
 
 // SetSubscriptionLease retrieves a subscription
@@ -65,12 +379,44 @@ func (c *InMemoryCache) SetSubscriptionLease(topic string, lease time.Duration)
 		return false, nil
 	}
 
+	clock := clockOrDefault(c.Clock)
 	item.timer.Stop()
-	item.timer = time.AfterFunc(item.sub.Lease, item.sub.Renew)
 	item.sub.Lease = lease
+	item.sub.ExpiresAt = clock.Now().Add(lease)
+	item.timer = clock.AfterFunc(item.sub.Lease, c.renew(topic, item.sub))
+	item.renewAt = item.sub.ExpiresAt
 	return true, nil
 }
 
+// RenewalAt implements RenewalScheduler, a renewal is scheduled until its
+// timer fires
+func (c *InMemoryCache) RenewalAt(topic string) (time.Time, error) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	item, ok := c.c[topic]
+	if !ok {
+		return time.Time{}, ErrSubscriptionNotFound
+	}
+	return item.renewAt, nil
+}
+
+// renew returns the timer func renewing sub, it marks the renewal as no
+// longer scheduled before running it
+func (c *InMemoryCache) renew(topic string, sub *Subscription) func() {
+	return func() {
+		c.m.Lock()
+		if item, ok := c.c[topic]; ok && item.sub == sub {
+			item.renewAt = time.Time{}
+		}
+		c.m.Unlock()
+
+		if sub.Renew != nil {
+			sub.Renew()
+		}
+	}
+}
+
 // Delete removes a subscription
 func (c *InMemoryCache) Delete(topic string) error {
 	c.m.Lock()
@@ -81,8 +427,6 @@ func (c *InMemoryCache) Delete(topic string) error {
 		return nil
 	}
 
-	item.timer.Stop()
-
-	delete(c.c, topic)
+	c.remove(item)
 	return nil
 }
@@ -0,0 +1,14 @@
+package twitchhook_test
+
+import (
+	"testing"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/storetest"
+)
+
+func TestInMemoryCache(t *testing.T) {
+	storetest.TestSubscriptionManager(t, func(t *testing.T, clock twitchhook.Clock) twitchhook.SubscriptionManager {
+		return &twitchhook.InMemoryCache{Clock: clock}
+	})
+}
@@ -0,0 +1,106 @@
+package twitchhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// ErrInsecureCallback is returned for callback base urls that don't use https
+// while AllowInsecureCallbacks is unset
+var ErrInsecureCallback = errors.New("callback base url must use https")
+
+// ErrCallbackUnreachable is returned when ProbeCallbacks is set and the
+// callback url isn't answered by this handler
+var ErrCallbackUnreachable = errors.New("callback url is not reachable")
+
+const probeMode = "twitchhook.probe"
+
+// generateCallbackURL embeds the subscription id in the base url using the
+// handler's CallbackURLBuilder
+func (m *TwitchWebhookHandler) generateCallbackURL(baseURL string, subscriptionID SubscriptionID) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	if !u.IsAbs() || u.Host == "" {
+		return "", fmt.Errorf("callback base url %q must be absolute", baseURL)
+	}
+
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !m.AllowInsecureCallbacks {
+			return "", ErrInsecureCallback
+		}
+	default:
+		return "", fmt.Errorf("callback base url %q must use https", baseURL)
+	}
+
+	u.Fragment = ""
+	u, err = m.callbackURLBuilder().BuildCallbackURL(u, subscriptionID)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// probeCallback requests the callback url with a one time challenge only this
+// handler knows and checks it's echoed back
+func (m *TwitchWebhookHandler) probeCallback(ctx context.Context, callbackURL string) error {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return err
+	}
+	challenge := hex.EncodeToString(buf)
+
+	m.probes.Store(challenge, struct{}{})
+	defer m.probes.Delete(challenge)
+
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("hub.mode", probeMode)
+	q.Set("hub.challenge", challenge)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := m.baseClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCallbackUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	bs, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(len(challenge))+1))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCallbackUnreachable, err)
+	}
+	if resp.StatusCode != http.StatusOK || string(bs) != challenge {
+		return fmt.Errorf("%w: unexpected response %s", ErrCallbackUnreachable, resp.Status)
+	}
+	return nil
+}
+
+// probeHandler answers probes sent by this handler, anything else is
+// rejected so the callback doesn't echo arbitrary input
+func (m *TwitchWebhookHandler) probeHandler(w http.ResponseWriter, challenge string) {
+	if _, ok := m.probes.Load(challenge); !ok {
+		http.Error(w, "unknown probe", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, challenge)
+}
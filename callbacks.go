@@ -0,0 +1,71 @@
+package twitchhook
+
+import "sync"
+
+// CallbackRegistry holds the denial callbacks of subscriptions by topic.
+// Callbacks are runtime state: Managers backed by external stores only keep
+// a SubscriptionRecord, so subscriptions loaded from them, such as by Start
+// after a restart, have no DenialCallback. The handler falls back to the
+// registry for those, register callbacks before Start so they're bound again
+// to the restored subscriptions. The zero value is ready to use, a nil
+// registry holds no callbacks.
+type CallbackRegistry struct {
+	// Denied is called for denials of topics without a registered callback
+	Denied func(topic, reason string)
+
+	m      sync.RWMutex
+	denial map[string]func(reason string)
+}
+
+// SetDenialCallback registers f for topic's denials, a nil f removes the
+// topic's callback
+func (r *CallbackRegistry) SetDenialCallback(topic string, f func(reason string)) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if f == nil {
+		delete(r.denial, topic)
+		return
+	}
+	if r.denial == nil {
+		r.denial = make(map[string]func(reason string))
+	}
+	r.denial[topic] = f
+}
+
+// DenialCallback returns the callback for topic's denials, it's nil when
+// neither topic's callback nor Denied are set
+func (r *CallbackRegistry) DenialCallback(topic string) func(reason string) {
+	if r == nil {
+		return nil
+	}
+	r.m.RLock()
+	f, ok := r.denial[topic]
+	r.m.RUnlock()
+	if ok {
+		return f
+	}
+	if r.Denied == nil {
+		return nil
+	}
+	return func(reason string) {
+		r.Denied(topic, reason)
+	}
+}
+
+// Delete removes topic's callback
+func (r *CallbackRegistry) Delete(topic string) {
+	if r == nil {
+		return
+	}
+	r.SetDenialCallback(topic, nil)
+}
+
+// denialCallback returns sub's DenialCallback, or the one registered for its
+// topic when it was loaded without funcs
+func (m *TwitchWebhookHandler) denialCallback(sub *Subscription) func(reason string) {
+	if sub.DenialCallback != nil {
+		return sub.DenialCallback
+	}
+	return m.Callbacks.DenialCallback(sub.Topic)
+}
@@ -0,0 +1,223 @@
+package twitchhook
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// CallbackURLBuilder controls how subscription ids are embedded in callback
+// urls. ExtractSubscriptionID must recover the id from requests sent to urls
+// built by BuildCallbackURL.
+type CallbackURLBuilder interface {
+	// BuildCallbackURL embeds id in base. base is a copy and may be modified.
+	BuildCallbackURL(base *url.URL, id SubscriptionID) (*url.URL, error)
+	ExtractSubscriptionID(r *http.Request) (SubscriptionID, error)
+}
+
+// PathCallbackURL appends the subscription id as the last path segment of the
+// callback url, this is the default
+type PathCallbackURL struct{}
+
+// BuildCallbackURL implements CallbackURLBuilder
+func (PathCallbackURL) BuildCallbackURL(base *url.URL, id SubscriptionID) (*url.URL, error) {
+	base.Path = path.Join("/", base.Path, string(id))
+	base.RawPath = ""
+	return base, nil
+}
+
+// ExtractSubscriptionID implements CallbackURLBuilder
+func (PathCallbackURL) ExtractSubscriptionID(r *http.Request) (SubscriptionID, error) {
+	_, id := path.Split(r.URL.EscapedPath())
+	if id == "" {
+		return "", ErrInvalidSubscriptionID
+	}
+	return SubscriptionID(id), nil
+}
+
+// DefaultCallbackQueryParam is the query parameter used by QueryCallbackURL
+// when Param is empty
+const DefaultCallbackQueryParam = "subscription"
+
+// QueryCallbackURL carries the subscription id in a query parameter, for
+// deployments that route every callback to a single path
+type QueryCallbackURL struct {
+	Param string
+}
+
+func (q QueryCallbackURL) param() string {
+	if q.Param == "" {
+		return DefaultCallbackQueryParam
+	}
+	return q.Param
+}
+
+// BuildCallbackURL implements CallbackURLBuilder
+func (q QueryCallbackURL) BuildCallbackURL(base *url.URL, id SubscriptionID) (*url.URL, error) {
+	if strings.HasPrefix(q.param(), "hub.") {
+		return nil, errors.New("callback query parameter must not use the hub. prefix")
+	}
+	kv := base.Query()
+	kv.Set(q.param(), string(id))
+	base.RawQuery = kv.Encode()
+	return base, nil
+}
+
+// ExtractSubscriptionID implements CallbackURLBuilder
+func (q QueryCallbackURL) ExtractSubscriptionID(r *http.Request) (SubscriptionID, error) {
+	id := r.URL.Query().Get(q.param())
+	if id == "" {
+		return "", ErrInvalidSubscriptionID
+	}
+	return SubscriptionID(id), nil
+}
+
+// maxLabelLength is the longest a single dns label may be
+const maxLabelLength = 63
+
+// subdomainEncoding is case insensitive so ids survive hostname
+// normalization
+var subdomainEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// SubdomainCallbackURL carries the subscription id in subdomains of the base
+// url's host. The id is base32 encoded and split into labels, so the base
+// host needs a wildcard dns record and certificate. Long topics can exceed the
+// 253 byte hostname limit, in which case BuildCallbackURL returns an error.
+type SubdomainCallbackURL struct {
+	// Host is the base host requests are expected on, it's required for
+	// extraction
+	Host string
+}
+
+// BuildCallbackURL implements CallbackURLBuilder
+func (s SubdomainCallbackURL) BuildCallbackURL(base *url.URL, id SubscriptionID) (*url.URL, error) {
+	encoded := strings.ToLower(subdomainEncoding.EncodeToString([]byte(id)))
+
+	var labels []string
+	for len(encoded) > maxLabelLength {
+		labels = append(labels, encoded[:maxLabelLength])
+		encoded = encoded[maxLabelLength:]
+	}
+	labels = append(labels, encoded)
+
+	subdomain := strings.Join(labels, ".")
+	if len(subdomain)+1+len(base.Hostname()) > 253 {
+		return nil, errors.New("subscription id is too long to embed in a hostname")
+	}
+	base.Host = subdomain + "." + base.Host
+	return base, nil
+}
+
+// ExtractSubscriptionID implements CallbackURLBuilder
+func (s SubdomainCallbackURL) ExtractSubscriptionID(r *http.Request) (SubscriptionID, error) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	suffix := "." + strings.ToLower(s.Host)
+	if s.Host == "" || !strings.HasSuffix(host, suffix) {
+		return "", ErrInvalidSubscriptionID
+	}
+
+	encoded := strings.Replace(strings.TrimSuffix(host, suffix), ".", "", -1)
+	bs, err := subdomainEncoding.DecodeString(strings.ToUpper(encoded))
+	if err != nil {
+		return "", ErrInvalidSubscriptionID
+	}
+	return SubscriptionID(bs), nil
+}
+
+func (m *TwitchWebhookHandler) callbackURLBuilder() CallbackURLBuilder {
+	if m.CallbackURLBuilder == nil {
+		return PathCallbackURL{}
+	}
+	return m.CallbackURLBuilder
+}
+
+type subscriptionIDKey struct{}
+
+// ServeCallback serves a websub request like the SubscriptionCallbackHandler
+// for routers that have already extracted the subscription id, such as from a
+// url parameter, bypassing the CallbackURLBuilder
+func (m *TwitchWebhookHandler) ServeCallback(w http.ResponseWriter, r *http.Request, id SubscriptionID) {
+	ctx := context.WithValue(r.Context(), subscriptionIDKey{}, id)
+	m.SubscriptionCallbackHandler()(w, r.WithContext(ctx))
+}
+
+func (m *TwitchWebhookHandler) extractSubscriptionID(r *http.Request) (SubscriptionID, error) {
+	if id, ok := r.Context().Value(subscriptionIDKey{}).(SubscriptionID); ok {
+		if id == "" {
+			return "", ErrInvalidSubscriptionID
+		}
+		return id, nil
+	}
+	return m.callbackURLBuilder().ExtractSubscriptionID(r)
+}
+
+// requestSubscriptionID extracts the subscription id and topic from a
+// notification request
+func (m *TwitchWebhookHandler) requestSubscriptionID(r *http.Request) (SubscriptionID, string, error) {
+	id, err := m.extractSubscriptionID(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	if decoder, ok := m.idGenerator().(TopicDecoder); ok {
+		if topic, ok := m.topics.get(id); ok {
+			return id, topic, nil
+		}
+		topic, err := decoder.SubscriptionIDTopic(id)
+		if err != nil {
+			return "", "", err
+		}
+		m.topics.put(id, topic)
+		return id, topic, nil
+	}
+
+	index, ok := m.Manager.(SubscriptionIDIndex)
+	if !ok {
+		return "", "", ErrOpaqueSubscriptionIDs
+	}
+	sub, err := index.GetByID(id)
+	if errors.Is(err, ErrSubscriptionNotFound) || (err == nil && sub == nil) {
+		return "", "", ErrInvalidSubscriptionID
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return id, sub.Topic, nil
+}
+
+// maxDecodedTopics bounds the topicCache, it's emptied when full
+const maxDecodedTopics = 4096
+
+// topicCache holds the topics TopicDecoders decoded from subscription ids so
+// notifications to a callback don't decode its id every time
+type topicCache struct {
+	m      sync.RWMutex
+	topics map[SubscriptionID]string
+}
+
+func (c *topicCache) get(id SubscriptionID) (string, bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	topic, ok := c.topics[id]
+	return topic, ok
+}
+
+func (c *topicCache) put(id SubscriptionID, topic string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.topics == nil || len(c.topics) >= maxDecodedTopics {
+		c.topics = make(map[SubscriptionID]string)
+	}
+	// ids are sliced from request urls, which the cache shouldn't keep
+	c.topics[SubscriptionID(strings.Clone(string(id)))] = topic
+}
@@ -0,0 +1,40 @@
+package twitchhook_test
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+func TestSubdomainCallbackURL(t *testing.T) {
+	id, err := twitchhook.NewSubscriptionID(testTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := twitchhook.SubdomainCallbackURL{Host: "hooks.example.com"}
+
+	for _, base := range []string{"https://hooks.example.com/callback", "https://hooks.example.com:8443/callback"} {
+		u, _ := url.Parse(base)
+		callbackURL, err := b.BuildCallbackURL(u, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// hostnames are case insensitive
+		req := httptest.NewRequest("POST", strings.ToUpper(callbackURL.String()), nil)
+		got, err := b.ExtractSubscriptionID(req)
+		if err != nil || got != id {
+			t.Fatalf("ExtractSubscriptionID(%s) = %s, %v, want %s", req.Host, got, err, id)
+		}
+	}
+
+	for _, host := range []string{"hooks.example.com", "hooks.example.com:8443", "x.other.example.com", "!!.hooks.example.com"} {
+		req := httptest.NewRequest("POST", "https://example.com/callback", nil)
+		req.Host = host
+		if got, err := b.ExtractSubscriptionID(req); err != twitchhook.ErrInvalidSubscriptionID {
+			t.Errorf("ExtractSubscriptionID(%s) = %s, %v, want ErrInvalidSubscriptionID", host, got, err)
+		}
+	}
+}
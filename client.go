@@ -0,0 +1,33 @@
+package twitchhook
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Defaults used by NewHTTPClient
+const (
+	DefaultHTTPTimeout         = 30 * time.Second
+	DefaultDialTimeout         = 10 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// NewHTTPClient returns the default base client for hub requests
+func NewHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: DefaultHTTPTimeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   DefaultDialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   DefaultTLSHandshakeTimeout,
+			ResponseHeaderTimeout: DefaultHTTPTimeout,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConnsPerHost:   10,
+			ForceAttemptHTTP2:     true,
+		},
+	}
+}
@@ -0,0 +1,135 @@
+// Package clocktest provides a fake twitchhook.Clock for deterministic tests.
+package clocktest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// Clock is a fake clock whose time only moves when Advance or Set is called.
+// Timers fire synchronously from the goroutine that moves the clock.
+type Clock struct {
+	m       sync.Mutex
+	now     time.Time
+	timers  []*Timer
+	counter int
+}
+
+// NewClock returns a fake clock set to now
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the fake time
+func (c *Clock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+// AfterFunc schedules f to run once the clock has advanced by d
+func (c *Clock) AfterFunc(d time.Duration, f func()) twitchhook.Timer {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	t := &Timer{c: c, f: f}
+	c.schedule(t, d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing any timers that come due
+func (c *Clock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to now, firing any timers that come due. Moving the
+// clock backwards does not fire anything.
+func (c *Clock) Set(now time.Time) {
+	for {
+		c.m.Lock()
+		t := c.next(now)
+		if t == nil {
+			if now.After(c.now) {
+				c.now = now
+			}
+			c.m.Unlock()
+			return
+		}
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		c.remove(t)
+		c.m.Unlock()
+
+		t.f()
+	}
+}
+
+// Pending returns the number of timers that have not fired or been stopped
+func (c *Clock) Pending() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return len(c.timers)
+}
+
+// schedule must be called with c.m held
+func (c *Clock) schedule(t *Timer, d time.Duration) {
+	c.counter++
+	t.when = c.now.Add(d)
+	t.seq = c.counter
+	c.timers = append(c.timers, t)
+	sort.Slice(c.timers, func(i, j int) bool {
+		if c.timers[i].when.Equal(c.timers[j].when) {
+			return c.timers[i].seq < c.timers[j].seq
+		}
+		return c.timers[i].when.Before(c.timers[j].when)
+	})
+}
+
+// next must be called with c.m held
+func (c *Clock) next(now time.Time) *Timer {
+	if len(c.timers) == 0 || c.timers[0].when.After(now) {
+		return nil
+	}
+	return c.timers[0]
+}
+
+// remove must be called with c.m held
+func (c *Clock) remove(t *Timer) bool {
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Timer is a timer created by a fake Clock
+type Timer struct {
+	c    *Clock
+	f    func()
+	when time.Time
+	seq  int
+}
+
+// Stop prevents the timer from firing, returns false if it already fired or
+// was stopped
+func (t *Timer) Stop() bool {
+	t.c.m.Lock()
+	defer t.c.m.Unlock()
+	return t.c.remove(t)
+}
+
+// Reset reschedules the timer to fire after d, returns whether the timer was
+// still pending
+func (t *Timer) Reset(d time.Duration) bool {
+	t.c.m.Lock()
+	defer t.c.m.Unlock()
+	active := t.c.remove(t)
+	t.c.schedule(t, d)
+	return active
+}
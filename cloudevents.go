@@ -0,0 +1,144 @@
+package twitchhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The CloudEvents version built and its structured mode content type
+const (
+	CloudEventsSpecVersion = "1.0"
+	CloudEventsContentType = "application/cloudevents+json"
+)
+
+// DefaultCloudEventTypePrefix prefixes the types of CloudEvents built from
+// notifications
+const DefaultCloudEventTypePrefix = "tv.twitch"
+
+// CloudEvent is a CloudEvents 1.0 envelope in the structured JSON format
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time,omitzero"`
+	DataContentType string    `json:"datacontenttype,omitempty"`
+
+	// Data is the decoded event, or the raw body when it wasn't decoded
+	Data interface{} `json:"data,omitempty"`
+
+	// DataBase64 carries bodies that aren't valid JSON
+	DataBase64 []byte `json:"data_base64,omitempty"`
+
+	// TwitchSubscriptionID is the id of the subscription the notification
+	// was delivered for
+	TwitchSubscriptionID string `json:"twitchsubscriptionid,omitempty"`
+
+	// TwitchRequestID is the notification's RequestID
+	TwitchRequestID string `json:"twitchrequestid,omitempty"`
+}
+
+// CloudEvents wraps notifications into CloudEvent envelopes
+type CloudEvents struct {
+	// Source overrides the event source, defaults to the notification's
+	// topic
+	Source string
+
+	// TypePrefix defaults to DefaultCloudEventTypePrefix
+	TypePrefix string
+}
+
+// Middleware sets n.Event to the notification's *CloudEvent before calling
+// next, decoding the body with DecodeEvent when no earlier middleware did.
+// Apply it to the NotificationHandler, after middleware such as the helix
+// enricher, so the envelope carries the enriched event.
+func (c *CloudEvents) Middleware() Middleware {
+	return func(next NotificationHandler) NotificationHandler {
+		return NotificationHandlerFunc(func(ctx context.Context, n *Notification) error {
+			if _, ok := n.Event.(*CloudEvent); !ok {
+				n.Event = c.Event(n)
+			}
+			return next.HandleNotification(ctx, n)
+		})
+	}
+}
+
+// Event returns n's envelope. Its id, time and subscription id come from the
+// Twitch-Notification-Id, Twitch-Notification-Timestamp and subscription id
+// headers, its type from the path of the topic, so a follows topic has the
+// type tv.twitch.helix.users.follows.
+func (c *CloudEvents) Event(n *Notification) *CloudEvent {
+	prefix := c.TypePrefix
+	if prefix == "" {
+		prefix = DefaultCloudEventTypePrefix
+	}
+	e := &CloudEvent{
+		SpecVersion:          CloudEventsSpecVersion,
+		ID:                   n.ID,
+		Source:               c.Source,
+		Type:                 cloudEventType(prefix, n.Topic),
+		Time:                 n.Timestamp,
+		TwitchSubscriptionID: string(n.SubscriptionID),
+		TwitchRequestID:      n.RequestID,
+	}
+	if e.Source == "" {
+		e.Source = n.Topic
+	} else {
+		e.Subject = n.Topic
+	}
+	if e.ID == "" {
+		e.ID = string(n.SubscriptionID) + "-" + n.ReceivedAt.Format(time.RFC3339Nano)
+	}
+	if e.Time.IsZero() {
+		e.Time = n.ReceivedAt
+	}
+
+	event := n.Event
+	if event == nil {
+		decoded, err := DecodeEvent(n.Topic, n.Body)
+		if err == nil {
+			event = decoded
+		}
+	}
+	switch {
+	case event != nil:
+		e.DataContentType = "application/json"
+		e.Data = event
+	case json.Valid(n.Body):
+		e.DataContentType = "application/json"
+		e.Data = json.RawMessage(n.Body)
+	case len(n.Body) > 0:
+		e.DataContentType = "application/octet-stream"
+		e.DataBase64 = n.Body
+	}
+	return e
+}
+
+// NewCloudEvent returns n's envelope with the default source and type prefix
+func NewCloudEvent(n *Notification) *CloudEvent {
+	return (&CloudEvents{}).Event(n)
+}
+
+// cloudEventType joins prefix and topic's TopicType with a dot
+func cloudEventType(prefix, topic string) string {
+	t := TopicType(topic)
+	if t == "" {
+		return prefix
+	}
+	return prefix + "." + t
+}
+
+// TopicType returns the segments of topic's path joined by dots, such as
+// helix.users.follows
+func TopicType(topic string) string {
+	u, err := url.Parse(topic)
+	if err != nil {
+		return ""
+	}
+	segments := strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' })
+	return strings.Join(segments, ".")
+}
@@ -0,0 +1,387 @@
+// Command twitchhookctl manages twitch webhook subscriptions.
+//
+// Usage:
+//
+//	twitchhookctl [-config file] [-client-id id] [-client-secret secret] [-dry-run] <command> [flags]
+//
+// Commands:
+//
+//	subscribe    subscribe a callback to a topic
+//	unsubscribe  unsubscribe a callback from a topic
+//	list         list the hub's subscriptions for the client
+//	reconcile    subscribe missing topics from a file, optionally pruning others
+//	serve        run a receiver logging notifications for topics from a file
+//
+// Credentials are read from flags, then the TWITCH_CLIENT_ID and
+// TWITCH_CLIENT_SECRET environment variables, then the config file, a JSON,
+// YAML or TOML twitchhook.Config with TWITCHHOOK_* environment overrides.
+// With -dry-run subscribe and unsubscribe log the requests they would send
+// to the hub. subscribe prints the callback url, and the subscription's
+// secret with -show-secret.
+//
+// For local development serve -tunnel ngrok or -tunnel cloudflared exposes
+// the receiver through a tunnel, subscribes with the tunnel's url as the
+// callback base and unsubscribes when it exits.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/tunnel"
+	"go.uber.org/zap"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, cfg *twitchhook.Config, args []string) error
+}
+
+var commands = []command{
+	{"subscribe", "-topic topic [-callback-base url] [-lease duration] [-show-secret]", subscribe},
+	{"unsubscribe", "-topic topic -callback url", unsubscribe},
+	{"list", "", list},
+	{"reconcile", "-topics file [-callback-base url] [-lease duration] [-prune]", reconcile},
+	{"serve", "-topics file [-addr addr] [-callback-base url] [-lease duration] [-tunnel ngrok|cloudflared]", serve},
+}
+
+func main() {
+	flags := flag.NewFlagSet("twitchhookctl", flag.ExitOnError)
+	configFile := flags.String("config", "", "JSON, YAML or TOML config file")
+	clientID := flags.String("client-id", "", "twitch client id")
+	clientSecret := flags.String("client-secret", "", "twitch client secret")
+	dryRun := flags.Bool("dry-run", false, "log hub requests instead of sending them")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: twitchhookctl [-config file] [-client-id id] [-client-secret secret] [-dry-run] <command> [flags]")
+		fmt.Fprintln(flags.Output(), "\ncommands:")
+		for _, c := range commands {
+			fmt.Fprintf(flags.Output(), "  %s %s\n", c.name, c.usage)
+		}
+		fmt.Fprintln(flags.Output(), "\nflags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fatal(err)
+	}
+	cfg.ClientID = firstNonEmpty(*clientID, os.Getenv("TWITCH_CLIENT_ID"), cfg.ClientID)
+	cfg.ClientSecret = firstNonEmpty(*clientSecret, os.Getenv("TWITCH_CLIENT_SECRET"), cfg.ClientSecret)
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		fatal(fmt.Errorf("client id and secret are required"))
+	}
+	cfg.DryRun = cfg.DryRun || *dryRun
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	name := flags.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			err = c.run(ctx, cfg, flags.Args()[1:])
+			if err != nil {
+				fatal(err)
+			}
+			return
+		}
+	}
+	flags.Usage()
+	os.Exit(2)
+}
+
+func loadConfig(path string) (*twitchhook.Config, error) {
+	if path != "" {
+		return twitchhook.LoadConfig(path)
+	}
+	cfg := &twitchhook.Config{}
+	return cfg, cfg.ApplyEnv()
+}
+
+func newHandler(cfg *twitchhook.Config, logger *zap.Logger) *twitchhook.TwitchWebhookHandler {
+	if cfg.DryRun && !logger.Core().Enabled(zap.InfoLevel) {
+		// the requests are only logged, they can't be dropped
+		logger = zap.NewExample()
+	}
+	h, err := twitchhook.FromConfig(cfg, logger)
+	if err != nil {
+		fatal(err)
+	}
+	return h
+}
+
+// subscriptionFlags registers the flags shared by commands that subscribe
+func subscriptionFlags(flags *flag.FlagSet, cfg *twitchhook.Config) (callbackBase *string, lease *time.Duration) {
+	defaultLease := 24 * time.Hour
+	if cfg.DefaultLease > 0 {
+		defaultLease = time.Duration(cfg.DefaultLease)
+	}
+	callbackBase = flags.String("callback-base", cfg.CallbackBaseURL, "callback base url")
+	lease = flags.Duration("lease", defaultLease, "subscription lease")
+	return callbackBase, lease
+}
+
+func subscribe(ctx context.Context, cfg *twitchhook.Config, args []string) error {
+	flags := flag.NewFlagSet("subscribe", flag.ExitOnError)
+	topic := flags.String("topic", "", "topic to subscribe to")
+	showSecret := flags.Bool("show-secret", false, "print the subscription's secret")
+	callbackBase, lease := subscriptionFlags(flags, cfg)
+	flags.Parse(args)
+
+	h := newHandler(cfg, zap.NewNop())
+	err := h.SubscribeContext(ctx, twitchhook.SubscriptionRequest{
+		Topic:           *topic,
+		CallbackBaseURL: *callbackBase,
+		Lease:           *lease,
+	}, nil)
+	if err != nil || h.DryRun {
+		return err
+	}
+
+	sub, err := h.Manager.Get(*topic)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("callback: %s\n", sub.CallbackURL)
+	if *showSecret {
+		fmt.Printf("secret:   %s\n", sub.Secret)
+	}
+	return nil
+}
+
+func unsubscribe(ctx context.Context, cfg *twitchhook.Config, args []string) error {
+	flags := flag.NewFlagSet("unsubscribe", flag.ExitOnError)
+	topic := flags.String("topic", "", "topic to unsubscribe from")
+	callback := flags.String("callback", "", "subscribed callback url")
+	flags.Parse(args)
+
+	if *topic == "" || *callback == "" {
+		return fmt.Errorf("unsubscribe requires -topic and -callback")
+	}
+	return newHandler(cfg, zap.NewNop()).UnsubscribeCallback(ctx, *topic, *callback)
+}
+
+func list(ctx context.Context, cfg *twitchhook.Config, args []string) error {
+	subs, err := newHandler(cfg, zap.NewNop()).HubSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tCALLBACK\tEXPIRES")
+	for _, sub := range subs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", sub.Topic, sub.Callback, sub.ExpiresAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func reconcile(ctx context.Context, cfg *twitchhook.Config, args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	topicsFile := flags.String("topics", "", "file listing one topic per line")
+	prune := flags.Bool("prune", false, "unsubscribe callbacks under the callback base for topics not in the file")
+	callbackBase, lease := subscriptionFlags(flags, cfg)
+	flags.Parse(args)
+
+	topics, err := readTopics(*topicsFile)
+	if err != nil {
+		return err
+	}
+
+	h := newHandler(cfg, zap.NewNop())
+	existing, err := h.HubSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	subscribed := make(map[string]bool)
+	for _, sub := range existing {
+		if !strings.HasPrefix(sub.Callback, *callbackBase) {
+			continue
+		}
+		if topics[sub.Topic] {
+			subscribed[sub.Topic] = true
+			continue
+		}
+		if *prune {
+			fmt.Printf("unsubscribe %s\n", sub.Topic)
+			err = h.UnsubscribeCallback(ctx, sub.Topic, sub.Callback)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for topic := range topics {
+		if subscribed[topic] {
+			continue
+		}
+		fmt.Printf("subscribe %s\n", topic)
+		err = h.SubscribeContext(ctx, twitchhook.SubscriptionRequest{
+			Topic:           topic,
+			CallbackBaseURL: *callbackBase,
+			Lease:           *lease,
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func serve(ctx context.Context, cfg *twitchhook.Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "listen address")
+	topicsFile := flags.String("topics", "", "file listing one topic per line")
+	tunnelAgent := flags.String("tunnel", "", "expose addr through an ngrok or cloudflared tunnel and use its url as the callback base")
+	callbackBase, lease := subscriptionFlags(flags, cfg)
+	flags.Parse(args)
+
+	topics, err := readTopics(*topicsFile)
+	if err != nil {
+		return err
+	}
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+
+	h := newHandler(cfg, logger)
+	h.NotificationHandler = twitchhook.NotificationHandlerFunc(func(ctx context.Context, n *twitchhook.Notification) error {
+		logger.Info("notification", zap.String("topic", n.Topic), zap.ByteString("body", n.Body))
+		return nil
+	})
+
+	srv := &http.Server{Addr: *addr, Handler: h.SubscriptionCallbackHandler()}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	var tunnelDone <-chan struct{}
+	if *tunnelAgent != "" {
+		tun, err := startTunnel(ctx, *tunnelAgent, *addr, logger)
+		if err != nil {
+			return err
+		}
+		defer tun.Close()
+		tunnelDone = tun.Done()
+		*callbackBase = tun.URL
+		logger.Info("tunnel started", zap.String("agent", *tunnelAgent), zap.String("url", tun.URL))
+	}
+
+	err = h.Start(ctx)
+	if err != nil && !errors.Is(err, twitchhook.ErrNotSupported) {
+		logger.Error("error restoring stored subscriptions", zap.Error(err))
+	}
+
+	for topic := range topics {
+		err = h.SubscribeContext(ctx, twitchhook.SubscriptionRequest{
+			Topic:           topic,
+			CallbackBaseURL: *callbackBase,
+			Lease:           *lease,
+		}, func(reason string) {
+			logger.Error("subscription denied", zap.String("topic", topic), zap.String("reason", reason))
+		})
+		if err != nil {
+			logger.Error("error subscribing", zap.String("topic", topic), zap.Error(err))
+		}
+	}
+
+	select {
+	case err = <-errs:
+		return err
+	case <-tunnelDone:
+		logger.Error("tunnel exited")
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for topic := range topics {
+		err = h.UnsubscribeContext(shutdown, topic)
+		if err != nil {
+			logger.Error("error unsubscribing", zap.String("topic", topic), zap.Error(err))
+		}
+	}
+	return srv.Shutdown(shutdown)
+}
+
+// startTunnel exposes the listen address addr through agent
+func startTunnel(ctx context.Context, agent, addr string, logger *zap.Logger) (*tunnel.Tunnel, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	local := net.JoinHostPort(host, port)
+
+	var cmd *tunnel.Command
+	switch agent {
+	case "ngrok":
+		cmd = tunnel.Ngrok(local)
+	case "cloudflared":
+		cmd = tunnel.Cloudflared(local)
+	default:
+		return nil, fmt.Errorf("unknown tunnel %q, use ngrok or cloudflared", agent)
+	}
+	cmd.Logger = logger
+	return cmd.Start(ctx)
+}
+
+func readTopics(path string) (map[string]bool, error) {
+	if path == "" {
+		return nil, fmt.Errorf("-topics is required")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	topics := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		topics[line] = true
+	}
+	return topics, scanner.Err()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "twitchhookctl:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,254 @@
+// Command twitchhookd is a standalone webhook receiver. It restores the
+// subscriptions held by its storage backend, subscribes the configured
+// topics and forwards every verified notification to a sink url.
+//
+// Usage:
+//
+//	twitchhookd [-config file]
+//
+// Configuration is a JSON, YAML or TOML twitchhook.Config with TWITCHHOOK_*
+// environment overrides, see twitchhook.Config.ApplyEnv. The server section
+// sets the listen address, the TLS certificate and key, reloaded on SIGHUP,
+// or ACME to obtain certificates from Let's Encrypt, an optional separate
+// metrics address and the topics to subscribe. The sink
+// section sets where notifications are posted, they're logged when it's
+// unset, and sink.format "cloudevents" posts them as structured CloudEvents.
+// The bus section replaces it with named sinks and routes from topic
+// patterns to them, see twitchhook.Bus. Bus sinks are http(s) urls posted
+// like the sink section's, or discord:// and slack:// webhook urls. SIGHUP
+// reloads the sink and bus sections from the config file, as does a change
+// to the file when server.config_poll_interval is set, without restarting
+// the listener or touching subscriptions.
+//
+// Callbacks are served under the path of the callback base url, along with
+//
+//	/healthz           liveness check
+//	/readyz            readiness check
+//	/metrics           prometheus metrics, unless server.metrics_addr is set
+//	/debug/twitchhook  handler state when server.debug is set, next to /metrics
+//
+// Subscriptions are left in place on shutdown, use a durable storage DSN so
+// they survive restarts.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	_ "github.com/bsdlp/twitchhook/codec"
+	_ "github.com/bsdlp/twitchhook/etcdstore"
+	_ "github.com/bsdlp/twitchhook/firestorestore"
+	_ "github.com/bsdlp/twitchhook/memcachestore"
+	_ "github.com/bsdlp/twitchhook/mongostore"
+	_ "github.com/bsdlp/twitchhook/sinks/discord"
+	_ "github.com/bsdlp/twitchhook/sinks/slack"
+	"go.uber.org/zap"
+)
+
+// Defaults
+const (
+	defaultAddr            = ":8080"
+	defaultShutdownTimeout = 10 * time.Second
+	defaultLease           = 24 * time.Hour
+)
+
+func main() {
+	configFile := flag.String("config", "", "JSON, YAML or TOML config file")
+	flag.Parse()
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "twitchhookd:", err)
+		os.Exit(1)
+	}
+	defer logger.Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = run(ctx, *configFile, logger)
+	if err != nil {
+		logger.Fatal("twitchhookd exited", zap.Error(err))
+	}
+}
+
+func loadConfig(path string) (*twitchhook.Config, error) {
+	if path != "" {
+		return twitchhook.LoadConfig(path)
+	}
+	cfg := &twitchhook.Config{}
+	return cfg, cfg.ApplyEnv()
+}
+
+func run(ctx context.Context, configFile string, logger *zap.Logger) error {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	loaded := *cfg
+	if cfg.CallbackBaseURL == "" {
+		return errors.New("callback_base_url is required")
+	}
+	callbackBase, err := url.Parse(cfg.CallbackBaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback_base_url: %w", err)
+	}
+	if cfg.DefaultLease == 0 {
+		cfg.DefaultLease = twitchhook.Duration(defaultLease)
+	}
+	// replicas sharing the store reuse their subscriptions instead of
+	// rotating each other's secrets
+	if cfg.Resubscribe == "" {
+		cfg.Resubscribe = twitchhook.ResubscribeReuse
+	}
+
+	h, err := twitchhook.FromConfig(cfg, logger)
+	if err != nil {
+		return err
+	}
+	if closer, ok := h.Manager.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	metrics := &registry{}
+	h.Metrics = metrics
+	notifications := &reloadingHandler{logger: logger, metrics: metrics}
+	err = notifications.apply(cfg)
+	if err != nil {
+		return err
+	}
+	h.NotificationHandler = notifications
+	if configFile != "" {
+		go notifications.watch(ctx, configFile, time.Duration(cfg.Server.ConfigPollInterval), &loaded)
+	}
+
+	callbackPath := strings.TrimSuffix(callbackBase.Path, "/") + "/"
+	mux := http.NewServeMux()
+	mux.Handle(callbackPath, h.SubscriptionCallbackHandler())
+	mux.Handle("/healthz", h.Healthz())
+	mux.Handle("/readyz", h.Readyz())
+
+	var servers []*http.Server
+	metricsMux := mux
+	if cfg.Server.MetricsAddr != "" {
+		metricsMux = http.NewServeMux()
+		servers = append(servers, &http.Server{Addr: cfg.Server.MetricsAddr, Handler: metricsMux})
+	}
+	metricsMux.Handle("/metrics", metrics)
+	if cfg.Server.Debug {
+		// as open as the metrics, the state it serves is redacted
+		metricsMux.Handle("/debug/twitchhook", h.DebugHandler(func(*http.Request) bool { return true }))
+	}
+
+	addr := cfg.Server.Addr
+	if addr == "" && cfg.Server.ACME.Enabled {
+		// tls-alpn-01 challenges arrive on 443
+		addr = ":443"
+	}
+	if addr == "" {
+		addr = defaultAddr
+	}
+	srv := &http.Server{Addr: addr, Handler: mux}
+	var certs *certReloader
+	switch {
+	case cfg.Server.ACME.Enabled:
+		if cfg.Server.TLSCertFile != "" || cfg.Server.TLSKeyFile != "" {
+			return errors.New("acme can't be combined with tls_cert_file and tls_key_file")
+		}
+		acmeManager, err := newACMEManager(cfg.Server.ACME, callbackBase.Hostname())
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = acmeManager.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+		if cfg.Server.ACME.HTTPAddr != "" {
+			servers = append(servers, &http.Server{Addr: cfg.Server.ACME.HTTPAddr, Handler: acmeManager.HTTPHandler(nil)})
+		}
+	case cfg.Server.TLSCertFile != "" || cfg.Server.TLSKeyFile != "":
+		certs, err = newCertReloader(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.GetCertificate}
+		go certs.watch(ctx, logger)
+	}
+	servers = append(servers, srv)
+
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() {
+			logger.Info("listening", zap.String("addr", s.Addr), zap.Bool("tls", s.TLSConfig != nil))
+			var err error
+			if s.TLSConfig != nil {
+				err = s.ListenAndServeTLS("", "")
+			} else {
+				err = s.ListenAndServe()
+			}
+			errs <- err
+		}()
+	}
+
+	// the hub confirms subscriptions through the server, listen before
+	// subscribing
+	go subscribe(ctx, h, cfg.Server.Topics, logger)
+
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+
+	timeout := time.Duration(cfg.Server.ShutdownTimeout)
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, s := range servers {
+		shutdownErr := s.Shutdown(shutdown)
+		if shutdownErr != nil {
+			logger.Error("error shutting down server", zap.String("addr", s.Addr), zap.Error(shutdownErr))
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// subscribe restores the stored subscriptions, then subscribes the topics the
+// Manager doesn't hold
+func subscribe(ctx context.Context, h *twitchhook.TwitchWebhookHandler, topics []string, logger *zap.Logger) {
+	err := h.Start(ctx)
+	if err != nil && !errors.Is(err, twitchhook.ErrNotSupported) {
+		logger.Error("error restoring stored subscriptions", zap.Error(err))
+	}
+
+	for _, topic := range topics {
+		_, err = h.Manager.Get(topic)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, twitchhook.ErrSubscriptionNotFound) {
+			logger.Error("error looking up subscription", zap.String("topic", topic), zap.Error(err))
+			continue
+		}
+		err = h.SubscribeContext(ctx, twitchhook.SubscriptionRequest{Topic: topic}, func(reason string) {
+			logger.Error("subscription denied", zap.String("topic", topic), zap.String("reason", reason))
+		})
+		if err != nil {
+			logger.Error("error subscribing", zap.String("topic", topic), zap.Error(err))
+		}
+	}
+}
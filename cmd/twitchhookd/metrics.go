@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// registry keeps the handler's counters and gauges and serves them in the
+// prometheus text format
+type registry struct {
+	m      sync.Mutex
+	values map[string]*metric
+}
+
+type metric struct {
+	name   string
+	kind   string
+	labels string
+	value  float64
+}
+
+// IncCounter implements twitchhook.Metrics
+func (r *registry) IncCounter(name string, labels ...string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.metric(name, "counter", labels).value++
+}
+
+// SetGauge implements twitchhook.Metrics
+func (r *registry) SetGauge(name string, value float64, labels ...string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.metric(name, "gauge", labels).value = value
+}
+
+// metric returns the series of name with labels, the caller holds r.m
+func (r *registry) metric(name, kind string, labels []string) *metric {
+	formatted := formatLabels(labels)
+	key := name + formatted
+	m, ok := r.values[key]
+	if !ok {
+		if r.values == nil {
+			r.values = make(map[string]*metric)
+		}
+		m = &metric{name: name, kind: kind, labels: formatted}
+		r.values[key] = m
+	}
+	return m
+}
+
+// formatLabels formats name value pairs as {name="value",...}
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"="+strconv.Quote(labels[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// ServeHTTP writes every series, grouped by name
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.m.Lock()
+	series := make([]metric, 0, len(r.values))
+	for _, m := range r.values {
+		series = append(series, *m)
+	}
+	r.m.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		return series[i].labels < series[j].labels
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for i, m := range series {
+		if i == 0 || series[i-1].name != m.name {
+			fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+		}
+		fmt.Fprintf(w, "%s%s %s\n", m.name, m.labels, strconv.FormatFloat(m.value, 'g', -1, 64))
+	}
+}
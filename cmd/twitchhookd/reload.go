@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"go.uber.org/zap"
+)
+
+// reloadingHandler forwards notifications to the sink or bus of the latest
+// config, so they can change without restarting the listener or touching
+// subscriptions
+type reloadingHandler struct {
+	logger  *zap.Logger
+	metrics twitchhook.Metrics
+
+	m       sync.RWMutex
+	handler twitchhook.NotificationHandler
+}
+
+// apply switches to the sink or bus of cfg, keeping the current one when it
+// can't be opened. A bus is reloaded in place, keeping its unchanged sinks.
+func (r *reloadingHandler) apply(cfg *twitchhook.Config) error {
+	r.m.RLock()
+	current := r.handler
+	r.m.RUnlock()
+
+	var next twitchhook.NotificationHandler
+	if len(cfg.Bus.Sinks) > 0 {
+		if bus, ok := current.(*twitchhook.Bus); ok {
+			return bus.Reload(cfg.Bus)
+		}
+		bus, err := twitchhook.OpenBus(cfg.Bus, r.logger)
+		if err != nil {
+			return err
+		}
+		bus.Metrics = r.metrics
+		next = bus
+	} else {
+		var err error
+		next, err = newSink(cfg.Sink, r.logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.handler = next
+	return nil
+}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (r *reloadingHandler) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	r.m.RLock()
+	h := r.handler
+	r.m.RUnlock()
+	return h.HandleNotification(ctx, n)
+}
+
+// watch reloads the config file on SIGHUP, and when its modification time
+// changes if interval is positive, until ctx is done. loaded is the config
+// the daemon started with, changes to other sections than sink and bus are
+// logged as needing a restart.
+func (r *reloadingHandler) watch(ctx context.Context, path string, interval time.Duration, loaded *twitchhook.Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modTime := fileModTime(path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-tick:
+			t := fileModTime(path)
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+		}
+
+		cfg, err := loadConfig(path)
+		if err == nil {
+			err = r.apply(cfg)
+		}
+		if err != nil {
+			r.metrics.IncCounter("twitchhookd_config_reloads_total", "result", "error")
+			r.logger.Error("error reloading config", zap.Error(err))
+			continue
+		}
+		r.metrics.IncCounter("twitchhookd_config_reloads_total", "result", "ok")
+		r.logger.Info("reloaded sink config")
+		if restartRequired(loaded, cfg) {
+			r.logger.Warn("config changes outside the sink and bus sections need a restart")
+		}
+	}
+}
+
+// restartRequired reports whether the configs differ outside the sections
+// reloadingHandler applies
+func restartRequired(a, b *twitchhook.Config) bool {
+	x, y := *a, *b
+	x.Sink, y.Sink = twitchhook.SinkConfig{}, twitchhook.SinkConfig{}
+	x.Bus, y.Bus = twitchhook.BusConfig{}, twitchhook.BusConfig{}
+	return !reflect.DeepEqual(x, y)
+}
+
+func fileModTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"go.uber.org/zap"
+)
+
+func newReloadingHandler() *reloadingHandler {
+	return &reloadingHandler{logger: zap.NewNop(), metrics: &registry{}}
+}
+
+func (r *reloadingHandler) current() twitchhook.NotificationHandler {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.handler
+}
+
+func sinkURL(t *testing.T, h twitchhook.NotificationHandler) string {
+	t.Helper()
+	s, ok := h.(*sink)
+	if !ok {
+		t.Fatalf("handler is a %T, want a sink", h)
+	}
+	return s.url
+}
+
+func TestReloadingHandlerSink(t *testing.T) {
+	r := newReloadingHandler()
+	if err := r.apply(&twitchhook.Config{Sink: twitchhook.SinkConfig{URL: "http://relay.internal/a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.apply(&twitchhook.Config{Sink: twitchhook.SinkConfig{URL: "http://relay.internal/b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if url := sinkURL(t, r.current()); url != "http://relay.internal/b" {
+		t.Fatalf("sink url = %s after a reload", url)
+	}
+
+	// sinks that can't be opened keep the current one
+	if err := r.apply(&twitchhook.Config{Sink: twitchhook.SinkConfig{URL: "http://relay.internal/c", Format: "xml"}}); err == nil {
+		t.Fatal("apply opened a sink with an unknown format")
+	}
+	if url := sinkURL(t, r.current()); url != "http://relay.internal/b" {
+		t.Fatalf("sink url = %s after a failed reload", url)
+	}
+}
+
+func TestReloadingHandlerBus(t *testing.T) {
+	r := newReloadingHandler()
+	cfg := &twitchhook.Config{Bus: twitchhook.BusConfig{
+		Sinks: map[string]twitchhook.BusSinkConfig{
+			"relay":   {URL: "http://relay.internal/notifications"},
+			"discord": {URL: "https://discord.example/webhook"},
+		},
+		Routes: []twitchhook.RouteConfig{{Sinks: []string{"relay", "discord"}}},
+	}}
+	if err := r.apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	bus, ok := r.current().(*twitchhook.Bus)
+	if !ok {
+		t.Fatalf("handler is a %T, want a bus", r.current())
+	}
+	if bus.Metrics == nil {
+		t.Fatal("the bus doesn't report to the daemon's metrics")
+	}
+	relay := bus.Sinks["relay"].Handler
+
+	// the bus is reloaded in place, keeping the sinks whose url didn't change
+	cfg.Bus.Sinks = map[string]twitchhook.BusSinkConfig{
+		"relay":   {URL: "http://relay.internal/notifications", BestEffort: true},
+		"discord": {URL: "https://discord.example/webhook2"},
+	}
+	if err := r.apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if r.current() != bus {
+		t.Fatal("apply replaced the bus instead of reloading it")
+	}
+	if bus.Sinks["relay"].Handler != relay || !bus.Sinks["relay"].BestEffort {
+		t.Fatal("Reload didn't keep the unchanged relay sink with its new config")
+	}
+	if url := sinkURL(t, bus.Sinks["discord"].Handler); url != "https://discord.example/webhook2" {
+		t.Fatalf("discord sink url = %s, want the reloaded url", url)
+	}
+
+	// a failed reload keeps the bus's sinks
+	bad := *cfg
+	bad.Bus.Sinks = map[string]twitchhook.BusSinkConfig{"relay": {URL: "ftp://relay.internal"}}
+	if err := r.apply(&bad); err == nil {
+		t.Fatal("apply opened a sink with an unregistered scheme")
+	}
+	if r.current() != bus || len(bus.Sinks) != 2 {
+		t.Fatal("a failed reload changed the bus")
+	}
+
+	// dropping the bus section switches back to the single sink
+	if err := r.apply(&twitchhook.Config{Sink: twitchhook.SinkConfig{URL: "http://relay.internal/a"}}); err != nil {
+		t.Fatal(err)
+	}
+	sinkURL(t, r.current())
+}
+
+func TestReloadingHandlerWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(url string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(`{"sink": {"url": "`+url+`"}}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modTime := time.Now().Add(-time.Hour)
+	write("http://relay.internal/a", modTime)
+	loaded, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newReloadingHandler()
+	if err := r.apply(loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.watch(ctx, path, time.Millisecond, loaded)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// the file is touched until the change is seen, watch may not have read
+	// its first modification time yet
+	for deadline := time.Now().Add(5 * time.Second); sinkURL(t, r.current()) != "http://relay.internal/b"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("watch didn't reload the changed config file")
+		}
+		modTime = modTime.Add(time.Minute)
+		write("http://relay.internal/b", modTime)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	a := &twitchhook.Config{CallbackBaseURL: "https://example.com/callback"}
+	b := *a
+	b.Sink = twitchhook.SinkConfig{URL: "http://relay.internal"}
+	b.Bus = twitchhook.BusConfig{Sinks: map[string]twitchhook.BusSinkConfig{"relay": {URL: "http://relay.internal"}}}
+	if restartRequired(a, &b) {
+		t.Fatal("sink and bus changes need a restart")
+	}
+	b.Server.Addr = ":8081"
+	if !restartRequired(a, &b) {
+		t.Fatal("a server change doesn't need a restart")
+	}
+}
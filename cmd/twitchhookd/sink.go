@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"go.uber.org/zap"
+)
+
+// Headers sent with forwarded notifications
+const (
+	headerTopic          = "Twitchhook-Topic"
+	headerSubscriptionID = "Twitchhook-Subscription-Id"
+	headerNotificationID = "Twitchhook-Notification-Id"
+	headerTimestamp      = "Twitchhook-Timestamp"
+)
+
+const defaultSinkTimeout = 10 * time.Second
+
+// formatCloudEvents posts structured mode CloudEvents
+const formatCloudEvents = "cloudevents"
+
+// sink posts notification bodies to a url. Sink errors and 5xx responses ask
+// the hub to deliver the notification again, 4xx responses drop it.
+type sink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	// cloudEvents is set when the sink posts CloudEvent envelopes
+	cloudEvents *twitchhook.CloudEvents
+}
+
+func init() {
+	openHTTP := func(rawURL string) (twitchhook.NotificationHandler, error) {
+		return newSink(twitchhook.SinkConfig{URL: rawURL}, nil)
+	}
+	twitchhook.RegisterSink("http", openHTTP)
+	twitchhook.RegisterSink("https", openHTTP)
+}
+
+// newSink returns a handler forwarding to cfg.URL, or logging notifications
+// when it's unset
+func newSink(cfg twitchhook.SinkConfig, logger *zap.Logger) (twitchhook.NotificationHandler, error) {
+	var cloudEvents *twitchhook.CloudEvents
+	switch cfg.Format {
+	case "":
+	case formatCloudEvents:
+		cloudEvents = &twitchhook.CloudEvents{}
+	default:
+		return nil, fmt.Errorf("unknown sink format %q", cfg.Format)
+	}
+
+	if cfg.URL == "" {
+		return twitchhook.NotificationHandlerFunc(func(ctx context.Context, n *twitchhook.Notification) error {
+			logger.Info("notification", zap.String("topic", n.Topic), zap.ByteString("body", n.Body))
+			return nil
+		}), nil
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultSinkTimeout
+	}
+	return &sink{
+		url:         cfg.URL,
+		headers:     cfg.Headers,
+		client:      &http.Client{Timeout: timeout},
+		cloudEvents: cloudEvents,
+	}, nil
+}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (s *sink) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	body, contentType := n.Body, "application/json"
+	if s.cloudEvents != nil {
+		event, ok := n.Event.(*twitchhook.CloudEvent)
+		if !ok {
+			event = s.cloudEvents.Event(n)
+		}
+		var err error
+		body, err = json.Marshal(event)
+		if err != nil {
+			return twitchhook.Reject(fmt.Errorf("encoding cloudevent: %w", err))
+		}
+		contentType = twitchhook.CloudEventsContentType
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return twitchhook.RetryLater(0, err)
+	}
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(headerTopic, n.Topic)
+	req.Header.Set(headerSubscriptionID, string(n.SubscriptionID))
+	req.Header.Set(headerNotificationID, n.ID)
+	if n.RequestID != "" {
+		req.Header.Set(twitchhook.HeaderRequestID, n.RequestID)
+	}
+	if !n.Timestamp.IsZero() {
+		req.Header.Set(headerTimestamp, n.Timestamp.Format(time.RFC3339Nano))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return twitchhook.RetryLater(0, fmt.Errorf("forwarding notification: %w", err))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode < 500:
+		return twitchhook.Reject(fmt.Errorf("sink rejected notification: %s", resp.Status))
+	default:
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return twitchhook.RetryLater(time.Duration(retryAfter)*time.Second, fmt.Errorf("sink failed: %s", resp.Status))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/bsdlp/twitchhook"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certReloader serves a certificate loaded from files, loading them again on
+// SIGHUP so renewed certificates are picked up without a restart
+type certReloader struct {
+	certFile, keyFile string
+
+	m    sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls_cert_file and tls_key_file must both be set")
+	}
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	return c, c.load()
+}
+
+func (c *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.cert, nil
+}
+
+// watch reloads the certificate on SIGHUP until ctx is done, keeping the
+// current one when the files can't be loaded
+func (c *certReloader) watch(ctx context.Context, logger *zap.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		err := c.load()
+		if err != nil {
+			logger.Error("error reloading tls certificate", zap.Error(err))
+			continue
+		}
+		logger.Info("reloaded tls certificate")
+	}
+}
+
+// newACMEManager returns an autocert manager for cfg, issuing certificates for
+// callbackHost when cfg doesn't list hosts
+func newACMEManager(cfg twitchhook.ACMEConfig, callbackHost string) (*autocert.Manager, error) {
+	hosts := cfg.Hosts
+	if len(hosts) == 0 {
+		if callbackHost == "" {
+			return nil, errors.New("acme needs hosts or a callback_base_url host")
+		}
+		hosts = []string{callbackHost}
+	}
+
+	dir := cfg.CacheDir
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("acme cache_dir isn't set: %w", err)
+		}
+		dir = filepath.Join(cache, "twitchhookd", "acme")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(dir),
+		Email:      cfg.Email,
+	}
+	if cfg.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	}
+	return m, nil
+}
@@ -0,0 +1,62 @@
+package twitchhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec serializes subscription records for SubscriptionManagers storing
+// bytes, such as etcdstore and memcachestore. Records only hold a
+// subscription's persistent fields: Renew and DenialCallback can't be
+// serialized and are bound again by topic when a subscription is loaded, see
+// SubscriptionTimers.Attach and CallbackRegistry.
+//
+// Stores don't tag values with their codec, migrate a store to another codec
+// with Export and Import.
+type Codec interface {
+	Marshal(r *SubscriptionRecord) ([]byte, error)
+	Unmarshal(data []byte, r *SubscriptionRecord) error
+}
+
+// JSONCodec encodes records as JSON, it's the default Codec
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(r *SubscriptionRecord) ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (jsonCodec) Unmarshal(data []byte, r *SubscriptionRecord) error {
+	return json.Unmarshal(data, r)
+}
+
+var (
+	codecs   = map[string]Codec{"json": JSONCodec}
+	codecsMu sync.RWMutex
+)
+
+// RegisterCodec makes a Codec available to storage DSNs by name, such as a
+// codec=cbor query parameter. The codec package registers cbor and proto
+// when imported.
+func RegisterCodec(name string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = c
+}
+
+// LookupCodec returns the Codec registered as name, JSONCodec when name is
+// empty
+func LookupCodec(name string) (Codec, error) {
+	if name == "" {
+		return JSONCodec, nil
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("no codec registered as %q", name)
+	}
+	return c, nil
+}
@@ -0,0 +1,105 @@
+// Package codec provides compact twitchhook.Codecs for stores holding many
+// subscriptions, registered as cbor and proto when imported.
+package codec
+
+import (
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/rpc/twitchhookv1"
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func init() {
+	twitchhook.RegisterCodec("cbor", CBOR)
+	twitchhook.RegisterCodec("proto", Proto)
+}
+
+// CBOR encodes records as CBOR maps keyed like their JSON encoding
+var CBOR twitchhook.Codec = newCBORCodec()
+
+// Proto encodes records as twitchhookv1.SubscriptionRecord messages
+var Proto twitchhook.Codec = protoCodec{}
+
+type cborCodec struct {
+	enc cbor.EncMode
+}
+
+func newCBORCodec() cborCodec {
+	enc, err := cbor.EncOptions{
+		Sort: cbor.SortCanonical,
+		Time: cbor.TimeRFC3339Nano,
+	}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return cborCodec{enc: enc}
+}
+
+func (c cborCodec) Marshal(r *twitchhook.SubscriptionRecord) ([]byte, error) {
+	return c.enc.Marshal(r)
+}
+
+func (c cborCodec) Unmarshal(data []byte, r *twitchhook.SubscriptionRecord) error {
+	return cbor.Unmarshal(data, r)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Marshal(r *twitchhook.SubscriptionRecord) ([]byte, error) {
+	pb := &twitchhookv1.SubscriptionRecord{
+		Id:              string(r.ID),
+		Topic:           r.Topic,
+		CallbackBaseUrl: r.CallbackBaseURL,
+		CallbackUrl:     r.CallbackURL,
+		Secret:          r.Secret,
+		SecretRef:       r.SecretRef,
+		Namespace:       r.Namespace,
+		Metadata:        r.Metadata,
+
+		PreviousSecret:    r.PreviousSecret,
+		PreviousSecretRef: r.PreviousSecretRef,
+	}
+	if r.Lease != 0 {
+		pb.Lease = durationpb.New(r.Lease)
+	}
+	if !r.ExpiresAt.IsZero() {
+		pb.ExpiresAt = timestamppb.New(r.ExpiresAt)
+	}
+	if !r.PreviousSecretExpiresAt.IsZero() {
+		pb.PreviousSecretExpiresAt = timestamppb.New(r.PreviousSecretExpiresAt)
+	}
+	return proto.Marshal(pb)
+}
+
+func (protoCodec) Unmarshal(data []byte, r *twitchhook.SubscriptionRecord) error {
+	var pb twitchhookv1.SubscriptionRecord
+	err := proto.Unmarshal(data, &pb)
+	if err != nil {
+		return err
+	}
+	*r = twitchhook.SubscriptionRecord{
+		ID:              twitchhook.SubscriptionID(pb.Id),
+		Topic:           pb.Topic,
+		CallbackBaseURL: pb.CallbackBaseUrl,
+		CallbackURL:     pb.CallbackUrl,
+		Secret:          pb.Secret,
+		SecretRef:       pb.SecretRef,
+		Namespace:       pb.Namespace,
+		Metadata:        pb.Metadata,
+
+		PreviousSecret:    pb.PreviousSecret,
+		PreviousSecretRef: pb.PreviousSecretRef,
+	}
+	if pb.Lease != nil {
+		r.Lease = pb.Lease.AsDuration()
+	}
+	if pb.ExpiresAt != nil {
+		r.ExpiresAt = pb.ExpiresAt.AsTime()
+	}
+	if pb.PreviousSecretExpiresAt != nil {
+		r.PreviousSecretExpiresAt = pb.PreviousSecretExpiresAt.AsTime()
+	}
+	return nil
+}
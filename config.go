@@ -0,0 +1,420 @@
+package twitchhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Config configures a TwitchWebhookHandler deployed as a standalone service
+type Config struct {
+	ClientID        string   `json:"client_id" yaml:"client_id" toml:"client_id"`
+	ClientSecret    string   `json:"client_secret" yaml:"client_secret" toml:"client_secret"`
+	HubURL          string   `json:"hub_url" yaml:"hub_url" toml:"hub_url"`
+	CallbackBaseURL string   `json:"callback_base_url" yaml:"callback_base_url" toml:"callback_base_url"`
+	DefaultLease    Duration `json:"default_lease" yaml:"default_lease" toml:"default_lease"`
+
+	// Resubscribe is the handler's ResubscribePolicy
+	Resubscribe ResubscribePolicy `json:"resubscribe" yaml:"resubscribe" toml:"resubscribe"`
+
+	// DryRun sets the handler's DryRun
+	DryRun bool `json:"dry_run" yaml:"dry_run" toml:"dry_run"`
+
+	Storage StorageConfig `json:"storage" yaml:"storage" toml:"storage"`
+	Limits  LimitsConfig  `json:"limits" yaml:"limits" toml:"limits"`
+	Sources SourcesConfig `json:"sources" yaml:"sources" toml:"sources"`
+	Secrets SecretsConfig `json:"secrets" yaml:"secrets" toml:"secrets"`
+	Server  ServerConfig  `json:"server" yaml:"server" toml:"server"`
+	Sink    SinkConfig    `json:"sink" yaml:"sink" toml:"sink"`
+
+	// Bus routes notifications to several sinks, see OpenBus
+	Bus BusConfig `json:"bus" yaml:"bus" toml:"bus"`
+}
+
+// ServerConfig configures the listener of a standalone receiver such as
+// twitchhookd
+type ServerConfig struct {
+	Addr string `json:"addr" yaml:"addr" toml:"addr"`
+
+	// MetricsAddr serves metrics on a separate listener when it's set
+	MetricsAddr string `json:"metrics_addr" yaml:"metrics_addr" toml:"metrics_addr"`
+
+	// Debug serves the handler's DebugState at /debug/twitchhook, next to
+	// the metrics
+	Debug bool `json:"debug" yaml:"debug" toml:"debug"`
+
+	// TLSCertFile and TLSKeyFile terminate TLS when both are set
+	TLSCertFile string `json:"tls_cert_file" yaml:"tls_cert_file" toml:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file" yaml:"tls_key_file" toml:"tls_key_file"`
+
+	// ACME obtains certificates automatically instead of reading them from
+	// TLSCertFile and TLSKeyFile
+	ACME ACMEConfig `json:"acme" yaml:"acme" toml:"acme"`
+
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+
+	// Topics are subscribed on startup unless the Manager holds them
+	Topics []string `json:"topics" yaml:"topics" toml:"topics"`
+
+	// ConfigPollInterval is how often the config file is checked for
+	// changes to the sink and bus sections, which are applied without a
+	// restart. The file isn't polled when it's zero, SIGHUP reloads it too.
+	ConfigPollInterval Duration `json:"config_poll_interval" yaml:"config_poll_interval" toml:"config_poll_interval"`
+}
+
+// ACMEConfig obtains TLS certificates from Let's Encrypt or another ACME
+// directory. Challenges are answered with tls-alpn-01 on the server address,
+// which must be reachable on port 443, and http-01 on HTTPAddr when it's set.
+type ACMEConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+
+	// Hosts certificates are issued for, defaults to the host of the
+	// callback base url
+	Hosts []string `json:"hosts" yaml:"hosts" toml:"hosts"`
+
+	// Email is given to the ACME directory for expiry notices
+	Email string `json:"email" yaml:"email" toml:"email"`
+
+	// CacheDir keeps certificates and the account key across restarts,
+	// defaults to a directory in the user cache directory
+	CacheDir string `json:"cache_dir" yaml:"cache_dir" toml:"cache_dir"`
+
+	// DirectoryURL defaults to Let's Encrypt's production directory
+	DirectoryURL string `json:"directory_url" yaml:"directory_url" toml:"directory_url"`
+
+	// HTTPAddr serves http-01 challenges and redirects other requests to
+	// https when it's set, such as ":80"
+	HTTPAddr string `json:"http_addr" yaml:"http_addr" toml:"http_addr"`
+}
+
+// SinkConfig forwards notifications to an http endpoint
+type SinkConfig struct {
+	URL     string            `json:"url" yaml:"url" toml:"url"`
+	Headers map[string]string `json:"headers" yaml:"headers" toml:"headers"`
+	Timeout Duration          `json:"timeout" yaml:"timeout" toml:"timeout"`
+
+	// Format is "cloudevents" to post CloudEvent envelopes rather than
+	// notification bodies
+	Format string `json:"format" yaml:"format" toml:"format"`
+}
+
+// SecretsConfig controls generated subscription secrets
+type SecretsConfig struct {
+	Bytes    int            `json:"bytes" yaml:"bytes" toml:"bytes"`
+	Encoding SecretEncoding `json:"encoding" yaml:"encoding" toml:"encoding"`
+
+	// Audit is the handler's SecretAuditPolicy
+	Audit SecretAuditPolicy `json:"audit" yaml:"audit" toml:"audit"`
+
+	// Overlap is the handler's SecretOverlap
+	Overlap Duration `json:"overlap" yaml:"overlap" toml:"overlap"`
+}
+
+// SourcesConfig restricts which addresses may post notifications, no
+// filtering is done when Allowed is empty
+type SourcesConfig struct {
+	Allowed        []string `json:"allowed" yaml:"allowed" toml:"allowed"`
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// StorageConfig picks the SubscriptionManager
+type StorageConfig struct {
+	// DSN is a url whose scheme names a registered manager, see
+	// RegisterManager. Defaults to memory://, memory://?max_size=N caps the
+	// in-memory cache at N subscriptions and memory://?shards=N spreads it
+	// over N shards
+	DSN string `json:"dsn" yaml:"dsn" toml:"dsn"`
+}
+
+// LimitsConfig bounds requests handled by the handler
+type LimitsConfig struct {
+	MaxNotificationAge   Duration `json:"max_notification_age" yaml:"max_notification_age" toml:"max_notification_age"`
+	MaxNotificationBytes int64    `json:"max_notification_bytes" yaml:"max_notification_bytes" toml:"max_notification_bytes"`
+	HTTPTimeout          Duration `json:"http_timeout" yaml:"http_timeout" toml:"http_timeout"`
+
+	// HandlerTimeout and HandlerDeadline bound notification handlers, see
+	// TwitchWebhookHandler
+	HandlerTimeout  Duration `json:"handler_timeout" yaml:"handler_timeout" toml:"handler_timeout"`
+	HandlerDeadline Duration `json:"handler_deadline" yaml:"handler_deadline" toml:"handler_deadline"`
+
+	// SubscriptionSoftLimit, SubscriptionHardLimit and QueueSubscriptions
+	// set up the handler's Quota when either limit is set
+	SubscriptionSoftLimit int  `json:"subscription_soft_limit" yaml:"subscription_soft_limit" toml:"subscription_soft_limit"`
+	SubscriptionHardLimit int  `json:"subscription_hard_limit" yaml:"subscription_hard_limit" toml:"subscription_hard_limit"`
+	QueueSubscriptions    bool `json:"queue_subscriptions" yaml:"queue_subscriptions" toml:"queue_subscriptions"`
+}
+
+// Duration is a time.Duration written as a string such as "24h" in config
+// files
+type Duration time.Duration
+
+// UnmarshalText parses a duration string
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText formats the duration
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// LoadConfig reads a JSON, YAML or TOML config file, picked by extension, and
+// applies environment overrides
+func LoadConfig(path string) (*Config, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(bs, cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bs, cfg)
+	case ".toml":
+		err = toml.Unmarshal(bs, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	err = cfg.ApplyEnv()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides fields with the TWITCHHOOK_* environment variables that
+// are set: CLIENT_ID, CLIENT_SECRET, HUB_URL, CALLBACK_BASE_URL,
+// DEFAULT_LEASE, STORAGE_DSN, MAX_NOTIFICATION_AGE, MAX_NOTIFICATION_BYTES,
+// HTTP_TIMEOUT, DRY_RUN, SERVER_ADDR, METRICS_ADDR, TLS_CERT_FILE,
+// TLS_KEY_FILE, ACME_ENABLED, ACME_EMAIL, ACME_CACHE_DIR, SINK_URL,
+// SINK_FORMAT and TOPICS, a comma separated list
+func (c *Config) ApplyEnv() error {
+	strs := map[string]*string{
+		"TWITCHHOOK_CLIENT_ID":         &c.ClientID,
+		"TWITCHHOOK_CLIENT_SECRET":     &c.ClientSecret,
+		"TWITCHHOOK_HUB_URL":           &c.HubURL,
+		"TWITCHHOOK_CALLBACK_BASE_URL": &c.CallbackBaseURL,
+		"TWITCHHOOK_STORAGE_DSN":       &c.Storage.DSN,
+		"TWITCHHOOK_SERVER_ADDR":       &c.Server.Addr,
+		"TWITCHHOOK_METRICS_ADDR":      &c.Server.MetricsAddr,
+		"TWITCHHOOK_TLS_CERT_FILE":     &c.Server.TLSCertFile,
+		"TWITCHHOOK_TLS_KEY_FILE":      &c.Server.TLSKeyFile,
+		"TWITCHHOOK_ACME_EMAIL":        &c.Server.ACME.Email,
+		"TWITCHHOOK_ACME_CACHE_DIR":    &c.Server.ACME.CacheDir,
+		"TWITCHHOOK_SINK_URL":          &c.Sink.URL,
+		"TWITCHHOOK_SINK_FORMAT":       &c.Sink.Format,
+	}
+	for key, field := range strs {
+		if v, ok := os.LookupEnv(key); ok {
+			*field = v
+		}
+	}
+
+	durations := map[string]*Duration{
+		"TWITCHHOOK_DEFAULT_LEASE":        &c.DefaultLease,
+		"TWITCHHOOK_MAX_NOTIFICATION_AGE": &c.Limits.MaxNotificationAge,
+		"TWITCHHOOK_HTTP_TIMEOUT":         &c.Limits.HTTPTimeout,
+		"TWITCHHOOK_HANDLER_TIMEOUT":      &c.Limits.HandlerTimeout,
+		"TWITCHHOOK_HANDLER_DEADLINE":     &c.Limits.HandlerDeadline,
+		"TWITCHHOOK_SECRET_OVERLAP":       &c.Secrets.Overlap,
+	}
+	for key, field := range durations {
+		if v, ok := os.LookupEnv(key); ok {
+			err := field.UnmarshalText([]byte(v))
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	if v, ok := os.LookupEnv("TWITCHHOOK_MAX_NOTIFICATION_BYTES"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("TWITCHHOOK_MAX_NOTIFICATION_BYTES: %w", err)
+		}
+		c.Limits.MaxNotificationBytes = n
+	}
+
+	bools := map[string]*bool{
+		"TWITCHHOOK_DRY_RUN":      &c.DryRun,
+		"TWITCHHOOK_ACME_ENABLED": &c.Server.ACME.Enabled,
+	}
+	for key, field := range bools {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			*field = b
+		}
+	}
+
+	if v, ok := os.LookupEnv("TWITCHHOOK_TOPICS"); ok {
+		c.Server.Topics = nil
+		for _, topic := range strings.Split(v, ",") {
+			topic = strings.TrimSpace(topic)
+			if topic != "" {
+				c.Server.Topics = append(c.Server.Topics, topic)
+			}
+		}
+	}
+	return nil
+}
+
+// ManagerFactory opens a SubscriptionManager from a storage DSN
+type ManagerFactory func(dsn string) (SubscriptionManager, error)
+
+var (
+	managerFactories   = map[string]ManagerFactory{}
+	managerFactoriesMu sync.RWMutex
+)
+
+// RegisterManager makes a SubscriptionManager available to Config storage
+// DSNs with the given url scheme. Storage packages register themselves when
+// imported.
+func RegisterManager(scheme string, factory ManagerFactory) {
+	managerFactoriesMu.Lock()
+	defer managerFactoriesMu.Unlock()
+	managerFactories[scheme] = factory
+}
+
+func init() {
+	RegisterManager("memory", openInMemoryCache)
+}
+
+// openInMemoryCache opens memory:// DSNs, a max_size query parameter limits
+// the cache's size and a shards parameter opens a ShardedCache
+func openInMemoryCache(dsn string) (SubscriptionManager, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	maxSize, err := dsnInt(q, "max_size")
+	if err != nil {
+		return nil, err
+	}
+	shards, err := dsnInt(q, "shards")
+	if err != nil {
+		return nil, err
+	}
+	if shards > 0 {
+		return &ShardedCache{Shards: shards, MaxSize: maxSize}, nil
+	}
+	return NewLRUCache(nil, maxSize, nil), nil
+}
+
+func dsnInt(q url.Values, name string) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+// OpenManager opens the SubscriptionManager registered for the DSN's scheme
+func OpenManager(dsn string) (SubscriptionManager, error) {
+	if dsn == "" {
+		dsn = "memory://"
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage dsn: %w", err)
+	}
+
+	managerFactoriesMu.RLock()
+	factory, ok := managerFactories[u.Scheme]
+	managerFactoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no subscription manager registered for %q", u.Scheme)
+	}
+	return factory(dsn)
+}
+
+// FromConfig builds a handler from a Config
+func FromConfig(cfg *Config, logger *zap.Logger) (*TwitchWebhookHandler, error) {
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, fmt.Errorf("client id and secret are required")
+	}
+
+	manager, err := OpenManager(cfg.Storage.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &TwitchWebhookHandler{
+		Manager:              manager,
+		OAuth2ClientID:       cfg.ClientID,
+		OAuth2ClientSecret:   cfg.ClientSecret,
+		HubURL:               cfg.HubURL,
+		CallbackBaseURL:      cfg.CallbackBaseURL,
+		DefaultLease:         time.Duration(cfg.DefaultLease),
+		MaxNotificationAge:   time.Duration(cfg.Limits.MaxNotificationAge),
+		MaxNotificationBytes: cfg.Limits.MaxNotificationBytes,
+		HandlerTimeout:       time.Duration(cfg.Limits.HandlerTimeout),
+		HandlerDeadline:      time.Duration(cfg.Limits.HandlerDeadline),
+		SecretBytes:          cfg.Secrets.Bytes,
+		SecretEncoding:       cfg.Secrets.Encoding,
+		Resubscribe:          cfg.Resubscribe,
+		SecretAudit:          cfg.Secrets.Audit,
+		SecretOverlap:        time.Duration(cfg.Secrets.Overlap),
+		DryRun:               cfg.DryRun,
+		Logger:               logger,
+	}
+	if cfg.Limits.SubscriptionSoftLimit > 0 || cfg.Limits.SubscriptionHardLimit > 0 {
+		h.Quota = &Quota{
+			SoftLimit: cfg.Limits.SubscriptionSoftLimit,
+			HardLimit: cfg.Limits.SubscriptionHardLimit,
+			Queue:     cfg.Limits.QueueSubscriptions,
+		}
+	}
+	if cfg.Limits.HTTPTimeout > 0 {
+		h.HTTPClient = NewHTTPClient()
+		h.HTTPClient.Timeout = time.Duration(cfg.Limits.HTTPTimeout)
+	}
+	err = h.checkSecretOptions()
+	if err != nil {
+		return nil, err
+	}
+	err = h.Resubscribe.check()
+	if err != nil {
+		return nil, err
+	}
+	err = h.SecretAudit.check()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Sources.Allowed) > 0 {
+		h.SourceAllowlist, err = NewIPAllowlist(cfg.Sources.Allowed, cfg.Sources.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
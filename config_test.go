@@ -0,0 +1,187 @@
+package twitchhook_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// configFiles are the same config in every supported format
+var configFiles = map[string]string{
+	"config.json": `{
+	"client_id": "client",
+	"callback_base_url": "https://example.com/callback",
+	"default_lease": "24h",
+	"limits": {"handler_timeout": "1m30s", "max_notification_bytes": 1024},
+	"server": {"addr": ":8080", "topics": ["` + testTopic + `"]},
+	"bus": {
+		"sinks": {"relay": {"url": "http://relay.internal", "retry": {"attempts": 3, "backoff": "250ms"}}},
+		"routes": [{"topics": ["helix.streams"], "sinks": ["relay"], "where": {"type": ["live"]}}]
+	}
+}`,
+	"config.yaml": `
+client_id: client
+callback_base_url: https://example.com/callback
+default_lease: 24h
+limits:
+  handler_timeout: 1m30s
+  max_notification_bytes: 1024
+server:
+  addr: ":8080"
+  topics: ["` + testTopic + `"]
+bus:
+  sinks:
+    relay:
+      url: http://relay.internal
+      retry: {attempts: 3, backoff: 250ms}
+  routes:
+    - topics: [helix.streams]
+      sinks: [relay]
+      where: {type: [live]}
+`,
+	"config.toml": `
+client_id = "client"
+callback_base_url = "https://example.com/callback"
+default_lease = "24h"
+
+[limits]
+handler_timeout = "1m30s"
+max_notification_bytes = 1024
+
+[server]
+addr = ":8080"
+topics = ["` + testTopic + `"]
+
+[bus.sinks.relay]
+url = "http://relay.internal"
+retry = {attempts = 3, backoff = "250ms"}
+
+[[bus.routes]]
+topics = ["helix.streams"]
+sinks = ["relay"]
+where = {type = ["live"]}
+`,
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	want := &twitchhook.Config{
+		ClientID:        "client",
+		CallbackBaseURL: "https://example.com/callback",
+		DefaultLease:    twitchhook.Duration(24 * time.Hour),
+		Limits: twitchhook.LimitsConfig{
+			HandlerTimeout:       twitchhook.Duration(90 * time.Second),
+			MaxNotificationBytes: 1024,
+		},
+		Server: twitchhook.ServerConfig{Addr: ":8080", Topics: []string{testTopic}},
+		Bus: twitchhook.BusConfig{
+			Sinks: map[string]twitchhook.BusSinkConfig{
+				"relay": {URL: "http://relay.internal", Retry: twitchhook.RetryPolicy{Attempts: 3, Backoff: twitchhook.Duration(250 * time.Millisecond)}},
+			},
+			Routes: []twitchhook.RouteConfig{{Topics: []string{"helix.streams"}, Sinks: []string{"relay"}, Where: map[string][]string{"type": {"live"}}}},
+		},
+	}
+	for name, content := range configFiles {
+		cfg, err := twitchhook.LoadConfig(writeConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("LoadConfig(%s): %v", name, err)
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("LoadConfig(%s) = %+v, want %+v", name, cfg, want)
+		}
+	}
+
+	// the environment overrides the file
+	t.Setenv("TWITCHHOOK_DEFAULT_LEASE", "1h")
+	cfg, err := twitchhook.LoadConfig(writeConfig(t, "config.yml", configFiles["config.yaml"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DefaultLease != twitchhook.Duration(time.Hour) {
+		t.Fatalf("DefaultLease = %s, want the environment's", time.Duration(cfg.DefaultLease))
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.ini":  "client_id = client",
+		"config.json": `{"default_lease": "a day"}`,
+		"config.yaml": "default_lease: 10",
+		"config.toml": `limits = {handler_timeout = "1 minute"}`,
+	} {
+		if _, err := twitchhook.LoadConfig(writeConfig(t, name, content)); err == nil {
+			t.Errorf("LoadConfig(%s) of %q succeeded", name, content)
+		}
+	}
+	if _, err := twitchhook.LoadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadConfig of a missing file succeeded")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("TWITCHHOOK_CLIENT_ID", "env-client")
+	t.Setenv("TWITCHHOOK_STORAGE_DSN", "memory://?max_size=10")
+	t.Setenv("TWITCHHOOK_HANDLER_DEADLINE", "2m")
+	t.Setenv("TWITCHHOOK_SECRET_OVERLAP", "90s")
+	t.Setenv("TWITCHHOOK_MAX_NOTIFICATION_BYTES", "2048")
+	t.Setenv("TWITCHHOOK_DRY_RUN", "true")
+	t.Setenv("TWITCHHOOK_TOPICS", testTopic+", ,https://api.twitch.tv/helix/users?id=1")
+
+	cfg := &twitchhook.Config{ClientID: "file-client", ClientSecret: "file-secret", Server: twitchhook.ServerConfig{Topics: []string{"replaced"}}}
+	if err := cfg.ApplyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	want := &twitchhook.Config{
+		ClientID:     "env-client",
+		ClientSecret: "file-secret",
+		DryRun:       true,
+		Storage:      twitchhook.StorageConfig{DSN: "memory://?max_size=10"},
+		Limits:       twitchhook.LimitsConfig{HandlerDeadline: twitchhook.Duration(2 * time.Minute), MaxNotificationBytes: 2048},
+		Secrets:      twitchhook.SecretsConfig{Overlap: twitchhook.Duration(90 * time.Second)},
+		Server:       twitchhook.ServerConfig{Topics: []string{testTopic, "https://api.twitch.tv/helix/users?id=1"}},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("ApplyEnv = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	for key, value := range map[string]string{
+		"TWITCHHOOK_HTTP_TIMEOUT":           "30",
+		"TWITCHHOOK_MAX_NOTIFICATION_BYTES": "1kb",
+		"TWITCHHOOK_ACME_ENABLED":           "yes please",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if err := (&twitchhook.Config{}).ApplyEnv(); err == nil {
+				t.Fatalf("ApplyEnv with %s=%s succeeded", key, value)
+			}
+		})
+	}
+}
+
+func TestDuration(t *testing.T) {
+	var d twitchhook.Duration
+	if err := d.UnmarshalText([]byte("1h30m")); err != nil || d != twitchhook.Duration(90*time.Minute) {
+		t.Fatalf("UnmarshalText(1h30m) = %s, %v", time.Duration(d), err)
+	}
+	text, err := d.MarshalText()
+	if err != nil || string(text) != "1h30m0s" {
+		t.Fatalf("MarshalText = %s, %v", text, err)
+	}
+	if err := d.UnmarshalText([]byte("forever")); err == nil {
+		t.Fatal("UnmarshalText(forever) succeeded")
+	}
+}
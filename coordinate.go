@@ -0,0 +1,126 @@
+package twitchhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultRenewalClaimTTL is how long a renewal claim is held when
+// RenewalClaimTTL isn't set
+const DefaultRenewalClaimTTL = 5 * time.Minute
+
+// RenewalCoordinator elects a single instance to renew each subscription when
+// several instances share a SubscriptionManager. See the sqlstore package for
+// a coordinator backed by a leases table.
+type RenewalCoordinator interface {
+	// Acquire claims the renewal of topic for ttl and reports whether this
+	// instance holds the claim. The holder may acquire again to extend it.
+	Acquire(ctx context.Context, topic string, ttl time.Duration) (bool, error)
+
+	// Release gives up a claim held by this instance
+	Release(ctx context.Context, topic string) error
+}
+
+// InMemoryRenewalCoordinator is a RenewalCoordinator for instances sharing a
+// process, each instance uses its own Owner
+type InMemoryRenewalCoordinator struct {
+	Clock Clock
+
+	claims map[string]claim
+	m      sync.Mutex
+}
+
+type claim struct {
+	owner     string
+	expiresAt time.Time
+}
+
+// For returns a RenewalCoordinator claiming renewals as owner
+func (c *InMemoryRenewalCoordinator) For(owner string) RenewalCoordinator {
+	return &inMemoryOwner{c: c, owner: owner}
+}
+
+type inMemoryOwner struct {
+	c     *InMemoryRenewalCoordinator
+	owner string
+}
+
+func (o *inMemoryOwner) Acquire(ctx context.Context, topic string, ttl time.Duration) (bool, error) {
+	o.c.m.Lock()
+	defer o.c.m.Unlock()
+
+	now := clockOrDefault(o.c.Clock).Now()
+	if o.c.claims == nil {
+		o.c.claims = make(map[string]claim)
+	}
+	current, ok := o.c.claims[topic]
+	if ok && current.owner != o.owner && now.Before(current.expiresAt) {
+		return false, nil
+	}
+	o.c.claims[topic] = claim{owner: o.owner, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (o *inMemoryOwner) Release(ctx context.Context, topic string) error {
+	o.c.m.Lock()
+	defer o.c.m.Unlock()
+
+	if current, ok := o.c.claims[topic]; ok && current.owner == o.owner {
+		delete(o.c.claims, topic)
+	}
+	return nil
+}
+
+func (m *TwitchWebhookHandler) renewalClaimTTL() time.Duration {
+	if m.RenewalClaimTTL > 0 {
+		return m.RenewalClaimTTL
+	}
+	return DefaultRenewalClaimTTL
+}
+
+// coordinatedRenew renews the subscription if this instance wins the renewal
+// claim. Otherwise it checks back once the claim has lapsed and takes over if
+// the instance holding it didn't renew.
+func (m *TwitchWebhookHandler) coordinatedRenew(request SubscriptionRequest, denialCallback func(reason string)) {
+	renew := func() {
+		err := m.protect("renew", func() error {
+			return m.subscribe(context.Background(), request, denialCallback, true)
+		})
+		if err != nil {
+			m.logger().Error("unable to renew webhook subscription", zap.String("topic", request.Topic), zap.Error(err))
+			m.hookError("renew", err)
+			m.retryRenewal(request, denialCallback, 1, err)
+		}
+	}
+
+	if m.Coordinator == nil {
+		renew()
+		return
+	}
+
+	ttl := m.renewalClaimTTL()
+	acquired, err := m.Coordinator.Acquire(context.Background(), request.Topic, ttl)
+	if err != nil {
+		m.logger().Error("error claiming subscription renewal", zap.String("topic", request.Topic), zap.Error(err))
+	}
+	if acquired {
+		renew()
+		return
+	}
+
+	var before time.Time
+	if sub, err := m.getSubscription(request.Topic); err == nil {
+		before = sub.ExpiresAt
+	}
+	clockOrDefault(m.Clock).AfterFunc(ttl, func() {
+		sub, err := m.getSubscription(request.Topic)
+		if err == nil && !sub.ExpiresAt.Equal(before) {
+			// the claim holder renewed
+			return
+		}
+		m.coordinatedRenew(request, denialCallback)
+	})
+}
@@ -0,0 +1,342 @@
+package twitchhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultDeadLetterRetries is the hub retry count from which notifications
+// handlers asked to be redelivered are dead-lettered instead
+const DefaultDeadLetterRetries = 2
+
+// ErrDeadLetterNotFound is returned by DeadLetterStores for unknown ids
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a verified notification its handler failed to process
+type DeadLetter struct {
+	ID             string         `json:"id"`
+	Topic          string         `json:"topic"`
+	SubscriptionID SubscriptionID `json:"subscription_id,omitempty"`
+	NotificationID string         `json:"notification_id,omitempty"`
+	ReceivedAt     time.Time      `json:"received_at"`
+	Header         http.Header    `json:"header"`
+	Body           []byte         `json:"body"`
+
+	// FailedAt and Error describe the last failure, Panic is set when the
+	// handler panicked
+	FailedAt time.Time `json:"failed_at"`
+	Error    string    `json:"error"`
+	Panic    bool      `json:"panic,omitempty"`
+
+	// Retry is the hub's retry count of the failed delivery, Redrives counts
+	// the failed redrives since
+	Retry    int `json:"retry,omitempty"`
+	Redrives int `json:"redrives,omitempty"`
+}
+
+// DeadLetterStore persists dead letters so they can be inspected and
+// redriven, see the sqlstore package for a table backed store
+type DeadLetterStore interface {
+	// Put adds or replaces the letter with l's ID
+	Put(ctx context.Context, l *DeadLetter) error
+	Get(ctx context.Context, id string) (*DeadLetter, error)
+	// List returns every letter ordered by failure time
+	List(ctx context.Context) ([]DeadLetter, error)
+	Remove(ctx context.Context, id string) error
+}
+
+// InMemoryDeadLetters is a DeadLetterStore that doesn't survive restarts
+type InMemoryDeadLetters struct {
+	m       sync.Mutex
+	letters map[string]DeadLetter
+}
+
+// Put adds or replaces the letter with l's ID
+func (s *InMemoryDeadLetters) Put(ctx context.Context, l *DeadLetter) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.letters == nil {
+		s.letters = make(map[string]DeadLetter)
+	}
+	s.letters[l.ID] = *l
+	return nil
+}
+
+// Get returns the letter id
+func (s *InMemoryDeadLetters) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	l, ok := s.letters[id]
+	if !ok {
+		return nil, ErrDeadLetterNotFound
+	}
+	return &l, nil
+}
+
+// List returns every letter ordered by failure time
+func (s *InMemoryDeadLetters) List(ctx context.Context) ([]DeadLetter, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for _, l := range s.letters {
+		letters = append(letters, l)
+	}
+	SortDeadLetters(letters)
+	return letters, nil
+}
+
+// Remove removes the letter id
+func (s *InMemoryDeadLetters) Remove(ctx context.Context, id string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.letters, id)
+	return nil
+}
+
+// DirDeadLetters is a DeadLetterStore keeping each letter in a json file
+// named after its id under Dir, writes replace files atomically
+type DirDeadLetters struct {
+	Dir string
+}
+
+var deadLetterID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (s *DirDeadLetters) path(id string) (string, error) {
+	if !deadLetterID.MatchString(id) {
+		return "", fmt.Errorf("invalid dead letter id %q", id)
+	}
+	return filepath.Join(s.Dir, id+".json"), nil
+}
+
+// Put adds or replaces the letter with l's ID
+func (s *DirDeadLetters) Put(ctx context.Context, l *DeadLetter) error {
+	p, err := s.path(l.ID)
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(s.Dir, 0700)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, bs)
+}
+
+// Get returns the letter id
+func (s *DirDeadLetters) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	p, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l DeadLetter
+	err = json.Unmarshal(bs, &l)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// List returns every letter ordered by failure time
+func (s *DirDeadLetters) List(ctx context.Context) ([]DeadLetter, error) {
+	entries, err := ioutil.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var letters []DeadLetter
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		l, err := s.Get(ctx, strings.TrimSuffix(name, ".json"))
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			// redriven while listing
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, *l)
+	}
+	SortDeadLetters(letters)
+	return letters, nil
+}
+
+// Remove removes the letter id
+func (s *DirDeadLetters) Remove(ctx context.Context, id string) error {
+	p, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// SortDeadLetters orders letters by failure time, then id
+func SortDeadLetters(letters []DeadLetter) {
+	sort.Slice(letters, func(i, j int) bool {
+		if !letters[i].FailedAt.Equal(letters[j].FailedAt) {
+			return letters[i].FailedAt.Before(letters[j].FailedAt)
+		}
+		return letters[i].ID < letters[j].ID
+	})
+}
+
+func newDeadLetterID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (m *TwitchWebhookHandler) deadLetterRetries() int {
+	if m.DeadLetterRetries <= 0 {
+		return DefaultDeadLetterRetries
+	}
+	return m.DeadLetterRetries
+}
+
+// deadLetter stores n after its handler failed with cause, reporting
+// whether it was stored
+func (m *TwitchWebhookHandler) deadLetter(ctx context.Context, n *Notification, cause error) bool {
+	if m.DeadLetters == nil {
+		return false
+	}
+
+	var panicErr *PanicError
+	l := &DeadLetter{
+		ID:             newDeadLetterID(),
+		Topic:          n.Topic,
+		SubscriptionID: n.SubscriptionID,
+		NotificationID: n.ID,
+		ReceivedAt:     n.ReceivedAt,
+		Header:         n.Header.Clone(),
+		Body:           n.Body,
+		FailedAt:       clockOrDefault(m.Clock).Now(),
+		Error:          cause.Error(),
+		Panic:          errors.As(cause, &panicErr),
+		Retry:          n.Retry,
+	}
+	if n.RequestID != "" {
+		// kept with the headers so redrives can be traced to the delivery
+		if l.Header == nil {
+			l.Header = make(http.Header)
+		}
+		l.Header.Set(m.requestIDHeader(), n.RequestID)
+	}
+	logger := m.requestLogger(ctx)
+	err := m.DeadLetters.Put(context.WithoutCancel(ctx), l)
+	if err != nil {
+		logger.Error("error storing dead letter", zap.String("topic", n.Topic), zap.Error(err))
+		m.hookError("dead letter", err)
+		return false
+	}
+	m.metrics().IncCounter("twitchhook_dead_letters_total", "topic", n.Topic)
+	logger.Info("dead-lettered notification", zap.String("topic", n.Topic), zap.String("dead_letter_id", l.ID))
+	return true
+}
+
+// Redrive dispatches the dead letter id again like Dispatch, removing it
+// once its handler succeeds. Failed redrives update the letter's failure.
+func (m *TwitchWebhookHandler) Redrive(ctx context.Context, id string) error {
+	if m.DeadLetters == nil {
+		return ErrNotSupported
+	}
+
+	l, err := m.DeadLetters.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	n := &Notification{
+		Topic:          l.Topic,
+		SubscriptionID: l.SubscriptionID,
+		ReceivedAt:     l.ReceivedAt,
+		Body:           l.Body,
+		Replay:         true,
+	}
+	n.ReadHeader(l.Header)
+	if id := l.Header.Get(m.requestIDHeader()); validRequestID(id) {
+		n.RequestID = id
+	}
+	n.Subscription, err = m.getSubscription(l.Topic)
+	if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+		return err
+	}
+
+	cause := m.Dispatch(ctx, n)
+	if cause == nil {
+		return m.DeadLetters.Remove(ctx, id)
+	}
+
+	var panicErr *PanicError
+	l.FailedAt = clockOrDefault(m.Clock).Now()
+	l.Error = cause.Error()
+	l.Panic = errors.As(cause, &panicErr)
+	l.Redrives++
+	err = m.DeadLetters.Put(ctx, l)
+	if err != nil {
+		return errors.Join(cause, err)
+	}
+	return cause
+}
+
+// RedriveAll redrives every dead letter, returning how many succeeded.
+// Errors are returned once every letter has been tried.
+func (m *TwitchWebhookHandler) RedriveAll(ctx context.Context) (int, error) {
+	if m.DeadLetters == nil {
+		return 0, ErrNotSupported
+	}
+
+	letters, err := m.DeadLetters.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var redriven int
+	var errs []error
+	for _, l := range letters {
+		err = ctx.Err()
+		if err != nil {
+			return redriven, errors.Join(append(errs, err)...)
+		}
+		err = m.Redrive(ctx, l.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", l.ID, err))
+			continue
+		}
+		redriven++
+	}
+	return redriven, errors.Join(errs...)
+}
@@ -0,0 +1,229 @@
+package twitchhook
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DebugRecentErrors is how many errors DebugState keeps
+const DebugRecentErrors = 50
+
+// redacted replaces secrets in DebugConfig
+const redacted = "[redacted]"
+
+// DebugState is a snapshot of the handler for operators, served by
+// DebugHandler
+type DebugState struct {
+	Time   time.Time   `json:"time"`
+	Config DebugConfig `json:"config"`
+
+	// Subscriptions counts stored subscriptions by state, it's nil when the
+	// Manager isn't a SubscriptionLister
+	Subscriptions map[SubscriptionState]int `json:"subscriptions,omitempty"`
+	// SubscriptionsError is why Subscriptions couldn't be counted
+	SubscriptionsError string `json:"subscriptions_error,omitempty"`
+	// Pending counts subscriptions sent to the hub and not yet confirmed
+	Pending int `json:"pending"`
+
+	// RenewalRetries counts failed renewals waiting to be retried by this
+	// process, QueuedRenewalRetries those held in the RetryQueue
+	RenewalRetries       int64 `json:"renewal_retries"`
+	QueuedRenewalRetries *int  `json:"queued_renewal_retries,omitempty"`
+
+	CircuitBreaker BreakerState `json:"circuit_breaker,omitempty"`
+	Quota          *QuotaUsage  `json:"quota,omitempty"`
+
+	// RecentErrors are the last DebugRecentErrors errors reported to
+	// Hooks.OnError, newest first
+	RecentErrors []DebugError `json:"recent_errors"`
+}
+
+// DebugConfig is the handler's configuration with secrets redacted
+type DebugConfig struct {
+	ClientID             string            `json:"client_id"`
+	ClientSecret         string            `json:"client_secret,omitempty"`
+	HubURL               string            `json:"hub_url"`
+	CallbackBaseURL      string            `json:"callback_base_url"`
+	DefaultLease         string            `json:"default_lease"`
+	DryRun               bool              `json:"dry_run"`
+	Resubscribe          ResubscribePolicy `json:"resubscribe,omitempty"`
+	SecretAudit          SecretAuditPolicy `json:"secret_audit,omitempty"`
+	SecretBytes          int               `json:"secret_bytes"`
+	SecretEncoding       SecretEncoding    `json:"secret_encoding,omitempty"`
+	SecretOverlap        string            `json:"secret_overlap"`
+	MaxNotificationBytes int64             `json:"max_notification_bytes,omitempty"`
+	MaxNotificationAge   string            `json:"max_notification_age,omitempty"`
+	HandlerTimeout       string            `json:"handler_timeout,omitempty"`
+	HandlerDeadline      string            `json:"handler_deadline,omitempty"`
+	RenewalRetries       int               `json:"renewal_retries"`
+	SubscribeConcurrency int               `json:"subscribe_concurrency,omitempty"`
+
+	// Manager and NotificationHandler are the types of the handler's
+	// components
+	Manager             string `json:"manager"`
+	NotificationHandler string `json:"notification_handler,omitempty"`
+	DeadLetters         string `json:"dead_letters,omitempty"`
+	Dedup               string `json:"dedup,omitempty"`
+}
+
+// DebugError is an error reported by the handler
+type DebugError struct {
+	Time  time.Time `json:"time"`
+	Op    string    `json:"op"`
+	Error string    `json:"error"`
+}
+
+// errorRing keeps the last DebugRecentErrors errors
+type errorRing struct {
+	m       sync.Mutex
+	entries []DebugError
+	next    int
+}
+
+func (r *errorRing) add(e DebugError) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if len(r.entries) < DebugRecentErrors {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % DebugRecentErrors
+}
+
+// list returns the errors newest first
+func (r *errorRing) list() []DebugError {
+	r.m.Lock()
+	defer r.m.Unlock()
+	list := make([]DebugError, 0, len(r.entries))
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		list = append(list, r.entries[(r.next+i)%len(r.entries)])
+	}
+	return list
+}
+
+// DebugState returns a snapshot of the handler
+func (m *TwitchWebhookHandler) DebugState() DebugState {
+	now := clockOrDefault(m.Clock).Now()
+	state := DebugState{
+		Time:           now,
+		Config:         m.debugConfig(),
+		RenewalRetries: m.renewalRetriesScheduled.Load(),
+		RecentErrors:   m.recentErrors.list(),
+	}
+
+	if lister, ok := m.Manager.(SubscriptionLister); ok {
+		subs, err := lister.List()
+		if err != nil {
+			state.SubscriptionsError = err.Error()
+		} else {
+			state.Subscriptions = make(map[SubscriptionState]int)
+			for _, sub := range subs {
+				state.Subscriptions[sub.State(now)]++
+			}
+		}
+	}
+	m.pending.Range(func(_, _ interface{}) bool {
+		state.Pending++
+		return true
+	})
+
+	if m.RetryQueue != nil {
+		if items, err := m.RetryQueue.List(); err == nil {
+			n := len(items)
+			state.QueuedRenewalRetries = &n
+		}
+	}
+	if m.CircuitBreaker != nil {
+		state.CircuitBreaker = m.CircuitBreaker.State()
+	}
+	if m.Quota != nil {
+		usage := m.Quota.Usage(m.OAuth2ClientID)
+		state.Quota = &usage
+	}
+	return state
+}
+
+func (m *TwitchWebhookHandler) debugConfig() DebugConfig {
+	cfg := DebugConfig{
+		ClientID:             m.OAuth2ClientID,
+		HubURL:               redactURL(m.HubURL),
+		CallbackBaseURL:      redactURL(m.CallbackBaseURL),
+		DefaultLease:         m.DefaultLease.String(),
+		DryRun:               m.DryRun,
+		Resubscribe:          m.Resubscribe,
+		SecretAudit:          m.SecretAudit,
+		SecretBytes:          m.secretBytes(),
+		SecretEncoding:       m.SecretEncoding,
+		SecretOverlap:        m.secretOverlap().String(),
+		MaxNotificationBytes: m.MaxNotificationBytes,
+		RenewalRetries:       m.renewalRetries(),
+		SubscribeConcurrency: m.SubscribeConcurrency,
+		Manager:              typeName(m.Manager),
+		NotificationHandler:  typeName(m.NotificationHandler),
+		DeadLetters:          typeName(m.DeadLetters),
+		Dedup:                typeName(m.Dedup),
+	}
+	if m.OAuth2ClientSecret != "" {
+		cfg.ClientSecret = redacted
+	}
+	if m.MaxNotificationAge > 0 {
+		cfg.MaxNotificationAge = m.MaxNotificationAge.String()
+	}
+	if m.HandlerTimeout > 0 {
+		cfg.HandlerTimeout = m.HandlerTimeout.String()
+	}
+	if m.HandlerDeadline > 0 {
+		cfg.HandlerDeadline = m.HandlerDeadline.String()
+	}
+	return cfg
+}
+
+// redactURL hides the password and query of u, which may carry credentials
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return redacted
+	}
+	if parsed.RawQuery != "" {
+		parsed.RawQuery = redacted
+	}
+	return parsed.Redacted()
+}
+
+func typeName(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// DebugHandler serves the handler's DebugState as JSON, for mounting at a
+// path such as /debug/twitchhook. Every request must pass authorize, a nil
+// authorize rejects everything.
+func (m *TwitchWebhookHandler) DebugHandler(authorize func(r *http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize == nil || !authorize(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		m.writeJSON(w, m.DebugState())
+	})
+}
+
+// DebugVar returns the handler's DebugState as an expvar, publish it with
+// expvar.Publish to serve it from /debug/vars
+func (m *TwitchWebhookHandler) DebugVar() expvar.Var {
+	return expvar.Func(func() interface{} {
+		return m.DebugState()
+	})
+}
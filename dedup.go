@@ -0,0 +1,67 @@
+package twitchhook
+
+import (
+	"sync"
+	"time"
+)
+
+// DedupStore remembers ids of notifications that have already been delivered
+type DedupStore interface {
+	// Seen records id until expiry and reports whether it had already been
+	// recorded
+	Seen(id string, expiry time.Time) (bool, error)
+}
+
+// DedupForgetter is implemented by DedupStores that can forget an id, so
+// notifications a handler asks to be delivered again aren't rejected as
+// duplicates
+type DedupForgetter interface {
+	Forget(id string) error
+}
+
+const dedupSweepInterval = time.Minute
+
+// InMemoryDedupStore is a DedupStore backed by a map
+type InMemoryDedupStore struct {
+	// Clock decides when recorded ids expire, defaults to SystemClock
+	Clock Clock
+
+	seen      map[string]time.Time
+	lastSweep time.Time
+	m         sync.Mutex
+}
+
+// Seen records id until expiry and reports whether it had already been
+// recorded
+func (s *InMemoryDedupStore) Seen(id string, expiry time.Time) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := clockOrDefault(s.Clock).Now()
+	if s.seen == nil {
+		s.seen = make(map[string]time.Time)
+	}
+	if now.Sub(s.lastSweep) >= dedupSweepInterval {
+		for k, exp := range s.seen {
+			if !now.Before(exp) {
+				delete(s.seen, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	exp, ok := s.seen[id]
+	if ok && now.Before(exp) {
+		return true, nil
+	}
+	s.seen[id] = expiry
+	return false, nil
+}
+
+// Forget implements DedupForgetter
+func (s *InMemoryDedupStore) Forget(id string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.seen, id)
+	return nil
+}
@@ -0,0 +1,113 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// DenialReason classifies the hub.reason of a denied subscription
+type DenialReason string
+
+// Denial reasons
+const (
+	DenialUnknown      DenialReason = "unknown"
+	DenialUnauthorized DenialReason = "unauthorized"
+	DenialInvalidTopic DenialReason = "invalid_topic"
+	DenialRateLimited  DenialReason = "rate_limited"
+	DenialHubError     DenialReason = "hub_error"
+)
+
+// denialKeywords maps phrases found in hub.reason to their reason, checked
+// in order
+var denialKeywords = []struct {
+	reason   DenialReason
+	keywords []string
+}{
+	{DenialRateLimited, []string{"rate limit", "too many"}},
+	{DenialHubError, []string{"internal", "server error", "unavailable", "timeout", "timed out", "try again"}},
+	{DenialUnauthorized, []string{"unauthorized", "forbidden", "token", "scope", "permission"}},
+	{DenialInvalidTopic, []string{"topic"}},
+}
+
+// ParseDenialReason classifies a hub.reason
+func ParseDenialReason(reason string) DenialReason {
+	reason = strings.ToLower(reason)
+	for _, k := range denialKeywords {
+		for _, keyword := range k.keywords {
+			if strings.Contains(reason, keyword) {
+				return k.reason
+			}
+		}
+	}
+	return DenialUnknown
+}
+
+// Transient reports whether a denial may not recur when the subscription is
+// tried again
+func (r DenialReason) Transient() bool {
+	return r == DenialRateLimited || r == DenialHubError
+}
+
+// RetryTransientDenials is a RetryDenial retrying rate limited denials and
+// hub errors
+func RetryTransientDenials(reason DenialReason) bool {
+	return reason.Transient()
+}
+
+// retryDenial subscribes a denied topic again after the renewal retry
+// backoff, reporting whether it did. Retries of a topic count against
+// RenewalRetries until it's confirmed.
+func (m *TwitchWebhookHandler) retryDenial(subscription *Subscription, reason DenialReason) bool {
+	if m.RetryDenial == nil || !m.RetryDenial(reason) {
+		return false
+	}
+
+	topic := subscription.Topic
+	attempts := 1
+	if v, ok := m.denials.Load(topic); ok {
+		attempts = v.(int) + 1
+	}
+	if attempts > m.renewalRetries() {
+		m.denials.Delete(topic)
+		m.logger().Error("giving up retrying denied subscription", zap.String("topic", topic), zap.Int("attempts", attempts))
+		return false
+	}
+	m.denials.Store(topic, attempts)
+
+	delay := m.renewalRetryDelay(attempts)
+	m.logger().Info("retrying denied subscription",
+		zap.String("topic", topic),
+		zap.String("reason", string(reason)),
+		zap.Int("attempts", attempts),
+		zap.Duration("delay", delay),
+	)
+	request := SubscriptionRequest{
+		Topic:           topic,
+		CallbackBaseURL: subscription.CallbackBaseURL,
+		Lease:           subscription.Lease,
+		Metadata:        subscription.Metadata,
+	}
+	clockOrDefault(m.Clock).AfterFunc(delay, func() {
+		err := m.protect("denial retry", func() error {
+			_, err := m.getSubscription(topic)
+			if errors.Is(err, ErrSubscriptionNotFound) {
+				// unsubscribed while waiting to retry
+				m.denials.Delete(topic)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return m.subscribe(context.Background(), request, subscription.DenialCallback, true)
+		})
+		if err != nil {
+			m.logger().Error("unable to retry denied subscription", zap.String("topic", topic), zap.Error(err))
+			m.hookError("denial retry", err)
+			m.retryRenewal(request, subscription.DenialCallback, 1, err)
+		}
+	})
+	return true
+}
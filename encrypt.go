@@ -0,0 +1,277 @@
+package twitchhook
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// KeyWrapper encrypts and decrypts data keys for envelope encryption, it's
+// typically backed by a KMS
+type KeyWrapper interface {
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// Encrypted secret prefixes. Secrets without a prefix are treated as
+// plaintext so existing stores can be migrated in place.
+const (
+	encryptedSecretPrefix = "enc:v1:"
+	envelopeSecretPrefix  = "enc:v2:"
+)
+
+// ErrNotSupported is returned by decorators when the underlying
+// SubscriptionManager doesn't implement an optional interface
+var ErrNotSupported = errors.New("not supported by the underlying subscription manager")
+
+// SecretSealer is implemented by SubscriptionManagers that encrypt secrets at
+// rest, the handler seals the secrets it writes to its Outbox with it
+type SecretSealer interface {
+	SealSecret(ctx context.Context, secret string) (string, error)
+	OpenSecret(ctx context.Context, sealed string) (string, error)
+}
+
+// EncryptedManager is a SubscriptionManager that encrypts Subscription.Secret
+// and PreviousSecret with AES-GCM before delegating to Manager. Set Key to
+// encrypt with a fixed key or KeyWrapper to encrypt each secret with a fresh
+// data key wrapped by the KeyWrapper.
+type EncryptedManager struct {
+	Manager SubscriptionManager
+
+	// Key is a 16, 24 or 32 byte AES key
+	Key []byte
+
+	// KeyWrapper enables envelope encryption, it's used instead of Key when
+	// set. Secrets encrypted with Key can still be read when both are set.
+	KeyWrapper KeyWrapper
+}
+
+// Get retrieves and decrypts a subscription
+func (e *EncryptedManager) Get(topic string) (*Subscription, error) {
+	sub, err := e.Manager.Get(topic)
+	if err != nil || sub == nil {
+		return sub, err
+	}
+	return e.decrypt(sub)
+}
+
+// Save encrypts and saves a subscription
+func (e *EncryptedManager) Save(topic string, sub *Subscription) error {
+	encrypted := *sub
+	secret, err := e.encryptSecret(context.Background(), sub.Secret)
+	if err != nil {
+		return err
+	}
+	encrypted.Secret = secret
+	previous, err := e.encryptSecret(context.Background(), sub.PreviousSecret)
+	if err != nil {
+		return err
+	}
+	encrypted.PreviousSecret = previous
+	return e.Manager.Save(topic, &encrypted)
+}
+
+// Delete removes a subscription
+func (e *EncryptedManager) Delete(topic string) error {
+	return e.Manager.Delete(topic)
+}
+
+// SetSubscriptionLease sets a subscription's lease
+func (e *EncryptedManager) SetSubscriptionLease(topic string, lease time.Duration) (bool, error) {
+	return e.Manager.SetSubscriptionLease(topic, lease)
+}
+
+// List implements SubscriptionLister when Manager does
+func (e *EncryptedManager) List() ([]*Subscription, error) {
+	lister, ok := e.Manager.(SubscriptionLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	subs, err := lister.List()
+	if err != nil {
+		return nil, err
+	}
+	for i, sub := range subs {
+		subs[i], err = e.decrypt(sub)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return subs, nil
+}
+
+// RenewalAt implements RenewalScheduler when Manager does
+func (e *EncryptedManager) RenewalAt(topic string) (time.Time, error) {
+	scheduler, ok := e.Manager.(RenewalScheduler)
+	if !ok {
+		return time.Time{}, ErrNotSupported
+	}
+	return scheduler.RenewalAt(topic)
+}
+
+// NotifyEviction implements EvictionNotifier when Manager does
+func (e *EncryptedManager) NotifyEviction(f func(sub *Subscription)) {
+	if notifier, ok := e.Manager.(EvictionNotifier); ok {
+		notifier.NotifyEviction(f)
+	}
+}
+
+// GetByID implements SubscriptionIDIndex when Manager does
+func (e *EncryptedManager) GetByID(id SubscriptionID) (*Subscription, error) {
+	index, ok := e.Manager.(SubscriptionIDIndex)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	sub, err := index.GetByID(id)
+	if err != nil || sub == nil {
+		return sub, err
+	}
+	return e.decrypt(sub)
+}
+
+// Ping implements Pinger when Manager does
+func (e *EncryptedManager) Ping(ctx context.Context) error {
+	if pinger, ok := e.Manager.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// SealSecret implements SecretSealer
+func (e *EncryptedManager) SealSecret(ctx context.Context, secret string) (string, error) {
+	return e.encryptSecret(ctx, secret)
+}
+
+// OpenSecret implements SecretSealer, unencrypted secrets are returned as is
+func (e *EncryptedManager) OpenSecret(ctx context.Context, sealed string) (string, error) {
+	return e.decryptSecret(ctx, sealed)
+}
+
+// encryptedSecret reports whether secret was sealed by an EncryptedManager
+func encryptedSecret(secret string) bool {
+	return strings.HasPrefix(secret, encryptedSecretPrefix) || strings.HasPrefix(secret, envelopeSecretPrefix)
+}
+
+func (e *EncryptedManager) decrypt(sub *Subscription) (*Subscription, error) {
+	secret, err := e.decryptSecret(context.Background(), sub.Secret)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := e.decryptSecret(context.Background(), sub.PreviousSecret)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := *sub
+	decrypted.Secret = secret
+	decrypted.PreviousSecret = previous
+	return &decrypted, nil
+}
+
+func (e *EncryptedManager) encryptSecret(ctx context.Context, secret string) (string, error) {
+	if secret == "" {
+		return "", nil
+	}
+
+	if e.KeyWrapper == nil {
+		ciphertext, err := seal(e.Key, []byte(secret))
+		if err != nil {
+			return "", err
+		}
+		return encryptedSecretPrefix + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+	}
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := e.KeyWrapper.WrapKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(key, []byte(secret))
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(wrapped)+len(ciphertext))
+	buf = buf[:binary.PutUvarint(buf, uint64(len(wrapped)))]
+	buf = append(buf, wrapped...)
+	buf = append(buf, ciphertext...)
+	return envelopeSecretPrefix + base64.RawStdEncoding.EncodeToString(buf), nil
+}
+
+func (e *EncryptedManager) decryptSecret(ctx context.Context, secret string) (string, error) {
+	switch {
+	case strings.HasPrefix(secret, encryptedSecretPrefix):
+		bs, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(secret, encryptedSecretPrefix))
+		if err != nil {
+			return "", err
+		}
+		plaintext, err := open(e.Key, bs)
+		return string(plaintext), err
+
+	case strings.HasPrefix(secret, envelopeSecretPrefix):
+		if e.KeyWrapper == nil {
+			return "", errors.New("secret uses envelope encryption but no KeyWrapper is set")
+		}
+		bs, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(secret, envelopeSecretPrefix))
+		if err != nil {
+			return "", err
+		}
+		length, n := binary.Uvarint(bs)
+		if n <= 0 || length > uint64(len(bs)-n) {
+			return "", errors.New("malformed envelope encrypted secret")
+		}
+		bs = bs[n:]
+		key, err := e.KeyWrapper.UnwrapKey(ctx, bs[:length])
+		if err != nil {
+			return "", err
+		}
+		plaintext, err := open(key, bs[length:])
+		return string(plaintext), err
+
+	default:
+		return secret, nil
+	}
+}
+
+// seal encrypts plaintext with AES-GCM, prefixing the random nonce
+func seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, ciphertext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,514 @@
+// Package etcdstore implements twitchhook.SubscriptionManager on etcd. Every
+// subscription is attached to an etcd lease outliving its hub lease, so etcd
+// removes expired subscriptions itself, and replicas share a read cache kept
+// current by a watch on the key prefix.
+//
+// Importing the package registers the etcd storage DSN scheme:
+//
+//	etcd://[user:password@]host:port[,host:port...][/<prefix>][?codec=json|cbor|proto]
+//
+// The cbor and proto codecs are registered by the codec package.
+package etcdstore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Defaults
+const (
+	DefaultPrefix     = "twitchhook/"
+	DefaultTimeout    = 10 * time.Second
+	DefaultGrace      = time.Hour
+	DefaultPendingTTL = time.Hour
+)
+
+// watchRetry is how long the watch waits before restarting after a failure
+const watchRetry = time.Second
+
+func init() {
+	twitchhook.RegisterManager("etcd", Open)
+}
+
+// Open opens a Manager from an etcd:// DSN, the Manager's Close closes the
+// client
+func Open(dsn string) (twitchhook.SubscriptionManager, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("etcdstore: dsn must name an endpoint")
+	}
+
+	cfg := clientv3.Config{
+		Endpoints:   strings.Split(u.Host, ","),
+		DialTimeout: DefaultTimeout,
+	}
+	if u.User != nil {
+		cfg.Username = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+	codec, err := twitchhook.LookupCodec(u.Query().Get("codec"))
+	if err != nil {
+		return nil, err
+	}
+	client, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{Client: client, Codec: codec, closeClient: true}
+	if prefix := strings.Trim(u.Path, "/"); prefix != "" {
+		m.Prefix = prefix + "/"
+	}
+	return m, nil
+}
+
+// Manager is a twitchhook.SubscriptionManager storing subscriptions in etcd
+// under Prefix. Secrets are stored as given, wrap the Manager in a
+// twitchhook.EncryptedManager to encrypt them.
+//
+// Confirmed subscriptions are attached to an etcd lease running Grace past
+// their expiry, pending ones to a lease of PendingTTL, so subscriptions that
+// are never renewed or confirmed disappear without a sweeper.
+//
+// Renewals are scheduled by the process that saved the subscription, see
+// twitchhook.SubscriptionTimers.
+type Manager struct {
+	Client *clientv3.Client
+
+	// Prefix namespaces the Manager's keys, defaults to DefaultPrefix
+	Prefix string
+
+	// Timeout bounds each operation, defaults to DefaultTimeout
+	Timeout time.Duration
+
+	// Grace is how long a subscription is kept after its lease runs out,
+	// defaults to DefaultGrace
+	Grace time.Duration
+
+	// PendingTTL is how long an unconfirmed subscription is kept, defaults
+	// to DefaultPendingTTL
+	PendingTTL time.Duration
+
+	// Clock schedules renewals, defaults to twitchhook.SystemClock
+	Clock twitchhook.Clock
+
+	// Codec encodes stored subscriptions, defaults to twitchhook.JSONCodec
+	Codec twitchhook.Codec
+
+	timers      twitchhook.SubscriptionTimers
+	once        sync.Once
+	closeClient bool
+
+	stop context.CancelFunc
+	done chan struct{}
+
+	m        sync.Mutex
+	cache    map[string]cachedValue
+	watching bool
+	rev      int64
+}
+
+// cachedValue is a value read from etcd and the revision it was last
+// modified at
+type cachedValue struct {
+	value []byte
+	rev   int64
+}
+
+func (m *Manager) setup() {
+	if m.Prefix == "" {
+		m.Prefix = DefaultPrefix
+	}
+	if m.Timeout == 0 {
+		m.Timeout = DefaultTimeout
+	}
+	if m.Grace == 0 {
+		m.Grace = DefaultGrace
+	}
+	if m.PendingTTL == 0 {
+		m.PendingTTL = DefaultPendingTTL
+	}
+	if m.Codec == nil {
+		m.Codec = twitchhook.JSONCodec
+	}
+	m.timers.Clock = m.Clock
+
+	var ctx context.Context
+	ctx, m.stop = context.WithCancel(context.Background())
+	m.done = make(chan struct{})
+	go m.watch(ctx)
+}
+
+func (m *Manager) context() (context.Context, context.CancelFunc) {
+	m.once.Do(m.setup)
+	return context.WithTimeout(context.Background(), m.Timeout)
+}
+
+func (m *Manager) topicKey(topic string) string {
+	return m.Prefix + "topics/" + base64.RawURLEncoding.EncodeToString([]byte(topic))
+}
+
+func (m *Manager) idKey(id twitchhook.SubscriptionID) string {
+	return m.Prefix + "ids/" + string(id)
+}
+
+// Close stops the watch, closing the Client if the Manager was opened from a
+// DSN
+func (m *Manager) Close() error {
+	m.once.Do(m.setup)
+	m.stop()
+	<-m.done
+	if m.closeClient {
+		return m.Client.Close()
+	}
+	return nil
+}
+
+// watch invalidates cached values changed by any replica. The cache is only
+// used while the watch is established, so a partitioned or lagging watch
+// falls back to reading from etcd.
+func (m *Manager) watch(ctx context.Context) {
+	defer close(m.done)
+
+	for {
+		wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+		for resp := range m.Client.Watch(wctx, m.Prefix, clientv3.WithPrefix(), clientv3.WithCreatedNotify()) {
+			if resp.Err() != nil {
+				break
+			}
+			m.m.Lock()
+			if resp.Created {
+				m.watching = true
+			}
+			for _, ev := range resp.Events {
+				key := string(ev.Kv.Key)
+				if cached, ok := m.cache[key]; ok && cached.rev < ev.Kv.ModRevision {
+					delete(m.cache, key)
+				}
+			}
+			m.rev = max(m.rev, resp.Header.Revision)
+			m.m.Unlock()
+		}
+		cancel()
+
+		m.m.Lock()
+		m.watching = false
+		m.cache = nil
+		m.m.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetry):
+		}
+	}
+}
+
+// get reads key from the cache or etcd, caching the value unless the watch
+// has seen later revisions that may have changed it
+func (m *Manager) get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.m.Lock()
+	cached, ok := m.cache[key]
+	m.m.Unlock()
+	if ok {
+		return cached.value, true, nil
+	}
+
+	resp, err := m.Client.Get(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, false, nil
+	}
+	kv := resp.Kvs[0]
+
+	m.m.Lock()
+	if m.watching && m.rev <= resp.Header.Revision {
+		if m.cache == nil {
+			m.cache = make(map[string]cachedValue)
+		}
+		m.cache[key] = cachedValue{value: kv.Value, rev: kv.ModRevision}
+	}
+	m.m.Unlock()
+	return kv.Value, true, nil
+}
+
+// invalidate drops keys written by this process so it reads its own writes
+// before the watch delivers them
+func (m *Manager) invalidate(keys ...string) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	for _, key := range keys {
+		delete(m.cache, key)
+	}
+}
+
+// Get retrieves a subscription, returning twitchhook.ErrSubscriptionNotFound
+// if there isn't one for topic
+func (m *Manager) Get(topic string) (*twitchhook.Subscription, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	key := m.topicKey(topic)
+	value, ok, err := m.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, twitchhook.ErrSubscriptionNotFound
+	}
+	return m.subscription(key, value)
+}
+
+func (m *Manager) subscription(key string, value []byte) (*twitchhook.Subscription, error) {
+	var record twitchhook.SubscriptionRecord
+	err := m.Codec.Unmarshal(value, &record)
+	if err != nil {
+		return nil, err
+	}
+	// the key holds the topic the subscription was saved under, which
+	// differs from its topic behind a NamespacedManager
+	topic, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(key, m.Prefix+"topics/"))
+	if err != nil {
+		return nil, err
+	}
+	return m.timers.Attach(string(topic), record.Subscription()), nil
+}
+
+// ttl returns the etcd lease ttl in seconds for record
+func (m *Manager) ttl(record *twitchhook.SubscriptionRecord) int64 {
+	ttl := m.PendingTTL
+	if !record.ExpiresAt.IsZero() {
+		ttl = record.ExpiresAt.Sub(m.clock().Now()) + m.Grace
+	}
+	return max(int64(ttl/time.Second), 1)
+}
+
+// update replaces the subscription stored under key with the record returned
+// by f, which is given the current record or nil. When f returns nil nothing
+// is written. The write is retried if the subscription changes concurrently.
+func (m *Manager) update(ctx context.Context, key string, f func(old *twitchhook.SubscriptionRecord) *twitchhook.SubscriptionRecord) (bool, error) {
+	for {
+		resp, err := m.Client.Get(ctx, key)
+		if err != nil {
+			return false, err
+		}
+
+		var old *twitchhook.SubscriptionRecord
+		var oldLease clientv3.LeaseID
+		cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		if len(resp.Kvs) > 0 {
+			kv := resp.Kvs[0]
+			old = new(twitchhook.SubscriptionRecord)
+			err = m.Codec.Unmarshal(kv.Value, old)
+			if err != nil {
+				return false, err
+			}
+			oldLease = clientv3.LeaseID(kv.Lease)
+			cmp = clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)
+		}
+
+		record := f(old)
+		if record == nil {
+			return false, nil
+		}
+		value, err := m.Codec.Marshal(record)
+		if err != nil {
+			return false, err
+		}
+
+		lease, err := m.Client.Grant(ctx, m.ttl(record))
+		if err != nil {
+			return false, err
+		}
+		ops := []clientv3.Op{clientv3.OpPut(key, string(value), clientv3.WithLease(lease.ID))}
+		keys := []string{key}
+		if record.ID != "" {
+			ops = append(ops, clientv3.OpPut(m.idKey(record.ID), key, clientv3.WithLease(lease.ID)))
+			keys = append(keys, m.idKey(record.ID))
+		}
+		if old != nil && old.ID != "" && old.ID != record.ID {
+			ops = append(ops, clientv3.OpDelete(m.idKey(old.ID)))
+			keys = append(keys, m.idKey(old.ID))
+		}
+
+		txn, err := m.Client.Txn(ctx).If(cmp).Then(ops...).Commit()
+		if err != nil {
+			m.Client.Revoke(ctx, lease.ID)
+			return false, err
+		}
+		if !txn.Succeeded {
+			m.Client.Revoke(ctx, lease.ID)
+			continue
+		}
+		m.invalidate(keys...)
+
+		// nothing is attached to the old lease anymore, it would expire on
+		// its own so a failed revoke is ignored
+		if oldLease != clientv3.NoLease {
+			m.Client.Revoke(ctx, oldLease)
+		}
+		return true, nil
+	}
+}
+
+// Save stores a subscription, replacing any existing subscription to topic
+func (m *Manager) Save(topic string, sub *twitchhook.Subscription) error {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	record := sub.Record()
+	_, err := m.update(ctx, m.topicKey(topic), func(*twitchhook.SubscriptionRecord) *twitchhook.SubscriptionRecord {
+		return &record
+	})
+	if err != nil {
+		return err
+	}
+	m.timers.Save(topic, sub)
+	return nil
+}
+
+// Delete removes a subscription
+func (m *Manager) Delete(topic string) error {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	key := m.topicKey(topic)
+	for {
+		resp, err := m.Client.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			break
+		}
+		kv := resp.Kvs[0]
+		var record twitchhook.SubscriptionRecord
+		err = m.Codec.Unmarshal(kv.Value, &record)
+		if err != nil {
+			return err
+		}
+
+		ops := []clientv3.Op{clientv3.OpDelete(key)}
+		keys := []string{key}
+		if record.ID != "" {
+			ops = append(ops, clientv3.OpDelete(m.idKey(record.ID)))
+			keys = append(keys, m.idKey(record.ID))
+		}
+		txn, err := m.Client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)).
+			Then(ops...).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if !txn.Succeeded {
+			continue
+		}
+		m.invalidate(keys...)
+		if kv.Lease != 0 {
+			m.Client.Revoke(ctx, clientv3.LeaseID(kv.Lease))
+		}
+		break
+	}
+	m.timers.Delete(topic)
+	return nil
+}
+
+// SetSubscriptionLease sets a subscription's lease and expiry, moving it to
+// an etcd lease running Grace past the new expiry
+func (m *Manager) SetSubscriptionLease(topic string, lease time.Duration) (bool, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	expiresAt := m.clock().Now().Add(lease)
+	exists, err := m.update(ctx, m.topicKey(topic), func(old *twitchhook.SubscriptionRecord) *twitchhook.SubscriptionRecord {
+		if old == nil {
+			return nil
+		}
+		old.Lease = lease
+		old.ExpiresAt = expiresAt
+		return old
+	})
+	if err != nil || !exists {
+		return false, err
+	}
+	m.timers.Extend(topic, lease)
+	return true, nil
+}
+
+func (m *Manager) clock() twitchhook.Clock {
+	if m.Clock == nil {
+		return twitchhook.SystemClock
+	}
+	return m.Clock
+}
+
+// GetByID implements twitchhook.SubscriptionIDIndex
+func (m *Manager) GetByID(id twitchhook.SubscriptionID) (*twitchhook.Subscription, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	key, ok, err := m.get(ctx, m.idKey(id))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, twitchhook.ErrSubscriptionNotFound
+	}
+	value, ok, err := m.get(ctx, string(key))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, twitchhook.ErrSubscriptionNotFound
+	}
+	return m.subscription(string(key), value)
+}
+
+// List implements twitchhook.SubscriptionLister
+func (m *Manager) List() ([]*twitchhook.Subscription, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	resp, err := m.Client.Get(ctx, m.Prefix+"topics/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]*twitchhook.Subscription, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		sub, err := m.subscription(string(kv.Key), kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// RenewalAt implements twitchhook.RenewalScheduler, only renewals scheduled
+// by this process are known
+func (m *Manager) RenewalAt(topic string) (time.Time, error) {
+	return m.timers.RenewalAt(topic), nil
+}
+
+// Ping implements twitchhook.Pinger
+func (m *Manager) Ping(ctx context.Context) error {
+	m.once.Do(m.setup)
+
+	_, err := m.Client.Get(ctx, m.Prefix, clientv3.WithCountOnly())
+	return err
+}
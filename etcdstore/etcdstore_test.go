@@ -0,0 +1,509 @@
+package etcdstore
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+	"github.com/bsdlp/twitchhook/storetest"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+// fakeEtcd is an in-memory etcd serving the KV, Lease and Watch calls the
+// Manager makes. Leases never expire on their own.
+type fakeEtcd struct {
+	pb.UnimplementedKVServer
+	pb.UnimplementedLeaseServer
+	pb.UnimplementedWatchServer
+
+	m         sync.Mutex
+	rev       int64
+	kvs       map[string]*mvccpb.KeyValue
+	leases    map[int64]int64
+	nextLease int64
+	watchers  map[*fakeWatcher]bool
+	ranges    int
+}
+
+type fakeWatcher struct {
+	id       int64
+	key      string
+	rangeEnd string
+	stream   *fakeWatchStream
+}
+
+type fakeWatchStream struct {
+	m      sync.Mutex
+	stream pb.Watch_WatchServer
+}
+
+func (s *fakeWatchStream) send(resp *pb.WatchResponse) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.stream.Send(resp)
+}
+
+// newFakeEtcd serves a fakeEtcd on localhost and returns a client connected
+// to it
+func newFakeEtcd(t *testing.T) (*fakeEtcd, func(t *testing.T) *clientv3.Client) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	f := &fakeEtcd{
+		rev:      1,
+		kvs:      make(map[string]*mvccpb.KeyValue),
+		leases:   make(map[int64]int64),
+		watchers: make(map[*fakeWatcher]bool),
+	}
+	pb.RegisterKVServer(srv, f)
+	pb.RegisterLeaseServer(srv, f)
+	pb.RegisterWatchServer(srv, f)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return f, func(t *testing.T) *clientv3.Client {
+		client, err := clientv3.New(clientv3.Config{
+			Endpoints:   []string{lis.Addr().String()},
+			DialTimeout: time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+}
+
+func (f *fakeEtcd) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: f.rev}
+}
+
+// inRange reports whether key is in the etcd range starting at start and
+// ending before end, a "\x00" end is open and an empty one selects start
+func inRange(key, start, end string) bool {
+	switch end {
+	case "":
+		return key == start
+	case "\x00":
+		return key >= start
+	default:
+		return key >= start && key < end
+	}
+}
+
+func (f *fakeEtcd) keys(start, end []byte) []string {
+	var keys []string
+	for key := range f.kvs {
+		if inRange(key, string(start), string(end)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeEtcd) Range(_ context.Context, req *pb.RangeRequest) (*pb.RangeResponse, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.ranges++
+	return f.rangeLocked(req), nil
+}
+
+func (f *fakeEtcd) rangeLocked(req *pb.RangeRequest) *pb.RangeResponse {
+	keys := f.keys(req.Key, req.RangeEnd)
+	resp := &pb.RangeResponse{Header: f.header(), Count: int64(len(keys))}
+	if req.CountOnly {
+		return resp
+	}
+	for _, key := range keys {
+		kv := *f.kvs[key]
+		resp.Kvs = append(resp.Kvs, &kv)
+	}
+	return resp
+}
+
+func (f *fakeEtcd) Put(_ context.Context, req *pb.PutRequest) (*pb.PutResponse, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	var events []*mvccpb.Event
+	if err := f.put(req, f.rev+1, &events); err != nil {
+		return nil, err
+	}
+	f.commit(events)
+	return &pb.PutResponse{Header: f.header()}, nil
+}
+
+func (f *fakeEtcd) put(req *pb.PutRequest, rev int64, events *[]*mvccpb.Event) error {
+	if _, ok := f.leases[req.Lease]; req.Lease != 0 && !ok {
+		return rpctypes.ErrGRPCLeaseNotFound
+	}
+	kv := &mvccpb.KeyValue{Key: req.Key, Value: req.Value, CreateRevision: rev, ModRevision: rev, Version: 1, Lease: req.Lease}
+	if old, ok := f.kvs[string(req.Key)]; ok {
+		kv.CreateRevision = old.CreateRevision
+		kv.Version = old.Version + 1
+	}
+	f.kvs[string(req.Key)] = kv
+	*events = append(*events, &mvccpb.Event{Type: mvccpb.PUT, Kv: kv})
+	return nil
+}
+
+func (f *fakeEtcd) DeleteRange(_ context.Context, req *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	var events []*mvccpb.Event
+	deleted := f.deleteRange(req.Key, req.RangeEnd, f.rev+1, &events)
+	f.commit(events)
+	return &pb.DeleteRangeResponse{Header: f.header(), Deleted: deleted}, nil
+}
+
+func (f *fakeEtcd) deleteRange(start, end []byte, rev int64, events *[]*mvccpb.Event) int64 {
+	keys := f.keys(start, end)
+	for _, key := range keys {
+		delete(f.kvs, key)
+		*events = append(*events, &mvccpb.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte(key), ModRevision: rev}})
+	}
+	return int64(len(keys))
+}
+
+// commit moves to the next revision when events were written, sending them
+// to the watchers of their keys
+func (f *fakeEtcd) commit(events []*mvccpb.Event) {
+	if len(events) == 0 {
+		return
+	}
+	f.rev++
+	for w := range f.watchers {
+		resp := &pb.WatchResponse{Header: f.header(), WatchId: w.id}
+		for _, ev := range events {
+			if inRange(string(ev.Kv.Key), w.key, w.rangeEnd) {
+				resp.Events = append(resp.Events, ev)
+			}
+		}
+		if len(resp.Events) > 0 {
+			w.stream.send(resp)
+		}
+	}
+}
+
+func (f *fakeEtcd) compare(c *pb.Compare) bool {
+	kv, ok := f.kvs[string(c.Key)]
+	if !ok {
+		kv = &mvccpb.KeyValue{}
+	}
+	var result int
+	switch c.Target {
+	case pb.Compare_VERSION:
+		result = cmpInt(kv.Version, c.GetVersion())
+	case pb.Compare_CREATE:
+		result = cmpInt(kv.CreateRevision, c.GetCreateRevision())
+	case pb.Compare_MOD:
+		result = cmpInt(kv.ModRevision, c.GetModRevision())
+	case pb.Compare_VALUE:
+		if !ok {
+			return false
+		}
+		result = bytes.Compare(kv.Value, c.GetValue())
+	case pb.Compare_LEASE:
+		result = cmpInt(kv.Lease, c.GetLease())
+	}
+	switch c.Result {
+	case pb.Compare_EQUAL:
+		return result == 0
+	case pb.Compare_NOT_EQUAL:
+		return result != 0
+	case pb.Compare_GREATER:
+		return result > 0
+	default:
+		return result < 0
+	}
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (f *fakeEtcd) Txn(_ context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	succeeded := true
+	for _, c := range req.Compare {
+		succeeded = succeeded && f.compare(c)
+	}
+	ops := req.Success
+	if !succeeded {
+		ops = req.Failure
+	}
+
+	var events []*mvccpb.Event
+	resp := &pb.TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		switch r := op.Request.(type) {
+		case *pb.RequestOp_RequestRange:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: f.rangeLocked(r.RequestRange)}})
+		case *pb.RequestOp_RequestPut:
+			if err := f.put(r.RequestPut, f.rev+1, &events); err != nil {
+				return nil, err
+			}
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: &pb.PutResponse{}}})
+		case *pb.RequestOp_RequestDeleteRange:
+			deleted := f.deleteRange(r.RequestDeleteRange.Key, r.RequestDeleteRange.RangeEnd, f.rev+1, &events)
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: &pb.DeleteRangeResponse{Deleted: deleted}}})
+		}
+	}
+	f.commit(events)
+	resp.Header = f.header()
+	return resp, nil
+}
+
+func (f *fakeEtcd) LeaseGrant(_ context.Context, req *pb.LeaseGrantRequest) (*pb.LeaseGrantResponse, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.nextLease++
+	f.leases[f.nextLease] = req.TTL
+	return &pb.LeaseGrantResponse{Header: f.header(), ID: f.nextLease, TTL: req.TTL}, nil
+}
+
+func (f *fakeEtcd) LeaseRevoke(_ context.Context, req *pb.LeaseRevokeRequest) (*pb.LeaseRevokeResponse, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if !f.revoke(req.ID) {
+		return nil, rpctypes.ErrGRPCLeaseNotFound
+	}
+	return &pb.LeaseRevokeResponse{Header: f.header()}, nil
+}
+
+// revoke deletes lease and the keys attached to it, as etcd does when a lease
+// expires
+func (f *fakeEtcd) revoke(lease int64) bool {
+	if _, ok := f.leases[lease]; !ok {
+		return false
+	}
+	delete(f.leases, lease)
+	var events []*mvccpb.Event
+	for _, key := range f.keys(nil, []byte("\x00")) {
+		if f.kvs[key].Lease == lease {
+			f.deleteRange([]byte(key), nil, f.rev+1, &events)
+		}
+	}
+	f.commit(events)
+	return true
+}
+
+// expire expires the lease of key, returning its ttl
+func (f *fakeEtcd) expire(t *testing.T, key string) int64 {
+	t.Helper()
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	kv, ok := f.kvs[key]
+	if !ok || kv.Lease == 0 {
+		t.Fatalf("%s isn't attached to a lease", key)
+	}
+	ttl := f.leases[kv.Lease]
+	f.revoke(kv.Lease)
+	return ttl
+}
+
+func (f *fakeEtcd) Watch(stream pb.Watch_WatchServer) error {
+	s := &fakeWatchStream{stream: stream}
+	var watchers []*fakeWatcher
+	defer func() {
+		f.m.Lock()
+		defer f.m.Unlock()
+		for _, w := range watchers {
+			delete(f.watchers, w)
+		}
+	}()
+
+	var nextID int64
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		switch r := req.RequestUnion.(type) {
+		case *pb.WatchRequest_CreateRequest:
+			f.m.Lock()
+			w := &fakeWatcher{id: nextID, key: string(r.CreateRequest.Key), rangeEnd: string(r.CreateRequest.RangeEnd), stream: s}
+			nextID++
+			f.watchers[w] = true
+			watchers = append(watchers, w)
+			s.send(&pb.WatchResponse{Header: f.header(), WatchId: w.id, Created: true})
+			f.m.Unlock()
+		case *pb.WatchRequest_CancelRequest:
+			f.m.Lock()
+			for w := range f.watchers {
+				if w.stream == s && w.id == r.CancelRequest.WatchId {
+					delete(f.watchers, w)
+				}
+			}
+			s.send(&pb.WatchResponse{Header: f.header(), WatchId: r.CancelRequest.WatchId, Canceled: true})
+			f.m.Unlock()
+		}
+	}
+}
+
+func (f *fakeEtcd) rangeCalls() int {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.ranges
+}
+
+func newManager(t *testing.T, client *clientv3.Client, clock twitchhook.Clock) *Manager {
+	m := &Manager{Client: client, Clock: clock, Timeout: 5 * time.Second}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// waitWatching waits for m's watch to be established, until then reads
+// aren't cached
+func waitWatching(t *testing.T, m *Manager) {
+	t.Helper()
+	m.once.Do(m.setup)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		m.m.Lock()
+		watching := m.watching
+		m.m.Unlock()
+		if watching {
+			return
+		}
+	}
+	t.Fatal("watch wasn't established")
+}
+
+func TestManager(t *testing.T) {
+	storetest.TestSubscriptionManager(t, func(t *testing.T, clock twitchhook.Clock) twitchhook.SubscriptionManager {
+		_, dial := newFakeEtcd(t)
+		return newManager(t, dial(t), clock)
+	})
+}
+
+func TestManagerLeases(t *testing.T) {
+	f, dial := newFakeEtcd(t)
+	clock := clocktest.NewClock(storetest.Epoch)
+	m := newManager(t, dial(t), clock)
+	m.Grace = 30 * time.Minute
+	m.PendingTTL = 20 * time.Minute
+
+	const topic = "https://api.twitch.tv/helix/streams?user_id=1"
+	pending := storetest.Subscription(topic, "v1.a")
+	pending.ExpiresAt = time.Time{}
+	if err := m.Save(topic, pending); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := f.expire(t, m.topicKey(topic)); ttl != int64((20 * time.Minute).Seconds()) {
+		t.Fatalf("pending subscription ttl = %ds, want PendingTTL", ttl)
+	}
+	if sub, err := m.Get(topic); err != twitchhook.ErrSubscriptionNotFound {
+		t.Fatalf("Get after its lease expired = %v, %v", sub, err)
+	}
+	if sub, err := m.GetByID("v1.a"); err != twitchhook.ErrSubscriptionNotFound {
+		t.Fatalf("GetByID after its lease expired = %v, %v", sub, err)
+	}
+
+	if err := m.Save(topic, pending); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.SetSubscriptionLease(topic, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := f.expire(t, m.topicKey(topic)); ttl != int64((90 * time.Minute).Seconds()) {
+		t.Fatalf("confirmed subscription ttl = %ds, want its lease and Grace", ttl)
+	}
+
+	f.m.Lock()
+	leases := len(f.leases)
+	f.m.Unlock()
+	if leases != 0 {
+		t.Fatalf("%d leases left after replaced leases were revoked", leases)
+	}
+}
+
+func TestManagerCache(t *testing.T) {
+	f, dial := newFakeEtcd(t)
+	clock := clocktest.NewClock(storetest.Epoch)
+	replica := newManager(t, dial(t), clock)
+	m := newManager(t, dial(t), clock)
+	waitWatching(t, m)
+
+	const topic = "https://api.twitch.tv/helix/streams?user_id=1"
+	sub := storetest.Subscription(topic, "v1.a")
+	if err := replica.Save(topic, sub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get(topic); err != nil {
+		t.Fatal(err)
+	}
+	ranges := f.rangeCalls()
+	got, err := m.Get(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.CheckSubscription(t, got, sub)
+	if f.rangeCalls() != ranges {
+		t.Fatal("a cached subscription was read from etcd")
+	}
+
+	// the watch invalidates subscriptions another replica changes
+	replacement := storetest.Subscription(topic, "v1.b")
+	if err := replica.Save(topic, replacement); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		got, err = m.Get(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID == replacement.ID {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the watch didn't invalidate a subscription saved by a replica")
+		}
+	}
+	storetest.CheckSubscription(t, got, replacement)
+
+	// writes are read back before the watch delivers them
+	if _, err := m.SetSubscriptionLease(topic, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	got, err = m.Get(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Lease != 2*time.Hour {
+		t.Fatalf("lease = %s after SetSubscriptionLease, want 2h", got.Lease)
+	}
+	if err := m.Delete(topic); err != nil {
+		t.Fatal(err)
+	}
+	if sub, err := m.Get(topic); err != twitchhook.ErrSubscriptionNotFound {
+		t.Fatalf("Get after Delete = %v, %v", sub, err)
+	}
+}
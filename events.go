@@ -0,0 +1,132 @@
+package twitchhook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrUnknownTopic is returned by DecodeEvent for topics it has no event type
+// for
+var ErrUnknownTopic = errors.New("unknown topic")
+
+// StreamChanged is the payload of stream changed notifications, Streams is
+// empty when the stream went offline
+type StreamChanged struct {
+	Streams []Stream `json:"data"`
+}
+
+// Stream describes a live stream
+type Stream struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	UserName     string    `json:"user_name"`
+	GameID       string    `json:"game_id"`
+	Type         string    `json:"type"`
+	Title        string    `json:"title"`
+	ViewerCount  int       `json:"viewer_count"`
+	StartedAt    time.Time `json:"started_at"`
+	Language     string    `json:"language"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+
+	// GameName isn't sent by the hub, it's filled in by enrichment
+	GameName string `json:"game_name,omitempty"`
+}
+
+// Follows is the payload of user follows notifications
+type Follows struct {
+	Follows []Follow `json:"data"`
+}
+
+// Follow describes one user following another
+type Follow struct {
+	FromID     string    `json:"from_id"`
+	FromName   string    `json:"from_name"`
+	ToID       string    `json:"to_id"`
+	ToName     string    `json:"to_name"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// UserChanged is the payload of user changed notifications
+type UserChanged struct {
+	Users []User `json:"data"`
+}
+
+// User describes a twitch user
+type User struct {
+	ID              string `json:"id"`
+	Login           string `json:"login"`
+	DisplayName     string `json:"display_name"`
+	Type            string `json:"type"`
+	BroadcasterType string `json:"broadcaster_type"`
+	Description     string `json:"description"`
+	ProfileImageURL string `json:"profile_image_url"`
+	OfflineImageURL string `json:"offline_image_url"`
+	ViewCount       int    `json:"view_count"`
+}
+
+// SubscriberEvents is the payload of subscription events notifications
+type SubscriberEvents struct {
+	Events []SubscriberEvent `json:"data"`
+}
+
+// SubscriberEvent describes a user subscribing to or unsubscribing from a
+// broadcaster
+type SubscriberEvent struct {
+	ID             string     `json:"id"`
+	EventType      string     `json:"event_type"`
+	EventTimestamp time.Time  `json:"event_timestamp"`
+	Version        string     `json:"version"`
+	EventData      Subscriber `json:"event_data"`
+}
+
+// Subscriber event types
+const (
+	SubscriberEventSubscribe    = "subscriptions.subscribe"
+	SubscriberEventUnsubscribe  = "subscriptions.unsubscribe"
+	SubscriberEventNotification = "subscriptions.notification"
+)
+
+// Subscriber describes a user's subscription to a broadcaster
+type Subscriber struct {
+	BroadcasterID   string `json:"broadcaster_id"`
+	BroadcasterName string `json:"broadcaster_name"`
+	IsGift          bool   `json:"is_gift"`
+	PlanName        string `json:"plan_name"`
+	Tier            string `json:"tier"`
+	UserID          string `json:"user_id"`
+	UserName        string `json:"user_name"`
+	GifterID        string `json:"gifter_id,omitempty"`
+	GifterName      string `json:"gifter_name,omitempty"`
+}
+
+// DecodeEvent decodes a notification body into *StreamChanged, *Follows,
+// *UserChanged or *SubscriberEvents depending on its topic
+func DecodeEvent(topic string, body []byte) (interface{}, error) {
+	u, err := url.Parse(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	var event interface{}
+	switch strings.TrimSuffix(u.Path, "/") {
+	case "/helix/streams":
+		event = &StreamChanged{}
+	case "/helix/users/follows":
+		event = &Follows{}
+	case "/helix/users":
+		event = &UserChanged{}
+	case "/helix/subscriptions/events":
+		event = &SubscriberEvents{}
+	default:
+		return nil, ErrUnknownTopic
+	}
+
+	err = json.Unmarshal(body, event)
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
@@ -0,0 +1,304 @@
+// Package eventsub implements the Twitch EventSub API: managing
+// subscriptions and conduits and receiving webhook and conduit shard
+// deliveries.
+package eventsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// DefaultBaseURL is the helix API root
+const DefaultBaseURL = "https://api.twitch.tv/helix"
+
+// Transport methods
+const (
+	TransportWebhook   = "webhook"
+	TransportWebSocket = "websocket"
+	TransportConduit   = "conduit"
+)
+
+// Transport describes where eventsub delivers events
+type Transport struct {
+	Method    string `json:"method"`
+	Callback  string `json:"callback,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	ConduitID string `json:"conduit_id,omitempty"`
+}
+
+// Subscription is an eventsub subscription
+type Subscription struct {
+	ID        string            `json:"id,omitempty"`
+	Status    string            `json:"status,omitempty"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+	Transport Transport         `json:"transport"`
+	CreatedAt time.Time         `json:"created_at,omitzero"`
+	Cost      int               `json:"cost,omitempty"`
+}
+
+// Conduit groups shards that share the delivery of subscriptions
+type Conduit struct {
+	ID         string `json:"id"`
+	ShardCount int    `json:"shard_count"`
+}
+
+// Shard is a conduit shard
+type Shard struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status,omitempty"`
+	Transport Transport `json:"transport"`
+}
+
+// ShardError describes a shard that couldn't be updated
+type ShardError struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+// Client calls the helix eventsub and conduit endpoints
+type Client struct {
+	// HTTPClient must authenticate requests with an app access token, such
+	// as the client of a twitchhook.TwitchWebhookHandler
+	HTTPClient *http.Client
+
+	// ClientID is sent in the Client-Id header helix requires
+	ClientID string
+
+	// BaseURL defaults to DefaultBaseURL
+	BaseURL string
+
+	m     sync.Mutex
+	stats Stats
+}
+
+// CreateSubscription creates an eventsub subscription. It fails with a
+// CostExceededError without calling the API once the last reported total cost
+// has reached the max total cost.
+func (c *Client) CreateSubscription(ctx context.Context, sub Subscription) (*Subscription, error) {
+	err := c.checkCost(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	_, bs, err := c.send(ctx, http.MethodPost, "/eventsub/subscriptions", nil, sub)
+	if err != nil {
+		return nil, err
+	}
+	c.recordStats(bs)
+
+	var resp struct {
+		Data []Subscription `json:"data"`
+	}
+	err = json.Unmarshal(bs, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("eventsub: no subscription in response")
+	}
+	return &resp.Data[0], nil
+}
+
+// GetSubscriptions lists eventsub subscriptions, query may filter by status,
+// type or user_id
+func (c *Client) GetSubscriptions(ctx context.Context, query url.Values) ([]Subscription, error) {
+	return c.SubscriptionPages(query).All(ctx)
+}
+
+// SubscriptionPages pages through eventsub subscriptions, query filters as
+// in GetSubscriptions
+func (c *Client) SubscriptionPages(query url.Values) *twitchhook.Paginator[Subscription] {
+	return paginate[Subscription](c, "/eventsub/subscriptions", query, c.recordStats)
+}
+
+// DeleteSubscription deletes an eventsub subscription
+func (c *Client) DeleteSubscription(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/eventsub/subscriptions", url.Values{"id": {id}}, nil, nil)
+}
+
+// CreateConduit creates a conduit with shardCount shards
+func (c *Client) CreateConduit(ctx context.Context, shardCount int) (*Conduit, error) {
+	var resp struct {
+		Data []Conduit `json:"data"`
+	}
+	err := c.do(ctx, http.MethodPost, "/eventsub/conduits", nil, map[string]int{"shard_count": shardCount}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("eventsub: no conduit in response")
+	}
+	return &resp.Data[0], nil
+}
+
+// GetConduits lists the application's conduits
+func (c *Client) GetConduits(ctx context.Context) ([]Conduit, error) {
+	var resp struct {
+		Data []Conduit `json:"data"`
+	}
+	err := c.do(ctx, http.MethodGet, "/eventsub/conduits", nil, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// UpdateConduit changes the shard count of a conduit
+func (c *Client) UpdateConduit(ctx context.Context, id string, shardCount int) (*Conduit, error) {
+	var resp struct {
+		Data []Conduit `json:"data"`
+	}
+	body := struct {
+		ID         string `json:"id"`
+		ShardCount int    `json:"shard_count"`
+	}{id, shardCount}
+	err := c.do(ctx, http.MethodPatch, "/eventsub/conduits", nil, body, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("eventsub: no conduit in response")
+	}
+	return &resp.Data[0], nil
+}
+
+// DeleteConduit deletes a conduit
+func (c *Client) DeleteConduit(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/eventsub/conduits", url.Values{"id": {id}}, nil, nil)
+}
+
+// GetConduitShards lists the shards of a conduit, status filters by shard
+// status when set
+func (c *Client) GetConduitShards(ctx context.Context, conduitID, status string) ([]Shard, error) {
+	return c.ConduitShardPages(conduitID, status).All(ctx)
+}
+
+// ConduitShardPages pages through the shards of a conduit, status filters as
+// in GetConduitShards
+func (c *Client) ConduitShardPages(conduitID, status string) *twitchhook.Paginator[Shard] {
+	q := url.Values{"conduit_id": {conduitID}}
+	if status != "" {
+		q.Set("status", status)
+	}
+	return paginate[Shard](c, "/eventsub/conduits/shards", q, nil)
+}
+
+// UpdateConduitShards assigns transports to shards, returning the updated
+// shards and the shards that couldn't be updated
+func (c *Client) UpdateConduitShards(ctx context.Context, conduitID string, shards []Shard) ([]Shard, []ShardError, error) {
+	var resp struct {
+		Data   []Shard      `json:"data"`
+		Errors []ShardError `json:"errors"`
+	}
+	body := struct {
+		ConduitID string  `json:"conduit_id"`
+		Shards    []Shard `json:"shards"`
+	}{conduitID, shards}
+	err := c.do(ctx, http.MethodPatch, "/eventsub/conduits/shards", nil, body, &resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Data, resp.Errors, nil
+}
+
+// paginate pages through a GET of path, setting after to each page's cursor.
+// received is called with each page's body when it's set.
+func paginate[T any](c *Client, path string, query url.Values, received func([]byte)) *twitchhook.Paginator[T] {
+	return &twitchhook.Paginator[T]{Fetch: func(ctx context.Context, cursor string) (twitchhook.Page[T], error) {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		if cursor != "" {
+			q.Set("after", cursor)
+		}
+		header, bs, err := c.send(ctx, http.MethodGet, path, q, nil)
+		if err != nil {
+			return twitchhook.Page[T]{}, err
+		}
+		if received != nil {
+			received(bs)
+		}
+		return twitchhook.DecodePage[T](header, bs)
+	}}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	_, bs, err := c.send(ctx, method, path, query, in)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(bs) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bs, out)
+}
+
+// send makes a request, returning the response headers and body of a 2xx
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, in interface{}) (http.Header, []byte, error) {
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	u := base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		bs, err := json.Marshal(in)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = bytes.NewReader(bs)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Client-Id", c.ClientID)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		tErr := twitchhook.TwitchError{Status: int64(resp.StatusCode)}
+		if jsonErr := json.Unmarshal(bs, &tErr); jsonErr != nil || tErr.Message == "" {
+			tErr.Message = fmt.Sprintf("eventsub: %s %s: %s", method, path, resp.Status)
+		}
+		return nil, nil, tErr
+	}
+
+	return resp.Header, bs, nil
+}
@@ -0,0 +1,393 @@
+package eventsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+const clientID = "client"
+
+// fakeHelix serves the eventsub subscription and conduit endpoints from
+// memory. Lists are paged two items at a time. Created subscriptions are
+// pending verification, listing one by id verifies it.
+type fakeHelix struct {
+	*httptest.Server
+
+	m        sync.Mutex
+	subs     []Subscription
+	conduits []Conduit
+	shards   map[string][]Shard
+	ids      int
+	requests []string
+
+	// verified has the statuses subscriptions of a type get once they're
+	// verified, instead of enabled
+	verified map[string]string
+}
+
+func newFakeHelix(t *testing.T) (*fakeHelix, *Client) {
+	t.Helper()
+	f := &fakeHelix{shards: make(map[string][]Shard), verified: make(map[string]string)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f, &Client{HTTPClient: f.Client(), ClientID: clientID, BaseURL: f.URL}
+}
+
+func (f *fakeHelix) serve(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	if r.Header.Get("Client-Id") != clientID {
+		helixError(w, http.StatusUnauthorized, "Client-Id header required")
+		return
+	}
+	q := r.URL.Query()
+	switch r.Method + " " + r.URL.Path {
+	case "POST /eventsub/subscriptions":
+		var sub Subscription
+		if !decode(w, r, &sub) {
+			return
+		}
+		f.ids++
+		sub.ID = fmt.Sprintf("sub-%d", f.ids)
+		sub.Status = StatusVerificationPending
+		sub.Cost = 1
+		sub.Transport.Secret = ""
+		f.subs = append(f.subs, sub)
+		f.respond(w, []Subscription{sub})
+	case "GET /eventsub/subscriptions":
+		var subs []Subscription
+		for i, sub := range f.subs {
+			if id := q.Get("subscription_id"); id != "" {
+				if sub.ID != id {
+					continue
+				}
+				// listing a pending subscription by id verifies it
+				if sub.Status == StatusVerificationPending {
+					status, ok := f.verified[sub.Type]
+					if !ok {
+						status = StatusEnabled
+					}
+					f.subs[i].Status = status
+					sub.Status = status
+				}
+			}
+			if typ := q.Get("type"); typ != "" && sub.Type != typ {
+				continue
+			}
+			subs = append(subs, sub)
+		}
+		page(w, r, subs, f.totals())
+	case "DELETE /eventsub/subscriptions":
+		for i, sub := range f.subs {
+			if sub.ID == q.Get("id") {
+				f.subs = append(f.subs[:i], f.subs[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		helixError(w, http.StatusNotFound, "subscription not found")
+	case "POST /eventsub/conduits":
+		var body struct {
+			ShardCount int `json:"shard_count"`
+		}
+		if !decode(w, r, &body) {
+			return
+		}
+		f.ids++
+		conduit := Conduit{ID: fmt.Sprintf("conduit-%d", f.ids)}
+		f.conduits = append(f.conduits, conduit)
+		f.resize(&f.conduits[len(f.conduits)-1], body.ShardCount)
+		f.respond(w, []Conduit{f.conduits[len(f.conduits)-1]})
+	case "GET /eventsub/conduits":
+		f.respond(w, f.conduits)
+	case "PATCH /eventsub/conduits":
+		var body Conduit
+		if !decode(w, r, &body) {
+			return
+		}
+		conduit := f.conduit(body.ID)
+		if conduit == nil {
+			helixError(w, http.StatusNotFound, "conduit not found")
+			return
+		}
+		f.resize(conduit, body.ShardCount)
+		f.respond(w, []Conduit{*conduit})
+	case "DELETE /eventsub/conduits":
+		for i, conduit := range f.conduits {
+			if conduit.ID == q.Get("id") {
+				f.conduits = append(f.conduits[:i], f.conduits[i+1:]...)
+				delete(f.shards, conduit.ID)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		helixError(w, http.StatusNotFound, "conduit not found")
+	case "GET /eventsub/conduits/shards":
+		if f.conduit(q.Get("conduit_id")) == nil {
+			helixError(w, http.StatusNotFound, "conduit not found")
+			return
+		}
+		var shards []Shard
+		for _, shard := range f.shards[q.Get("conduit_id")] {
+			if status := q.Get("status"); status == "" || shard.Status == status {
+				shards = append(shards, shard)
+			}
+		}
+		page(w, r, shards, nil)
+	case "PATCH /eventsub/conduits/shards":
+		var body struct {
+			ConduitID string  `json:"conduit_id"`
+			Shards    []Shard `json:"shards"`
+		}
+		if !decode(w, r, &body) {
+			return
+		}
+		shards := f.shards[body.ConduitID]
+		resp := struct {
+			Data   []Shard      `json:"data"`
+			Errors []ShardError `json:"errors"`
+		}{Data: []Shard{}}
+		for _, update := range body.Shards {
+			i, err := strconv.Atoi(update.ID)
+			if err != nil || i < 0 || i >= len(shards) {
+				resp.Errors = append(resp.Errors, ShardError{ID: update.ID, Message: "shard not found", Code: "not_found"})
+				continue
+			}
+			update.Status = ShardEnabled
+			update.Transport.Secret = ""
+			shards[i] = update
+			resp.Data = append(resp.Data, update)
+		}
+		json.NewEncoder(w).Encode(resp)
+	default:
+		helixError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (f *fakeHelix) conduit(id string) *Conduit {
+	for i := range f.conduits {
+		if f.conduits[i].ID == id {
+			return &f.conduits[i]
+		}
+	}
+	return nil
+}
+
+// resize adds disabled shards to a conduit or drops its last ones
+func (f *fakeHelix) resize(conduit *Conduit, shardCount int) {
+	shards := f.shards[conduit.ID]
+	for i := len(shards); i < shardCount; i++ {
+		shards = append(shards, Shard{ID: strconv.Itoa(i), Status: "disabled"})
+	}
+	f.shards[conduit.ID] = shards[:shardCount]
+	conduit.ShardCount = shardCount
+}
+
+// totals are the cost fields of subscription responses
+func (f *fakeHelix) totals() map[string]any {
+	return map[string]any{"total": len(f.subs), "total_cost": len(f.subs), "max_total_cost": 10}
+}
+
+func (f *fakeHelix) respond(w http.ResponseWriter, data any) {
+	resp := map[string]any{"data": data}
+	if _, ok := data.([]Subscription); ok {
+		for k, v := range f.totals() {
+			resp[k] = v
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// page writes the two items of items after the request's cursor
+func page[T any](w http.ResponseWriter, r *http.Request, items []T, fields map[string]any) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("after"))
+	end := min(start+2, len(items))
+	resp := map[string]any{"data": append([]T{}, items[min(start, end):end]...), "pagination": map[string]string{}}
+	if end < len(items) {
+		resp["pagination"] = map[string]string{"cursor": strconv.Itoa(end)}
+	}
+	for k, v := range fields {
+		resp[k] = v
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Header.Get("Content-Type") != "application/json" {
+		helixError(w, http.StatusBadRequest, "Content-Type must be application/json")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		helixError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func helixError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(twitchhook.TwitchError{Err: http.StatusText(status), Status: int64(status), Message: message})
+}
+
+func (f *fakeHelix) requested() []string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+func TestClientSubscriptions(t *testing.T) {
+	_, c := newFakeHelix(t)
+	ctx := context.Background()
+
+	transport := Transport{Method: TransportWebhook, Callback: "https://example.com/eventsub", Secret: "0123456789abcdef"}
+	var created []Subscription
+	for _, typ := range []string{"stream.online", "stream.offline", "stream.online", "user.update", "stream.online"} {
+		sub, err := c.CreateSubscription(ctx, Subscription{
+			Type:      typ,
+			Version:   "1",
+			Condition: map[string]string{"broadcaster_user_id": "1"},
+			Transport: transport,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub.ID == "" || sub.Type != typ || sub.Status != StatusVerificationPending || sub.Transport.Callback != transport.Callback {
+			t.Fatalf("CreateSubscription = %+v", sub)
+		}
+		created = append(created, *sub)
+	}
+
+	// every page is fetched
+	subs, err := c.GetSubscriptions(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(subs, created) {
+		t.Fatalf("GetSubscriptions = %+v, want %+v", subs, created)
+	}
+	online, err := c.GetSubscriptions(ctx, url.Values{"type": {"stream.online"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(online) != 3 {
+		t.Fatalf("GetSubscriptions of stream.online = %+v", online)
+	}
+
+	if err := c.DeleteSubscription(ctx, created[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	subs, err = c.GetSubscriptions(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 4 || subs[0].ID != created[1].ID {
+		t.Fatalf("GetSubscriptions after DeleteSubscription = %+v", subs)
+	}
+	if stats := c.Stats(); stats.Total != 4 || stats.MaxTotalCost != 10 {
+		t.Fatalf("Stats = %+v, want the totals of the last list", stats)
+	}
+}
+
+func TestClientConduits(t *testing.T) {
+	_, c := newFakeHelix(t)
+	ctx := context.Background()
+
+	conduit, err := c.CreateConduit(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conduit.ID == "" || conduit.ShardCount != 3 {
+		t.Fatalf("CreateConduit = %+v", conduit)
+	}
+	conduits, err := c.GetConduits(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conduits, []Conduit{*conduit}) {
+		t.Fatalf("GetConduits = %+v", conduits)
+	}
+
+	updated, errs, err := c.UpdateConduitShards(ctx, conduit.ID, []Shard{
+		{ID: "1", Transport: Transport{Method: TransportWebhook, Callback: "https://a.example.com/eventsub", Secret: "secret"}},
+		{ID: "7", Transport: Transport{Method: TransportWebhook, Callback: "https://b.example.com/eventsub", Secret: "secret"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 1 || updated[0].ID != "1" || updated[0].Status != ShardEnabled {
+		t.Fatalf("UpdateConduitShards updated %+v", updated)
+	}
+	if len(errs) != 1 || errs[0].ID != "7" || errs[0].Message == "" {
+		t.Fatalf("UpdateConduitShards errors = %+v, want the missing shard", errs)
+	}
+
+	shards, err := c.GetConduitShards(ctx, conduit.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 3 || shards[1].Transport.Callback != "https://a.example.com/eventsub" {
+		t.Fatalf("GetConduitShards = %+v", shards)
+	}
+	enabled, err := c.GetConduitShards(ctx, conduit.ID, ShardEnabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enabled) != 1 || enabled[0].ID != "1" {
+		t.Fatalf("GetConduitShards of enabled shards = %+v", enabled)
+	}
+
+	conduit, err = c.UpdateConduit(ctx, conduit.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conduit.ShardCount != 1 {
+		t.Fatalf("UpdateConduit = %+v", conduit)
+	}
+	if err := c.DeleteConduit(ctx, conduit.ID); err != nil {
+		t.Fatal(err)
+	}
+	if conduits, err := c.GetConduits(ctx); err != nil || len(conduits) != 0 {
+		t.Fatalf("GetConduits after DeleteConduit = %+v, %v", conduits, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	_, c := newFakeHelix(t)
+	ctx := context.Background()
+
+	err := c.DeleteConduit(ctx, "missing")
+	var tErr twitchhook.TwitchError
+	if !errors.As(err, &tErr) || tErr.Status != http.StatusNotFound || tErr.Message != "conduit not found" {
+		t.Fatalf("DeleteConduit of a missing conduit = %#v, want helix's error", err)
+	}
+
+	c.ClientID = ""
+	_, err = c.GetConduits(ctx)
+	if !errors.As(err, &tErr) || tErr.Status != http.StatusUnauthorized {
+		t.Fatalf("GetConduits without a client id = %#v", err)
+	}
+
+	// errors without a message are described by the request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream timeout", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	c = &Client{BaseURL: srv.URL}
+	_, err = c.GetConduits(ctx)
+	if !errors.As(err, &tErr) || tErr.Status != http.StatusBadGateway || tErr.Message != "eventsub: GET /eventsub/conduits: 502 Bad Gateway" {
+		t.Fatalf("GetConduits of a 502 = %#v", err)
+	}
+}
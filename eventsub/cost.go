@@ -0,0 +1,58 @@
+package eventsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Stats are the subscription totals last reported by the eventsub API
+type Stats struct {
+	Total        int       `json:"total"`
+	TotalCost    int       `json:"total_cost"`
+	MaxTotalCost int       `json:"max_total_cost"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// CostExceededError is returned by CreateSubscription when the last reported
+// total cost leaves no room for another subscription
+type CostExceededError struct {
+	Type         string
+	TotalCost    int
+	MaxTotalCost int
+}
+
+func (e *CostExceededError) Error() string {
+	return fmt.Sprintf("eventsub: creating %s subscription would exceed max total cost %d, total cost is %d", e.Type, e.MaxTotalCost, e.TotalCost)
+}
+
+// Stats returns the subscription totals from the last create or list
+// response, they're zero until one has been received
+func (c *Client) Stats() Stats {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.stats
+}
+
+// recordStats keeps the totals of a create or list response body
+func (c *Client) recordStats(bs []byte) {
+	var stats Stats
+	if json.Unmarshal(bs, &stats) != nil || stats.MaxTotalCost == 0 {
+		return
+	}
+	stats.UpdatedAt = time.Now()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.stats = stats
+}
+
+// checkCost fails when a subscription, which costs at most 1, can't fit under
+// the last reported max total cost
+func (c *Client) checkCost(sub Subscription) error {
+	stats := c.Stats()
+	if stats.MaxTotalCost > 0 && stats.TotalCost >= stats.MaxTotalCost {
+		return &CostExceededError{Type: sub.Type, TotalCost: stats.TotalCost, MaxTotalCost: stats.MaxTotalCost}
+	}
+	return nil
+}
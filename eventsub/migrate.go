@@ -0,0 +1,277 @@
+package eventsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"go.uber.org/zap"
+)
+
+// ErrUnsupportedTopic is returned for websub topics without an eventsub
+// equivalent
+var ErrUnsupportedTopic = errors.New("eventsub: websub topic has no eventsub equivalent")
+
+// Subscription statuses
+const (
+	StatusEnabled             = "enabled"
+	StatusVerificationPending = "webhook_callback_verification_pending"
+)
+
+type topicMapping struct {
+	param   string
+	types   []string
+	version string
+	keys    []string
+}
+
+var topicMappings = map[string]topicMapping{
+	"/helix/users/follows": {
+		param: "to_id", types: []string{"channel.follow"}, version: "2",
+		keys: []string{"broadcaster_user_id", "moderator_user_id"},
+	},
+	"/helix/streams": {
+		param: "user_id", types: []string{"stream.online", "stream.offline"}, version: "1",
+		keys: []string{"broadcaster_user_id"},
+	},
+	"/helix/users": {
+		param: "id", types: []string{"user.update"}, version: "1",
+		keys: []string{"user_id"},
+	},
+	"/helix/subscriptions/events": {
+		param: "broadcaster_id", version: "1",
+		types: []string{
+			"channel.subscribe",
+			"channel.subscription.end",
+			"channel.subscription.gift",
+			"channel.subscription.message",
+		},
+		keys: []string{"broadcaster_user_id"},
+	},
+	"/helix/moderation/moderators/events": {
+		param: "broadcaster_id", types: []string{"channel.moderator.add", "channel.moderator.remove"}, version: "1",
+		keys: []string{"broadcaster_user_id"},
+	},
+	"/helix/moderation/banned/events": {
+		param: "broadcaster_id", types: []string{"channel.ban", "channel.unban"}, version: "1",
+		keys: []string{"broadcaster_user_id"},
+	},
+	"/helix/hypetrain/events": {
+		param: "broadcaster_id", version: "1",
+		types: []string{"channel.hype_train.begin", "channel.hype_train.progress", "channel.hype_train.end"},
+		keys:  []string{"broadcaster_user_id"},
+	},
+	"/helix/extensions/transactions": {
+		param: "extension_id", types: []string{"extension.bits_transaction.create"}, version: "1",
+		keys: []string{"extension_client_id"},
+	},
+}
+
+// TopicSubscriptions returns the eventsub subscriptions, without transports,
+// that deliver the events of a websub topic
+func TopicSubscriptions(topic string) ([]Subscription, error) {
+	u, err := url.Parse(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping, ok := topicMappings[strings.TrimSuffix(u.Path, "/")]
+	if !ok {
+		return nil, ErrUnsupportedTopic
+	}
+	id := u.Query().Get(mapping.param)
+	if id == "" {
+		return nil, fmt.Errorf("%w: %s requires %s", ErrUnsupportedTopic, u.Path, mapping.param)
+	}
+
+	subs := make([]Subscription, 0, len(mapping.types))
+	for _, typ := range mapping.types {
+		condition := make(map[string]string, len(mapping.keys))
+		for _, key := range mapping.keys {
+			condition[key] = id
+		}
+		subs = append(subs, Subscription{
+			Type:      typ,
+			Version:   mapping.version,
+			Condition: condition,
+		})
+	}
+	return subs, nil
+}
+
+// Migration is the outcome of migrating a websub topic
+type Migration struct {
+	Topic string
+
+	// Subscriptions are the eventsub subscriptions replacing the topic, they
+	// have ids once created
+	Subscriptions []Subscription
+
+	// Unsubscribed is set once the websub subscription has been removed
+	Unsubscribed bool
+
+	Err error
+}
+
+// Migrator moves websub subscriptions to eventsub
+type Migrator struct {
+	// Hub holds the websub subscriptions, its Manager must implement
+	// twitchhook.SubscriptionLister
+	Hub *twitchhook.TwitchWebhookHandler
+
+	Client *Client
+
+	// Transport is used for every created subscription
+	Transport Transport
+
+	// Registry, when set, tracks the created subscriptions with OnRevoked
+	// as their revocation callback
+	Registry  *Registry
+	OnRevoked RevocationFunc
+
+	// DryRun plans the migration without creating or removing subscriptions
+	DryRun bool
+
+	// VerifyTimeout bounds the wait for each subscription to be verified,
+	// defaults to a minute
+	VerifyTimeout time.Duration
+
+	// PollInterval is how often verification is checked, defaults to two
+	// seconds
+	PollInterval time.Duration
+
+	Logger *zap.Logger
+}
+
+// Migrate creates the eventsub subscriptions for every stored websub topic,
+// waits for them to be verified and then unsubscribes the websub topic. Topics
+// whose migration fails keep their websub subscription and report the error
+// in their Migration.
+func (m *Migrator) Migrate(ctx context.Context) ([]Migration, error) {
+	lister, ok := m.Hub.Manager.(twitchhook.SubscriptionLister)
+	if !ok {
+		return nil, errors.New("eventsub: hub manager can't list subscriptions")
+	}
+
+	stored, err := lister.List()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(stored))
+	for _, sub := range stored {
+		migration := m.migrate(ctx, sub.Topic)
+		if migration.Err != nil {
+			m.logger().Error("error migrating websub topic", zap.String("topic", sub.Topic), zap.Error(migration.Err))
+		}
+		migrations = append(migrations, migration)
+
+		if ctx.Err() != nil {
+			return migrations, ctx.Err()
+		}
+	}
+	return migrations, nil
+}
+
+func (m *Migrator) migrate(ctx context.Context, topic string) Migration {
+	migration := Migration{Topic: topic}
+
+	planned, err := TopicSubscriptions(topic)
+	if err != nil {
+		migration.Err = err
+		return migration
+	}
+	for i := range planned {
+		planned[i].Transport = m.Transport
+	}
+	migration.Subscriptions = planned
+
+	if m.DryRun {
+		for _, sub := range planned {
+			m.logger().Info("would create eventsub subscription",
+				zap.String("topic", topic),
+				zap.String("type", sub.Type),
+				zap.Any("condition", sub.Condition),
+			)
+		}
+		return migration
+	}
+
+	for i, sub := range planned {
+		created, err := m.Client.CreateSubscription(ctx, sub)
+		if err != nil {
+			migration.Err = err
+			return migration
+		}
+		migration.Subscriptions[i] = *created
+		if m.Registry != nil {
+			m.Registry.Add(*created, m.OnRevoked)
+		}
+	}
+
+	for _, sub := range migration.Subscriptions {
+		err = m.waitVerified(ctx, sub.ID)
+		if err != nil {
+			migration.Err = err
+			return migration
+		}
+	}
+
+	err = m.Hub.UnsubscribeContext(ctx, topic)
+	if err != nil {
+		migration.Err = err
+		return migration
+	}
+	migration.Unsubscribed = true
+	return migration
+}
+
+func (m *Migrator) waitVerified(ctx context.Context, id string) error {
+	timeout := m.VerifyTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	interval := m.PollInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		subs, err := m.Client.GetSubscriptions(ctx, url.Values{"subscription_id": {id}})
+		if err != nil {
+			return err
+		}
+		if len(subs) == 0 {
+			return fmt.Errorf("eventsub: subscription %s disappeared before verification", id)
+		}
+		switch subs[0].Status {
+		case StatusEnabled:
+			return nil
+		case StatusVerificationPending:
+		default:
+			return fmt.Errorf("eventsub: subscription %s is %s", id, subs[0].Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("eventsub: waiting for verification of %s: %w", id, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Migrator) logger() *zap.Logger {
+	if m.Logger == nil {
+		return zap.NewNop()
+	}
+	return m.Logger
+}
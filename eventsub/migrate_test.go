@@ -0,0 +1,228 @@
+package eventsub
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+)
+
+func TestTopicSubscriptions(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  []Subscription
+		err   bool
+	}{
+		{
+			topic: "https://api.twitch.tv/helix/streams?user_id=1",
+			want: []Subscription{
+				{Type: "stream.online", Version: "1", Condition: map[string]string{"broadcaster_user_id": "1"}},
+				{Type: "stream.offline", Version: "1", Condition: map[string]string{"broadcaster_user_id": "1"}},
+			},
+		},
+		{
+			topic: "https://api.twitch.tv/helix/users/follows?first=1&to_id=2",
+			want: []Subscription{
+				{Type: "channel.follow", Version: "2", Condition: map[string]string{"broadcaster_user_id": "2", "moderator_user_id": "2"}},
+			},
+		},
+		{
+			topic: "https://api.twitch.tv/helix/users/?id=3",
+			want:  []Subscription{{Type: "user.update", Version: "1", Condition: map[string]string{"user_id": "3"}}},
+		},
+		// follows from a user have no eventsub equivalent
+		{topic: "https://api.twitch.tv/helix/users/follows?from_id=1", err: true},
+		{topic: "https://api.twitch.tv/helix/games?id=1", err: true},
+		{topic: "://", err: true},
+	}
+	for _, tt := range tests {
+		got, err := TopicSubscriptions(tt.topic)
+		if tt.err {
+			if err == nil {
+				t.Errorf("TopicSubscriptions(%s) = %+v, want an error", tt.topic, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TopicSubscriptions(%s) = %+v, %v, want %+v", tt.topic, got, err, tt.want)
+		}
+	}
+	if _, err := TopicSubscriptions("https://api.twitch.tv/helix/streams"); !errors.Is(err, ErrUnsupportedTopic) {
+		t.Fatalf("TopicSubscriptions without user_id = %v, want ErrUnsupportedTopic", err)
+	}
+}
+
+// websubHub answers token requests and accepts every websub hub request,
+// recording their modes and topics
+type websubHub struct {
+	m        sync.Mutex
+	requests []string
+}
+
+func (hub *websubHub) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == "id.twitch.tv" {
+		return response(http.StatusOK, `{"access_token":"token","token_type":"bearer","expires_in":3600}`), nil
+	}
+	bs, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(bs))
+	if err != nil {
+		return nil, err
+	}
+	hub.m.Lock()
+	defer hub.m.Unlock()
+	hub.requests = append(hub.requests, form.Get("hub.mode")+" "+form.Get("hub.topic"))
+	return response(http.StatusAccepted, ""), nil
+}
+
+func response(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Header: http.Header{"Content-Type": {"application/json"}}, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+// newMigrator returns a Migrator of a hub holding topics
+func newMigrator(t *testing.T, topics ...string) (*Migrator, *fakeHelix, *websubHub) {
+	t.Helper()
+	clock := clocktest.NewClock(epoch)
+	hub := &websubHub{}
+	h := &twitchhook.TwitchWebhookHandler{
+		OAuth2ClientID:     clientID,
+		OAuth2ClientSecret: "secret",
+		CallbackBaseURL:    "https://example.com/callback",
+		Manager:            &twitchhook.InMemoryCache{Clock: clock},
+		HTTPClient:         &http.Client{Transport: hub},
+		Clock:              clock,
+	}
+	for _, topic := range topics {
+		id, err := twitchhook.NewSubscriptionID(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = h.Manager.Save(topic, &twitchhook.Subscription{
+			ID:          id,
+			Topic:       topic,
+			CallbackURL: "https://example.com/callback/" + string(id),
+			Lease:       time.Hour,
+			ExpiresAt:   epoch.Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, c := newFakeHelix(t)
+	return &Migrator{
+		Hub:          h,
+		Client:       c,
+		Transport:    Transport{Method: TransportWebhook, Callback: "https://example.com/eventsub", Secret: secret},
+		PollInterval: time.Millisecond,
+	}, f, hub
+}
+
+const (
+	streams = "https://api.twitch.tv/helix/streams?user_id=1"
+	users   = "https://api.twitch.tv/helix/users?id=1"
+	games   = "https://api.twitch.tv/helix/games?id=1"
+)
+
+func TestMigrator(t *testing.T) {
+	m, f, hub := newMigrator(t, streams, users, games)
+	m.Registry = &Registry{}
+	f.verified["user.update"] = "webhook_callback_verification_failed"
+
+	migrations, err := m.Migrate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTopic := make(map[string]Migration, len(migrations))
+	for _, migration := range migrations {
+		byTopic[migration.Topic] = migration
+	}
+	if len(byTopic) != 3 {
+		t.Fatalf("Migrate = %+v, want a migration per topic", migrations)
+	}
+
+	migrated := byTopic[streams]
+	if migrated.Err != nil || !migrated.Unsubscribed || len(migrated.Subscriptions) != 2 {
+		t.Fatalf("streams migration = %+v", migrated)
+	}
+	for _, sub := range migrated.Subscriptions {
+		if sub.ID == "" || sub.Transport.Callback != m.Transport.Callback {
+			t.Fatalf("migrated subscription = %+v, want it created with the Migrator's transport", sub)
+		}
+		if _, ok := m.Registry.Get(sub.ID); !ok {
+			t.Fatalf("subscription %s isn't in the Registry", sub.ID)
+		}
+	}
+
+	// topics whose subscriptions aren't verified or can't be mapped keep
+	// their websub subscription
+	if failed := byTopic[users]; failed.Err == nil || failed.Unsubscribed {
+		t.Fatalf("users migration = %+v, want the failed verification", failed)
+	}
+	if unsupported := byTopic[games]; !errors.Is(unsupported.Err, ErrUnsupportedTopic) || unsupported.Unsubscribed {
+		t.Fatalf("games migration = %+v", unsupported)
+	}
+	if !reflect.DeepEqual(hub.requests, []string{"unsubscribe " + streams}) {
+		t.Fatalf("hub requests = %v, want the migrated topic unsubscribed", hub.requests)
+	}
+	for _, topic := range []string{users, games} {
+		if _, err := m.Hub.Manager.Get(topic); err != nil {
+			t.Fatalf("Get(%s) = %v, want the websub subscription kept", topic, err)
+		}
+	}
+}
+
+func TestMigratorDryRun(t *testing.T) {
+	m, f, hub := newMigrator(t, streams)
+	m.DryRun = true
+
+	migrations, err := m.Migrate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 1 || migrations[0].Err != nil || migrations[0].Unsubscribed || len(migrations[0].Subscriptions) != 2 {
+		t.Fatalf("Migrate = %+v, want the planned subscriptions", migrations)
+	}
+	if reqs := f.requested(); len(reqs) != 0 {
+		t.Fatalf("dry run made eventsub requests %v", reqs)
+	}
+	if len(hub.requests) != 0 {
+		t.Fatalf("dry run made hub requests %v", hub.requests)
+	}
+}
+
+func TestMigratorVerifyTimeout(t *testing.T) {
+	m, f, hub := newMigrator(t, streams)
+	m.VerifyTimeout = 20 * time.Millisecond
+	// the stream.online subscription stays pending
+	f.verified["stream.online"] = StatusVerificationPending
+
+	migrations, err := m.Migrate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 1 || !errors.Is(migrations[0].Err, context.DeadlineExceeded) || migrations[0].Unsubscribed {
+		t.Fatalf("Migrate = %+v, want the verification timed out", migrations)
+	}
+	if len(hub.requests) != 0 {
+		t.Fatalf("hub requests = %v, want the websub subscription kept", hub.requests)
+	}
+}
+
+func TestMigratorRequiresLister(t *testing.T) {
+	m, _, _ := newMigrator(t)
+	m.Hub.Manager = struct{ twitchhook.SubscriptionManager }{m.Hub.Manager}
+	if _, err := m.Migrate(context.Background()); err == nil {
+		t.Fatal("Migrate succeeded with a manager that can't list subscriptions")
+	}
+}
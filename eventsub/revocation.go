@@ -0,0 +1,104 @@
+package eventsub
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// RevocationReason is the status of a revoked subscription
+type RevocationReason string
+
+// Revocation reasons
+const (
+	RevocationUserRemoved                  RevocationReason = "user_removed"
+	RevocationAuthorizationRevoked         RevocationReason = "authorization_revoked"
+	RevocationNotificationFailuresExceeded RevocationReason = "notification_failures_exceeded"
+	RevocationVersionRemoved               RevocationReason = "version_removed"
+)
+
+// RevocationReason returns the reason of a revocation message
+func (m *Message) RevocationReason() RevocationReason {
+	return RevocationReason(m.Subscription.Status)
+}
+
+// RevocationFunc is called when a subscription is revoked
+type RevocationFunc func(ctx context.Context, reason RevocationReason, sub Subscription)
+
+type registered struct {
+	sub     Subscription
+	revoked RevocationFunc
+}
+
+// Registry tracks eventsub subscriptions by id and calls their revocation
+// callbacks. A Handler with a Registry removes revoked subscriptions from it,
+// as the websub handler deletes denied subscriptions from its Manager.
+type Registry struct {
+	m    sync.Mutex
+	subs map[string]registered
+}
+
+// Add tracks sub, calling revoked if it's revoked. revoked may be nil.
+func (r *Registry) Add(sub Subscription, revoked RevocationFunc) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.subs == nil {
+		r.subs = make(map[string]registered)
+	}
+	r.subs[sub.ID] = registered{sub: sub, revoked: revoked}
+}
+
+// Create creates sub with c and tracks it
+func (r *Registry) Create(ctx context.Context, c *Client, sub Subscription, revoked RevocationFunc) (*Subscription, error) {
+	created, err := c.CreateSubscription(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+	r.Add(*created, revoked)
+	return created, nil
+}
+
+// Get returns a tracked subscription
+func (r *Registry) Get(id string) (Subscription, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	entry, ok := r.subs[id]
+	return entry.sub, ok
+}
+
+// Remove stops tracking a subscription, its revocation callback isn't called
+func (r *Registry) Remove(id string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.subs, id)
+}
+
+// List returns the tracked subscriptions ordered by id
+func (r *Registry) List() []Subscription {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	subs := make([]Subscription, 0, len(r.subs))
+	for _, entry := range r.subs {
+		subs = append(subs, entry.sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+	return subs
+}
+
+// revoke removes a revoked subscription and calls its callback, reporting
+// whether it was tracked
+func (r *Registry) revoke(ctx context.Context, reason RevocationReason, sub Subscription) bool {
+	r.m.Lock()
+	entry, ok := r.subs[sub.ID]
+	delete(r.subs, sub.ID)
+	r.m.Unlock()
+
+	if ok && entry.revoked != nil {
+		entry.revoked(ctx, reason, sub)
+	}
+	return ok
+}
@@ -0,0 +1,152 @@
+package eventsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Shard statuses
+const (
+	ShardEnabled = "enabled"
+)
+
+// SubscriptionTypeShardDisabled is the eventsub type notifying that a conduit
+// shard has been disabled
+const SubscriptionTypeShardDisabled = "conduit.shard.disabled"
+
+// ShardDisabledEvent is the event of a conduit.shard.disabled notification
+type ShardDisabledEvent struct {
+	ConduitID string    `json:"conduit_id"`
+	ShardID   string    `json:"shard_id"`
+	Status    string    `json:"status"`
+	Transport Transport `json:"transport"`
+}
+
+// ShardTransport registers this process as a webhook shard of a conduit and
+// keeps the registration current. Deliveries arrive at Callback and should be
+// served by a Handler signed with Secret.
+type ShardTransport struct {
+	Client    *Client
+	ConduitID string
+
+	// ShardID is the shard this process serves. When the conduit shrinks
+	// past it or another process takes it over, the transport moves to a
+	// shard that isn't enabled and OnReassign is called with the new id.
+	ShardID    string
+	OnReassign func(shardID string)
+
+	Callback string
+	Secret   string
+
+	Logger *zap.Logger
+}
+
+// Register assigns this process's webhook to ShardID
+func (s *ShardTransport) Register(ctx context.Context) error {
+	_, errs, err := s.Client.UpdateConduitShards(ctx, s.ConduitID, []Shard{{
+		ID: s.ShardID,
+		Transport: Transport{
+			Method:   TransportWebhook,
+			Callback: s.Callback,
+			Secret:   s.Secret,
+		},
+	}})
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("eventsub: updating shard %s: %s", errs[0].ID, errs[0].Message)
+	}
+	return nil
+}
+
+// Check fetches the conduit's shards and registers again if this process's
+// shard is disabled, pointed elsewhere or gone
+func (s *ShardTransport) Check(ctx context.Context) error {
+	shards, err := s.Client.GetConduitShards(ctx, s.ConduitID, "")
+	if err != nil {
+		return err
+	}
+
+	var own *Shard
+	for i := range shards {
+		if shards[i].ID == s.ShardID {
+			own = &shards[i]
+			break
+		}
+	}
+
+	switch {
+	case own == nil, own.Status == ShardEnabled && own.Transport.Callback != s.Callback:
+		// the shard is gone or another process serves it
+		return s.reassign(ctx, shards)
+	case own.Status != ShardEnabled:
+		return s.Register(ctx)
+	default:
+		return nil
+	}
+}
+
+func (s *ShardTransport) reassign(ctx context.Context, shards []Shard) error {
+	for _, shard := range shards {
+		if shard.Status == ShardEnabled {
+			continue
+		}
+		s.ShardID = shard.ID
+		if s.OnReassign != nil {
+			s.OnReassign(shard.ID)
+		}
+		return s.Register(ctx)
+	}
+	return fmt.Errorf("eventsub: no free shard in conduit %s of %d shards", s.ConduitID, len(shards))
+}
+
+// Run checks the shard every interval until ctx is done
+func (s *ShardTransport) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := s.Check(ctx)
+		if err != nil {
+			s.logger().Error("error checking conduit shard", zap.String("shard", s.ShardID), zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Wrap intercepts conduit.shard.disabled notifications for this process's
+// shard to register again, every notification is passed on to next
+func (s *ShardTransport) Wrap(next NotificationFunc) NotificationFunc {
+	return func(ctx context.Context, msg *Message) error {
+		if msg.Subscription.Type == SubscriptionTypeShardDisabled {
+			var event ShardDisabledEvent
+			err := json.Unmarshal(msg.Event, &event)
+			if err == nil && event.ConduitID == s.ConduitID && event.ShardID == s.ShardID {
+				err = s.Check(ctx)
+				if err != nil {
+					s.logger().Error("error re-registering disabled shard", zap.String("shard", s.ShardID), zap.Error(err))
+				}
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		return next(ctx, msg)
+	}
+}
+
+func (s *ShardTransport) logger() *zap.Logger {
+	if s.Logger == nil {
+		return zap.NewNop()
+	}
+	return s.Logger
+}
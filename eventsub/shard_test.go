@@ -0,0 +1,175 @@
+package eventsub
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const (
+	ownCallback   = "https://a.example.com/eventsub"
+	otherCallback = "https://b.example.com/eventsub"
+)
+
+// newConduit creates a conduit of shardCount shards with the shards in
+// callbacks registered to their callback
+func newConduit(t *testing.T, c *Client, shardCount int, callbacks map[string]string) string {
+	t.Helper()
+	conduit, err := c.CreateConduit(context.Background(), shardCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, callback := range callbacks {
+		s := &ShardTransport{Client: c, ConduitID: conduit.ID, ShardID: id, Callback: callback, Secret: "secret"}
+		if err := s.Register(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conduit.ID
+}
+
+// updates counts the shard updates f received
+func (f *fakeHelix) updates() int {
+	n := 0
+	for _, req := range f.requested() {
+		if req == "PATCH /eventsub/conduits/shards" {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeHelix) shard(conduitID, id string) Shard {
+	f.m.Lock()
+	defer f.m.Unlock()
+	for _, shard := range f.shards[conduitID] {
+		if shard.ID == id {
+			return shard
+		}
+	}
+	return Shard{}
+}
+
+func TestShardTransportCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		shardCount int
+		callbacks  map[string]string
+		disable    bool
+		shardID    string
+		want       string
+		reassigned bool
+		updates    int
+		err        bool
+	}{
+		{name: "registered", shardCount: 2, callbacks: map[string]string{"0": ownCallback}, shardID: "0", want: "0"},
+		{name: "disabled", shardCount: 2, callbacks: map[string]string{"0": ownCallback}, disable: true, shardID: "0", want: "0", updates: 1},
+		{name: "taken over", shardCount: 3, callbacks: map[string]string{"0": otherCallback, "1": otherCallback}, shardID: "0", want: "2", reassigned: true, updates: 1},
+		{name: "conduit shrank", shardCount: 2, callbacks: map[string]string{"1": otherCallback}, shardID: "5", want: "0", reassigned: true, updates: 1},
+		{name: "no free shard", shardCount: 1, callbacks: map[string]string{"0": otherCallback}, shardID: "0", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, c := newFakeHelix(t)
+			conduitID := newConduit(t, c, tt.shardCount, tt.callbacks)
+			if tt.disable {
+				f.m.Lock()
+				f.shards[conduitID][0].Status = "webhook_callback_verification_failed"
+				f.m.Unlock()
+			}
+			var reassigned []string
+			s := &ShardTransport{
+				Client:     c,
+				ConduitID:  conduitID,
+				ShardID:    tt.shardID,
+				OnReassign: func(id string) { reassigned = append(reassigned, id) },
+				Callback:   ownCallback,
+				Secret:     "secret",
+			}
+			updates := f.updates()
+
+			err := s.Check(context.Background())
+			if tt.err {
+				if err == nil {
+					t.Fatal("Check succeeded without a free shard")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.ShardID != tt.want {
+				t.Fatalf("ShardID = %s after Check, want %s", s.ShardID, tt.want)
+			}
+			if n := f.updates() - updates; n != tt.updates {
+				t.Fatalf("Check updated shards %d times, want %d", n, tt.updates)
+			}
+			if shard := f.shard(conduitID, tt.want); shard.Status != ShardEnabled || shard.Transport.Callback != ownCallback {
+				t.Fatalf("shard %s = %+v after Check, want it registered", tt.want, shard)
+			}
+			var want []string
+			if tt.reassigned {
+				want = []string{tt.want}
+			}
+			if !reflect.DeepEqual(reassigned, want) {
+				t.Fatalf("OnReassign called with %v, want %v", reassigned, want)
+			}
+		})
+	}
+}
+
+func TestShardTransportRegisterErrors(t *testing.T) {
+	_, c := newFakeHelix(t)
+	s := &ShardTransport{Client: c, ConduitID: newConduit(t, c, 1, nil), ShardID: "3", Callback: ownCallback, Secret: "secret"}
+	if err := s.Register(context.Background()); err == nil {
+		t.Fatal("Register of a missing shard succeeded")
+	}
+}
+
+func TestShardTransportWrap(t *testing.T) {
+	f, c := newFakeHelix(t)
+	conduitID := newConduit(t, c, 2, map[string]string{"0": ownCallback})
+	s := &ShardTransport{Client: c, ConduitID: conduitID, ShardID: "0", Callback: ownCallback, Secret: "secret"}
+
+	var passed []string
+	h := s.Wrap(func(ctx context.Context, msg *Message) error {
+		passed = append(passed, msg.ID)
+		return nil
+	})
+	disabled := func(id, conduitID, shardID string) *Message {
+		event, _ := json.Marshal(ShardDisabledEvent{ConduitID: conduitID, ShardID: shardID, Status: "webhook_callback_verification_failed"})
+		return &Message{ID: id, Type: MessageTypeNotification, Subscription: Subscription{Type: SubscriptionTypeShardDisabled}, Event: event}
+	}
+
+	f.m.Lock()
+	f.shards[conduitID][0].Status = "webhook_callback_verification_failed"
+	f.m.Unlock()
+	updates := f.updates()
+	for _, msg := range []*Message{
+		disabled("other conduit", "conduit-0", "0"),
+		disabled("other shard", conduitID, "1"),
+		{ID: "notification", Type: MessageTypeNotification, Subscription: Subscription{Type: "stream.online"}},
+	} {
+		if err := h(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.updates() != updates {
+		t.Fatal("Wrap registered again for another shard's notification")
+	}
+	if err := h(context.Background(), disabled("own shard", conduitID, "0")); err != nil {
+		t.Fatal(err)
+	}
+	if f.updates() != updates+1 || f.shard(conduitID, "0").Status != ShardEnabled {
+		t.Fatal("Wrap didn't register the disabled shard again")
+	}
+	if len(passed) != 4 {
+		t.Fatalf("next got %v, want every message", passed)
+	}
+
+	// without next the notification is acknowledged
+	if err := s.Wrap(nil)(context.Background(), disabled("own shard", conduitID, "0")); err != nil {
+		t.Fatal(err)
+	}
+}
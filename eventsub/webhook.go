@@ -0,0 +1,229 @@
+package eventsub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"go.uber.org/zap"
+)
+
+// Webhook headers
+const (
+	HeaderMessageID           = "Twitch-Eventsub-Message-Id"
+	HeaderMessageRetry        = "Twitch-Eventsub-Message-Retry"
+	HeaderMessageType         = "Twitch-Eventsub-Message-Type"
+	HeaderMessageSignature    = "Twitch-Eventsub-Message-Signature"
+	HeaderMessageTimestamp    = "Twitch-Eventsub-Message-Timestamp"
+	HeaderSubscriptionType    = "Twitch-Eventsub-Subscription-Type"
+	HeaderSubscriptionVersion = "Twitch-Eventsub-Subscription-Version"
+)
+
+// Message types
+const (
+	MessageTypeNotification = "notification"
+	MessageTypeVerification = "webhook_callback_verification"
+	MessageTypeRevocation   = "revocation"
+)
+
+// DefaultMaxMessageAge is the age past which messages are rejected when
+// Handler.MaxMessageAge isn't set, as recommended by Twitch
+const DefaultMaxMessageAge = 10 * time.Minute
+
+var (
+	// ErrInvalidSignature is returned for messages whose signature doesn't
+	// match
+	ErrInvalidSignature = errors.New("eventsub: invalid message signature")
+
+	// ErrStaleMessage is returned for messages older than MaxMessageAge
+	ErrStaleMessage = errors.New("eventsub: stale message")
+)
+
+// Message is a verified eventsub webhook message
+type Message struct {
+	ID        string
+	Type      string
+	Timestamp time.Time
+	Retry     int
+
+	Subscription Subscription    `json:"subscription"`
+	Event        json.RawMessage `json:"event,omitempty"`
+	Challenge    string          `json:"challenge,omitempty"`
+}
+
+// NotificationFunc handles notification messages
+type NotificationFunc func(ctx context.Context, msg *Message) error
+
+// Handler receives eventsub webhook deliveries, answering verification
+// challenges and dispatching notifications and revocations
+type Handler struct {
+	// Secret is the transport secret messages are signed with
+	Secret string
+
+	// OnNotification handles notifications, an error responds with a 500 so
+	// Twitch retries the delivery
+	OnNotification NotificationFunc
+
+	// OnRevocation is called when Twitch revokes a subscription, after the
+	// subscription's own revocation callback
+	OnRevocation func(ctx context.Context, msg *Message)
+
+	// Registry, when set, has revoked subscriptions removed and their
+	// revocation callbacks called
+	Registry *Registry
+
+	// MaxMessageAge defaults to DefaultMaxMessageAge
+	MaxMessageAge time.Duration
+
+	// Dedup drops redelivered messages
+	Dedup twitchhook.DedupStore
+
+	Clock  twitchhook.Clock
+	Logger *zap.Logger
+}
+
+// ServeHTTP handles a delivery
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	msg, err := h.verify(r)
+	switch err {
+	case nil:
+	case ErrInvalidSignature, ErrStaleMessage:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	default:
+		h.logger().Info("error reading eventsub message", zap.Error(err))
+		http.Error(w, "invalid message", http.StatusBadRequest)
+		return
+	}
+
+	if msg.Type != MessageTypeVerification && h.Dedup != nil {
+		seen, err := h.Dedup.Seen(msg.ID, h.now().Add(h.maxMessageAge()))
+		if err != nil {
+			h.logger().Error("error checking eventsub message id", zap.Error(err))
+		} else if seen {
+			return
+		}
+	}
+
+	switch msg.Type {
+	case MessageTypeVerification:
+		w.Header().Set("Content-Type", "text/plain")
+		_, err = io.WriteString(w, msg.Challenge)
+		if err != nil {
+			h.logger().Info("error responding with challenge", zap.Error(err))
+		}
+	case MessageTypeRevocation:
+		h.revoked(r.Context(), msg)
+	case MessageTypeNotification:
+		if h.OnNotification == nil {
+			return
+		}
+		err = h.OnNotification(r.Context(), msg)
+		if err != nil {
+			h.logger().Error("error handling eventsub notification",
+				zap.String("type", msg.Subscription.Type),
+				zap.Error(err),
+			)
+			http.Error(w, "error handling notification", http.StatusInternalServerError)
+		}
+	default:
+		http.Error(w, "unknown message type", http.StatusBadRequest)
+	}
+}
+
+func (h *Handler) revoked(ctx context.Context, msg *Message) {
+	reason := msg.RevocationReason()
+	tracked := false
+	if h.Registry != nil {
+		tracked = h.Registry.revoke(ctx, reason, msg.Subscription)
+	}
+	h.logger().Info("eventsub subscription revoked",
+		zap.String("id", msg.Subscription.ID),
+		zap.String("type", msg.Subscription.Type),
+		zap.String("reason", string(reason)),
+		zap.Bool("tracked", tracked),
+	)
+	if h.OnRevocation != nil {
+		h.OnRevocation(ctx, msg)
+	}
+}
+
+func (h *Handler) verify(r *http.Request) (*Message, error) {
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	id := r.Header.Get(HeaderMessageID)
+	timestamp := r.Header.Get(HeaderMessageTimestamp)
+	signature := strings.TrimPrefix(r.Header.Get(HeaderMessageSignature), "sha256=")
+	provided, err := hex.DecodeString(signature)
+	if err != nil || signature == "" {
+		return nil, ErrInvalidSignature
+	}
+	if !hmac.Equal(Sign(h.Secret, id, timestamp, bs), provided) {
+		return nil, ErrInvalidSignature
+	}
+
+	sent, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return nil, ErrStaleMessage
+	}
+	if age := h.now().Sub(sent); age > h.maxMessageAge() || -age > h.maxMessageAge() {
+		return nil, ErrStaleMessage
+	}
+
+	msg := &Message{
+		ID:        id,
+		Type:      r.Header.Get(HeaderMessageType),
+		Timestamp: sent,
+	}
+	msg.Retry, _ = strconv.Atoi(r.Header.Get(HeaderMessageRetry))
+	err = json.Unmarshal(bs, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Sign returns the HMAC-SHA256 eventsub signs messages with
+func Sign(secret, id, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func (h *Handler) maxMessageAge() time.Duration {
+	if h.MaxMessageAge > 0 {
+		return h.MaxMessageAge
+	}
+	return DefaultMaxMessageAge
+}
+
+func (h *Handler) now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+	return h.Clock.Now()
+}
+
+func (h *Handler) logger() *zap.Logger {
+	if h.Logger == nil {
+		return zap.NewNop()
+	}
+	return h.Logger
+}
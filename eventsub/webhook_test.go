@@ -0,0 +1,161 @@
+package eventsub
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+)
+
+const secret = "0123456789abcdef"
+
+var epoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// delivery is a webhook message of type signed with secret, sent at sent
+func delivery(id, typ string, sent time.Time, body string) *http.Request {
+	timestamp := sent.Format(time.RFC3339Nano)
+	req := httptest.NewRequest(http.MethodPost, "/eventsub", strings.NewReader(body))
+	req.Header.Set(HeaderMessageID, id)
+	req.Header.Set(HeaderMessageType, typ)
+	req.Header.Set(HeaderMessageTimestamp, timestamp)
+	req.Header.Set(HeaderMessageRetry, "2")
+	req.Header.Set(HeaderMessageSignature, "sha256="+hex.EncodeToString(Sign(secret, id, timestamp, []byte(body))))
+	return req
+}
+
+func serve(h *Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+const (
+	notificationBody = `{"subscription":{"id":"sub-1","type":"stream.online","version":"1","condition":{"broadcaster_user_id":"1"},"transport":{"method":"webhook"}},"event":{"broadcaster_user_id":"1"}}`
+	verificationBody = `{"subscription":{"id":"sub-1","status":"webhook_callback_verification_pending","type":"stream.online","version":"1"},"challenge":"pogchamp-kappa-360noscope"}`
+	revocationBody   = `{"subscription":{"id":"sub-1","status":"authorization_revoked","type":"stream.online","version":"1"}}`
+)
+
+func TestHandlerVerification(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	h := &Handler{Secret: secret, Clock: clock, Dedup: &twitchhook.InMemoryDedupStore{Clock: clock}}
+	for range 2 {
+		w := serve(h, delivery("1", MessageTypeVerification, epoch, verificationBody))
+		if w.Code != http.StatusOK || w.Body.String() != "pogchamp-kappa-360noscope" {
+			t.Fatalf("verification response = %d %q, want the challenge", w.Code, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/plain" {
+			t.Fatalf("Content-Type = %q", ct)
+		}
+	}
+}
+
+func TestHandlerNotification(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	var got []*Message
+	var fail error
+	h := &Handler{
+		Secret: secret,
+		Clock:  clock,
+		Dedup:  &twitchhook.InMemoryDedupStore{Clock: clock},
+		OnNotification: func(ctx context.Context, msg *Message) error {
+			got = append(got, msg)
+			return fail
+		},
+	}
+
+	sent := epoch.Add(-time.Minute)
+	if w := serve(h, delivery("1", MessageTypeNotification, sent, notificationBody)); w.Code != http.StatusOK {
+		t.Fatalf("notification response = %d", w.Code)
+	}
+	if len(got) != 1 {
+		t.Fatalf("OnNotification called %d times", len(got))
+	}
+	msg := got[0]
+	if msg.ID != "1" || msg.Type != MessageTypeNotification || !msg.Timestamp.Equal(sent) || msg.Retry != 2 {
+		t.Fatalf("message = %+v", msg)
+	}
+	if msg.Subscription.Type != "stream.online" || msg.Subscription.Condition["broadcaster_user_id"] != "1" || string(msg.Event) != `{"broadcaster_user_id":"1"}` {
+		t.Fatalf("message subscription and event = %+v %s", msg.Subscription, msg.Event)
+	}
+
+	// redeliveries are acknowledged without handling them again
+	if w := serve(h, delivery("1", MessageTypeNotification, sent, notificationBody)); w.Code != http.StatusOK || len(got) != 1 {
+		t.Fatalf("redelivery response = %d after %d notifications", w.Code, len(got))
+	}
+
+	// errors ask Twitch to retry
+	fail = errors.New("database down")
+	if w := serve(h, delivery("2", MessageTypeNotification, sent, notificationBody)); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failed notification response = %d, want a 500", w.Code)
+	}
+}
+
+func TestHandlerRejects(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	called := false
+	h := &Handler{
+		Secret: secret,
+		Clock:  clock,
+		OnNotification: func(ctx context.Context, msg *Message) error {
+			called = true
+			return nil
+		},
+	}
+
+	tampered := delivery("1", MessageTypeNotification, epoch, notificationBody)
+	tampered.Body = http.NoBody
+	unsigned := delivery("1", MessageTypeNotification, epoch, notificationBody)
+	unsigned.Header.Del(HeaderMessageSignature)
+	wrongSecret := delivery("1", MessageTypeNotification, epoch, notificationBody)
+	wrongSecret.Header.Set(HeaderMessageSignature, "sha256="+hex.EncodeToString(Sign("other", "1", epoch.Format(time.RFC3339Nano), []byte(notificationBody))))
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{name: "tampered", req: tampered, status: http.StatusForbidden},
+		{name: "unsigned", req: unsigned, status: http.StatusForbidden},
+		{name: "wrong secret", req: wrongSecret, status: http.StatusForbidden},
+		{name: "stale", req: delivery("1", MessageTypeNotification, epoch.Add(-DefaultMaxMessageAge-time.Second), notificationBody), status: http.StatusForbidden},
+		{name: "from the future", req: delivery("1", MessageTypeNotification, epoch.Add(DefaultMaxMessageAge+time.Second), notificationBody), status: http.StatusForbidden},
+		{name: "malformed", req: delivery("1", MessageTypeNotification, epoch, `{"subscription":`), status: http.StatusBadRequest},
+		{name: "unknown type", req: delivery("1", "heartbeat", epoch, notificationBody), status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := serve(h, tt.req); w.Code != tt.status {
+			t.Errorf("%s message response = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+	if called {
+		t.Fatal("OnNotification called for a rejected message")
+	}
+
+	// MaxMessageAge replaces the default
+	h.MaxMessageAge = time.Minute
+	if w := serve(h, delivery("1", MessageTypeNotification, epoch.Add(-2*time.Minute), notificationBody)); w.Code != http.StatusForbidden {
+		t.Fatalf("message older than MaxMessageAge response = %d", w.Code)
+	}
+}
+
+func TestHandlerRevocation(t *testing.T) {
+	var revoked *Message
+	h := &Handler{
+		Secret:       secret,
+		Clock:        clocktest.NewClock(epoch),
+		OnRevocation: func(ctx context.Context, msg *Message) { revoked = msg },
+	}
+	if w := serve(h, delivery("1", MessageTypeRevocation, epoch, revocationBody)); w.Code != http.StatusOK {
+		t.Fatalf("revocation response = %d", w.Code)
+	}
+	if revoked == nil || revoked.Subscription.ID != "sub-1" || revoked.RevocationReason() != RevocationAuthorizationRevoked {
+		t.Fatalf("OnRevocation got %+v", revoked)
+	}
+}
@@ -0,0 +1,113 @@
+// Command cloudrun is an example receiver deployed on Cloud Run. It
+// subscribes to the comma separated TOPICS and logs their notifications.
+//
+// Configuration comes from the TWITCHHOOK_* environment variables read by
+// twitchhook.Config.ApplyEnv. Subscription state has to outlive instances,
+// TWITCHHOOK_STORAGE_DSN defaults to a Firestore collection in the
+// instance's project. The callback base url is detected from the platform
+// unless TWITCHHOOK_CALLBACK_BASE_URL is set.
+//
+// Build and deploy from the repository root:
+//
+//	gcloud builds submit --tag gcr.io/$PROJECT/twitchhook -f examples/cloudrun/Dockerfile .
+//	gcloud run deploy twitchhook --image gcr.io/$PROJECT/twitchhook \
+//		--set-env-vars TOPICS=https://api.twitch.tv/helix/streams?user_id=1 \
+//		--set-secrets TWITCHHOOK_CLIENT_ID=twitch-client-id:latest,TWITCHHOOK_CLIENT_SECRET=twitch-client-secret:latest
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/adapters/cloudrun"
+	_ "github.com/bsdlp/twitchhook/firestorestore"
+	"go.uber.org/zap"
+)
+
+func main() {
+	logger, err := zap.NewProduction()
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err = run(ctx, logger)
+	if err != nil && err != http.ErrServerClosed {
+		logger.Fatal("twitchhook exited", zap.Error(err))
+	}
+}
+
+func run(ctx context.Context, logger *zap.Logger) error {
+	cfg := &twitchhook.Config{}
+	err := cfg.ApplyEnv()
+	if err != nil {
+		return err
+	}
+	cfg.CallbackBaseURL, err = cloudrun.CallbackBaseURL(ctx, "/callbacks")
+	if err != nil {
+		return err
+	}
+	if cfg.Storage.DSN == "" {
+		project, err := metadata.ProjectIDWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		cfg.Storage.DSN = "firestore://" + project
+	}
+	if cfg.DefaultLease == 0 {
+		cfg.DefaultLease = twitchhook.Duration(24 * time.Hour)
+	}
+	// instances scaling out share the store, reuse their subscriptions
+	// instead of rotating each other's secrets
+	if cfg.Resubscribe == "" {
+		cfg.Resubscribe = twitchhook.ResubscribeReuse
+	}
+
+	h, err := twitchhook.FromConfig(cfg, logger)
+	if err != nil {
+		return err
+	}
+	h.NotificationHandler = twitchhook.NotificationHandlerFunc(func(ctx context.Context, n *twitchhook.Notification) error {
+		logger.Info("notification", zap.String("topic", n.Topic), zap.ByteString("body", n.Body))
+		return nil
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/callbacks/", h.SubscriptionCallbackHandler())
+	mux.Handle("/healthz", h.Healthz())
+	mux.Handle("/readyz", h.Readyz())
+
+	// the hub confirms subscriptions through the server, listen before
+	// subscribing
+	ln, err := cloudrun.Listen()
+	if err != nil {
+		return err
+	}
+
+	for _, topic := range strings.Split(os.Getenv("TOPICS"), ",") {
+		topic = strings.TrimSpace(topic)
+		if topic == "" {
+			continue
+		}
+		go func() {
+			err := h.SubscribeContext(ctx, twitchhook.SubscriptionRequest{Topic: topic}, func(reason string) {
+				logger.Error("subscription denied", zap.String("topic", topic), zap.String("reason", reason))
+			})
+			if err != nil {
+				logger.Error("error subscribing", zap.String("topic", topic), zap.Error(err))
+			}
+		}()
+	}
+
+	return cloudrun.Serve(ctx, ln, mux)
+}
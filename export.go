@@ -0,0 +1,116 @@
+package twitchhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ExportVersion is the version of the format written by Export
+const ExportVersion = 1
+
+// SubscriptionExport is the portable format written by Export and read by
+// Import
+type SubscriptionExport struct {
+	Version       int                    `json:"version"`
+	ExportedAt    time.Time              `json:"exported_at"`
+	Subscriptions []ExportedSubscription `json:"subscriptions"`
+}
+
+// ExportedSubscription is a subscription's persisted fields and its state
+// when it was exported. Import derives the state from ExpiresAt again.
+type ExportedSubscription struct {
+	SubscriptionRecord
+	State SubscriptionState `json:"state"`
+}
+
+// Export writes every subscription in the Manager to w, so they can be
+// imported into another Manager or kept as a snapshot. The Manager must be a
+// SubscriptionLister. Secrets are written as the Manager returns them,
+// subscriptions with a SecretRef only carry the reference.
+func (m *TwitchWebhookHandler) Export(ctx context.Context, w io.Writer) error {
+	lister, ok := m.Manager.(SubscriptionLister)
+	if !ok {
+		return ErrNotSupported
+	}
+	subs, err := lister.List()
+	if err != nil {
+		return err
+	}
+
+	now := clockOrDefault(m.Clock).Now()
+	export := SubscriptionExport{
+		Version:       ExportVersion,
+		ExportedAt:    now,
+		Subscriptions: make([]ExportedSubscription, 0, len(subs)),
+	}
+	for _, sub := range subs {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+		export.Subscriptions = append(export.Subscriptions, ExportedSubscription{
+			SubscriptionRecord: sub.Record(),
+			State:              sub.State(now),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(export)
+}
+
+// Import saves the subscriptions written by Export to the Manager, replacing
+// existing subscriptions to the same topics. Imported subscriptions are
+// renewed by this handler when they expire, ones that already have are
+// renewed right away.
+func (m *TwitchWebhookHandler) Import(ctx context.Context, r io.Reader) error {
+	m.once.Do(m.setup)
+
+	var export SubscriptionExport
+	err := json.NewDecoder(r).Decode(&export)
+	if err != nil {
+		return err
+	}
+	if export.Version != ExportVersion {
+		return fmt.Errorf("unsupported subscription export version %d", export.Version)
+	}
+
+	for _, exported := range export.Subscriptions {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		record := exported.SubscriptionRecord
+		sub := m.newSubscription(OutboxEntry{
+			Renewal: SubscriptionRequest{
+				Topic:           record.Topic,
+				CallbackBaseURL: record.CallbackBaseURL,
+				Lease:           record.Lease,
+				Metadata:        record.Metadata,
+			},
+			ID:              record.ID,
+			Topic:           record.Topic,
+			CallbackBaseURL: record.CallbackBaseURL,
+			CallbackURL:     record.CallbackURL,
+			Lease:           record.Lease,
+			Secret:          record.Secret,
+			SecretRef:       record.SecretRef,
+			Metadata:        record.Metadata,
+
+			PreviousSecret:          record.PreviousSecret,
+			PreviousSecretRef:       record.PreviousSecretRef,
+			PreviousSecretExpiresAt: record.PreviousSecretExpiresAt,
+		}, nil)
+		sub.ExpiresAt = record.ExpiresAt
+
+		err = m.Manager.Save(record.Topic, sub)
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", record.Topic, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package twitchhook
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// NotificationFilter reports whether a notification should be dispatched
+type NotificationFilter func(ctx context.Context, n *Notification) bool
+
+// FilterTopic registers filters for topic's notifications, which are only
+// dispatched when every filter passes and are acknowledged otherwise. Filters
+// run after Middleware, so they see events decoded by middleware such as the
+// helix enricher, and are dropped along with the topic's handler when it's
+// unsubscribed. Filters registered again replace the topic's filters.
+func (m *TwitchWebhookHandler) FilterTopic(topic string, filters ...NotificationFilter) {
+	if len(filters) == 0 {
+		m.topicFilters.Delete(topic)
+		return
+	}
+	m.topicFilters.Store(topic, filters)
+}
+
+// FilterEvent returns a filter passing notifications whose event satisfies
+// pred. The event is n.Event when it's a T, otherwise the body is decoded
+// into T with encoding/json and kept as n.Event. Notifications that can't be
+// decoded are filtered out.
+func FilterEvent[T any](pred func(event T) bool) NotificationFilter {
+	return func(ctx context.Context, n *Notification) bool {
+		if event, ok := n.Event.(T); ok {
+			return pred(event)
+		}
+		var event T
+		if json.Unmarshal(n.Body, &event) != nil {
+			return false
+		}
+		if n.Event == nil {
+			n.Event = event
+		}
+		return pred(event)
+	}
+}
+
+// filtered reports whether one of the filters registered for n's topic
+// rejects it
+func (m *TwitchWebhookHandler) filtered(ctx context.Context, n *Notification) bool {
+	v, ok := m.topicFilters.Load(n.Topic)
+	if !ok {
+		return false
+	}
+	for _, filter := range v.([]NotificationFilter) {
+		if !filter(ctx, n) {
+			m.metrics().IncCounter("twitchhook_notifications_filtered_total")
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+// Package firestorestore implements twitchhook.SubscriptionManager on Google
+// Cloud Firestore, one document per topic.
+//
+// Importing the package registers the firestore storage DSN scheme:
+//
+//	firestore://<project>[/<collection>]
+package firestorestore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/bsdlp/twitchhook"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults
+const (
+	DefaultCollection = "twitchhook_subscriptions"
+	DefaultTimeout    = 10 * time.Second
+)
+
+func init() {
+	twitchhook.RegisterManager("firestore", Open)
+}
+
+// Open opens a Manager from a firestore://<project>[/<collection>] DSN using
+// application default credentials
+func Open(dsn string) (twitchhook.SubscriptionManager, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("firestorestore: dsn must name a project")
+	}
+	client, err := firestore.NewClient(context.Background(), u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{Client: client, Collection: strings.Trim(u.Path, "/")}, nil
+}
+
+// Manager is a twitchhook.SubscriptionManager storing subscriptions in a
+// Firestore collection. Document ids are the unpadded url-safe base64 of the
+// topic. Secrets are stored as given, wrap the Manager in a
+// twitchhook.EncryptedManager to encrypt them.
+//
+// Renewals are scheduled by the process that saved the subscription, see
+// twitchhook.SubscriptionTimers.
+type Manager struct {
+	Client *firestore.Client
+
+	// Collection defaults to DefaultCollection
+	Collection string
+
+	// Timeout bounds each operation, defaults to DefaultTimeout
+	Timeout time.Duration
+
+	// Clock schedules renewals, defaults to twitchhook.SystemClock
+	Clock twitchhook.Clock
+
+	timers twitchhook.SubscriptionTimers
+	once   sync.Once
+}
+
+func (m *Manager) setup() {
+	if m.Collection == "" {
+		m.Collection = DefaultCollection
+	}
+	if m.Timeout == 0 {
+		m.Timeout = DefaultTimeout
+	}
+	m.timers.Clock = m.Clock
+}
+
+func (m *Manager) context() (context.Context, context.CancelFunc) {
+	m.once.Do(m.setup)
+	return context.WithTimeout(context.Background(), m.Timeout)
+}
+
+func (m *Manager) doc(topic string) *firestore.DocumentRef {
+	return m.Client.Collection(m.Collection).Doc(base64.RawURLEncoding.EncodeToString([]byte(topic)))
+}
+
+// Get retrieves a subscription, returning twitchhook.ErrSubscriptionNotFound
+// if there isn't one for topic
+func (m *Manager) Get(topic string) (*twitchhook.Subscription, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	snap, err := m.doc(topic).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, twitchhook.ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.subscription(snap)
+}
+
+func (m *Manager) subscription(snap *firestore.DocumentSnapshot) (*twitchhook.Subscription, error) {
+	var record twitchhook.SubscriptionRecord
+	err := snap.DataTo(&record)
+	if err != nil {
+		return nil, err
+	}
+	// the document id is the key the subscription was saved under, which
+	// differs from its topic behind a NamespacedManager
+	key, err := base64.RawURLEncoding.DecodeString(snap.Ref.ID)
+	if err != nil {
+		return nil, err
+	}
+	return m.timers.Attach(string(key), record.Subscription()), nil
+}
+
+// Save stores a subscription, replacing any existing subscription to topic
+func (m *Manager) Save(topic string, sub *twitchhook.Subscription) error {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	_, err := m.doc(topic).Set(ctx, sub.Record())
+	if err != nil {
+		return err
+	}
+	m.timers.Save(topic, sub)
+	return nil
+}
+
+// Delete removes a subscription
+func (m *Manager) Delete(topic string) error {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	_, err := m.doc(topic).Delete(ctx)
+	if err != nil {
+		return err
+	}
+	m.timers.Delete(topic)
+	return nil
+}
+
+// SetSubscriptionLease sets a subscription's lease and expiry in a
+// transaction, so a concurrent Save isn't overwritten with a stale document
+func (m *Manager) SetSubscriptionLease(topic string, lease time.Duration) (bool, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	exists := true
+	expiresAt := m.clock().Now().Add(lease)
+	err := m.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc := m.doc(topic)
+		_, err := tx.Get(doc)
+		if status.Code(err) == codes.NotFound {
+			exists = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		exists = true
+		return tx.Update(doc, []firestore.Update{
+			{Path: "Lease", Value: lease},
+			{Path: "ExpiresAt", Value: expiresAt},
+		})
+	})
+	if err != nil || !exists {
+		return false, err
+	}
+	m.timers.Extend(topic, lease)
+	return true, nil
+}
+
+func (m *Manager) clock() twitchhook.Clock {
+	if m.Clock == nil {
+		return twitchhook.SystemClock
+	}
+	return m.Clock
+}
+
+// GetByID implements twitchhook.SubscriptionIDIndex
+func (m *Manager) GetByID(id twitchhook.SubscriptionID) (*twitchhook.Subscription, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	it := m.Client.Collection(m.Collection).Where("ID", "==", string(id)).Limit(1).Documents(ctx)
+	defer it.Stop()
+	snap, err := it.Next()
+	if err == iterator.Done {
+		return nil, twitchhook.ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.subscription(snap)
+}
+
+// List implements twitchhook.SubscriptionLister
+func (m *Manager) List() ([]*twitchhook.Subscription, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	var subs []*twitchhook.Subscription
+	it := m.Client.Collection(m.Collection).Documents(ctx)
+	defer it.Stop()
+	for {
+		snap, err := it.Next()
+		if err == iterator.Done {
+			return subs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sub, err := m.subscription(snap)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+}
+
+// RenewalAt implements twitchhook.RenewalScheduler, only renewals scheduled
+// by this process are known
+func (m *Manager) RenewalAt(topic string) (time.Time, error) {
+	return m.timers.RenewalAt(topic), nil
+}
+
+// Ping implements twitchhook.Pinger
+func (m *Manager) Ping(ctx context.Context) error {
+	m.once.Do(m.setup)
+
+	it := m.Client.Collection(m.Collection).Limit(1).Documents(ctx)
+	defer it.Stop()
+	_, err := it.Next()
+	if err == iterator.Done {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,276 @@
+package firestorestore
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	pb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+	"github.com/bsdlp/twitchhook/storetest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const project = "test"
+
+// fakeFirestore is an in-memory Firestore serving the document reads, writes
+// and equality queries the Manager makes. Transactions don't hold locks, the
+// tests don't run them concurrently.
+type fakeFirestore struct {
+	pb.UnimplementedFirestoreServer
+
+	m    sync.Mutex
+	docs map[string]*pb.Document
+	txns int
+}
+
+// newFakeFirestore serves a fakeFirestore on localhost and returns a client
+// connected to it
+func newFakeFirestore(t *testing.T) (*fakeFirestore, *firestore.Client) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	f := &fakeFirestore{docs: make(map[string]*pb.Document)}
+	pb.RegisterFirestoreServer(srv, f)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := firestore.NewClient(context.Background(), project, option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return f, client
+}
+
+func (f *fakeFirestore) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	f.m.Lock()
+	var resps []*pb.BatchGetDocumentsResponse
+	for _, name := range req.Documents {
+		resp := &pb.BatchGetDocumentsResponse{ReadTime: timestamppb.Now()}
+		if doc, ok := f.docs[name]; ok {
+			resp.Result = &pb.BatchGetDocumentsResponse_Found{Found: proto.Clone(doc).(*pb.Document)}
+		} else {
+			resp.Result = &pb.BatchGetDocumentsResponse_Missing{Missing: name}
+		}
+		resps = append(resps, resp)
+	}
+	f.m.Unlock()
+
+	for _, resp := range resps {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeFirestore) BeginTransaction(context.Context, *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.txns++
+	return &pb.BeginTransactionResponse{Transaction: []byte{byte(f.txns)}}, nil
+}
+
+func (f *fakeFirestore) Rollback(context.Context, *pb.RollbackRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeFirestore) Commit(_ context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	now := timestamppb.Now()
+	resp := &pb.CommitResponse{CommitTime: now}
+	for _, w := range req.Writes {
+		if err := f.write(w, now); err != nil {
+			return nil, err
+		}
+		resp.WriteResults = append(resp.WriteResults, &pb.WriteResult{UpdateTime: now})
+	}
+	return resp, nil
+}
+
+func (f *fakeFirestore) write(w *pb.Write, now *timestamppb.Timestamp) error {
+	var name string
+	switch op := w.Operation.(type) {
+	case *pb.Write_Update:
+		name = op.Update.Name
+	case *pb.Write_Delete:
+		name = op.Delete
+	default:
+		return status.Error(codes.Unimplemented, "unsupported write")
+	}
+	old, exists := f.docs[name]
+	if exists, ok := w.GetCurrentDocument().GetConditionType().(*pb.Precondition_Exists); ok && exists.Exists != (old != nil) {
+		return status.Errorf(codes.NotFound, "no document %s", name)
+	}
+
+	update, ok := w.Operation.(*pb.Write_Update)
+	if !ok {
+		delete(f.docs, name)
+		return nil
+	}
+	doc := proto.Clone(update.Update).(*pb.Document)
+	doc.CreateTime, doc.UpdateTime = now, now
+	if exists {
+		doc.CreateTime = old.CreateTime
+	}
+	if mask := w.GetUpdateMask(); mask != nil && exists {
+		fields := make(map[string]*pb.Value, len(old.Fields))
+		for path, value := range old.Fields {
+			fields[path] = value
+		}
+		for _, path := range mask.FieldPaths {
+			if value, ok := doc.Fields[path]; ok {
+				fields[path] = value
+			} else {
+				delete(fields, path)
+			}
+		}
+		doc.Fields = fields
+	}
+	f.docs[name] = doc
+	return nil
+}
+
+func (f *fakeFirestore) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	q := req.GetStructuredQuery()
+	if len(q.GetFrom()) != 1 {
+		return status.Error(codes.Unimplemented, "queries must select one collection")
+	}
+	prefix := req.Parent + "/" + q.From[0].CollectionId + "/"
+
+	f.m.Lock()
+	var names []string
+	for name, doc := range f.docs {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") && matches(doc, q.Where) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if limit := q.GetLimit(); limit != nil && int(limit.Value) < len(names) {
+		names = names[:limit.Value]
+	}
+	var resps []*pb.RunQueryResponse
+	for _, name := range names {
+		resps = append(resps, &pb.RunQueryResponse{Document: proto.Clone(f.docs[name]).(*pb.Document), ReadTime: timestamppb.Now()})
+	}
+	f.m.Unlock()
+
+	for _, resp := range resps {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether doc passes filter, only equality filters and
+// their conjunctions are supported
+func matches(doc *pb.Document, filter *pb.StructuredQuery_Filter) bool {
+	if filter == nil {
+		return true
+	}
+	switch filter := filter.FilterType.(type) {
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		ff := filter.FieldFilter
+		return ff.Op == pb.StructuredQuery_FieldFilter_EQUAL && proto.Equal(doc.Fields[ff.Field.FieldPath], ff.Value)
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		for _, f := range filter.CompositeFilter.Filters {
+			if !matches(doc, f) {
+				return false
+			}
+		}
+		return filter.CompositeFilter.Op == pb.StructuredQuery_CompositeFilter_AND
+	}
+	return false
+}
+
+func (f *fakeFirestore) document(name string) (*pb.Document, bool) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	doc, ok := f.docs[name]
+	return doc, ok
+}
+
+func TestManager(t *testing.T) {
+	storetest.TestSubscriptionManager(t, func(t *testing.T, clock twitchhook.Clock) twitchhook.SubscriptionManager {
+		_, client := newFakeFirestore(t)
+		return &Manager{Client: client, Clock: clock}
+	})
+}
+
+func TestManagerDocuments(t *testing.T) {
+	f, client := newFakeFirestore(t)
+	clock := clocktest.NewClock(storetest.Epoch)
+	m := &Manager{Client: client, Collection: "subs", Clock: clock}
+
+	const topic = "https://api.twitch.tv/helix/streams?user_id=1"
+	sub := storetest.Subscription(topic, "v1.a")
+	if err := m.Save("tenant/"+topic, sub); err != nil {
+		t.Fatal(err)
+	}
+	name := "projects/" + project + "/databases/(default)/documents/subs/" + base64.RawURLEncoding.EncodeToString([]byte("tenant/"+topic))
+	doc, ok := f.document(name)
+	if !ok {
+		t.Fatalf("no document %s", name)
+	}
+	if id := doc.Fields["ID"].GetStringValue(); id != "v1.a" {
+		t.Fatalf("document ID field = %q, want v1.a", id)
+	}
+
+	// subscriptions are attached to the key they were saved under, not
+	// their topic
+	sub.Renew = func() {}
+	if err := m.Save("tenant/"+topic, sub); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.GetByID("v1.a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.CheckSubscription(t, got, sub)
+	if got.Renew == nil {
+		t.Fatal("GetByID didn't attach the renewal of the key the subscription was saved under")
+	}
+	if _, err := m.Get(topic); err != twitchhook.ErrSubscriptionNotFound {
+		t.Fatalf("Get(topic) of a subscription saved under another key = %v", err)
+	}
+
+	// SetSubscriptionLease only updates the lease and expiry
+	if _, err := m.SetSubscriptionLease("tenant/"+topic, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	updated, _ := f.document(name)
+	for field, value := range doc.Fields {
+		if field != "Lease" && field != "ExpiresAt" && !proto.Equal(updated.Fields[field], value) {
+			t.Errorf("SetSubscriptionLease changed %s to %v", field, updated.Fields[field])
+		}
+	}
+	if updated.Fields["Lease"].GetIntegerValue() != int64(2*time.Hour) {
+		t.Fatalf("Lease = %v, want 2h", updated.Fields["Lease"])
+	}
+}
@@ -0,0 +1,147 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+)
+
+// Topic urls for the hub's topics
+const (
+	topicStreams = "https://api.twitch.tv/helix/streams"
+	topicFollows = "https://api.twitch.tv/helix/users/follows"
+	topicUsers   = "https://api.twitch.tv/helix/users"
+)
+
+// StreamTopic is the stream changed topic for a user
+func StreamTopic(userID string) string {
+	return topicStreams + "?" + url.Values{"user_id": {userID}}.Encode()
+}
+
+// FollowersTopic is the topic for users following userID
+func FollowersTopic(userID string) string {
+	return topicFollows + "?" + url.Values{"first": {"1"}, "to_id": {userID}}.Encode()
+}
+
+// FollowingTopic is the topic for userID following other users
+func FollowingTopic(userID string) string {
+	return topicFollows + "?" + url.Values{"first": {"1"}, "from_id": {userID}}.Encode()
+}
+
+// UserTopic is the user changed topic for a user
+func UserTopic(userID string) string {
+	return topicUsers + "?" + url.Values{"id": {userID}}.Encode()
+}
+
+// BroadcasterTopics are the stream, follower and user topics of a
+// broadcaster
+func BroadcasterTopics(userID string) []string {
+	return []string{StreamTopic(userID), FollowersTopic(userID), UserTopic(userID)}
+}
+
+// SubscriptionMissing is the state of a group topic the Manager holds no
+// subscription for
+const SubscriptionMissing SubscriptionState = "missing"
+
+// SubscriptionGroup manages a set of related topics, such as everything for
+// one broadcaster, as a unit
+type SubscriptionGroup struct {
+	Handler *TwitchWebhookHandler
+	Topics  []string
+
+	// CallbackBaseURL and Lease apply to every topic, they default to the
+	// handler's
+	CallbackBaseURL string
+	Lease           time.Duration
+
+	// DenialCallback is called with the topic and reason when the hub denies
+	// one of the group's subscriptions
+	DenialCallback func(topic, reason string)
+}
+
+// GroupState is the state of every topic in a group
+type GroupState struct {
+	// State is active when every topic is active, otherwise it's the state
+	// of the first topic that isn't
+	State  SubscriptionState
+	Topics map[string]SubscriptionState
+}
+
+// NewBroadcasterGroup returns a group of the BroadcasterTopics of userID
+func (m *TwitchWebhookHandler) NewBroadcasterGroup(userID string) *SubscriptionGroup {
+	return &SubscriptionGroup{Handler: m, Topics: BroadcasterTopics(userID)}
+}
+
+// Subscribe subscribes every topic. If any subscription fails the topics
+// already subscribed are unsubscribed again and the errors are returned.
+func (g *SubscriptionGroup) Subscribe(ctx context.Context) error {
+	for i, topic := range g.Topics {
+		err := g.Handler.SubscribeContext(ctx, g.request(topic), g.denialCallback(topic))
+		if err != nil {
+			errs := []error{err}
+			for _, subscribed := range g.Topics[:i] {
+				errs = append(errs, g.Handler.UnsubscribeContext(ctx, subscribed))
+			}
+			return errors.Join(errs...)
+		}
+	}
+	return nil
+}
+
+// Unsubscribe unsubscribes every topic, continuing past errors
+func (g *SubscriptionGroup) Unsubscribe(ctx context.Context) error {
+	var errs []error
+	for _, topic := range g.Topics {
+		errs = append(errs, g.Handler.UnsubscribeContext(ctx, topic))
+	}
+	return errors.Join(errs...)
+}
+
+// Renew renews every topic, continuing past errors
+func (g *SubscriptionGroup) Renew(ctx context.Context) error {
+	var errs []error
+	for _, topic := range g.Topics {
+		errs = append(errs, g.Handler.RenewContext(ctx, topic))
+	}
+	return errors.Join(errs...)
+}
+
+// State reports the state of every topic in the group
+func (g *SubscriptionGroup) State() (*GroupState, error) {
+	now := clockOrDefault(g.Handler.Clock).Now()
+	state := &GroupState{State: SubscriptionActive, Topics: make(map[string]SubscriptionState, len(g.Topics))}
+	for _, topic := range g.Topics {
+		sub, err := g.Handler.getSubscription(topic)
+		if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+			return nil, err
+		}
+
+		topicState := SubscriptionMissing
+		if sub != nil {
+			topicState = sub.State(now)
+		}
+		state.Topics[topic] = topicState
+		if state.State == SubscriptionActive && topicState != SubscriptionActive {
+			state.State = topicState
+		}
+	}
+	return state, nil
+}
+
+func (g *SubscriptionGroup) request(topic string) SubscriptionRequest {
+	return SubscriptionRequest{
+		Topic:           topic,
+		CallbackBaseURL: g.CallbackBaseURL,
+		Lease:           g.Lease,
+	}
+}
+
+func (g *SubscriptionGroup) denialCallback(topic string) func(reason string) {
+	if g.DenialCallback == nil {
+		return nil
+	}
+	return func(reason string) {
+		g.DenialCallback(topic, reason)
+	}
+}
@@ -0,0 +1,146 @@
+package twitchhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Pinger is implemented by SubscriptionManagers that can check their backing
+// store is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// leaseCheckInterval is how often Readyz lists subscriptions to count
+// expired leases, probes in between report the last count
+const leaseCheckInterval = time.Minute
+
+// HealthStatus is the body served by Healthz and Readyz
+type HealthStatus struct {
+	OK     bool              `json:"ok"`
+	Checks map[string]string `json:"checks"`
+
+	// Warnings are reported without failing the check
+	Warnings map[string]string `json:"warnings,omitempty"`
+}
+
+// Healthz serves a liveness check, it only fails when the process can't
+// serve requests. Dependencies are checked by Readyz since restarting doesn't
+// fix them.
+func (m *TwitchWebhookHandler) Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.writeHealth(w, HealthStatus{OK: true, Checks: map[string]string{}})
+	}
+}
+
+// Readyz serves a readiness check that fails when an OAuth token can't be
+// fetched, the SubscriptionManager's Ping fails or the circuit breaker is
+// open. Subscriptions past their lease, meaning renewals have stopped
+// working, are reported as a warning.
+func (m *TwitchWebhookHandler) Readyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.once.Do(m.setup)
+
+		status := HealthStatus{OK: true, Checks: map[string]string{}}
+		status.check("oauth", m.checkToken())
+		status.check("manager", m.pingManager(r.Context()))
+		status.check("circuit", m.checkBreaker())
+		status.warn("leases", m.checkLeases())
+		m.writeHealth(w, status)
+	}
+}
+
+func (s *HealthStatus) check(name string, err error) {
+	if err != nil {
+		s.OK = false
+		s.Checks[name] = err.Error()
+		return
+	}
+	s.Checks[name] = "ok"
+}
+
+func (s *HealthStatus) warn(name string, err error) {
+	if err == nil {
+		return
+	}
+	if s.Warnings == nil {
+		s.Warnings = map[string]string{}
+	}
+	s.Warnings[name] = err.Error()
+}
+
+func (m *TwitchWebhookHandler) writeHealth(w http.ResponseWriter, status HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	if !status.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		m.logger().Info("error writing health status", zap.Error(err))
+	}
+}
+
+func (m *TwitchWebhookHandler) checkToken() error {
+	if m.tokenSource == nil {
+		return nil
+	}
+	_, err := m.tokenSource.Token()
+	return err
+}
+
+// pingManager pings Managers that are Pingers, others are assumed reachable
+// rather than listed on every probe
+func (m *TwitchWebhookHandler) pingManager(ctx context.Context) error {
+	if pinger, ok := m.Manager.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// leaseCheck caches the result of checkLeases for leaseCheckInterval
+type leaseCheck struct {
+	m   sync.Mutex
+	at  time.Time
+	err error
+}
+
+// checkLeases counts subscriptions past their lease, listing the Manager at
+// most once per leaseCheckInterval
+func (m *TwitchWebhookHandler) checkLeases() error {
+	lister, ok := m.Manager.(SubscriptionLister)
+	if !ok {
+		return nil
+	}
+
+	c := &m.leaseCheck
+	c.m.Lock()
+	defer c.m.Unlock()
+	now := clockOrDefault(m.Clock).Now()
+	if !c.at.IsZero() && now.Sub(c.at) < leaseCheckInterval {
+		return c.err
+	}
+	c.at = now
+
+	subs, err := lister.List()
+	if err != nil {
+		c.err = err
+		return err
+	}
+	var expired int
+	for _, sub := range subs {
+		if sub.State(now) == SubscriptionExpired {
+			expired++
+		}
+	}
+	c.err = nil
+	if expired > 0 {
+		c.err = fmt.Errorf("%d subscriptions past lease expiry", expired)
+	}
+	return c.err
+}
@@ -0,0 +1,202 @@
+// Package helix implements the helix lookups used to enrich notifications:
+// users, streams and games.
+package helix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// DefaultBaseURL is the helix API root
+const DefaultBaseURL = "https://api.twitch.tv/helix"
+
+// MaxIDsPerRequest is the most ids or logins helix accepts in one lookup,
+// longer lists are split across requests
+const MaxIDsPerRequest = 100
+
+// User is a helix user
+type User struct {
+	ID              string    `json:"id"`
+	Login           string    `json:"login"`
+	DisplayName     string    `json:"display_name"`
+	Type            string    `json:"type"`
+	BroadcasterType string    `json:"broadcaster_type"`
+	Description     string    `json:"description"`
+	ProfileImageURL string    `json:"profile_image_url"`
+	OfflineImageURL string    `json:"offline_image_url"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Stream is a helix live stream
+type Stream struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	UserLogin   string    `json:"user_login"`
+	UserName    string    `json:"user_name"`
+	GameID      string    `json:"game_id"`
+	GameName    string    `json:"game_name"`
+	Type        string    `json:"type"`
+	Title       string    `json:"title"`
+	ViewerCount int       `json:"viewer_count"`
+	StartedAt   time.Time `json:"started_at"`
+	Language    string    `json:"language"`
+}
+
+// Game is a helix game or category
+type Game struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	BoxArtURL string `json:"box_art_url"`
+}
+
+// Client calls the helix users, streams and games endpoints
+type Client struct {
+	// HTTPClient must authenticate requests with an app access token, such
+	// as the client of a twitchhook.TwitchWebhookHandler
+	HTTPClient *http.Client
+
+	// ClientID is sent in the Client-Id header helix requires
+	ClientID string
+
+	// BaseURL defaults to DefaultBaseURL
+	BaseURL string
+}
+
+// NewClient returns a Client sharing h's oauth client and rate limit
+func NewClient(h *twitchhook.TwitchWebhookHandler) *Client {
+	return &Client{HTTPClient: h.Client(), ClientID: h.OAuth2ClientID}
+}
+
+// GetUsers looks up users by id and login
+func (c *Client) GetUsers(ctx context.Context, ids, logins []string) ([]User, error) {
+	var users []User
+	err := c.lookup(map[string][]string{"id": ids, "login": logins}, func(q url.Values) error {
+		var resp struct {
+			Data []User `json:"data"`
+		}
+		err := c.do(ctx, "/users", q, &resp)
+		users = append(users, resp.Data...)
+		return err
+	})
+	return users, err
+}
+
+// GetStreams looks up the live streams of users by id
+func (c *Client) GetStreams(ctx context.Context, userIDs []string) ([]Stream, error) {
+	var streams []Stream
+	err := c.lookup(map[string][]string{"user_id": userIDs}, func(q url.Values) error {
+		q.Set("first", fmt.Sprint(MaxIDsPerRequest))
+		page, err := paginate[Stream](c, "/streams", q).All(ctx)
+		streams = append(streams, page...)
+		return err
+	})
+	return streams, err
+}
+
+// GetGames looks up games by id
+func (c *Client) GetGames(ctx context.Context, ids []string) ([]Game, error) {
+	var games []Game
+	err := c.lookup(map[string][]string{"id": ids}, func(q url.Values) error {
+		var resp struct {
+			Data []Game `json:"data"`
+		}
+		err := c.do(ctx, "/games", q, &resp)
+		games = append(games, resp.Data...)
+		return err
+	})
+	return games, err
+}
+
+// lookup calls f with queries of at most MaxIDsPerRequest values in total
+func (c *Client) lookup(params map[string][]string, f func(url.Values) error) error {
+	q := url.Values{}
+	n := 0
+	for _, key := range []string{"id", "login", "user_id"} {
+		for _, v := range params[key] {
+			q.Add(key, v)
+			n++
+			if n == MaxIDsPerRequest {
+				err := f(q)
+				if err != nil {
+					return err
+				}
+				q, n = url.Values{}, 0
+			}
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return f(q)
+}
+
+// paginate pages through a GET of path, setting after to each page's cursor
+func paginate[T any](c *Client, path string, query url.Values) *twitchhook.Paginator[T] {
+	return &twitchhook.Paginator[T]{Fetch: func(ctx context.Context, cursor string) (twitchhook.Page[T], error) {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		if cursor != "" {
+			q.Set("after", cursor)
+		}
+		header, bs, err := c.get(ctx, path, q)
+		if err != nil {
+			return twitchhook.Page[T]{}, err
+		}
+		return twitchhook.DecodePage[T](header, bs)
+	}}
+}
+
+func (c *Client) do(ctx context.Context, path string, query url.Values, out interface{}) error {
+	_, bs, err := c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, out)
+}
+
+// get makes a GET request, returning the response headers and body of a 2xx
+func (c *Client) get(ctx context.Context, path string, query url.Values) (http.Header, []byte, error) {
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Client-Id", c.ClientID)
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		tErr := twitchhook.TwitchError{Status: int64(resp.StatusCode)}
+		if jsonErr := json.Unmarshal(bs, &tErr); jsonErr != nil || tErr.Message == "" {
+			tErr.Message = fmt.Sprintf("helix: GET %s: %s", path, resp.Status)
+		}
+		return nil, nil, tErr
+	}
+	return resp.Header, bs, nil
+}
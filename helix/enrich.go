@@ -0,0 +1,191 @@
+package helix
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// DefaultEnrichTTL is how long looked up names are cached
+const DefaultEnrichTTL = time.Hour
+
+// Enricher decodes notifications with twitchhook.DecodeEvent and fills in
+// the display names and game titles they only carry ids for.
+type Enricher struct {
+	Client *Client
+
+	// TTL is how long names are cached, defaults to DefaultEnrichTTL
+	TTL time.Duration
+
+	// Clock defaults to twitchhook.SystemClock
+	Clock twitchhook.Clock
+
+	m     sync.Mutex
+	users map[string]cachedName
+	games map[string]cachedName
+}
+
+type cachedName struct {
+	name    string
+	expires time.Time
+}
+
+// Middleware decodes and enriches n.Event before calling next. Lookup errors
+// are returned, unknown topics are passed through undecoded. Apply it to the
+// NotificationHandler rather than the handler's Middleware so only verified
+// notifications cost lookups.
+func (e *Enricher) Middleware() twitchhook.Middleware {
+	return func(next twitchhook.NotificationHandler) twitchhook.NotificationHandler {
+		return twitchhook.NotificationHandlerFunc(func(ctx context.Context, n *twitchhook.Notification) error {
+			if n.Event == nil {
+				event, err := twitchhook.DecodeEvent(n.Topic, n.Body)
+				if err != nil && err != twitchhook.ErrUnknownTopic {
+					return err
+				}
+				n.Event = event
+			}
+
+			err := e.Enrich(ctx, n.Event)
+			if err != nil {
+				return err
+			}
+			return next.HandleNotification(ctx, n)
+		})
+	}
+}
+
+// Enrich fills in missing names on an event returned by DecodeEvent
+func (e *Enricher) Enrich(ctx context.Context, event interface{}) error {
+	switch event := event.(type) {
+	case *twitchhook.StreamChanged:
+		var userIDs, gameIDs []string
+		for _, s := range event.Streams {
+			if s.UserName == "" {
+				userIDs = append(userIDs, s.UserID)
+			}
+			if s.GameName == "" && s.GameID != "" {
+				gameIDs = append(gameIDs, s.GameID)
+			}
+		}
+		users, err := e.userNames(ctx, userIDs)
+		if err != nil {
+			return err
+		}
+		games, err := e.gameNames(ctx, gameIDs)
+		if err != nil {
+			return err
+		}
+		for i := range event.Streams {
+			s := &event.Streams[i]
+			if s.UserName == "" {
+				s.UserName = users[s.UserID]
+			}
+			if s.GameName == "" {
+				s.GameName = games[s.GameID]
+			}
+		}
+
+	case *twitchhook.Follows:
+		var ids []string
+		for _, f := range event.Follows {
+			if f.FromName == "" {
+				ids = append(ids, f.FromID)
+			}
+			if f.ToName == "" {
+				ids = append(ids, f.ToID)
+			}
+		}
+		users, err := e.userNames(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for i := range event.Follows {
+			f := &event.Follows[i]
+			if f.FromName == "" {
+				f.FromName = users[f.FromID]
+			}
+			if f.ToName == "" {
+				f.ToName = users[f.ToID]
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Enricher) userNames(ctx context.Context, ids []string) (map[string]string, error) {
+	return e.names(ctx, &e.users, ids, func(missing []string) (map[string]string, error) {
+		users, err := e.Client.GetUsers(ctx, missing, nil)
+		if err != nil {
+			return nil, err
+		}
+		names := make(map[string]string, len(users))
+		for _, u := range users {
+			names[u.ID] = u.DisplayName
+		}
+		return names, nil
+	})
+}
+
+func (e *Enricher) gameNames(ctx context.Context, ids []string) (map[string]string, error) {
+	return e.names(ctx, &e.games, ids, func(missing []string) (map[string]string, error) {
+		games, err := e.Client.GetGames(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		names := make(map[string]string, len(games))
+		for _, g := range games {
+			names[g.ID] = g.Name
+		}
+		return names, nil
+	})
+}
+
+// names resolves ids from cache, looking up the ones that are missing or
+// expired
+func (e *Enricher) names(ctx context.Context, cache *map[string]cachedName, ids []string, lookup func([]string) (map[string]string, error)) (map[string]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	clock := e.Clock
+	if clock == nil {
+		clock = twitchhook.SystemClock
+	}
+	now := clock.Now()
+
+	names := make(map[string]string, len(ids))
+	var missing []string
+	e.m.Lock()
+	for _, id := range ids {
+		if cached, ok := (*cache)[id]; ok && now.Before(cached.expires) {
+			names[id] = cached.name
+			continue
+		}
+		missing = append(missing, id)
+	}
+	e.m.Unlock()
+
+	if len(missing) == 0 {
+		return names, nil
+	}
+	found, err := lookup(missing)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := e.TTL
+	if ttl == 0 {
+		ttl = DefaultEnrichTTL
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+	if *cache == nil {
+		*cache = make(map[string]cachedName)
+	}
+	for id, name := range found {
+		names[id] = name
+		(*cache)[id] = cachedName{name: name, expires: now.Add(ttl)}
+	}
+	return names, nil
+}
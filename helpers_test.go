@@ -0,0 +1,155 @@
+package twitchhook_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+)
+
+const (
+	testTopic   = "https://api.twitch.tv/helix/streams?user_id=1"
+	testBaseURL = "https://example.com/callback"
+	testSecret  = "0123456789abcdef0123456789abcdef"
+)
+
+var testBody = []byte(`{"data":[{"id":"1","type":"live"}]}`)
+
+// newTestHandler returns a handler with an in-memory Manager on a fake clock
+func newTestHandler(t *testing.T) (*twitchhook.TwitchWebhookHandler, *clocktest.Clock) {
+	t.Helper()
+	clock := clocktest.NewClock(epoch)
+	return &twitchhook.TwitchWebhookHandler{
+		OAuth2ClientID:     "client",
+		OAuth2ClientSecret: "secret",
+		CallbackBaseURL:    testBaseURL,
+		DefaultLease:       time.Hour,
+		Manager:            &twitchhook.InMemoryCache{Clock: clock},
+		Clock:              clock,
+	}, clock
+}
+
+// fakeHub answers token requests and accepts every hub request, recording
+// their forms. Listing subscriptions fails so stored expiries are trusted.
+type fakeHub struct {
+	m     sync.Mutex
+	forms []url.Values
+
+	// err fails hub requests, such as when the hub can't be reached, and
+	// status replaces the 202 the hub answers with
+	err    error
+	status int
+}
+
+func (hub *fakeHub) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == "id.twitch.tv" {
+		return hubResponse(http.StatusOK, `{"access_token":"token","token_type":"bearer","expires_in":3600}`), nil
+	}
+	if req.Method == http.MethodGet {
+		return hubResponse(http.StatusServiceUnavailable, `{"error":"Service Unavailable","status":503}`), nil
+	}
+	bs, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(bs))
+	if err != nil {
+		return nil, err
+	}
+	hub.m.Lock()
+	defer hub.m.Unlock()
+	hub.forms = append(hub.forms, form)
+	if hub.err != nil {
+		return nil, hub.err
+	}
+	if hub.status != 0 {
+		return hubResponse(hub.status, `{"error":"Bad Request","status":400,"message":"invalid callback"}`), nil
+	}
+	return hubResponse(http.StatusAccepted, ""), nil
+}
+
+// requests returns the hub.mode and hub.callback of each hub request
+func (hub *fakeHub) requests() []string {
+	hub.m.Lock()
+	defer hub.m.Unlock()
+	requests := make([]string, len(hub.forms))
+	for i, form := range hub.forms {
+		requests[i] = form.Get("hub.mode") + " " + form.Get("hub.callback")
+	}
+	return requests
+}
+
+func hubResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// newHubHandler is newTestHandler sending hub requests to a fakeHub
+func newHubHandler(t *testing.T) (*twitchhook.TwitchWebhookHandler, *fakeHub, *clocktest.Clock) {
+	t.Helper()
+	h, clock := newTestHandler(t)
+	hub := &fakeHub{}
+	h.HTTPClient = &http.Client{Transport: hub}
+	return h, hub, clock
+}
+
+// saveSubscription saves a confirmed subscription to topic with secret
+func saveSubscription(t *testing.T, h *twitchhook.TwitchWebhookHandler, topic, secret string) *twitchhook.Subscription {
+	t.Helper()
+	id, err := twitchhook.NewSubscriptionID(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := url.Parse(testBaseURL)
+	callbackURL, err := twitchhook.PathCallbackURL{}.BuildCallbackURL(base, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := &twitchhook.Subscription{
+		ID:              id,
+		Topic:           topic,
+		CallbackBaseURL: testBaseURL,
+		CallbackURL:     callbackURL.String(),
+		Lease:           time.Hour,
+		Secret:          secret,
+		ExpiresAt:       h.Clock.Now().Add(time.Hour),
+	}
+	if err := h.Manager.Save(topic, sub); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+// signedRequest is a notification to sub's callback signed with secret
+func signedRequest(t *testing.T, sub *twitchhook.Subscription, algorithm, secret string, body []byte) *http.Request {
+	t.Helper()
+	signature, err := twitchhook.Sign(algorithm, secret, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, sub.CallbackURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(twitchhook.HeaderSignature, signature)
+	return req
+}
+
+// validate runs ValidateSignature on req, failing the test on errors
+func validate(t *testing.T, h *twitchhook.TwitchWebhookHandler, req *http.Request) bool {
+	t.Helper()
+	result, err := h.ValidateSignature(req)
+	if err != nil {
+		t.Fatalf("ValidateSignature: %v", err)
+	}
+	return result.Valid
+}
@@ -0,0 +1,89 @@
+package twitchhook
+
+import "time"
+
+// Hooks are called at each point of a subscription's and notification's
+// lifecycle, such as for custom metrics and alerting. Every hook is optional.
+// Hooks are called synchronously and panics are recovered like callbacks',
+// keep them quick and hand slow work off to a goroutine.
+type Hooks struct {
+	// OnSubscribe is called once a subscription request was sent to the hub,
+	// renewal is set for renewals and err when the hub didn't accept it
+	OnSubscribe func(topic string, renewal bool, err error)
+
+	// OnConfirm is called when the hub confirms a subscription's lease
+	OnConfirm func(topic string, lease time.Duration)
+
+	// OnDenial is called when the hub denies a subscription, before the
+	// denial is retried or the DenialCallback called
+	OnDenial func(topic string, reason DenialReason)
+
+	// OnRenewalScheduled is called with when a subscription is renewed next,
+	// on confirmation, restore by Start and for renewal retries
+	OnRenewalScheduled func(topic string, at time.Time)
+
+	// OnNotification is called for notifications whose signature was
+	// verified, before they're dispatched
+	OnNotification func(n *Notification)
+
+	// OnReject is called for notifications that failed verification, such as
+	// with ErrInvalidSignature or ErrDuplicateNotification
+	OnReject func(n *Notification, err error)
+
+	// OnError is called with errors the handler logs rather than returns,
+	// op names what failed, such as renew or notification handler
+	OnError func(op string, err error)
+}
+
+// hook runs a hook, recovering its panics
+func (m *TwitchWebhookHandler) hook(name string, f func()) {
+	m.protect(name+" hook", func() error {
+		f()
+		return nil
+	})
+}
+
+func (m *TwitchWebhookHandler) hookSubscribe(topic string, renewal bool, err error) {
+	if m.Hooks.OnSubscribe != nil {
+		m.hook("subscribe", func() { m.Hooks.OnSubscribe(topic, renewal, err) })
+	}
+}
+
+func (m *TwitchWebhookHandler) hookConfirm(topic string, lease time.Duration) {
+	if m.Hooks.OnConfirm != nil {
+		m.hook("confirm", func() { m.Hooks.OnConfirm(topic, lease) })
+	}
+}
+
+func (m *TwitchWebhookHandler) hookDenial(topic string, reason DenialReason) {
+	if m.Hooks.OnDenial != nil {
+		m.hook("denial", func() { m.Hooks.OnDenial(topic, reason) })
+	}
+}
+
+func (m *TwitchWebhookHandler) hookRenewalScheduled(topic string, at time.Time) {
+	if m.Hooks.OnRenewalScheduled != nil {
+		m.hook("renewal scheduled", func() { m.Hooks.OnRenewalScheduled(topic, at) })
+	}
+}
+
+func (m *TwitchWebhookHandler) hookNotification(n *Notification) {
+	if m.Hooks.OnNotification != nil {
+		m.hook("notification", func() { m.Hooks.OnNotification(n) })
+	}
+}
+
+func (m *TwitchWebhookHandler) hookReject(n *Notification, err error) {
+	if m.Hooks.OnReject != nil {
+		m.hook("reject", func() { m.Hooks.OnReject(n, err) })
+	}
+}
+
+func (m *TwitchWebhookHandler) hookError(op string, err error) {
+	if err != nil {
+		m.recentErrors.add(DebugError{Time: clockOrDefault(m.Clock).Now(), Op: op, Error: err.Error()})
+	}
+	if m.Hooks.OnError != nil {
+		m.hook("error", func() { m.Hooks.OnError(op, err) })
+	}
+}
@@ -0,0 +1,54 @@
+package twitchhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HubSubscription is a subscription as reported by the hub
+type HubSubscription struct {
+	Topic     string    `json:"topic"`
+	Callback  string    `json:"callback"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// HubSubscriptions lists every subscription the hub holds for the client
+func (m *TwitchWebhookHandler) HubSubscriptions(ctx context.Context) ([]HubSubscription, error) {
+	return m.HubSubscriptionPages().All(ctx)
+}
+
+// HubSubscriptionPages pages through the subscriptions the hub holds for the
+// client
+func (m *TwitchWebhookHandler) HubSubscriptionPages() *Paginator[HubSubscription] {
+	m.once.Do(m.setup)
+
+	return &Paginator[HubSubscription]{Fetch: func(ctx context.Context, cursor string) (Page[HubSubscription], error) {
+		q := url.Values{"first": {"100"}}
+		if cursor != "" {
+			q.Set("after", cursor)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.hubSubscriptionsURL+"?"+q.Encode(), nil)
+		if err != nil {
+			return Page[HubSubscription]{}, err
+		}
+		req.Header.Set("Client-Id", m.OAuth2ClientID)
+
+		resp, err := m.client.Do(req)
+		if err != nil {
+			return Page[HubSubscription]{}, err
+		}
+		bs, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return Page[HubSubscription]{}, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return Page[HubSubscription]{}, newHubError(resp, bs)
+		}
+		return DecodePage[HubSubscription](resp.Header, bs)
+	}}
+}
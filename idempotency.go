@@ -0,0 +1,194 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Idempotency defaults
+const (
+	DefaultIdempotencyClaimTTL  = time.Minute
+	DefaultIdempotencyRetention = 24 * time.Hour
+)
+
+// ErrNotificationInProgress is returned by IdempotencyStore.Claim while
+// another attempt holds an unexpired claim on the notification
+var ErrNotificationInProgress = errors.New("notification is being processed")
+
+// IdempotencyStore records the notifications handlers have completed. Unlike
+// a DedupStore, ids are only recorded once their handler succeeds, so a
+// crash while handling leaves the notification to its redelivery.
+type IdempotencyStore interface {
+	// Claim reserves id for an attempt until expiry, reporting whether id
+	// was already completed. It returns ErrNotificationInProgress while
+	// another attempt holds the claim.
+	Claim(ctx context.Context, id string, expiry time.Time) (completed bool, err error)
+
+	// Complete records id as completed until expiry
+	Complete(ctx context.Context, id string, expiry time.Time) error
+
+	// Release drops the claim on id so it can be attempted again
+	Release(ctx context.Context, id string) error
+}
+
+// Idempotency runs handlers at most once to completion per notification id.
+// Handlers are retried on failure, a crash after the handler's side effects
+// but before Complete runs them again once the claim expires; stores such as
+// sqlstore.Idempotency close that gap by recording the id in the handler's
+// transaction.
+type Idempotency struct {
+	Store IdempotencyStore
+
+	// ClaimTTL bounds an attempt, defaults to DefaultIdempotencyClaimTTL.
+	// Attempts of a crashed instance are taken over after it.
+	ClaimTTL time.Duration
+
+	// Retention is how long completed ids are remembered, defaults to
+	// DefaultIdempotencyRetention
+	Retention time.Duration
+
+	// Clock defaults to SystemClock
+	Clock Clock
+
+	// Logger defaults to a no-op logger
+	Logger *zap.Logger
+}
+
+// WithIdempotency wraps fn so it completes at most once per notification id
+// with the default Idempotency settings
+func WithIdempotency(store IdempotencyStore, fn func(ctx context.Context, n *Notification) error) NotificationHandler {
+	i := &Idempotency{Store: store}
+	return i.Wrap(NotificationHandlerFunc(fn))
+}
+
+// Wrap returns a handler calling h for notifications that haven't been
+// completed. Completed notifications are acknowledged, notifications another
+// attempt is processing are asked to be redelivered. Notifications without
+// an id are always handled.
+func (i *Idempotency) Wrap(h NotificationHandler) NotificationHandler {
+	return NotificationHandlerFunc(func(ctx context.Context, n *Notification) error {
+		if n.ID == "" {
+			return h.HandleNotification(ctx, n)
+		}
+
+		claimTTL := i.ClaimTTL
+		if claimTTL <= 0 {
+			claimTTL = DefaultIdempotencyClaimTTL
+		}
+		now := clockOrDefault(i.Clock).Now()
+		completed, err := i.Store.Claim(ctx, n.ID, now.Add(claimTTL))
+		if errors.Is(err, ErrNotificationInProgress) {
+			return RetryLater(claimTTL, err)
+		}
+		if err != nil {
+			return RetryLater(0, err)
+		}
+		if completed {
+			return nil
+		}
+
+		err = h.HandleNotification(ctx, n)
+		if err != nil {
+			// the claim is dropped even when the handler's context is done
+			releaseErr := i.Store.Release(context.WithoutCancel(ctx), n.ID)
+			if releaseErr != nil {
+				i.logger().Error("error releasing notification claim", zap.String("id", n.ID), zap.Error(releaseErr))
+			}
+			return err
+		}
+
+		retention := i.Retention
+		if retention <= 0 {
+			retention = DefaultIdempotencyRetention
+		}
+		err = i.Store.Complete(context.WithoutCancel(ctx), n.ID, clockOrDefault(i.Clock).Now().Add(retention))
+		if err != nil {
+			// handled, the claim expiring lets a redelivery run it again
+			i.logger().Error("error completing notification", zap.String("id", n.ID), zap.Error(err))
+		}
+		return nil
+	})
+}
+
+func (i *Idempotency) logger() *zap.Logger {
+	if i.Logger == nil {
+		return nopLogger
+	}
+	return i.Logger
+}
+
+// InMemoryIdempotencyStore is an IdempotencyStore backed by a map, it only
+// guards against double processing within one process
+type InMemoryIdempotencyStore struct {
+	// Clock decides when claims and completed ids expire, defaults to
+	// SystemClock
+	Clock Clock
+
+	m         sync.Mutex
+	ids       map[string]idempotencyEntry
+	lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+	completed bool
+	expiry    time.Time
+}
+
+// Claim implements IdempotencyStore
+func (s *InMemoryIdempotencyStore) Claim(ctx context.Context, id string, expiry time.Time) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := clockOrDefault(s.Clock).Now()
+	if s.ids == nil {
+		s.ids = make(map[string]idempotencyEntry)
+	}
+	if now.Sub(s.lastSweep) >= dedupSweepInterval {
+		for k, e := range s.ids {
+			if !now.Before(e.expiry) {
+				delete(s.ids, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	e, ok := s.ids[id]
+	if ok && !now.Before(e.expiry) {
+		ok = false
+	}
+	switch {
+	case ok && e.completed:
+		return true, nil
+	case ok:
+		return false, ErrNotificationInProgress
+	}
+	s.ids[id] = idempotencyEntry{expiry: expiry}
+	return false, nil
+}
+
+// Complete implements IdempotencyStore
+func (s *InMemoryIdempotencyStore) Complete(ctx context.Context, id string, expiry time.Time) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.ids == nil {
+		s.ids = make(map[string]idempotencyEntry)
+	}
+	s.ids[id] = idempotencyEntry{completed: true, expiry: expiry}
+	return nil
+}
+
+// Release implements IdempotencyStore
+func (s *InMemoryIdempotencyStore) Release(ctx context.Context, id string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if e, ok := s.ids[id]; ok && !e.completed {
+		delete(s.ids, id)
+	}
+	return nil
+}
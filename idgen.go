@@ -0,0 +1,145 @@
+package twitchhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// IDGenerator generates the subscription ids embedded in callback urls
+type IDGenerator interface {
+	NewSubscriptionID(topic string) (SubscriptionID, error)
+}
+
+// TopicDecoder is implemented by IDGenerators whose ids embed the topic.
+// Ids from generators that don't implement it are opaque and are resolved
+// through a SubscriptionIDIndex.
+type TopicDecoder interface {
+	SubscriptionIDTopic(id SubscriptionID) (string, error)
+}
+
+// SubscriptionIDIndex is implemented by SubscriptionManagers that can look up
+// subscriptions by id, it's required for opaque ids
+type SubscriptionIDIndex interface {
+	GetByID(id SubscriptionID) (*Subscription, error)
+}
+
+// ErrOpaqueSubscriptionIDs is returned from Subscribe when the IDGenerator
+// doesn't embed topics and the Manager can't look subscriptions up by id
+var ErrOpaqueSubscriptionIDs = errors.New("IDGenerator ids are opaque and Manager does not implement SubscriptionIDIndex")
+
+// TopicIDGenerator generates version 1 ids that embed the topic, this is the
+// default
+type TopicIDGenerator struct{}
+
+// NewSubscriptionID implements IDGenerator
+func (TopicIDGenerator) NewSubscriptionID(topic string) (SubscriptionID, error) {
+	return NewSubscriptionID(topic)
+}
+
+// SubscriptionIDTopic implements TopicDecoder
+func (TopicIDGenerator) SubscriptionIDTopic(id SubscriptionID) (string, error) {
+	return SubscriptionIDToTopic(id)
+}
+
+// UUIDv7Generator generates opaque RFC 9562 version 7 uuids
+type UUIDv7Generator struct {
+	// Clock provides the timestamp, defaults to SystemClock
+	Clock Clock
+}
+
+// NewSubscriptionID implements IDGenerator
+func (g UUIDv7Generator) NewSubscriptionID(topic string) (SubscriptionID, error) {
+	var u [16]byte
+	_, err := rand.Read(u[6:])
+	if err != nil {
+		return "", err
+	}
+	putMillis48(u[:6], clockOrDefault(g.Clock))
+	u[6] = u[6]&0x0f | 0x70
+	u[8] = u[8]&0x3f | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return SubscriptionID(buf[:]), nil
+}
+
+var ulidEncoding = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+// ULIDGenerator generates opaque ulids
+type ULIDGenerator struct {
+	// Clock provides the timestamp, defaults to SystemClock
+	Clock Clock
+}
+
+// NewSubscriptionID implements IDGenerator
+func (g ULIDGenerator) NewSubscriptionID(topic string) (SubscriptionID, error) {
+	// the 128 bit ulid is shifted into 130 bits so the 26 character base32
+	// encoding starts with the two leading zero bits the spec requires
+	var u [16]byte
+	_, err := rand.Read(u[6:])
+	if err != nil {
+		return "", err
+	}
+	putMillis48(u[:6], clockOrDefault(g.Clock))
+
+	var shifted [17]byte
+	for i := 0; i < 16; i++ {
+		shifted[i] |= u[i] >> 2
+		shifted[i+1] = u[i] << 6
+	}
+	return SubscriptionID(ulidEncoding.EncodeToString(shifted[:])[:26]), nil
+}
+
+// HMACIDGenerator generates opaque ids deterministically from the topic, so
+// a topic always gets the same callback url for a given key
+type HMACIDGenerator struct {
+	Key []byte
+}
+
+// NewSubscriptionID implements IDGenerator
+func (g HMACIDGenerator) NewSubscriptionID(topic string) (SubscriptionID, error) {
+	if len(g.Key) == 0 {
+		return "", errors.New("HMACIDGenerator key is required")
+	}
+	mac := hmac.New(sha256.New, g.Key)
+	mac.Write([]byte(topic))
+	return SubscriptionID(base64.RawURLEncoding.EncodeToString(mac.Sum(nil))), nil
+}
+
+func putMillis48(b []byte, c Clock) {
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(c.Now().UnixNano()/1e6))
+	copy(b, ms[2:])
+}
+
+func (m *TwitchWebhookHandler) idGenerator() IDGenerator {
+	if m.IDGenerator == nil {
+		return TopicIDGenerator{}
+	}
+	return m.IDGenerator
+}
+
+// checkIDGenerator makes sure ids from the generator can be resolved
+func (m *TwitchWebhookHandler) checkIDGenerator() error {
+	if _, ok := m.idGenerator().(TopicDecoder); ok {
+		return nil
+	}
+	if _, ok := m.Manager.(SubscriptionIDIndex); ok {
+		return nil
+	}
+	return ErrOpaqueSubscriptionIDs
+}
@@ -0,0 +1,86 @@
+package twitchhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// jsonFile is a map of T stored as a json file, writes replace the file
+// atomically
+type jsonFile[T any] struct {
+	m sync.Mutex
+}
+
+func (f *jsonFile[T]) put(path, key string, v T) error {
+	return f.update(path, func(items map[string]T) {
+		items[key] = v
+	})
+}
+
+func (f *jsonFile[T]) remove(path, key string) error {
+	return f.update(path, func(items map[string]T) {
+		delete(items, key)
+	})
+}
+
+func (f *jsonFile[T]) list(path string) (map[string]T, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.load(path)
+}
+
+func (f *jsonFile[T]) update(path string, update func(map[string]T)) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	items, err := f.load(path)
+	if err != nil {
+		return err
+	}
+	update(items)
+
+	bs, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bs)
+}
+
+// writeFileAtomic replaces the file at path with bs through a temporary file
+func writeFileAtomic(path string, bs []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(bs)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *jsonFile[T]) load(path string) (map[string]T, error) {
+	items := make(map[string]T)
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return items, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bs, &items)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,362 @@
+// Package loadtest replays synthetic signed notifications against a
+// subscription's callback and reports latency percentiles and allocations,
+// so the cost of verification and dispatch can be compared between changes.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// Defaults
+const (
+	DefaultAlgorithm   = "sha256"
+	DefaultDuration    = 10 * time.Second
+	DefaultConcurrency = 8
+)
+
+// Target is the subscription notifications are sent to
+type Target struct {
+	// Handler serves notifications in process, such as a handler's
+	// SubscriptionCallbackHandler. They're posted to URL when it's nil.
+	Handler http.Handler
+
+	// URL is the subscription's callback url
+	URL    string
+	Topic  string
+	Secret string
+
+	// Algorithm signs notifications, defaults to DefaultAlgorithm
+	Algorithm string
+
+	// Client posts to URL, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// Config configures a Run
+type Config struct {
+	Target Target
+
+	// Rate is how many notifications are sent per second, they're sent as
+	// fast as the Concurrency allows when it's zero
+	Rate int
+
+	// Duration bounds the run, defaults to DefaultDuration unless Count is
+	// set
+	Duration time.Duration
+
+	// Count stops the run after that many notifications when it's set
+	Count int
+
+	// Concurrency is how many notifications are in flight at once, defaults
+	// to DefaultConcurrency
+	Concurrency int
+
+	// Body returns the body of the i-th notification, defaults to
+	// StreamChangedBody
+	Body func(i int) []byte
+}
+
+// Latency summarizes request latencies
+type Latency struct {
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+// Report is the outcome of a Run
+type Report struct {
+	Requests int
+	// Errors counts requests that failed or weren't answered with a 2xx
+	Errors   int
+	Statuses map[int]int
+
+	Duration   time.Duration
+	Throughput float64
+
+	// Latency is measured from when a notification was due, so a target
+	// falling behind the Rate reports the time notifications waited
+	Latency Latency
+
+	// AllocsPerRequest and BytesPerRequest are the process's heap
+	// allocations during the run divided by the requests. They include
+	// generating and signing notifications, compare runs of the same
+	// Config.
+	AllocsPerRequest float64
+	BytesPerRequest  float64
+}
+
+// String formats the report for humans
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "requests     %d in %s, %.1f/s\n", r.Requests, r.Duration.Round(time.Millisecond), r.Throughput)
+	fmt.Fprintf(&b, "errors       %d\n", r.Errors)
+	statuses := make([]int, 0, len(r.Statuses))
+	for status := range r.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(&b, "status %d   %d\n", status, r.Statuses[status])
+	}
+	fmt.Fprintf(&b, "latency      mean %s p50 %s p90 %s p99 %s max %s\n", r.Latency.Mean, r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max)
+	fmt.Fprintf(&b, "allocations  %.1f allocs/req %.0f B/req\n", r.AllocsPerRequest, r.BytesPerRequest)
+	return b.String()
+}
+
+// StreamChangedBody is a stream changed notification body for a stream
+// going live
+func StreamChangedBody(i int) []byte {
+	return []byte(fmt.Sprintf(`{"data":[{"id":"%d","user_id":"1","user_name":"loadtest","game_id":"509658","type":"live","title":"load test","viewer_count":%d,"started_at":"2020-01-01T00:00:00Z"}]}`, i, i))
+}
+
+// InProcess saves a confirmed subscription for topic in h's Manager and
+// returns a Target serving notifications through h's
+// SubscriptionCallbackHandler, without the hub. Use a handler whose Manager
+// is disposable, such as one from twitchhook.OpenManager("").
+func InProcess(h *twitchhook.TwitchWebhookHandler, topic string) (Target, error) {
+	if h.CallbackURLBuilder != nil {
+		return Target{}, errors.New("loadtest: in process targets need the default CallbackURLBuilder")
+	}
+	base := h.CallbackBaseURL
+	if base == "" {
+		base = "https://loadtest.invalid/callback"
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return Target{}, err
+	}
+	id, err := twitchhook.NewSubscriptionID(topic)
+	if err != nil {
+		return Target{}, err
+	}
+	callbackURL, err := twitchhook.PathCallbackURL{}.BuildCallbackURL(baseURL, id)
+	if err != nil {
+		return Target{}, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Target{}, err
+	}
+
+	lease := 24 * time.Hour
+	sub := &twitchhook.Subscription{
+		ID:              id,
+		Topic:           topic,
+		CallbackBaseURL: base,
+		CallbackURL:     callbackURL.String(),
+		Lease:           lease,
+		Secret:          hex.EncodeToString(secret),
+		ExpiresAt:       time.Now().Add(lease),
+	}
+	if err := h.Manager.Save(topic, sub); err != nil {
+		return Target{}, err
+	}
+	return Target{
+		Handler: h.SubscriptionCallbackHandler(),
+		URL:     sub.CallbackURL,
+		Topic:   topic,
+		Secret:  sub.Secret,
+	}, nil
+}
+
+type job struct {
+	i   int
+	due time.Time
+}
+
+type result struct {
+	status  int
+	err     error
+	latency time.Duration
+}
+
+// Run sends notifications to cfg's Target until the Duration or Count runs
+// out or ctx is done, then reports on them
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	t := cfg.Target
+	if t.Handler == nil && t.URL == "" {
+		return nil, errors.New("loadtest: target needs a handler or url")
+	}
+	if t.Topic == "" || t.Secret == "" {
+		return nil, errors.New("loadtest: target needs a topic and secret")
+	}
+	algorithm := t.Algorithm
+	if algorithm == "" {
+		algorithm = DefaultAlgorithm
+	}
+	if _, err := twitchhook.Sign(algorithm, t.Secret, nil); err != nil {
+		return nil, err
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	duration := cfg.Duration
+	if duration <= 0 && cfg.Count <= 0 {
+		duration = DefaultDuration
+	}
+	if duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+	body := cfg.Body
+	if body == nil {
+		body = StreamChangedBody
+	}
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	// notification ids are unique across runs, so dedup stores don't
+	// drop them
+	var nonce [4]byte
+	rand.Read(nonce[:])
+	prefix := "loadtest-" + hex.EncodeToString(nonce[:]) + "-"
+	link := "<https://api.twitch.tv/helix/webhooks/hub>; rel=\"hub\", <" + t.Topic + ">; rel=\"self\""
+
+	jobs := make(chan job, concurrency)
+	results := make(chan result, concurrency)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- send(ctx, &t, client, algorithm, prefix, link, j, body(j.i))
+			}
+		}()
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	go func() {
+		defer close(jobs)
+		var interval time.Duration
+		if cfg.Rate > 0 {
+			interval = time.Second / time.Duration(cfg.Rate)
+		}
+		for i := 0; cfg.Count <= 0 || i < cfg.Count; i++ {
+			due := time.Now()
+			if interval > 0 {
+				due = start.Add(time.Duration(i) * interval)
+				if wait := time.Until(due); wait > 0 {
+					timer := time.NewTimer(wait)
+					select {
+					case <-timer.C:
+					case <-ctx.Done():
+						timer.Stop()
+						return
+					}
+				}
+			}
+			select {
+			case jobs <- job{i: i, due: due}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	report := &Report{Statuses: make(map[int]int)}
+	var latencies []time.Duration
+	for r := range results {
+		if r.err != nil && ctx.Err() != nil && errors.Is(r.err, ctx.Err()) {
+			// cut off by the end of the run
+			continue
+		}
+		report.Requests++
+		latencies = append(latencies, r.latency)
+		if r.err != nil || r.status < 200 || r.status > 299 {
+			report.Errors++
+		}
+		if r.err == nil {
+			report.Statuses[r.status]++
+		}
+	}
+
+	report.Duration = time.Since(start)
+	runtime.ReadMemStats(&after)
+	if report.Requests == 0 {
+		return report, nil
+	}
+	report.Throughput = float64(report.Requests) / report.Duration.Seconds()
+	report.AllocsPerRequest = float64(after.Mallocs-before.Mallocs) / float64(report.Requests)
+	report.BytesPerRequest = float64(after.TotalAlloc-before.TotalAlloc) / float64(report.Requests)
+	report.Latency = summarize(latencies)
+	return report, nil
+}
+
+// send delivers the j-th notification once
+func send(ctx context.Context, t *Target, client *http.Client, algorithm, prefix, link string, j job, body []byte) result {
+	signature, err := twitchhook.Sign(algorithm, t.Secret, body)
+	if err != nil {
+		return result{err: err, latency: time.Since(j.due)}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return result{err: err, latency: time.Since(j.due)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(twitchhook.HeaderSignature, signature)
+	req.Header.Set(twitchhook.HeaderNotificationID, fmt.Sprintf("%s%d", prefix, j.i))
+	req.Header.Set(twitchhook.HeaderNotificationTimestamp, time.Now().UTC().Format(time.RFC3339Nano))
+	req.Header.Set("Link", link)
+
+	if t.Handler != nil {
+		w := httptest.NewRecorder()
+		t.Handler.ServeHTTP(w, req)
+		return result{status: w.Code, latency: time.Since(j.due)}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return result{err: err, latency: time.Since(j.due)}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return result{status: resp.StatusCode, latency: time.Since(j.due)}
+}
+
+func summarize(latencies []time.Duration) Latency {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	at := func(q float64) time.Duration {
+		return latencies[int(q*float64(len(latencies)-1))]
+	}
+	return Latency{
+		Mean: total / time.Duration(len(latencies)),
+		P50:  at(0.50),
+		P90:  at(0.90),
+		P99:  at(0.99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
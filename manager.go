@@ -1,11 +1,15 @@
 package twitchhook
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -14,8 +18,19 @@ type SubscriptionRequest struct {
 	Topic           string
 	CallbackBaseURL string
 	Lease           time.Duration
+
+	// Metadata is kept with the subscription by every Manager, such as the
+	// tenant or feature that owns it, see ListByMetadata
+	Metadata map[string]string
 }
 
+// Lease bounds accepted by the hub, leases are sent in whole seconds
+const (
+	MinLease = time.Second
+	MaxLease = 864000 * time.Second
+)
+
+// validate checks the request, clamping leases longer than MaxLease
 func (r *SubscriptionRequest) validate() error {
 	if r.Topic == "" {
 		return errors.New("subscription topic is required")
@@ -29,36 +44,106 @@ func (r *SubscriptionRequest) validate() error {
 		return errors.New("lease is required")
 	}
 
+	if r.Lease < MinLease {
+		return fmt.Errorf("lease %s is shorter than the minimum of %s", r.Lease, MinLease)
+	}
+
+	if r.Lease > MaxLease {
+		r.Lease = MaxLease
+	}
+
 	return nil
 }
 
 // SubscriptionID describes an id
+//
+// Version 1 ids are "v1." followed by the unpadded url-safe base64 of 8
+// random bytes, the uvarint length of the topic and the topic itself. Ids
+// without a version prefix are legacy ids, the padded url-safe base64 of 4
+// random bytes followed by the topic.
 type SubscriptionID string
 
+const (
+	subscriptionIDV1Prefix  = "v1."
+	subscriptionIDNonceSize = 8
+
+	legacySubscriptionIDNonceSize = 4
+)
+
+// ErrInvalidSubscriptionID is returned when a subscription id can't be parsed
+var ErrInvalidSubscriptionID = errors.New("invalid subscription id")
+
 // NewSubscriptionID generates a random subscription id using topic
 func NewSubscriptionID(topic string) (SubscriptionID, error) {
-	buf := make([]byte, 4, 4+len(topic))
+	buf := make([]byte, subscriptionIDNonceSize, subscriptionIDNonceSize+binary.MaxVarintLen64+len(topic))
 	_, err := rand.Read(buf)
 	if err != nil {
 		return "", err
 	}
-	buf = append(buf, []byte(topic)...)
-	return SubscriptionID(base64.URLEncoding.EncodeToString(buf)), nil
+
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(topic)))
+	buf = append(buf, length[:n]...)
+	buf = append(buf, topic...)
+	return SubscriptionID(subscriptionIDV1Prefix + base64.RawURLEncoding.EncodeToString(buf)), nil
 }
 
 // SubscriptionIDToTopic converts an id to a topic
 func SubscriptionIDToTopic(id SubscriptionID) (string, error) {
-	bs, err := base64.URLEncoding.DecodeString(string(id))
+	if strings.HasPrefix(string(id), subscriptionIDV1Prefix) {
+		return parseV1SubscriptionID(strings.TrimPrefix(string(id), subscriptionIDV1Prefix))
+	}
+	return parseLegacySubscriptionID(string(id))
+}
+
+// parseV1SubscriptionID decodes ids on the stack in blocks, DecodeString
+// would allocate the id twice before the topic is copied out of it
+func parseV1SubscriptionID(s string) (string, error) {
+	var buf [256]byte
+	bs := buf[:0]
+	if n := base64.RawURLEncoding.DecodedLen(len(s)); n > len(buf) {
+		bs = make([]byte, 0, n)
+	}
+	var block [64]byte
+	for len(s) > 0 {
+		k := copy(block[:], s)
+		n, err := base64.RawURLEncoding.Decode(bs[len(bs):cap(bs)], block[:k])
+		if err != nil {
+			return "", ErrInvalidSubscriptionID
+		}
+		bs = bs[:len(bs)+n]
+		s = s[k:]
+	}
+	if len(bs) < subscriptionIDNonceSize {
+		return "", ErrInvalidSubscriptionID
+	}
+	bs = bs[subscriptionIDNonceSize:]
+
+	length, n := binary.Uvarint(bs)
+	if n <= 0 || length != uint64(len(bs)-n) {
+		return "", ErrInvalidSubscriptionID
+	}
+	return string(bs[n:]), nil
+}
+
+func parseLegacySubscriptionID(s string) (string, error) {
+	bs, err := base64.URLEncoding.DecodeString(s)
 	if err != nil {
-		return "", err
+		return "", ErrInvalidSubscriptionID
+	}
+	if len(bs) <= legacySubscriptionIDNonceSize {
+		return "", ErrInvalidSubscriptionID
 	}
-	return string(bs[0:3]), nil
+	return string(bs[legacySubscriptionIDNonceSize:]), nil
 }
 
 // Manager takes care of webhooks
 type Manager interface {
 	SubscriptionCallbackHandler() http.HandlerFunc
 	Subscribe(req SubscriptionRequest, deniedCallback func(reason string)) error
+	SubscribeContext(ctx context.Context, req SubscriptionRequest, deniedCallback func(reason string)) error
 	Unsubscribe(topic string) error
-	ValidateSignature(*http.Request) (valid bool, body io.Reader, err error)
+	UnsubscribeContext(ctx context.Context, topic string) error
+	ValidateSignature(*http.Request) (*VerificationResult, error)
+	ValidateSignatureStream(*http.Request) (body io.ReadCloser, err error)
 }
@@ -0,0 +1,99 @@
+package twitchhook_test
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// v1ID encodes a version 1 id with a zero nonce and the given length prefix
+func v1ID(length uint64, topic string) twitchhook.SubscriptionID {
+	buf := make([]byte, 8, 8+binary.MaxVarintLen64+len(topic))
+	buf = binary.AppendUvarint(buf, length)
+	buf = append(buf, topic...)
+	return twitchhook.SubscriptionID("v1." + base64.RawURLEncoding.EncodeToString(buf))
+}
+
+func legacyID(topic string) twitchhook.SubscriptionID {
+	return twitchhook.SubscriptionID(base64.URLEncoding.EncodeToString(append([]byte{1, 2, 3, 4}, topic...)))
+}
+
+func TestSubscriptionIDRoundTrip(t *testing.T) {
+	topics := []string{
+		"",
+		testTopic,
+		"https://api.twitch.tv/helix/users/follows?first=1&to_id=1234",
+		"https://api.twitch.tv/helix/streams?user_id=1&name=" + strings.Repeat("é", 20),
+		// ids whose decoded length passes the stack buffer and block sizes
+		testTopic + strings.Repeat("a", 248-len(testTopic)),
+		testTopic + strings.Repeat("a", 256),
+		testTopic + strings.Repeat("b", 4096),
+	}
+	for _, topic := range topics {
+		id, err := twitchhook.NewSubscriptionID(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(id), "v1.") {
+			t.Fatalf("NewSubscriptionID(%q) = %q, want a v1 id", topic, id)
+		}
+		got, err := twitchhook.SubscriptionIDToTopic(id)
+		if err != nil || got != topic {
+			t.Errorf("v1 round trip of a %d byte topic = %q, %v", len(topic), got, err)
+		}
+
+		if topic == "" {
+			continue
+		}
+		got, err = twitchhook.SubscriptionIDToTopic(legacyID(topic))
+		if err != nil || got != topic {
+			t.Errorf("legacy round trip of a %d byte topic = %q, %v", len(topic), got, err)
+		}
+	}
+}
+
+func TestSubscriptionIDsAreRandom(t *testing.T) {
+	a, _ := twitchhook.NewSubscriptionID(testTopic)
+	b, _ := twitchhook.NewSubscriptionID(testTopic)
+	if a == b {
+		t.Fatal("ids for the same topic are equal")
+	}
+}
+
+func TestSubscriptionIDToTopicInvalid(t *testing.T) {
+	valid := v1ID(uint64(len(testTopic)), testTopic)
+	long := v1ID(300, strings.Repeat("a", 300))
+	tests := []struct {
+		name string
+		id   twitchhook.SubscriptionID
+	}{
+		{"empty", ""},
+		{"empty v1", "v1."},
+		{"truncated", valid[:len(valid)-1]},
+		{"truncated to a block", valid[:len("v1.")+64]},
+		{"truncated long", long[:len(long)-4]},
+		{"padded", valid + "=="},
+		{"standard alphabet", twitchhook.SubscriptionID(strings.ReplaceAll(strings.ReplaceAll(string(long), "-", "+"), "_", "/")) + "+/"},
+		{"invalid characters", "v1.!!!!!!!!!!!!!!!!"},
+		{"short nonce", twitchhook.SubscriptionID("v1." + base64.RawURLEncoding.EncodeToString([]byte{1, 2, 3}))},
+		{"missing length", twitchhook.SubscriptionID("v1." + base64.RawURLEncoding.EncodeToString(make([]byte, 8)))},
+		{"bad uvarint", twitchhook.SubscriptionID("v1." + base64.RawURLEncoding.EncodeToString(append(make([]byte, 8), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)))},
+		{"length too long", v1ID(uint64(len(testTopic)+1), testTopic)},
+		{"length too short", v1ID(uint64(len(testTopic)-1), testTopic)},
+		{"long length mismatch", v1ID(299, strings.Repeat("a", 300))},
+		{"legacy nonce only", twitchhook.SubscriptionID(base64.URLEncoding.EncodeToString([]byte{1, 2, 3, 4}))},
+		{"legacy unpadded", twitchhook.SubscriptionID(strings.TrimRight(string(legacyID("abc")), "="))},
+	}
+	for _, tt := range tests {
+		if topic, err := twitchhook.SubscriptionIDToTopic(tt.id); err != twitchhook.ErrInvalidSubscriptionID {
+			t.Errorf("%s: SubscriptionIDToTopic(%q) = %q, %v, want ErrInvalidSubscriptionID", tt.name, tt.id, topic, err)
+		}
+	}
+
+	if topic, err := twitchhook.SubscriptionIDToTopic(valid); err != nil || topic != testTopic {
+		t.Fatalf("valid id = %q, %v", topic, err)
+	}
+}
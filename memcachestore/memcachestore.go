@@ -0,0 +1,356 @@
+// Package memcachestore layers a memcached read cache over a durable
+// twitchhook.SubscriptionManager, taking subscription lookups on the
+// signature verification path off the durable store.
+//
+// Importing the package registers the memcache storage DSN scheme, naming
+// the memcached servers and the backend's DSN:
+//
+//	memcache://host:port[,host:port...]?backend=<dsn>[&ttl=<duration>][&prefix=<prefix>][&codec=<codec>]
+package memcachestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/bsdlp/twitchhook"
+)
+
+// Defaults
+const (
+	DefaultPrefix = "twitchhook:"
+	DefaultTTL    = 5 * time.Minute
+)
+
+func init() {
+	twitchhook.RegisterManager("memcache", Open)
+}
+
+// Open opens a Manager from a memcache:// DSN, opening the backend from its
+// backend parameter
+func Open(dsn string) (twitchhook.SubscriptionManager, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("memcachestore: dsn must name a server")
+	}
+	query := u.Query()
+	if query.Get("backend") == "" {
+		return nil, errors.New("memcachestore: dsn must name a backend")
+	}
+
+	m := &Manager{
+		Client: memcache.New(strings.Split(u.Host, ",")...),
+		Prefix: query.Get("prefix"),
+	}
+	m.Codec, err = twitchhook.LookupCodec(query.Get("codec"))
+	if err != nil {
+		return nil, err
+	}
+	if ttl := query.Get("ttl"); ttl != "" {
+		m.TTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.Backend, err = twitchhook.OpenManager(query.Get("backend"))
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Manager serves Get and GetByID from memcached, filling it from Backend on
+// a miss, and writes through to Backend before updating memcached. Entries
+// expire after TTL, bounding how long another replica's write or a failed
+// invalidation can leave a stale entry behind. memcached failures fall back
+// to Backend.
+//
+// Entries hold the subscription's secret, wrap the Manager in a
+// twitchhook.EncryptedManager to keep them encrypted in memcached as well.
+type Manager struct {
+	Client  *memcache.Client
+	Backend twitchhook.SubscriptionManager
+
+	// Prefix namespaces the Manager's keys, defaults to DefaultPrefix
+	Prefix string
+
+	// TTL defaults to DefaultTTL
+	TTL time.Duration
+
+	// Codec encodes cached subscriptions, defaults to twitchhook.JSONCodec
+	Codec twitchhook.Codec
+
+	once sync.Once
+
+	// funcs holds the funcs of subscriptions saved by this process, which
+	// memcached entries don't carry
+	m     sync.Mutex
+	funcs map[string]subscriptionFuncs
+}
+
+type subscriptionFuncs struct {
+	renew  func()
+	denied func(reason string)
+}
+
+// entry is a cached subscription encoded by the Codec and the key it was
+// saved under
+type entry struct {
+	Key    string `json:"key"`
+	Record []byte `json:"record"`
+}
+
+func (m *Manager) setup() {
+	if m.Prefix == "" {
+		m.Prefix = DefaultPrefix
+	}
+	if m.TTL == 0 {
+		m.TTL = DefaultTTL
+	}
+	if m.Codec == nil {
+		m.Codec = twitchhook.JSONCodec
+	}
+}
+
+// cacheKey hashes name, memcached keys are limited to 250 bytes without
+// spaces or control characters
+func (m *Manager) cacheKey(kind, name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return m.Prefix + kind + hex.EncodeToString(sum[:])
+}
+
+func (m *Manager) topicKey(topic string) string {
+	return m.cacheKey("t:", topic)
+}
+
+func (m *Manager) idKey(id twitchhook.SubscriptionID) string {
+	return m.cacheKey("i:", string(id))
+}
+
+// Get retrieves a subscription from memcached or Backend
+func (m *Manager) Get(topic string) (*twitchhook.Subscription, error) {
+	m.once.Do(m.setup)
+	return m.get(m.topicKey(topic), func() (*twitchhook.Subscription, error) {
+		return m.Backend.Get(topic)
+	}, topic)
+}
+
+// GetByID implements twitchhook.SubscriptionIDIndex, Backend must be one
+func (m *Manager) GetByID(id twitchhook.SubscriptionID) (*twitchhook.Subscription, error) {
+	m.once.Do(m.setup)
+	index, ok := m.Backend.(twitchhook.SubscriptionIDIndex)
+	if !ok {
+		return nil, twitchhook.ErrNotSupported
+	}
+	return m.get(m.idKey(id), func() (*twitchhook.Subscription, error) {
+		return index.GetByID(id)
+	}, "")
+}
+
+// get looks up cacheKey, filling it from load on a miss. key is the topic
+// the subscription was saved under when the caller knows it.
+func (m *Manager) get(cacheKey string, load func() (*twitchhook.Subscription, error), key string) (*twitchhook.Subscription, error) {
+	item, err := m.Client.Get(cacheKey)
+	if err == nil {
+		var e entry
+		var record twitchhook.SubscriptionRecord
+		if json.Unmarshal(item.Value, &e) == nil && m.Codec.Unmarshal(e.Record, &record) == nil {
+			return m.attach(e.Key, record.Subscription()), nil
+		}
+	}
+
+	sub, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return nil, twitchhook.ErrSubscriptionNotFound
+	}
+	if key == "" {
+		key = savedKey(sub)
+	}
+
+	// Add rather than Set, so a fill racing a write through can't replace
+	// the newer entry
+	m.store(key, sub, m.Client.Add)
+	return m.attach(key, sub), nil
+}
+
+// store caches sub under its topic and id keys with op, dropping the keys
+// if op fails so a stale entry isn't left behind
+func (m *Manager) store(key string, sub *twitchhook.Subscription, op func(*memcache.Item) error) {
+	record := sub.Record()
+	data, err := m.Codec.Marshal(&record)
+	if err != nil {
+		return
+	}
+	value, err := json.Marshal(entry{Key: key, Record: data})
+	if err != nil {
+		return
+	}
+	cacheKeys := []string{m.topicKey(key)}
+	if sub.ID != "" {
+		cacheKeys = append(cacheKeys, m.idKey(sub.ID))
+	}
+	for _, cacheKey := range cacheKeys {
+		err = op(&memcache.Item{Key: cacheKey, Value: value, Expiration: int32(m.TTL / time.Second)})
+		if err != nil && !errors.Is(err, memcache.ErrNotStored) {
+			m.Client.Delete(cacheKey)
+		}
+	}
+}
+
+// savedKey returns the topic sub was saved under, which is prefixed by its
+// namespace behind a twitchhook.NamespacedManager
+func savedKey(sub *twitchhook.Subscription) string {
+	if sub.Namespace != "" {
+		return sub.Namespace + "/" + sub.Topic
+	}
+	return sub.Topic
+}
+
+// drop removes sub's entries from memcached
+func (m *Manager) drop(key string, sub *twitchhook.Subscription) {
+	m.Client.Delete(m.topicKey(key))
+	if sub != nil && sub.ID != "" {
+		m.Client.Delete(m.idKey(sub.ID))
+	}
+}
+
+func (m *Manager) attach(key string, sub *twitchhook.Subscription) *twitchhook.Subscription {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if f, ok := m.funcs[key]; ok {
+		if sub.Renew == nil {
+			sub.Renew = f.renew
+		}
+		if sub.DenialCallback == nil {
+			sub.DenialCallback = f.denied
+		}
+	}
+	return sub
+}
+
+// Save writes sub through to Backend and memcached
+func (m *Manager) Save(topic string, sub *twitchhook.Subscription) error {
+	m.once.Do(m.setup)
+
+	// the replaced subscription's id entry has to go
+	old, err := m.Backend.Get(topic)
+	if err != nil && !errors.Is(err, twitchhook.ErrSubscriptionNotFound) {
+		return err
+	}
+	err = m.Backend.Save(topic, sub)
+	if err != nil {
+		return err
+	}
+
+	m.m.Lock()
+	delete(m.funcs, topic)
+	if sub.Renew != nil || sub.DenialCallback != nil {
+		if m.funcs == nil {
+			m.funcs = make(map[string]subscriptionFuncs)
+		}
+		m.funcs[topic] = subscriptionFuncs{renew: sub.Renew, denied: sub.DenialCallback}
+	}
+	m.m.Unlock()
+
+	if old != nil && old.ID != "" && old.ID != sub.ID {
+		m.Client.Delete(m.idKey(old.ID))
+	}
+	m.store(topic, sub, m.Client.Set)
+	return nil
+}
+
+// Delete removes a subscription from Backend and memcached
+func (m *Manager) Delete(topic string) error {
+	m.once.Do(m.setup)
+
+	old, err := m.Backend.Get(topic)
+	if err != nil && !errors.Is(err, twitchhook.ErrSubscriptionNotFound) {
+		return err
+	}
+	err = m.Backend.Delete(topic)
+	if err != nil {
+		return err
+	}
+
+	m.m.Lock()
+	delete(m.funcs, topic)
+	m.m.Unlock()
+
+	m.drop(topic, old)
+	return nil
+}
+
+// SetSubscriptionLease sets the lease in Backend and refreshes memcached
+func (m *Manager) SetSubscriptionLease(topic string, lease time.Duration) (bool, error) {
+	m.once.Do(m.setup)
+
+	ok, err := m.Backend.SetSubscriptionLease(topic, lease)
+	if err != nil || !ok {
+		return ok, err
+	}
+	sub, err := m.Backend.Get(topic)
+	if err != nil {
+		m.drop(topic, nil)
+		return true, nil
+	}
+	m.store(topic, sub, m.Client.Set)
+	return true, nil
+}
+
+// List implements twitchhook.SubscriptionLister, listing Backend
+func (m *Manager) List() ([]*twitchhook.Subscription, error) {
+	lister, ok := m.Backend.(twitchhook.SubscriptionLister)
+	if !ok {
+		return nil, twitchhook.ErrNotSupported
+	}
+	return lister.List()
+}
+
+// RenewalAt implements twitchhook.RenewalScheduler, asking Backend
+func (m *Manager) RenewalAt(topic string) (time.Time, error) {
+	scheduler, ok := m.Backend.(twitchhook.RenewalScheduler)
+	if !ok {
+		return time.Time{}, twitchhook.ErrNotSupported
+	}
+	return scheduler.RenewalAt(topic)
+}
+
+// NotifyEviction implements twitchhook.EvictionNotifier, dropping evicted
+// subscriptions from memcached before calling f
+func (m *Manager) NotifyEviction(f func(sub *twitchhook.Subscription)) {
+	notifier, ok := m.Backend.(twitchhook.EvictionNotifier)
+	if !ok {
+		return
+	}
+	notifier.NotifyEviction(func(sub *twitchhook.Subscription) {
+		m.once.Do(m.setup)
+		m.drop(savedKey(sub), sub)
+		f(sub)
+	})
+}
+
+// Ping implements twitchhook.Pinger, pinging memcached and Backend
+func (m *Manager) Ping(ctx context.Context) error {
+	err := m.Client.Ping()
+	if err != nil {
+		return err
+	}
+	if pinger, ok := m.Backend.(twitchhook.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
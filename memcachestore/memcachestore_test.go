@@ -0,0 +1,256 @@
+package memcachestore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+	"github.com/bsdlp/twitchhook/storetest"
+)
+
+// fakeMemcached serves the get, set, add, delete and version commands of
+// the memcached text protocol from a map. Items don't expire.
+type fakeMemcached struct {
+	lis net.Listener
+
+	m     sync.Mutex
+	items map[string][]byte
+	conns map[net.Conn]bool
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeMemcached{lis: lis, items: make(map[string][]byte), conns: make(map[net.Conn]bool)}
+	go f.serve()
+	t.Cleanup(f.close)
+	return f
+}
+
+func (f *fakeMemcached) serve() {
+	for {
+		conn, err := f.lis.Accept()
+		if err != nil {
+			return
+		}
+		f.m.Lock()
+		f.conns[conn] = true
+		f.m.Unlock()
+		go f.serveConn(conn)
+	}
+}
+
+// close stops the server, clients fail to connect afterwards
+func (f *fakeMemcached) close() {
+	f.lis.Close()
+	f.m.Lock()
+	defer f.m.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func (f *fakeMemcached) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+		switch fields[0] {
+		case "get", "gets":
+			f.m.Lock()
+			for _, key := range fields[1:] {
+				if value, ok := f.items[key]; ok {
+					fmt.Fprintf(w, "VALUE %s 0 %d 1\r\n%s\r\n", key, len(value), value)
+				}
+			}
+			f.m.Unlock()
+			w.WriteString("END\r\n")
+		case "set", "add":
+			if len(fields) < 5 {
+				return
+			}
+			n, err := strconv.Atoi(fields[4])
+			if err != nil {
+				return
+			}
+			value := make([]byte, n+2)
+			if _, err := io.ReadFull(r, value); err != nil {
+				return
+			}
+			f.m.Lock()
+			_, exists := f.items[fields[1]]
+			if fields[0] == "add" && exists {
+				w.WriteString("NOT_STORED\r\n")
+			} else {
+				f.items[fields[1]] = value[:n]
+				w.WriteString("STORED\r\n")
+			}
+			f.m.Unlock()
+		case "delete":
+			f.m.Lock()
+			if _, ok := f.items[fields[1]]; ok {
+				delete(f.items, fields[1])
+				w.WriteString("DELETED\r\n")
+			} else {
+				w.WriteString("NOT_FOUND\r\n")
+			}
+			f.m.Unlock()
+		case "version":
+			w.WriteString("VERSION 1.6.0\r\n")
+		default:
+			w.WriteString("ERROR\r\n")
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeMemcached) len() int {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return len(f.items)
+}
+
+// countingBackend counts the lookups reaching the backend
+type countingBackend struct {
+	*twitchhook.InMemoryCache
+
+	m     sync.Mutex
+	reads int
+}
+
+func (b *countingBackend) Get(topic string) (*twitchhook.Subscription, error) {
+	b.count()
+	return b.InMemoryCache.Get(topic)
+}
+
+func (b *countingBackend) GetByID(id twitchhook.SubscriptionID) (*twitchhook.Subscription, error) {
+	b.count()
+	return b.InMemoryCache.GetByID(id)
+}
+
+func (b *countingBackend) count() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.reads++
+}
+
+func (b *countingBackend) lookups() int {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.reads
+}
+
+func newManager(t *testing.T, clock twitchhook.Clock) (*Manager, *fakeMemcached, *countingBackend) {
+	f := newFakeMemcached(t)
+	backend := &countingBackend{InMemoryCache: &twitchhook.InMemoryCache{Clock: clock}}
+	return &Manager{Client: memcache.New(f.lis.Addr().String()), Backend: backend}, f, backend
+}
+
+func TestManager(t *testing.T) {
+	storetest.TestSubscriptionManager(t, func(t *testing.T, clock twitchhook.Clock) twitchhook.SubscriptionManager {
+		m, _, _ := newManager(t, clock)
+		return m
+	})
+}
+
+const topic = "https://api.twitch.tv/helix/streams?user_id=1"
+
+func TestManagerCachesLookups(t *testing.T) {
+	m, _, backend := newManager(t, clocktest.NewClock(storetest.Epoch))
+
+	sub := storetest.Subscription(topic, "v1.a")
+	if err := m.Save(topic, sub); err != nil {
+		t.Fatal(err)
+	}
+	reads := backend.lookups()
+	for range 3 {
+		got, err := m.Get(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storetest.CheckSubscription(t, got, sub)
+		got, err = m.GetByID(sub.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storetest.CheckSubscription(t, got, sub)
+	}
+	if n := backend.lookups() - reads; n != 0 {
+		t.Fatalf("%d lookups reached the backend after a write through", n)
+	}
+}
+
+func TestManagerFillsMisses(t *testing.T) {
+	m, f, backend := newManager(t, clocktest.NewClock(storetest.Epoch))
+
+	// saved by another replica straight to the backend
+	sub := storetest.Subscription(topic, "v1.a")
+	if err := backend.Save(topic, sub); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		got, err := m.Get(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storetest.CheckSubscription(t, got, sub)
+	}
+	if n := backend.lookups(); n != 1 {
+		t.Fatalf("%d lookups reached the backend, want the first miss", n)
+	}
+	if n := f.len(); n != 2 {
+		t.Fatalf("%d entries cached, want the topic and id entries", n)
+	}
+	if _, err := m.GetByID(sub.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := backend.lookups(); n != 1 {
+		t.Fatal("the id entry filled by a Get missed")
+	}
+
+	if sub, err := m.Get("https://api.twitch.tv/helix/streams?user_id=2"); err != twitchhook.ErrSubscriptionNotFound {
+		t.Fatalf("Get of a missing subscription = %v, %v", sub, err)
+	}
+}
+
+func TestManagerFallsBackToBackend(t *testing.T) {
+	m, f, backend := newManager(t, clocktest.NewClock(storetest.Epoch))
+	f.close()
+
+	sub := storetest.Subscription(topic, "v1.a")
+	if err := m.Save(topic, sub); err != nil {
+		t.Fatalf("Save with memcached down: %v", err)
+	}
+	got, err := m.Get(topic)
+	if err != nil {
+		t.Fatalf("Get with memcached down: %v", err)
+	}
+	storetest.CheckSubscription(t, got, sub)
+	if backend.lookups() == 0 {
+		t.Fatal("Get didn't read the backend")
+	}
+	if err := m.Ping(t.Context()); err == nil {
+		t.Fatal("Ping succeeded with memcached down")
+	}
+}
@@ -0,0 +1,29 @@
+package twitchhook
+
+// HasMetadata reports whether the subscription's metadata has every key and
+// value in match
+func (s *Subscription) HasMetadata(match map[string]string) bool {
+	for k, v := range match {
+		if got, ok := s.Metadata[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ListByMetadata lists the subscriptions whose metadata has every key and
+// value in match, an empty match lists every subscription
+func ListByMetadata(lister SubscriptionLister, match map[string]string) ([]*Subscription, error) {
+	subs, err := lister.List()
+	if err != nil || len(match) == 0 {
+		return subs, err
+	}
+
+	matched := subs[:0:0]
+	for _, sub := range subs {
+		if sub.HasMetadata(match) {
+			matched = append(matched, sub)
+		}
+	}
+	return matched, nil
+}
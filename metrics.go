@@ -0,0 +1,20 @@
+package twitchhook
+
+// Metrics receives the handler's counters and gauges. Names are snake case
+// and prefixed with twitchhook_, labels are name value pairs.
+type Metrics interface {
+	IncCounter(name string, labels ...string)
+	SetGauge(name string, value float64, labels ...string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) IncCounter(name string, labels ...string)              {}
+func (nopMetrics) SetGauge(name string, value float64, labels ...string) {}
+
+func (m *TwitchWebhookHandler) metrics() Metrics {
+	if m.Metrics == nil {
+		return nopMetrics{}
+	}
+	return m.Metrics
+}
@@ -0,0 +1,182 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Callback migration defaults
+const (
+	DefaultMigrationTimeout = time.Minute
+	migrationPollInterval   = 100 * time.Millisecond
+)
+
+// ErrMigrationUnconfirmed is returned for topics whose subscription to the
+// new callback wasn't confirmed in time
+var ErrMigrationUnconfirmed = errors.New("subscription to new callback not confirmed")
+
+// migration is a topic moving to a new callback
+type migration struct {
+	old *Subscription
+	err error
+}
+
+// MigrateCallbackBase moves every active subscription to a callback under
+// newBaseURL. Each topic is subscribed again with a new callback, once the
+// hub confirms every new subscription or DefaultMigrationTimeout passes the
+// old callbacks of the confirmed ones are unsubscribed. Topics that aren't
+// confirmed keep their old subscription and the new callback is
+// unsubscribed instead. Notifications to an old callback are rejected once
+// its topic has moved. Topics are subscribed in Priority order. The Manager
+// must be a SubscriptionLister.
+//
+// Under DryRun the hub requests are logged and nothing is waited on.
+//
+// Renewals of migrated topics use newBaseURL, set CallbackBaseURL to it
+// before the handler is next started.
+func (m *TwitchWebhookHandler) MigrateCallbackBase(ctx context.Context, newBaseURL string) error {
+	m.once.Do(m.setup)
+
+	lister, ok := m.Manager.(SubscriptionLister)
+	if !ok {
+		return ErrNotSupported
+	}
+	subs, err := lister.List()
+	if err != nil {
+		return err
+	}
+
+	m.prioritizeSubscriptions(subs)
+	now := clockOrDefault(m.Clock).Now()
+	var migrations []*migration
+	for _, sub := range subs {
+		if sub.State(now) != SubscriptionActive || sub.CallbackBaseURL == newBaseURL {
+			continue
+		}
+		mg := &migration{old: sub}
+		mg.err = m.subscribe(ctx, SubscriptionRequest{
+			Topic:           sub.Topic,
+			CallbackBaseURL: newBaseURL,
+			Lease:           sub.Lease,
+			Metadata:        sub.Metadata,
+		}, sub.DenialCallback, true)
+		migrations = append(migrations, mg)
+	}
+
+	return m.completeMigrations(ctx, migrations)
+}
+
+// completeMigrations waits for migrations to be confirmed, then unsubscribes
+// the old callbacks of confirmed topics and restores the rest
+func (m *TwitchWebhookHandler) completeMigrations(ctx context.Context, migrations []*migration) error {
+	m.awaitMigrations(ctx, migrations)
+
+	var errs []error
+	for _, mg := range migrations {
+		topic := mg.old.Topic
+		if mg.err != nil {
+			m.logger().Error("error moving subscription", zap.String("topic", topic), zap.Error(mg.err))
+			errs = append(errs, fmt.Errorf("%s: %w", topic, mg.err))
+			m.restoreMigration(ctx, mg.old)
+			continue
+		}
+
+		err := m.UnsubscribeCallback(ctx, topic, mg.old.CallbackURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: unsubscribing old callback: %w", topic, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// awaitMigrations polls the Manager until every successfully requested
+// migration is confirmed, setting the errors of those that aren't
+func (m *TwitchWebhookHandler) awaitMigrations(ctx context.Context, migrations []*migration) {
+	if m.DryRun {
+		return
+	}
+	clock := clockOrDefault(m.Clock)
+	deadline := clock.Now().Add(DefaultMigrationTimeout)
+
+	for {
+		waiting := false
+		for _, mg := range migrations {
+			if mg.err != nil {
+				continue
+			}
+			confirmed, err := m.migrationConfirmed(mg.old)
+			if err != nil {
+				mg.err = err
+				continue
+			}
+			waiting = waiting || !confirmed
+		}
+		if !waiting {
+			return
+		}
+
+		err := ctx.Err()
+		if err == nil && !clock.Now().Before(deadline) {
+			err = ErrMigrationUnconfirmed
+		}
+		if err == nil {
+			tick := make(chan struct{})
+			timer := clock.AfterFunc(migrationPollInterval, func() { close(tick) })
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-tick:
+			}
+			continue
+		}
+
+		for _, mg := range migrations {
+			if mg.err == nil {
+				if confirmed, _ := m.migrationConfirmed(mg.old); !confirmed {
+					mg.err = err
+				}
+			}
+		}
+		return
+	}
+}
+
+// migrationConfirmed reports whether the topic's subscription has moved off
+// old and been confirmed
+func (m *TwitchWebhookHandler) migrationConfirmed(old *Subscription) (bool, error) {
+	sub, err := m.getSubscription(old.Topic)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		// the new subscription was denied
+		return false, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return sub.ID != old.ID && !sub.ExpiresAt.IsZero(), nil
+}
+
+// restoreMigration puts back the subscription of a topic that didn't
+// migrate, unsubscribing the new callback if one was subscribed
+func (m *TwitchWebhookHandler) restoreMigration(ctx context.Context, old *Subscription) {
+	current, err := m.getSubscription(old.Topic)
+	if err == nil && current.ID == old.ID {
+		return
+	}
+
+	err = m.Manager.Save(old.Topic, old)
+	if err != nil {
+		m.logger().Error("error restoring subscription", zap.String("topic", old.Topic), zap.Error(err))
+		return
+	}
+	if current == nil {
+		return
+	}
+	err = m.UnsubscribeCallback(ctx, old.Topic, current.CallbackURL)
+	if err != nil {
+		m.logger().Error("error unsubscribing new callback", zap.String("topic", old.Topic), zap.Error(err))
+	}
+}
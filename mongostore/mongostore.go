@@ -0,0 +1,384 @@
+// Package mongostore implements twitchhook.SubscriptionManager on MongoDB,
+// one document per topic.
+//
+// Importing the package registers the mongodb and mongodb+srv storage DSN
+// schemes, taking a MongoDB connection string whose path names the database
+// and an optional collection parameter:
+//
+//	mongodb://host:port/<database>[?collection=<collection>]
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Defaults
+const (
+	DefaultDatabase   = "twitchhook"
+	DefaultCollection = "twitchhook_subscriptions"
+	DefaultTimeout    = 10 * time.Second
+	DefaultGrace      = time.Hour
+	DefaultPendingTTL = time.Hour
+)
+
+func init() {
+	twitchhook.RegisterManager("mongodb", Open)
+	twitchhook.RegisterManager("mongodb+srv", Open)
+}
+
+// Open opens a Manager from a MongoDB connection string, the Manager's Close
+// disconnects the client
+func Open(dsn string) (twitchhook.SubscriptionManager, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("mongostore: dsn must name a host")
+	}
+
+	// the driver rejects options it doesn't know
+	query := u.Query()
+	collection := query.Get("collection")
+	query.Del("collection")
+	u.RawQuery = query.Encode()
+
+	client, err := mongo.Connect(options.Client().ApplyURI(u.String()))
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		Client:      client,
+		Database:    strings.Trim(u.Path, "/"),
+		Collection:  collection,
+		closeClient: true,
+	}, nil
+}
+
+// Manager is a twitchhook.SubscriptionManager storing subscriptions in a
+// MongoDB collection. Secrets are stored as given, wrap the Manager in a
+// twitchhook.EncryptedManager to encrypt them.
+//
+// The Manager creates a unique index on topic, an index on the subscription
+// id and a TTL index on expire_at, which runs Grace past a confirmed
+// subscription's expiry or PendingTTL past an unconfirmed one's save, so
+// MongoDB removes subscriptions that are never renewed or confirmed.
+//
+// Renewals are scheduled by the process that saved the subscription, see
+// twitchhook.SubscriptionTimers.
+type Manager struct {
+	Client *mongo.Client
+
+	// Database defaults to DefaultDatabase
+	Database string
+
+	// Collection defaults to DefaultCollection
+	Collection string
+
+	// Timeout bounds each operation, defaults to DefaultTimeout
+	Timeout time.Duration
+
+	// Grace is how long a subscription is kept after its lease runs out,
+	// defaults to DefaultGrace
+	Grace time.Duration
+
+	// PendingTTL is how long an unconfirmed subscription is kept, defaults
+	// to DefaultPendingTTL
+	PendingTTL time.Duration
+
+	// Clock schedules renewals, defaults to twitchhook.SystemClock
+	Clock twitchhook.Clock
+
+	timers      twitchhook.SubscriptionTimers
+	once        sync.Once
+	closeClient bool
+
+	m       sync.Mutex
+	indexed bool
+}
+
+// document is the stored form of a subscription, Topic is the key it was
+// saved under, which differs from the subscription's topic behind a
+// twitchhook.NamespacedManager
+type document struct {
+	Topic        string    `bson:"topic"`
+	Subscription record    `bson:"subscription"`
+	ExpireAt     time.Time `bson:"expire_at"`
+}
+
+type record struct {
+	ID              string            `bson:"id"`
+	Topic           string            `bson:"topic"`
+	CallbackBaseURL string            `bson:"callback_base_url"`
+	CallbackURL     string            `bson:"callback_url"`
+	Lease           time.Duration     `bson:"lease"`
+	Secret          string            `bson:"secret,omitempty"`
+	SecretRef       string            `bson:"secret_ref,omitempty"`
+	Namespace       string            `bson:"namespace,omitempty"`
+	ExpiresAt       time.Time         `bson:"expires_at,omitempty"`
+	Metadata        map[string]string `bson:"metadata,omitempty"`
+
+	PreviousSecret          string    `bson:"previous_secret,omitempty"`
+	PreviousSecretRef       string    `bson:"previous_secret_ref,omitempty"`
+	PreviousSecretExpiresAt time.Time `bson:"previous_secret_expires_at,omitempty"`
+}
+
+func newRecord(r twitchhook.SubscriptionRecord) record {
+	return record{
+		ID:              string(r.ID),
+		Topic:           r.Topic,
+		CallbackBaseURL: r.CallbackBaseURL,
+		CallbackURL:     r.CallbackURL,
+		Lease:           r.Lease,
+		Secret:          r.Secret,
+		SecretRef:       r.SecretRef,
+		Namespace:       r.Namespace,
+		ExpiresAt:       r.ExpiresAt,
+		Metadata:        r.Metadata,
+
+		PreviousSecret:          r.PreviousSecret,
+		PreviousSecretRef:       r.PreviousSecretRef,
+		PreviousSecretExpiresAt: r.PreviousSecretExpiresAt,
+	}
+}
+
+func (r record) subscriptionRecord() twitchhook.SubscriptionRecord {
+	return twitchhook.SubscriptionRecord{
+		ID:              twitchhook.SubscriptionID(r.ID),
+		Topic:           r.Topic,
+		CallbackBaseURL: r.CallbackBaseURL,
+		CallbackURL:     r.CallbackURL,
+		Lease:           r.Lease,
+		Secret:          r.Secret,
+		SecretRef:       r.SecretRef,
+		Namespace:       r.Namespace,
+		ExpiresAt:       r.ExpiresAt,
+		Metadata:        r.Metadata,
+
+		PreviousSecret:          r.PreviousSecret,
+		PreviousSecretRef:       r.PreviousSecretRef,
+		PreviousSecretExpiresAt: r.PreviousSecretExpiresAt,
+	}
+}
+
+func (m *Manager) setup() {
+	if m.Database == "" {
+		m.Database = DefaultDatabase
+	}
+	if m.Collection == "" {
+		m.Collection = DefaultCollection
+	}
+	if m.Timeout == 0 {
+		m.Timeout = DefaultTimeout
+	}
+	if m.Grace == 0 {
+		m.Grace = DefaultGrace
+	}
+	if m.PendingTTL == 0 {
+		m.PendingTTL = DefaultPendingTTL
+	}
+	m.timers.Clock = m.Clock
+}
+
+func (m *Manager) collection() *mongo.Collection {
+	return m.Client.Database(m.Database).Collection(m.Collection)
+}
+
+// context returns the context for an operation, creating the collection's
+// indexes on first use. Failing to create them fails the operation and is
+// tried again by the next.
+func (m *Manager) context() (context.Context, context.CancelFunc, error) {
+	m.once.Do(m.setup)
+	ctx, cancel := context.WithTimeout(context.Background(), m.Timeout)
+	err := m.ensureIndexes(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return ctx, cancel, nil
+}
+
+func (m *Manager) ensureIndexes(ctx context.Context) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if m.indexed {
+		return nil
+	}
+	_, err := m.collection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "topic", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "subscription.id", Value: 1}}},
+		{Keys: bson.D{{Key: "expire_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+	m.indexed = true
+	return nil
+}
+
+// Close disconnects the Client if the Manager was opened from a DSN
+func (m *Manager) Close() error {
+	if !m.closeClient {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	return m.Client.Disconnect(ctx)
+}
+
+// expireAt returns when MongoDB should remove a subscription
+func (m *Manager) expireAt(r record) time.Time {
+	if r.ExpiresAt.IsZero() {
+		return m.clock().Now().Add(m.PendingTTL)
+	}
+	return r.ExpiresAt.Add(m.Grace)
+}
+
+// Get retrieves a subscription, returning twitchhook.ErrSubscriptionNotFound
+// if there isn't one for topic
+func (m *Manager) Get(topic string) (*twitchhook.Subscription, error) {
+	return m.findOne(bson.D{{Key: "topic", Value: topic}})
+}
+
+func (m *Manager) findOne(filter bson.D) (*twitchhook.Subscription, error) {
+	ctx, cancel, err := m.context()
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var doc document
+	err = m.collection().FindOne(ctx, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, twitchhook.ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.subscription(doc), nil
+}
+
+func (m *Manager) subscription(doc document) *twitchhook.Subscription {
+	record := doc.Subscription.subscriptionRecord()
+	return m.timers.Attach(doc.Topic, record.Subscription())
+}
+
+// Save stores a subscription, replacing any existing subscription to topic
+func (m *Manager) Save(topic string, sub *twitchhook.Subscription) error {
+	ctx, cancel, err := m.context()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	r := newRecord(sub.Record())
+	_, err = m.collection().ReplaceOne(ctx,
+		bson.D{{Key: "topic", Value: topic}},
+		document{Topic: topic, Subscription: r, ExpireAt: m.expireAt(r)},
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+	m.timers.Save(topic, sub)
+	return nil
+}
+
+// Delete removes a subscription
+func (m *Manager) Delete(topic string) error {
+	ctx, cancel, err := m.context()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	_, err = m.collection().DeleteOne(ctx, bson.D{{Key: "topic", Value: topic}})
+	if err != nil {
+		return err
+	}
+	m.timers.Delete(topic)
+	return nil
+}
+
+// SetSubscriptionLease sets a subscription's lease and expiry, pushing back
+// its TTL
+func (m *Manager) SetSubscriptionLease(topic string, lease time.Duration) (bool, error) {
+	ctx, cancel, err := m.context()
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+
+	expiresAt := m.clock().Now().Add(lease)
+	res, err := m.collection().UpdateOne(ctx,
+		bson.D{{Key: "topic", Value: topic}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "subscription.lease", Value: lease},
+			{Key: "subscription.expires_at", Value: expiresAt},
+			{Key: "expire_at", Value: expiresAt.Add(m.Grace)},
+		}}},
+	)
+	if err != nil || res.MatchedCount == 0 {
+		return false, err
+	}
+	m.timers.Extend(topic, lease)
+	return true, nil
+}
+
+func (m *Manager) clock() twitchhook.Clock {
+	if m.Clock == nil {
+		return twitchhook.SystemClock
+	}
+	return m.Clock
+}
+
+// GetByID implements twitchhook.SubscriptionIDIndex
+func (m *Manager) GetByID(id twitchhook.SubscriptionID) (*twitchhook.Subscription, error) {
+	return m.findOne(bson.D{{Key: "subscription.id", Value: string(id)}})
+}
+
+// List implements twitchhook.SubscriptionLister
+func (m *Manager) List() ([]*twitchhook.Subscription, error) {
+	ctx, cancel, err := m.context()
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	cur, err := m.collection().Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var docs []document
+	err = cur.All(ctx, &docs)
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]*twitchhook.Subscription, 0, len(docs))
+	for _, doc := range docs {
+		subs = append(subs, m.subscription(doc))
+	}
+	return subs, nil
+}
+
+// RenewalAt implements twitchhook.RenewalScheduler, only renewals scheduled
+// by this process are known
+func (m *Manager) RenewalAt(topic string) (time.Time, error) {
+	return m.timers.RenewalAt(topic), nil
+}
+
+// Ping implements twitchhook.Pinger
+func (m *Manager) Ping(ctx context.Context) error {
+	return m.Client.Ping(ctx, nil)
+}
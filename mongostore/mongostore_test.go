@@ -0,0 +1,441 @@
+package mongostore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+	"github.com/bsdlp/twitchhook/storetest"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// wire protocol opcodes
+const (
+	opReply = 1
+	opQuery = 2004
+	opMsg   = 2013
+)
+
+// fakeMongo is a standalone MongoDB speaking enough of the wire protocol to
+// serve the commands the Manager sends: equality filters, replacements and
+// $set updates on single documents.
+type fakeMongo struct {
+	lis net.Listener
+
+	m       sync.Mutex
+	docs    map[string][]bson.D
+	indexes map[string][]bson.Raw
+	conns   map[net.Conn]bool
+}
+
+// newFakeMongo serves a fakeMongo on localhost and returns a client
+// connected to it
+func newFakeMongo(t *testing.T) (*fakeMongo, *mongo.Client) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeMongo{
+		lis:     lis,
+		docs:    make(map[string][]bson.D),
+		indexes: make(map[string][]bson.Raw),
+		conns:   make(map[net.Conn]bool),
+	}
+	go f.serve()
+	t.Cleanup(f.close)
+
+	client, err := mongo.Connect(options.Client().ApplyURI("mongodb://" + lis.Addr().String() + "/?directConnection=true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return f, client
+}
+
+func (f *fakeMongo) serve() {
+	for {
+		conn, err := f.lis.Accept()
+		if err != nil {
+			return
+		}
+		f.m.Lock()
+		f.conns[conn] = true
+		f.m.Unlock()
+		go f.serveConn(conn)
+	}
+}
+
+func (f *fakeMongo) close() {
+	f.lis.Close()
+	f.m.Lock()
+	defer f.m.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func (f *fakeMongo) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var header [16]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		length := binary.LittleEndian.Uint32(header[0:])
+		requestID := binary.LittleEndian.Uint32(header[4:])
+		opCode := binary.LittleEndian.Uint32(header[12:])
+		body := make([]byte, length-16)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+
+		var reply []byte
+		switch opCode {
+		case opQuery:
+			reply = f.query(body)
+		case opMsg:
+			reply = f.msg(body)
+		default:
+			return
+		}
+		if _, err := conn.Write(message(requestID, opCode, reply)); err != nil {
+			return
+		}
+	}
+}
+
+// message frames a reply to requestID, OP_QUERY is answered with an OP_REPLY
+func message(requestID, opCode uint32, body []byte) []byte {
+	if opCode == opQuery {
+		opCode = opReply
+	}
+	b := make([]byte, 16, 16+len(body))
+	binary.LittleEndian.PutUint32(b[0:], uint32(16+len(body)))
+	binary.LittleEndian.PutUint32(b[8:], requestID)
+	binary.LittleEndian.PutUint32(b[12:], opCode)
+	return append(b, body...)
+}
+
+// query answers the legacy hello handshake sent as an OP_QUERY
+func (f *fakeMongo) query(body []byte) []byte {
+	// flags, then the collection name before the skip and return counts
+	name := body[4:]
+	name = name[strings.IndexByte(string(name), 0)+1+8:]
+	cmd := bson.Raw(name[:binary.LittleEndian.Uint32(name)])
+
+	reply := make([]byte, 20)
+	binary.LittleEndian.PutUint32(reply[16:], 1)
+	return append(reply, f.command(cmd)...)
+}
+
+// msg answers an OP_MSG, document sequences are added to the command as
+// arrays
+func (f *fakeMongo) msg(body []byte) []byte {
+	var cmd bson.D
+	sections := body[4:]
+	for len(sections) > 0 {
+		kind := sections[0]
+		sections = sections[1:]
+		size := binary.LittleEndian.Uint32(sections)
+		switch kind {
+		case 0:
+			if err := bson.Unmarshal(sections[:size], &cmd); err != nil {
+				return nil
+			}
+		case 1:
+			seq := sections[4:size]
+			end := strings.IndexByte(string(seq), 0)
+			identifier := string(seq[:end])
+			var docs bson.A
+			for seq = seq[end+1:]; len(seq) > 0; {
+				n := binary.LittleEndian.Uint32(seq)
+				docs = append(docs, bson.Raw(seq[:n]))
+				seq = seq[n:]
+			}
+			cmd = append(cmd, bson.E{Key: identifier, Value: docs})
+		}
+		sections = sections[size:]
+	}
+	raw, err := bson.Marshal(cmd)
+	if err != nil {
+		return nil
+	}
+	return append([]byte{0, 0, 0, 0, 0}, f.command(raw)...)
+}
+
+func (f *fakeMongo) command(cmd bson.Raw) []byte {
+	reply, err := f.run(cmd)
+	if err != nil {
+		reply = bson.D{{Key: "ok", Value: 0}, {Key: "errmsg", Value: err.Error()}, {Key: "code", Value: 59}}
+	} else {
+		reply = append(reply, bson.E{Key: "ok", Value: 1})
+	}
+	b, _ := bson.Marshal(reply)
+	return b
+}
+
+func (f *fakeMongo) run(cmd bson.Raw) (bson.D, error) {
+	elems, err := cmd.Elements()
+	if err != nil || len(elems) == 0 {
+		return nil, errors.New("empty command")
+	}
+	name := elems[0].Key()
+	coll, _ := elems[0].Value().StringValueOK()
+	db, _ := cmd.Lookup("$db").StringValueOK()
+	ns := db + "." + coll
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	switch strings.ToLower(name) {
+	case "hello", "ismaster":
+		return bson.D{
+			{Key: "helloOk", Value: true},
+			{Key: "isWritablePrimary", Value: true},
+			{Key: "ismaster", Value: true},
+			{Key: "maxBsonObjectSize", Value: 16 * 1024 * 1024},
+			{Key: "maxMessageSizeBytes", Value: 48000000},
+			{Key: "maxWriteBatchSize", Value: 100000},
+			{Key: "localTime", Value: time.Now()},
+			{Key: "logicalSessionTimeoutMinutes", Value: 30},
+			{Key: "connectionId", Value: 1},
+			{Key: "minWireVersion", Value: 0},
+			{Key: "maxWireVersion", Value: 21},
+		}, nil
+	case "ping", "endsessions":
+		return bson.D{}, nil
+	case "createindexes":
+		for _, index := range values(cmd.Lookup("indexes")) {
+			f.indexes[ns] = append(f.indexes[ns], index.Document())
+		}
+		return bson.D{{Key: "numIndexesAfter", Value: len(f.indexes[ns]) + 1}}, nil
+	case "find":
+		batch := bson.A{}
+		limit, _ := cmd.Lookup("limit").AsInt64OK()
+		filter, _ := cmd.Lookup("filter").DocumentOK()
+		for _, doc := range f.docs[ns] {
+			if matches(doc, filter) {
+				batch = append(batch, doc)
+			}
+			if limit > 0 && int64(len(batch)) == limit {
+				break
+			}
+		}
+		return bson.D{{Key: "cursor", Value: bson.D{
+			{Key: "firstBatch", Value: batch},
+			{Key: "id", Value: int64(0)},
+			{Key: "ns", Value: ns},
+		}}}, nil
+	case "update":
+		var n, modified int
+		var upserted bson.A
+		for i, update := range values(cmd.Lookup("updates")) {
+			u := update.Document()
+			matched, err := f.update(ns, u.Lookup("q").Document(), u.Lookup("u").Document())
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case matched:
+				n++
+				modified++
+			case u.Lookup("upsert").Type == bson.TypeBoolean && u.Lookup("upsert").Boolean():
+				doc, err := upsert(u.Lookup("u").Document())
+				if err != nil {
+					return nil, err
+				}
+				f.docs[ns] = append(f.docs[ns], doc)
+				n++
+				upserted = append(upserted, bson.D{{Key: "index", Value: i}, {Key: "_id", Value: doc[0].Value}})
+			}
+		}
+		reply := bson.D{{Key: "n", Value: n}, {Key: "nModified", Value: modified}}
+		if len(upserted) > 0 {
+			reply = append(reply, bson.E{Key: "upserted", Value: upserted})
+		}
+		return reply, nil
+	case "delete":
+		var n int
+		for _, del := range values(cmd.Lookup("deletes")) {
+			q := del.Document().Lookup("q").Document()
+			for i, doc := range f.docs[ns] {
+				if matches(doc, q) {
+					f.docs[ns] = append(f.docs[ns][:i], f.docs[ns][i+1:]...)
+					n++
+					break
+				}
+			}
+		}
+		return bson.D{{Key: "n", Value: n}}, nil
+	}
+	return nil, errors.New("no such command: " + name)
+}
+
+// values returns the elements of an array, or the documents of a sequence
+func values(v bson.RawValue) []bson.RawValue {
+	if v.Type != bson.TypeArray {
+		return nil
+	}
+	vs, _ := v.Array().Values()
+	return vs
+}
+
+func matches(doc bson.D, filter bson.Raw) bool {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return false
+	}
+	elems, _ := filter.Elements()
+	for _, elem := range elems {
+		v, err := bson.Raw(raw).LookupErr(strings.Split(elem.Key(), ".")...)
+		if err != nil || !v.Equal(elem.Value()) {
+			return false
+		}
+	}
+	return true
+}
+
+// update applies u to the first document matching q, a replacement or a
+// $set of dotted paths
+func (f *fakeMongo) update(ns string, q, u bson.Raw) (bool, error) {
+	for i, doc := range f.docs[ns] {
+		if !matches(doc, q) {
+			continue
+		}
+		set, err := u.LookupErr("$set")
+		if err != nil {
+			replacement, err := upsert(u)
+			if err != nil {
+				return false, err
+			}
+			replacement[0] = doc[0]
+			f.docs[ns][i] = replacement
+			return true, nil
+		}
+		elems, _ := set.Document().Elements()
+		for _, elem := range elems {
+			var value any
+			if err := elem.Value().Unmarshal(&value); err != nil {
+				return false, err
+			}
+			doc = setPath(doc, strings.Split(elem.Key(), "."), value)
+		}
+		f.docs[ns][i] = doc
+		return true, nil
+	}
+	return false, nil
+}
+
+// upsert returns a replacement document as inserted, with an _id first
+func upsert(u bson.Raw) (bson.D, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(u, &doc); err != nil {
+		return nil, err
+	}
+	return append(bson.D{{Key: "_id", Value: bson.NewObjectID()}}, doc...), nil
+}
+
+func setPath(doc bson.D, path []string, value any) bson.D {
+	for i, elem := range doc {
+		if elem.Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			doc[i].Value = value
+		} else {
+			nested, _ := elem.Value.(bson.D)
+			doc[i].Value = setPath(nested, path[1:], value)
+		}
+		return doc
+	}
+	if len(path) == 1 {
+		return append(doc, bson.E{Key: path[0], Value: value})
+	}
+	return append(doc, bson.E{Key: path[0], Value: setPath(nil, path[1:], value)})
+}
+
+// document returns the stored document saved under topic
+func (f *fakeMongo) document(t *testing.T, m *Manager, topic string) document {
+	t.Helper()
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	for _, doc := range f.docs[m.Database+"."+m.Collection] {
+		raw, _ := bson.Marshal(doc)
+		if bson.Raw(raw).Lookup("topic").StringValue() != topic {
+			continue
+		}
+		var d document
+		if err := bson.Unmarshal(raw, &d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	t.Fatalf("no document for %s", topic)
+	return document{}
+}
+
+func TestManager(t *testing.T) {
+	storetest.TestSubscriptionManager(t, func(t *testing.T, clock twitchhook.Clock) twitchhook.SubscriptionManager {
+		_, client := newFakeMongo(t)
+		return &Manager{Client: client, Clock: clock}
+	})
+}
+
+func TestManagerExpireAt(t *testing.T) {
+	f, client := newFakeMongo(t)
+	clock := clocktest.NewClock(storetest.Epoch)
+	m := &Manager{Client: client, Clock: clock, Grace: 30 * time.Minute, PendingTTL: 20 * time.Minute}
+
+	const topic = "https://api.twitch.tv/helix/streams?user_id=1"
+	sub := storetest.Subscription(topic, "v1.a")
+	sub.ExpiresAt = time.Time{}
+	if err := m.Save(topic, sub); err != nil {
+		t.Fatal(err)
+	}
+
+	ns := m.Database + "." + m.Collection
+	f.m.Lock()
+	var ttl bool
+	for _, index := range f.indexes[ns] {
+		if index.Lookup("key", "expire_at").Type != 0 {
+			seconds, _ := index.Lookup("expireAfterSeconds").AsInt64OK()
+			ttl = seconds == 0
+		}
+	}
+	f.m.Unlock()
+	if !ttl {
+		t.Fatal("no TTL index on expire_at")
+	}
+
+	if at := f.document(t, m, topic).ExpireAt; !at.Equal(storetest.Epoch.Add(20 * time.Minute)) {
+		t.Fatalf("pending subscription expire_at = %s, want PendingTTL from now", at)
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := m.SetSubscriptionLease(topic, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if at := f.document(t, m, topic).ExpireAt; !at.Equal(clock.Now().Add(90 * time.Minute)) {
+		t.Fatalf("confirmed subscription expire_at = %s, want its lease and Grace from now", at)
+	}
+
+	sub.ExpiresAt = clock.Now().Add(2 * time.Hour)
+	if err := m.Save(topic, sub); err != nil {
+		t.Fatal(err)
+	}
+	if at := f.document(t, m, topic).ExpireAt; !at.Equal(sub.ExpiresAt.Add(30 * time.Minute)) {
+		t.Fatalf("saved subscription expire_at = %s, want Grace past its expiry", at)
+	}
+}
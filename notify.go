@@ -0,0 +1,234 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Notification is a hub notification
+type Notification struct {
+	Topic          string
+	SubscriptionID SubscriptionID
+	ID             string
+	Timestamp      time.Time
+	ReceivedAt     time.Time
+	RemoteAddr     string
+	Header         http.Header
+	Body           []byte
+
+	// Retry is the hub's retry count for the delivery, 0 for the first
+	// attempt
+	Retry int
+
+	// HubURL and SelfURL are the rel="hub" and rel="self" targets of the
+	// Link header, SelfURL is the topic the hub sent the notification for
+	HubURL  string
+	SelfURL string
+
+	// SignatureAlgorithm is the hash algorithm of the signature header
+	SignatureAlgorithm string
+
+	// Subscription is set once the notification's signature has been
+	// verified against it
+	Subscription *Subscription
+
+	// Event is the decoded body, it's set by middleware such as the helix
+	// enricher
+	Event interface{}
+
+	// Replay is set when the notification is redelivered from an archive
+	// rather than received from the hub
+	Replay bool
+
+	// RequestID is the id of the callback request that delivered the
+	// notification, sinks pass it on in HeaderRequestID
+	RequestID string
+}
+
+// Redelivered reports whether the hub is retrying the notification's
+// delivery, usually because an earlier attempt wasn't answered in time
+func (n *Notification) Redelivered() bool {
+	return n.Retry > 0
+}
+
+// ReadHeader sets n's Header and the fields parsed from it: ID, Timestamp,
+// Retry, HubURL and SelfURL. Fields of headers h doesn't carry are zeroed.
+func (n *Notification) ReadHeader(h http.Header) {
+	n.Header = h
+	n.ID = notificationID(h)
+	n.Timestamp, _ = notificationTimestamp(h)
+	n.Retry = notificationRetry(h)
+	n.HubURL = linkTarget(h, "hub")
+	n.SelfURL = linkTarget(h, "self")
+}
+
+// NotificationHandler handles notifications
+type NotificationHandler interface {
+	HandleNotification(ctx context.Context, n *Notification) error
+}
+
+// NotificationHandlerFunc adapts a function to a NotificationHandler
+type NotificationHandlerFunc func(ctx context.Context, n *Notification) error
+
+// HandleNotification calls f
+func (f NotificationHandlerFunc) HandleNotification(ctx context.Context, n *Notification) error {
+	return f(ctx, n)
+}
+
+// Middleware wraps a NotificationHandler. Middleware set on the handler runs
+// before the notification is verified, so it sees every notification posted
+// to the callback and n.Subscription is only set once next has verified it.
+type Middleware func(next NotificationHandler) NotificationHandler
+
+// Chain applies middleware to h, the first middleware is outermost
+func Chain(h NotificationHandler, middleware ...Middleware) NotificationHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Dispatch hands a notification passing its topic's filters to the handler
+// registered for its topic or the NotificationHandler. The handler's context
+// carries n's RequestID.
+func (m *TwitchWebhookHandler) Dispatch(ctx context.Context, n *Notification) error {
+	if n.RequestID != "" && RequestIDFromContext(ctx) == "" {
+		ctx = ContextWithRequestID(ctx, n.RequestID)
+	}
+	h := m.notificationHandlerFor(n.Topic)
+	if h == nil || m.filtered(ctx, n) {
+		return nil
+	}
+	return m.protect("notification handler", func() error {
+		return h.HandleNotification(ctx, n)
+	})
+}
+
+func (m *TwitchWebhookHandler) notificationHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if m.SourceAllowlist != nil && !m.SourceAllowlist.Allow(r.RemoteAddr, r.Header) {
+		annotateAudit(r.Context(), func(e *AuditEvent) {
+			e.Kind = AuditNotification
+			e.Error = ErrSourceNotAllowed.Error()
+		})
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	logger := m.requestLogger(r.Context())
+	n, err := m.newNotification(r)
+	if err == ErrNotificationTooLarge {
+		http.Error(w, "notification too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		logger.Info("error reading notification", zap.Error(err))
+		annotateAudit(r.Context(), func(e *AuditEvent) {
+			e.Kind = AuditNotification
+			e.Error = err.Error()
+		})
+		http.Error(w, "invalid notification", http.StatusBadRequest)
+		return
+	}
+
+	annotateAudit(r.Context(), func(e *AuditEvent) {
+		e.Kind = AuditNotification
+		e.Topic = n.Topic
+		e.NotificationID = n.ID
+		e.Retry = n.Retry
+	})
+	defer func() {
+		annotateAudit(r.Context(), func(e *AuditEvent) {
+			switch err.(type) {
+			case *UnsupportedAlgorithmError:
+				e.Kind = AuditSignatureFailure
+			}
+			switch err {
+			case ErrInvalidSignature:
+				e.Kind = AuditSignatureFailure
+			case ErrDuplicateNotification:
+				e.Outcome = AuditDuplicate
+			}
+			if err != nil {
+				e.Error = err.Error()
+			}
+		})
+	}()
+
+	// the middleware wraps verification and dispatch, errors returned once
+	// the notification has been dispatched are the handler's and are logged
+	// rather than rejecting the delivery, unless they're a
+	// NotificationResponse. Notifications redelivery was asked for are
+	// dropped from the dedup store.
+	var dispatched bool
+	h := Chain(NotificationHandlerFunc(func(ctx context.Context, n *Notification) error {
+		err := m.verifyNotification(ctx, n)
+		if err != nil {
+			return err
+		}
+		dispatched = true
+		return m.dispatchWithin(ctx, n)
+	}), m.Middleware...)
+
+	err = m.protect("middleware", func() error {
+		return h.HandleNotification(r.Context(), n)
+	})
+	if dispatched {
+		var resp *NotificationResponse
+		if errors.As(err, &resp) {
+			if resp.Err != nil {
+				logger.Error("error handling notification", zap.String("topic", n.Topic), zap.Int("status", resp.StatusCode), zap.Error(resp.Err))
+				m.hookError("notification handler", resp.Err)
+			}
+			if resp.StatusCode >= 500 && n.Retry >= m.deadLetterRetries() && m.deadLetter(r.Context(), n, resp) {
+				// stored, the hub can stop retrying
+				return
+			}
+			if resp.StatusCode >= 500 {
+				m.forgetNotification(r.Header)
+			} else if resp.StatusCode >= 300 {
+				// rejected, Acks aren't failures
+				m.deadLetter(r.Context(), n, resp)
+			}
+			resp.write(w)
+			return
+		}
+		if err != nil {
+			logger.Error("error handling notification", zap.String("topic", n.Topic), zap.Error(err))
+			m.hookError("notification handler", err)
+			m.deadLetter(r.Context(), n, err)
+		}
+		return
+	}
+
+	switch err.(type) {
+	case *PanicError:
+		http.Error(w, "error handling notification", http.StatusInternalServerError)
+		return
+	case *UnsupportedAlgorithmError:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch err {
+	case nil:
+	case ErrDuplicateNotification:
+		// already delivered, acknowledge so the hub stops retrying
+	case ErrInvalidSignature:
+		http.Error(w, "invalid signature", http.StatusForbidden)
+	case ErrStaleNotification:
+		http.Error(w, "stale notification", http.StatusForbidden)
+	case ErrSourceNotAllowed:
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case ErrSubscriptionNotFound:
+		http.Error(w, "subscription not found", http.StatusNotFound)
+	default:
+		logger.Info("error verifying notification", zap.String("topic", n.Topic), zap.Error(err))
+		http.Error(w, "error verifying notification", http.StatusBadRequest)
+	}
+}
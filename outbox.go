@@ -0,0 +1,290 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// OutboxEntry is a subscription that was saved before it was sent to the hub.
+// Secret and PreviousSecret are written to the Outbox sealed by the Manager
+// when it's a SecretSealer and left out otherwise, RecoverOutbox generates a
+// new secret for entries without one.
+type OutboxEntry struct {
+	// Renewal is the request the subscription is renewed with
+	Renewal SubscriptionRequest `json:"renewal"`
+
+	ID              SubscriptionID    `json:"id"`
+	Topic           string            `json:"topic"`
+	CallbackBaseURL string            `json:"callback_base_url"`
+	CallbackURL     string            `json:"callback_url"`
+	Lease           time.Duration     `json:"lease"`
+	Secret          string            `json:"secret,omitempty"`
+	SecretRef       string            `json:"secret_ref,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+
+	// PreviousSecret, PreviousSecretRef and PreviousSecretExpiresAt are the
+	// secret a renewal rotated out, see Subscription
+	PreviousSecret          string    `json:"previous_secret,omitempty"`
+	PreviousSecretRef       string    `json:"previous_secret_ref,omitempty"`
+	PreviousSecretExpiresAt time.Time `json:"previous_secret_expires_at,omitzero"`
+}
+
+// Outbox holds subscriptions between saving them and the hub accepting
+// them, so subscriptions interrupted by a crash can be completed or rolled
+// back by RecoverOutbox. Entries are keyed by topic.
+type Outbox interface {
+	Put(entry OutboxEntry) error
+	Remove(topic string) error
+	List() ([]OutboxEntry, error)
+}
+
+// InMemoryOutbox is an Outbox that doesn't survive restarts
+type InMemoryOutbox struct {
+	m       sync.Mutex
+	entries map[string]OutboxEntry
+}
+
+// Put adds or replaces the entry for its topic
+func (o *InMemoryOutbox) Put(entry OutboxEntry) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.entries == nil {
+		o.entries = make(map[string]OutboxEntry)
+	}
+	o.entries[entry.Topic] = entry
+	return nil
+}
+
+// Remove removes the entry for topic
+func (o *InMemoryOutbox) Remove(topic string) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	delete(o.entries, topic)
+	return nil
+}
+
+// List returns every entry, oldest first
+func (o *InMemoryOutbox) List() ([]OutboxEntry, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return sortedOutboxEntries(o.entries), nil
+}
+
+// FileOutbox is an Outbox stored as a json file, writes replace the file
+// atomically
+type FileOutbox struct {
+	Path string
+
+	f jsonFile[OutboxEntry]
+}
+
+// Put adds or replaces the entry for its topic
+func (o *FileOutbox) Put(entry OutboxEntry) error {
+	return o.f.put(o.Path, entry.Topic, entry)
+}
+
+// Remove removes the entry for topic
+func (o *FileOutbox) Remove(topic string) error {
+	return o.f.remove(o.Path, topic)
+}
+
+// List returns every entry, oldest first
+func (o *FileOutbox) List() ([]OutboxEntry, error) {
+	entries, err := o.f.list(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	return sortedOutboxEntries(entries), nil
+}
+
+func sortedOutboxEntries(entries map[string]OutboxEntry) []OutboxEntry {
+	list := make([]OutboxEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// newSubscription builds the subscription for an entry
+func (m *TwitchWebhookHandler) newSubscription(entry OutboxEntry, denialCallback func(reason string)) *Subscription {
+	renewal := entry.Renewal
+	return &Subscription{
+		ID:              entry.ID,
+		Topic:           entry.Topic,
+		CallbackBaseURL: entry.CallbackBaseURL,
+		CallbackURL:     entry.CallbackURL,
+		Lease:           entry.Lease,
+		Secret:          entry.Secret,
+		SecretRef:       entry.SecretRef,
+		Metadata:        entry.Metadata,
+		DenialCallback:  denialCallback,
+		Renew: func() {
+			m.coordinatedRenew(renewal, denialCallback)
+		},
+
+		PreviousSecret:          entry.PreviousSecret,
+		PreviousSecretRef:       entry.PreviousSecretRef,
+		PreviousSecretExpiresAt: entry.PreviousSecretExpiresAt,
+	}
+}
+
+func (m *TwitchWebhookHandler) removeOutboxEntry(topic string) {
+	if m.Outbox == nil {
+		return
+	}
+	err := m.Outbox.Remove(topic)
+	if err != nil {
+		m.logger().Error("error removing outbox entry", zap.String("topic", topic), zap.Error(err))
+	}
+}
+
+// RecoverOutbox completes or rolls back subscriptions interrupted between
+// being saved to the Outbox and the Manager, call it on startup before
+// subscribing. Entries the Manager already holds are dropped, the rest are
+// sent to the hub again and saved, or unsubscribed and dropped if the hub
+// rejects them. Entries written without their secret are sent with a new
+// one. Rolled back entries release their Quota slot. Entries that fail with
+// other errors are kept for the next run and their errors returned.
+func (m *TwitchWebhookHandler) RecoverOutbox(ctx context.Context) error {
+	if m.Outbox == nil {
+		return nil
+	}
+	m.once.Do(m.setup)
+
+	entries, err := m.Outbox.List()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		err = m.openOutboxEntry(ctx, &entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Topic, err))
+			continue
+		}
+		existing, err := m.getSubscription(entry.Topic)
+		if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+			errs = append(errs, err)
+			continue
+		}
+		// renewals keep the callback url, they're done once the secret is
+		// saved
+		current := existing != nil && existing.CallbackURL == entry.CallbackURL
+		known := entry.Secret != "" || entry.SecretRef != ""
+		if current && known && existing.Secret == entry.Secret && existing.SecretRef == entry.SecretRef {
+			m.removeOutboxEntry(entry.Topic)
+			continue
+		}
+		if !known {
+			err = m.regenerateOutboxSecret(ctx, &entry, existing, current)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", entry.Topic, err))
+				continue
+			}
+		}
+
+		subscription := m.newSubscription(entry, nil)
+		err = m.completeOutboxEntry(ctx, subscription)
+		var hErr *HubError
+		switch {
+		case err == nil:
+			m.logger().Info("completed interrupted subscription", zap.String("topic", entry.Topic))
+		case errors.As(err, &hErr):
+			m.logger().Info("rolling back interrupted subscription", zap.String("topic", entry.Topic), zap.Error(err))
+			if current {
+				// the renewed subscription keeps its old secret
+				break
+			}
+			unsubErr := m.UnsubscribeCallback(ctx, entry.Topic, entry.CallbackURL)
+			if unsubErr != nil {
+				m.logger().Info("error unsubscribing interrupted subscription", zap.String("topic", entry.Topic), zap.Error(unsubErr))
+			}
+			// the slot the interrupted subscription took, whether or not
+			// the hub acknowledged the unsubscribe
+			m.releaseQuota(entry.Topic, entry.CallbackURL)
+		default:
+			errs = append(errs, err)
+			continue
+		}
+		m.removeOutboxEntry(entry.Topic)
+	}
+	return errors.Join(errs...)
+}
+
+// sealOutboxEntry returns entry as it's written to the Outbox, with its
+// secrets sealed by the Manager or left out
+func (m *TwitchWebhookHandler) sealOutboxEntry(ctx context.Context, entry OutboxEntry) (OutboxEntry, error) {
+	sealer, ok := m.Manager.(SecretSealer)
+	if !ok {
+		entry.Secret, entry.PreviousSecret = "", ""
+		return entry, nil
+	}
+	var err error
+	entry.Secret, err = sealer.SealSecret(ctx, entry.Secret)
+	if err != nil {
+		return entry, err
+	}
+	entry.PreviousSecret, err = sealer.SealSecret(ctx, entry.PreviousSecret)
+	return entry, err
+}
+
+// openOutboxEntry opens the secrets sealOutboxEntry sealed
+func (m *TwitchWebhookHandler) openOutboxEntry(ctx context.Context, entry *OutboxEntry) error {
+	sealer, ok := m.Manager.(SecretSealer)
+	if !ok {
+		if encryptedSecret(entry.Secret) || encryptedSecret(entry.PreviousSecret) {
+			return errors.New("outbox entry secret is encrypted but the Manager isn't a SecretSealer")
+		}
+		return nil
+	}
+	var err error
+	entry.Secret, err = sealer.OpenSecret(ctx, entry.Secret)
+	if err != nil {
+		return err
+	}
+	entry.PreviousSecret, err = sealer.OpenSecret(ctx, entry.PreviousSecret)
+	return err
+}
+
+// regenerateOutboxSecret gives an entry written without its secret a new
+// one, the hub replaces the secret of the callback when it's sent again. A
+// renewal's current secret verifies for the overlap as the hub may still
+// sign with it.
+func (m *TwitchWebhookHandler) regenerateOutboxSecret(ctx context.Context, entry *OutboxEntry, existing *Subscription, current bool) error {
+	_, stored, ref, err := m.newSecret(ctx, entry.Topic)
+	if err != nil {
+		return err
+	}
+	entry.Secret, entry.SecretRef = stored, ref
+	if !current || entry.PreviousSecret != "" || entry.PreviousSecretRef != "" {
+		return nil
+	}
+	if overlap := m.secretOverlap(); overlap > 0 {
+		entry.PreviousSecret, entry.PreviousSecretRef = existing.Secret, existing.SecretRef
+		entry.PreviousSecretExpiresAt = clockOrDefault(m.Clock).Now().Add(overlap)
+	}
+	return nil
+}
+
+func (m *TwitchWebhookHandler) completeOutboxEntry(ctx context.Context, subscription *Subscription) error {
+	secret, err := m.subscriptionSecret(ctx, subscription)
+	if err != nil {
+		return err
+	}
+	err = m.postSubscription(ctx, subscription, secret)
+	if err != nil {
+		return err
+	}
+	return m.Manager.Save(subscription.Topic, subscription)
+}
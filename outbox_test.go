@@ -0,0 +1,102 @@
+package twitchhook_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// interruptedSubscribe subscribes to testTopic with the hub unreachable,
+// leaving the subscription in the handler's FileOutbox, and returns the
+// secret it was sent with and the outbox file's contents
+func interruptedSubscribe(t *testing.T, h *twitchhook.TwitchWebhookHandler, hub *fakeHub) (string, string) {
+	t.Helper()
+	h.Outbox = &twitchhook.FileOutbox{Path: filepath.Join(t.TempDir(), "outbox.json")}
+	hub.err = errors.New("connection refused")
+	if err := h.Subscribe(twitchhook.SubscriptionRequest{Topic: testTopic}, nil); err == nil {
+		t.Fatal("Subscribe succeeded with the hub unreachable")
+	}
+	hub.err = nil
+
+	secret := hub.forms[0].Get("hub.secret")
+	bs, err := os.ReadFile(h.Outbox.(*twitchhook.FileOutbox).Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), secret) {
+		t.Fatal("outbox holds the plaintext secret")
+	}
+	return secret, string(bs)
+}
+
+func TestRecoverOutboxRegeneratesSecret(t *testing.T) {
+	h, hub, _ := newHubHandler(t)
+	secret, _ := interruptedSubscribe(t, h, hub)
+
+	if err := h.RecoverOutbox(context.Background()); err != nil {
+		t.Fatalf("RecoverOutbox: %v", err)
+	}
+	sub, err := h.Manager.Get(testTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resent := hub.forms[1]
+	if resent.Get("hub.callback") != sub.CallbackURL || resent.Get("hub.secret") != sub.Secret {
+		t.Fatalf("recovered subscription doesn't match the hub request %v", resent)
+	}
+	if sub.Secret == "" || sub.Secret == secret {
+		t.Fatal("recovered subscription without a new secret")
+	}
+	if entries, _ := h.Outbox.List(); len(entries) != 0 {
+		t.Fatalf("%d outbox entries left after recovery", len(entries))
+	}
+}
+
+func TestRecoverOutboxOpensSealedSecret(t *testing.T) {
+	h, hub, _ := newHubHandler(t)
+	h.Manager = &twitchhook.EncryptedManager{
+		Manager: h.Manager,
+		Key:     []byte("0123456789abcdef0123456789abcdef"),
+	}
+	secret, stored := interruptedSubscribe(t, h, hub)
+	if !strings.Contains(stored, "enc:v1:") {
+		t.Fatalf("outbox secret isn't sealed by the Manager: %s", stored)
+	}
+
+	if err := h.RecoverOutbox(context.Background()); err != nil {
+		t.Fatalf("RecoverOutbox: %v", err)
+	}
+	if got := hub.forms[1].Get("hub.secret"); got != secret {
+		t.Fatal("recovery didn't resend the sealed secret")
+	}
+	sub, err := h.Manager.Get(testTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Secret != secret {
+		t.Fatal("recovered subscription has a different secret")
+	}
+}
+
+func TestRecoverOutboxRejectsSealedSecretWithoutSealer(t *testing.T) {
+	h, hub, _ := newHubHandler(t)
+	plain := h.Manager
+	h.Manager = &twitchhook.EncryptedManager{
+		Manager: plain,
+		Key:     []byte("0123456789abcdef0123456789abcdef"),
+	}
+	interruptedSubscribe(t, h, hub)
+
+	h.Manager = plain
+	if err := h.RecoverOutbox(context.Background()); err == nil {
+		t.Fatal("RecoverOutbox used a sealed secret it can't open")
+	}
+	if len(hub.forms) != 1 {
+		t.Fatal("RecoverOutbox sent an entry it can't open")
+	}
+}
@@ -0,0 +1,159 @@
+package twitchhook
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Page is one page of a cursor paginated twitch response
+type Page[T any] struct {
+	Data []T
+
+	// Cursor requests the next page, it's empty on the last page
+	Cursor string
+}
+
+// DecodePage decodes a paginated response body. The cursor is taken from
+// the body's pagination object, or from the after parameter of the rel="next"
+// Link header when the body doesn't have one.
+func DecodePage[T any](header http.Header, body []byte) (Page[T], error) {
+	var resp struct {
+		Data       []T `json:"data"`
+		Pagination struct {
+			Cursor string `json:"cursor"`
+		} `json:"pagination"`
+	}
+	err := json.Unmarshal(body, &resp)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	page := Page[T]{Data: resp.Data, Cursor: resp.Pagination.Cursor}
+	if page.Cursor == "" {
+		if next, err := url.Parse(NextLink(header)); err == nil {
+			page.Cursor = next.Query().Get("after")
+		}
+	}
+	return page, nil
+}
+
+// NextLink returns the target of the first rel="next" link in the Link
+// headers, or "" when there isn't one. Links may be spread across repeated
+// headers and repeated themselves.
+func NextLink(header http.Header) string {
+	return linkTarget(header, "next")
+}
+
+// linkTarget returns the target of the first link of relation rel in the
+// Link headers. It's called for every notification, so it only slices the
+// headers.
+func linkTarget(header http.Header, relation string) string {
+	for _, links := range header.Values("Link") {
+		for link := range splitLinks(links) {
+			target, params, ok := strings.Cut(link, ";")
+			target = strings.TrimSpace(target)
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for param := range strings.SplitSeq(params, ";") {
+				key, value, _ := strings.Cut(param, "=")
+				if !strings.EqualFold(strings.TrimSpace(key), "rel") {
+					continue
+				}
+				for rel := range strings.FieldsSeq(strings.Trim(strings.TrimSpace(value), `"`)) {
+					if strings.EqualFold(rel, relation) {
+						return target[1 : len(target)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// splitLinks splits a Link header on the commas between links, leaving
+// commas inside targets and quoted params alone
+func splitLinks(header string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		start, inTarget, inQuote := 0, false, false
+		for i, c := range header {
+			switch {
+			case inQuote:
+				inQuote = c != '"'
+			case c == '"':
+				inQuote = true
+			case c == '<':
+				inTarget = true
+			case c == '>':
+				inTarget = false
+			case c == ',' && !inTarget:
+				if !yield(header[start:i]) {
+					return
+				}
+				start = i + 1
+			}
+		}
+		yield(header[start:])
+	}
+}
+
+// Paginator iterates the pages of a cursor paginated request:
+//
+//	for p.More() {
+//		data, err := p.Next(ctx)
+//		...
+//	}
+//
+// Iteration stops at an empty cursor, an empty page or a cursor that was
+// already requested.
+type Paginator[T any] struct {
+	// Fetch requests the page after cursor, which is empty for the first
+	// page
+	Fetch func(ctx context.Context, cursor string) (Page[T], error)
+
+	cursor string
+	seen   map[string]bool
+	done   bool
+}
+
+// More reports whether there are pages left
+func (p *Paginator[T]) More() bool {
+	return !p.done
+}
+
+// Next returns the next page's data. After an error Next may be called again
+// to retry the same page.
+func (p *Paginator[T]) Next(ctx context.Context) ([]T, error) {
+	if p.done {
+		return nil, nil
+	}
+	page, err := p.Fetch(ctx, p.cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.seen == nil {
+		p.seen = make(map[string]bool)
+	}
+	p.seen[p.cursor] = true
+	p.done = page.Cursor == "" || len(page.Data) == 0 || p.seen[page.Cursor]
+	p.cursor = page.Cursor
+	return page.Data, nil
+}
+
+// All returns the data of every remaining page
+func (p *Paginator[T]) All(ctx context.Context) ([]T, error) {
+	var all []T
+	for p.More() {
+		data, err := p.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, data...)
+	}
+	return all, nil
+}
@@ -0,0 +1,40 @@
+package twitchhook
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+)
+
+// PanicError is returned in place of a panic recovered from a user callback
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// protect runs f, recovering and reporting a panic as a *PanicError
+func (m *TwitchWebhookHandler) protect(name string, f func() error) (err error) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+
+		stack := debug.Stack()
+		m.logger().Error("recovered panic in callback",
+			zap.String("callback", name),
+			zap.Any("panic", v),
+			zap.ByteString("stack", stack),
+		)
+		if m.OnPanic != nil {
+			m.OnPanic(v, stack)
+		}
+		err = &PanicError{Value: v, Stack: stack}
+	}()
+	return f()
+}
@@ -0,0 +1,121 @@
+package twitchhook
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultPartitionReplicas is the number of ring points per instance when
+// Partitioner.Replicas isn't set
+const DefaultPartitionReplicas = 128
+
+// Instance is a receiver in a partitioned fleet
+type Instance struct {
+	ID string
+
+	// CallbackBaseURL routes callbacks to the instance
+	CallbackBaseURL string
+}
+
+// Partitioner assigns topics to instances by consistent hashing so that each
+// topic's callbacks route to a single instance and only a small share of
+// topics move when instances come and go
+type Partitioner struct {
+	// Local is the ID of this instance
+	Local string
+
+	// Replicas is the number of ring points per instance, defaults to
+	// DefaultPartitionReplicas. Every instance must use the same value.
+	Replicas int
+
+	m      sync.RWMutex
+	points []uint64
+	owners map[uint64]Instance
+}
+
+// SetInstances replaces the instances topics are assigned to
+func (p *Partitioner) SetInstances(instances []Instance) {
+	replicas := p.Replicas
+	if replicas <= 0 {
+		replicas = DefaultPartitionReplicas
+	}
+
+	points := make([]uint64, 0, len(instances)*replicas)
+	owners := make(map[uint64]Instance, len(instances)*replicas)
+	for _, instance := range instances {
+		for i := 0; i < replicas; i++ {
+			point := hashKey(instance.ID + "#" + strconv.Itoa(i))
+			if _, taken := owners[point]; taken {
+				continue
+			}
+			owners[point] = instance
+			points = append(points, point)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.points = points
+	p.owners = owners
+}
+
+// Owner returns the instance owning topic, false if there are no instances
+func (p *Partitioner) Owner(topic string) (Instance, bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	if len(p.points) == 0 {
+		return Instance{}, false
+	}
+
+	h := hashKey(topic)
+	i := sort.Search(len(p.points), func(i int) bool { return p.points[i] >= h })
+	if i == len(p.points) {
+		i = 0
+	}
+	return p.owners[p.points[i]], true
+}
+
+// Owns reports whether this instance owns topic
+func (p *Partitioner) Owns(topic string) bool {
+	owner, ok := p.Owner(topic)
+	return ok && owner.ID == p.Local
+}
+
+// Watch refreshes the instances from discover every interval until ctx is
+// done, keeping the previous instances when discovery fails
+func (p *Partitioner) Watch(ctx context.Context, interval time.Duration, discover func(ctx context.Context) ([]Instance, error), logger *zap.Logger) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		instances, err := discover(ctx)
+		if err != nil {
+			logger.Error("error discovering instances", zap.Error(err))
+		} else {
+			p.SetInstances(instances)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
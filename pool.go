@@ -0,0 +1,154 @@
+package twitchhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"hash"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer keeps buffers grown by unusually large bodies out of the
+// pool
+const maxPooledBuffer = 1 << 20
+
+var bodyBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// readBody reads r, whose length is size or -1 when it isn't known, and
+// fails with ErrNotificationTooLarge past limit when it's set. Bodies of a
+// known length are read straight into buf when they fit or a buffer of that
+// size, others into a pooled buffer that absorbs the growth and is copied
+// once.
+func readBody(r io.Reader, buf []byte, size, limit int64) ([]byte, error) {
+	if limit > 0 && size > limit {
+		return nil, ErrNotificationTooLarge
+	}
+
+	var prefix []byte
+	if size >= 0 && size <= maxPooledBuffer {
+		// the extra byte finds bodies longer than declared
+		var bs []byte
+		if size < int64(len(buf)) {
+			bs = buf[:size+1]
+		} else {
+			bs = make([]byte, size+1)
+		}
+		n, err := io.ReadFull(r, bs)
+		if int64(n) == size && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return bs[:n:n], nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		prefix = bs
+	}
+
+	pooled := bodyBuffers.Get().(*bytes.Buffer)
+	pooled.Reset()
+	defer func() {
+		if pooled.Cap() <= maxPooledBuffer {
+			bodyBuffers.Put(pooled)
+		}
+	}()
+
+	pooled.Write(prefix)
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1-int64(len(prefix)))
+	}
+	_, err := pooled.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(pooled.Len()) > limit {
+		return nil, ErrNotificationTooLarge
+	}
+	return bytes.Clone(pooled.Bytes()), nil
+}
+
+// mac is an HMAC whose hashes and key pads come from a pool, hmac.New
+// allocates them for every notification
+type mac struct {
+	inner, outer hash.Hash
+	ipad, opad   []byte
+	sum          []byte
+	pool         *sync.Pool
+}
+
+var macPools = func() map[string]*sync.Pool {
+	pools := make(map[string]*sync.Pool, len(signatureAlgorithms))
+	for algorithm, newHash := range signatureAlgorithms {
+		pool := &sync.Pool{}
+		pool.New = func() interface{} {
+			inner, outer := newHash(), newHash()
+			return &mac{
+				inner: inner,
+				outer: outer,
+				ipad:  make([]byte, inner.BlockSize()),
+				opad:  make([]byte, inner.BlockSize()),
+				pool:  pool,
+			}
+		}
+		pools[algorithm] = pool
+	}
+	return pools
+}()
+
+// newMAC returns a pooled HMAC of the signature's algorithm keyed with key,
+// it must be released once the signature is checked
+func (s *signature) newMAC(key string) *mac {
+	h := macPools[s.algorithm].Get().(*mac)
+
+	clear(h.ipad)
+	if len(key) > len(h.ipad) {
+		h.outer.Reset()
+		io.WriteString(h.outer, key)
+		h.sum = h.outer.Sum(h.sum[:0])
+		copy(h.ipad, h.sum)
+	} else {
+		copy(h.ipad, key)
+	}
+	copy(h.opad, h.ipad)
+	for i := range h.ipad {
+		h.ipad[i] ^= 0x36
+		h.opad[i] ^= 0x5c
+	}
+
+	h.inner.Reset()
+	h.inner.Write(h.ipad)
+	return h
+}
+
+// matches reports whether body signed with key has the signature
+func (s *signature) matches(key string, body []byte) bool {
+	h := s.newMAC(key)
+	h.Write(body)
+	valid := h.equal(s.digest())
+	h.release()
+	return valid
+}
+
+func (h *mac) Write(p []byte) (int, error) {
+	return h.inner.Write(p)
+}
+
+// equal reports whether the HMAC of everything written matches want
+func (h *mac) equal(want []byte) bool {
+	h.sum = h.inner.Sum(h.sum[:0])
+	h.outer.Reset()
+	h.outer.Write(h.opad)
+	h.outer.Write(h.sum)
+	h.sum = h.outer.Sum(h.sum[:0])
+	return hmac.Equal(h.sum, want)
+}
+
+// release clears the key material and returns h to its pool
+func (h *mac) release() {
+	clear(h.ipad)
+	clear(h.opad)
+	clear(h.sum)
+	h.inner.Reset()
+	h.outer.Reset()
+	h.pool.Put(h)
+}
@@ -0,0 +1,231 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrQuotaExceeded is returned by Subscribe when the client ID holds its
+// Quota's HardLimit of subscriptions
+var ErrQuotaExceeded = errors.New("subscription quota exceeded")
+
+// Quota counts the active subscriptions of each client ID against limits,
+// share one between the handlers of a client ID. Subscriptions are counted
+// like the hub does, once per topic and callback, until they're
+// unsubscribed, denied or their lease runs out.
+type Quota struct {
+	// SoftLimit is the usage from which OnSoftLimit is called and
+	// subscriptions are logged as warnings, there's no soft limit when it's
+	// zero
+	SoftLimit int
+
+	// HardLimit is the usage from which new subscriptions are refused,
+	// there's no hard limit when it's zero
+	HardLimit int
+
+	// Queue makes Subscribe wait for room under the HardLimit until its
+	// context is done, instead of returning ErrQuotaExceeded
+	Queue bool
+
+	// OnSoftLimit is called when a client ID's usage reaches the SoftLimit
+	OnSoftLimit func(clientID string, used int)
+
+	// Clock defaults to SystemClock
+	Clock Clock
+
+	m       sync.Mutex
+	clients map[string]map[string]time.Time
+	changed chan struct{}
+}
+
+// QuotaUsage is a client ID's usage of a Quota
+type QuotaUsage struct {
+	Used      int
+	SoftLimit int
+	HardLimit int
+}
+
+// Acquire counts the subscription of topic to callbackURL until expiry,
+// waiting for room when Queue is set. Subscriptions already counted only
+// have their expiry extended. It returns the client ID's usage.
+func (q *Quota) Acquire(ctx context.Context, clientID, topic, callbackURL string, expiry time.Time) (int, error) {
+	used, _, err := q.acquire(ctx, clientID, topic, callbackURL, expiry)
+	return used, err
+}
+
+// acquire is Acquire, also reporting whether the subscription wasn't
+// counted before
+func (q *Quota) acquire(ctx context.Context, clientID, topic, callbackURL string, expiry time.Time) (int, bool, error) {
+	key := topic + " " + callbackURL
+	for {
+		q.m.Lock()
+		now := clockOrDefault(q.Clock).Now()
+		subs := q.subs(clientID, now)
+		_, counted := subs[key]
+		if counted || q.HardLimit <= 0 || len(subs) < q.HardLimit {
+			subs[key] = expiry
+			used := len(subs)
+			q.m.Unlock()
+			if !counted && q.SoftLimit > 0 && used == q.SoftLimit && q.OnSoftLimit != nil {
+				q.OnSoftLimit(clientID, used)
+			}
+			return used, !counted, nil
+		}
+		if !q.Queue {
+			q.m.Unlock()
+			return len(subs), false, ErrQuotaExceeded
+		}
+
+		// room is made by releases or the next lease running out
+		if q.changed == nil {
+			q.changed = make(chan struct{})
+		}
+		changed := q.changed
+		var next time.Time
+		for _, e := range subs {
+			if !e.IsZero() && (next.IsZero() || e.Before(next)) {
+				next = e
+			}
+		}
+		q.m.Unlock()
+
+		var expired chan struct{}
+		var timer Timer
+		if !next.IsZero() {
+			expired = make(chan struct{})
+			timer = clockOrDefault(q.Clock).AfterFunc(next.Sub(now), func() { close(expired) })
+		}
+		select {
+		case <-changed:
+		case <-expired:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, false, errors.Join(ErrQuotaExceeded, err)
+		}
+	}
+}
+
+// Release stops counting the subscription of topic to callbackURL
+func (q *Quota) Release(clientID, topic, callbackURL string) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	subs, ok := q.clients[clientID]
+	if !ok {
+		return
+	}
+	key := topic + " " + callbackURL
+	if _, ok := subs[key]; !ok {
+		return
+	}
+	delete(subs, key)
+	q.signal()
+}
+
+// Set replaces the subscriptions counted for clientID, such as with those
+// the hub holds
+func (q *Quota) Set(clientID string, subs []HubSubscription) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	counted := make(map[string]time.Time, len(subs))
+	for _, sub := range subs {
+		counted[sub.Topic+" "+sub.Callback] = sub.ExpiresAt
+	}
+	if q.clients == nil {
+		q.clients = make(map[string]map[string]time.Time)
+	}
+	q.clients[clientID] = counted
+	q.signal()
+}
+
+// Usage returns clientID's usage
+func (q *Quota) Usage(clientID string) QuotaUsage {
+	q.m.Lock()
+	defer q.m.Unlock()
+	subs := q.subs(clientID, clockOrDefault(q.Clock).Now())
+	return QuotaUsage{Used: len(subs), SoftLimit: q.SoftLimit, HardLimit: q.HardLimit}
+}
+
+// subs returns clientID's subscriptions, dropping those whose lease ran out
+func (q *Quota) subs(clientID string, now time.Time) map[string]time.Time {
+	if q.clients == nil {
+		q.clients = make(map[string]map[string]time.Time)
+	}
+	subs, ok := q.clients[clientID]
+	if !ok {
+		subs = make(map[string]time.Time)
+		q.clients[clientID] = subs
+	}
+	for key, expiry := range subs {
+		if !expiry.IsZero() && !now.Before(expiry) {
+			delete(subs, key)
+		}
+	}
+	return subs
+}
+
+// signal wakes queued Acquires
+func (q *Quota) signal() {
+	if q.changed != nil {
+		close(q.changed)
+		q.changed = nil
+	}
+}
+
+// SyncQuota counts the subscriptions the hub holds for the client in the
+// handler's Quota, Start calls it
+func (m *TwitchWebhookHandler) SyncQuota(ctx context.Context) error {
+	if m.Quota == nil {
+		return nil
+	}
+	subs, err := m.HubSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	m.Quota.Set(m.OAuth2ClientID, subs)
+	m.reportQuota()
+	return nil
+}
+
+// acquireQuota counts a subscription about to be sent to the hub, reporting
+// whether it wasn't counted before
+func (m *TwitchWebhookHandler) acquireQuota(ctx context.Context, sub *Subscription) (bool, error) {
+	if m.Quota == nil {
+		return false, nil
+	}
+	used, added, err := m.Quota.acquire(ctx, m.OAuth2ClientID, sub.Topic, sub.CallbackURL, clockOrDefault(m.Clock).Now().Add(sub.Lease))
+	if err != nil {
+		m.metrics().IncCounter("twitchhook_subscription_quota_rejected_total", "client_id", m.OAuth2ClientID)
+		m.logger().Warn("subscription quota exceeded", zap.String("topic", sub.Topic), zap.Int("used", used), zap.Int("hard_limit", m.Quota.HardLimit))
+		return false, err
+	}
+	m.reportQuota()
+	if soft := m.Quota.SoftLimit; added && soft > 0 && used >= soft {
+		m.logger().Warn("subscription quota soft limit reached", zap.String("topic", sub.Topic), zap.Int("used", used), zap.Int("soft_limit", soft))
+	}
+	return added, nil
+}
+
+// releaseQuota stops counting the subscription of topic to callbackURL
+func (m *TwitchWebhookHandler) releaseQuota(topic, callbackURL string) {
+	if m.Quota == nil {
+		return
+	}
+	m.Quota.Release(m.OAuth2ClientID, topic, callbackURL)
+	m.reportQuota()
+}
+
+func (m *TwitchWebhookHandler) reportQuota() {
+	usage := m.Quota.Usage(m.OAuth2ClientID)
+	m.metrics().SetGauge("twitchhook_subscription_quota_used", float64(usage.Used), "client_id", m.OAuth2ClientID)
+	if usage.HardLimit > 0 {
+		m.metrics().SetGauge("twitchhook_subscription_quota_limit", float64(usage.HardLimit), "client_id", m.OAuth2ClientID)
+	}
+}
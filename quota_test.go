@@ -0,0 +1,89 @@
+package twitchhook_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// failingSave is a SubscriptionManager whose saves fail
+type failingSave struct {
+	twitchhook.SubscriptionManager
+}
+
+func (failingSave) Save(topic string, sub *twitchhook.Subscription) error {
+	return errors.New("store unavailable")
+}
+
+func quotaUsed(h *twitchhook.TwitchWebhookHandler) int {
+	return h.Quota.Usage(h.OAuth2ClientID).Used
+}
+
+func TestSubscribeReleasesQuota(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(h *twitchhook.TwitchWebhookHandler, hub *fakeHub)
+	}{
+		{"hub rejected", func(h *twitchhook.TwitchWebhookHandler, hub *fakeHub) {
+			hub.status = http.StatusBadRequest
+		}},
+		{"hub unreachable without an outbox", func(h *twitchhook.TwitchWebhookHandler, hub *fakeHub) {
+			hub.err = errors.New("connection refused")
+		}},
+		{"save failed", func(h *twitchhook.TwitchWebhookHandler, hub *fakeHub) {
+			h.Manager = failingSave{h.Manager}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, hub, clock := newHubHandler(t)
+			h.Quota = &twitchhook.Quota{HardLimit: 1, Clock: clock}
+			tt.setup(h, hub)
+			if err := h.Subscribe(twitchhook.SubscriptionRequest{Topic: testTopic}, nil); err == nil {
+				t.Fatal("Subscribe succeeded")
+			}
+			if used := quotaUsed(h); used != 0 {
+				t.Fatalf("quota used = %d after the failed subscription, want 0", used)
+			}
+		})
+	}
+}
+
+func TestSubscribeCountsQuota(t *testing.T) {
+	h, _, clock := newHubHandler(t)
+	h.Quota = &twitchhook.Quota{HardLimit: 1, Clock: clock}
+	subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+	if used := quotaUsed(h); used != 1 {
+		t.Fatalf("quota used = %d, want 1", used)
+	}
+	err := h.Subscribe(twitchhook.SubscriptionRequest{Topic: testTopic + "2"}, nil)
+	if !errors.Is(err, twitchhook.ErrQuotaExceeded) {
+		t.Fatalf("Subscribe past the hard limit = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestRecoverOutboxReleasesQuota(t *testing.T) {
+	h, hub, clock := newHubHandler(t)
+	h.Quota = &twitchhook.Quota{HardLimit: 1, Clock: clock}
+	h.Outbox = &twitchhook.FileOutbox{Path: filepath.Join(t.TempDir(), "outbox.json")}
+	hub.err = errors.New("connection refused")
+	if err := h.Subscribe(twitchhook.SubscriptionRequest{Topic: testTopic}, nil); err == nil {
+		t.Fatal("Subscribe succeeded with the hub unreachable")
+	}
+	if used := quotaUsed(h); used != 1 {
+		t.Fatalf("quota used = %d, want the interrupted subscription counted until it's recovered", used)
+	}
+
+	hub.err = nil
+	hub.status = http.StatusBadRequest
+	if err := h.RecoverOutbox(context.Background()); err != nil {
+		t.Fatalf("RecoverOutbox: %v", err)
+	}
+	if used := quotaUsed(h); used != 0 {
+		t.Fatalf("quota used = %d after rolling back, want 0", used)
+	}
+}
@@ -0,0 +1,96 @@
+package twitchhook
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Helix rate limit headers
+const (
+	HeaderRatelimitLimit     = "Ratelimit-Limit"
+	HeaderRatelimitRemaining = "Ratelimit-Remaining"
+	HeaderRatelimitReset     = "Ratelimit-Reset"
+)
+
+// RateLimitTransport is an http.RoundTripper that follows the helix rate limit
+// headers, holding requests once the bucket is empty until it resets. The
+// handler's client uses one, so every caller sharing the client shares the
+// app token's bucket.
+type RateLimitTransport struct {
+	// Base defaults to http.DefaultTransport
+	Base http.RoundTripper
+
+	// Clock defaults to SystemClock
+	Clock Clock
+
+	m         sync.Mutex
+	remaining int
+	reset     time.Time
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	clock := clockOrDefault(t.Clock)
+
+	err := t.wait(req, clock)
+	if err != nil {
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.update(resp)
+	return resp, nil
+}
+
+// wait blocks until the bucket has room or its reset time passes
+func (t *RateLimitTransport) wait(req *http.Request, clock Clock) error {
+	t.m.Lock()
+	if t.reset.IsZero() || t.remaining > 0 {
+		if t.remaining > 0 {
+			t.remaining--
+		}
+		t.m.Unlock()
+		return nil
+	}
+	delay := t.reset.Sub(clock.Now())
+	t.m.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	done := make(chan struct{})
+	timer := clock.AfterFunc(delay, func() { close(done) })
+	select {
+	case <-done:
+		return nil
+	case <-req.Context().Done():
+		timer.Stop()
+		return req.Context().Err()
+	}
+}
+
+func (t *RateLimitTransport) update(resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get(HeaderRatelimitRemaining))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get(HeaderRatelimitReset), 10, 64)
+	if err != nil {
+		return
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.remaining = remaining
+	t.reset = time.Unix(reset, 0)
+}
@@ -0,0 +1,127 @@
+package twitchhook
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Headers carrying the notification id, send time and retry count, websub
+// notifications use the Twitch-Notification-* headers while eventsub
+// messages use the Twitch-Eventsub-Message-* ones
+const (
+	HeaderNotificationID        = "Twitch-Notification-Id"
+	HeaderNotificationTimestamp = "Twitch-Notification-Timestamp"
+	HeaderNotificationRetry     = "Twitch-Notification-Retry"
+	HeaderEventSubMessageID     = "Twitch-Eventsub-Message-Id"
+	HeaderEventSubTimestamp     = "Twitch-Eventsub-Message-Timestamp"
+	HeaderEventSubRetry         = "Twitch-Eventsub-Message-Retry"
+)
+
+// defaultDedupRetention is how long notification ids are remembered when
+// MaxNotificationAge isn't set
+const defaultDedupRetention = 10 * time.Minute
+
+var (
+	// ErrStaleNotification is returned for notifications sent outside of
+	// MaxNotificationAge, or without a timestamp while the check is enabled
+	ErrStaleNotification = errors.New("notification timestamp outside of allowed window")
+
+	// ErrDuplicateNotification is returned for notifications whose id has
+	// already been seen by the DedupStore
+	ErrDuplicateNotification = errors.New("duplicate notification")
+)
+
+func notificationID(h http.Header) string {
+	if id := h.Get(HeaderNotificationID); id != "" {
+		return id
+	}
+	return h.Get(HeaderEventSubMessageID)
+}
+
+func notificationTimestamp(h http.Header) (time.Time, bool) {
+	v := h.Get(HeaderNotificationTimestamp)
+	if v == "" {
+		v = h.Get(HeaderEventSubTimestamp)
+	}
+	if v == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func notificationRetry(h http.Header) int {
+	v := h.Get(HeaderNotificationRetry)
+	if v == "" {
+		v = h.Get(HeaderEventSubRetry)
+	}
+	if v == "" {
+		return 0
+	}
+	retry, _ := strconv.Atoi(v)
+	return retry
+}
+
+// checkReplay rejects stale and duplicate notifications, it must only be
+// called once the signature has been verified so that forged requests can't
+// fill the dedup store
+func (m *TwitchWebhookHandler) checkReplay(h http.Header) error {
+	now := clockOrDefault(m.Clock).Now()
+
+	if m.MaxNotificationAge > 0 {
+		sent, ok := notificationTimestamp(h)
+		if !ok {
+			return ErrStaleNotification
+		}
+		age := now.Sub(sent)
+		if age > m.MaxNotificationAge || -age > m.MaxNotificationAge {
+			return ErrStaleNotification
+		}
+	}
+
+	if m.Dedup == nil {
+		return nil
+	}
+
+	id := notificationID(h)
+	if id == "" {
+		return nil
+	}
+
+	retention := m.MaxNotificationAge
+	if retention < defaultDedupRetention {
+		retention = defaultDedupRetention
+	}
+	seen, err := m.Dedup.Seen(id, now.Add(retention))
+	if err != nil {
+		return err
+	}
+	if seen {
+		return ErrDuplicateNotification
+	}
+	return nil
+}
+
+// forgetNotification removes a notification from the dedup store so its
+// redelivery is handled, when the store is a DedupForgetter
+func (m *TwitchWebhookHandler) forgetNotification(h http.Header) {
+	forgetter, ok := m.Dedup.(DedupForgetter)
+	if !ok {
+		return
+	}
+	id := notificationID(h)
+	if id == "" {
+		return
+	}
+	err := forgetter.Forget(id)
+	if err != nil {
+		m.logger().Error("error forgetting notification", zap.String("id", id), zap.Error(err))
+	}
+}
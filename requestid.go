@@ -0,0 +1,85 @@
+package twitchhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// HeaderRequestID carries the id of a callback request, it's read from
+// requests, written to responses and sent by sinks forwarding notifications
+const HeaderRequestID = "X-Request-Id"
+
+// maxRequestIDLength bounds the incoming request ids honored
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the request id
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request id carried by ctx, empty when it
+// has none. Notification handlers are called with the id of the callback
+// request, it's also the notification's RequestID.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random request id of 32 hex characters
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// withRequestID gives every request an id, the one in its RequestIDHeader
+// when it's well formed, such as one set by a proxy in front of the handler
+func (m *TwitchWebhookHandler) withRequestID(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := m.requestIDHeader()
+		id := r.Header.Get(header)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(header, id)
+		h(w, r.WithContext(ContextWithRequestID(r.Context(), id)))
+	}
+}
+
+func (m *TwitchWebhookHandler) requestIDHeader() string {
+	if m.RequestIDHeader == "" {
+		return HeaderRequestID
+	}
+	return m.RequestIDHeader
+}
+
+// validRequestID reports whether id is short and printable ascii, so it's
+// safe to log and send on
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestLogger is the handler's logger with the request id of ctx
+func (m *TwitchWebhookHandler) requestLogger(ctx context.Context) *zap.Logger {
+	if m.Logger == nil {
+		return nopLogger
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		return m.logger().With(zap.String("request_id", id))
+	}
+	return m.logger()
+}
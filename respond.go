@@ -0,0 +1,66 @@
+package twitchhook
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// NotificationResponse is returned by a NotificationHandler to choose the
+// response to the hub. A 2xx acknowledges the notification, a 4xx tells the
+// hub to give up on it and a 5xx asks it to deliver the notification again.
+// Other errors returned by handlers are logged and the notification is
+// acknowledged.
+type NotificationResponse struct {
+	StatusCode int
+
+	// RetryAfter sets the Retry-After header when it's positive
+	RetryAfter time.Duration
+
+	// Err is logged when it's set
+	Err error
+}
+
+func (r *NotificationResponse) Error() string {
+	if r.Err == nil {
+		return fmt.Sprintf("notification response %d", r.StatusCode)
+	}
+	return fmt.Sprintf("notification response %d: %v", r.StatusCode, r.Err)
+}
+
+func (r *NotificationResponse) Unwrap() error {
+	return r.Err
+}
+
+// Ack acknowledges a notification, logging err if it's set
+func Ack(err error) error {
+	return &NotificationResponse{StatusCode: http.StatusOK, Err: err}
+}
+
+// Reject tells the hub to stop delivering a notification
+func Reject(err error) error {
+	return &NotificationResponse{StatusCode: http.StatusUnprocessableEntity, Err: err}
+}
+
+// RetryLater asks the hub to deliver a notification again, after retryAfter
+// if it's positive
+func RetryLater(retryAfter time.Duration, err error) error {
+	return &NotificationResponse{StatusCode: http.StatusServiceUnavailable, RetryAfter: retryAfter, Err: err}
+}
+
+// write writes the response, a StatusCode outside 200-599 is a 200
+func (r *NotificationResponse) write(w http.ResponseWriter) {
+	status := r.StatusCode
+	if status < 200 || status > 599 {
+		status = http.StatusOK
+	}
+	if r.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((r.RetryAfter+time.Second-1)/time.Second), 10))
+	}
+	if status < 300 {
+		w.WriteHeader(status)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
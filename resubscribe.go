@@ -0,0 +1,107 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ResubscribePolicy is what Subscribe does when the Manager already holds a
+// subscription to the topic with the same callback base. Whatever the policy,
+// a live subscription Subscribe replaces with a different callback is
+// unsubscribed once the hub accepts the new one.
+type ResubscribePolicy string
+
+// Resubscribe policies
+const (
+	// ResubscribeReuse leaves subscriptions that haven't expired alone
+	// without asking the hub, renewals reuse the id and secret. It's the
+	// default.
+	ResubscribeReuse ResubscribePolicy = "reuse"
+	// ResubscribeRenew asks the hub to extend the subscription, reusing its
+	// id and secret
+	ResubscribeRenew ResubscribePolicy = "renew"
+	// ResubscribeRotate replaces the subscription with one with a new id and
+	// secret. Renewals keep the id and callback url and only rotate the
+	// secret, the old one verifying notifications for the SecretOverlap.
+	ResubscribeRotate ResubscribePolicy = "rotate"
+)
+
+func (p ResubscribePolicy) check() error {
+	switch p {
+	case "", ResubscribeRotate, ResubscribeReuse, ResubscribeRenew:
+		return nil
+	default:
+		return fmt.Errorf("unknown resubscribe policy %q", p)
+	}
+}
+
+// rotates reports whether the policy gives resubscriptions new secrets
+func (p ResubscribePolicy) rotates() bool {
+	return p == ResubscribeRotate
+}
+
+// reuses reports whether the policy leaves live subscriptions alone
+func (p ResubscribePolicy) reuses() bool {
+	return p == "" || p == ResubscribeReuse
+}
+
+// existingSubscription returns the subscription a request should reuse the
+// id and callback url of, nil when a new one should be made. Renewals reuse
+// them whatever the policy. skip is set when the policy leaves the existing
+// subscription alone.
+func (m *TwitchWebhookHandler) existingSubscription(request SubscriptionRequest, renewing bool) (existing *Subscription, skip bool, err error) {
+	if m.Resubscribe.rotates() && !renewing {
+		return nil, false, nil
+	}
+
+	existing, err = m.getSubscription(request.Topic)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if existing.ID == "" || existing.CallbackURL == "" || existing.CallbackBaseURL != request.CallbackBaseURL {
+		return nil, false, nil
+	}
+
+	now := clockOrDefault(m.Clock).Now()
+	if m.Resubscribe.reuses() && !renewing && existing.State(now) != SubscriptionExpired {
+		return existing, true, nil
+	}
+	return existing, false, nil
+}
+
+// replacedSubscription returns the live subscription to topic a new one with
+// a different callback replaces, nil when there isn't one
+func (m *TwitchWebhookHandler) replacedSubscription(topic string) *Subscription {
+	current, err := m.getSubscription(topic)
+	if err != nil || current.CallbackURL == "" {
+		return nil
+	}
+	if current.State(clockOrDefault(m.Clock).Now()) == SubscriptionExpired {
+		// the hub no longer delivers to it
+		return nil
+	}
+	return current
+}
+
+// unsubscribeReplaced unsubscribes the callback of a subscription replaced by
+// sub so the hub stops delivering to both
+func (m *TwitchWebhookHandler) unsubscribeReplaced(ctx context.Context, replaced, sub *Subscription) {
+	if replaced == nil || replaced.CallbackURL == sub.CallbackURL {
+		return
+	}
+	err := m.UnsubscribeCallback(ctx, sub.Topic, replaced.CallbackURL)
+	if err != nil {
+		m.logger().Error("error unsubscribing replaced callback",
+			zap.String("topic", sub.Topic),
+			zap.String("callback_url", replaced.CallbackURL),
+			zap.Error(err),
+		)
+		m.hookError("unsubscribe replaced", err)
+	}
+}
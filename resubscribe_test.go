@@ -0,0 +1,106 @@
+package twitchhook_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+func subscribe(t *testing.T, h *twitchhook.TwitchWebhookHandler, request twitchhook.SubscriptionRequest) *twitchhook.Subscription {
+	t.Helper()
+	if err := h.Subscribe(request, nil); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	sub, err := h.Manager.Get(request.Topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func TestResubscribeDefaultReuses(t *testing.T) {
+	h, hub, _ := newHubHandler(t)
+	first := subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+	second := subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+
+	if second.ID != first.ID || second.Secret != first.Secret {
+		t.Fatal("resubscribing with the zero value policy replaced the subscription")
+	}
+	if want := []string{"subscribe " + first.CallbackURL}; !reflect.DeepEqual(hub.requests(), want) {
+		t.Fatalf("hub requests = %q, want %q", hub.requests(), want)
+	}
+}
+
+func TestResubscribeRenew(t *testing.T) {
+	h, hub, _ := newHubHandler(t)
+	h.Resubscribe = twitchhook.ResubscribeRenew
+	first := subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+	second := subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+
+	if second.ID != first.ID || second.Secret != first.Secret {
+		t.Fatal("renew policy replaced the subscription")
+	}
+	want := []string{"subscribe " + first.CallbackURL, "subscribe " + first.CallbackURL}
+	if !reflect.DeepEqual(hub.requests(), want) {
+		t.Fatalf("hub requests = %q, want %q", hub.requests(), want)
+	}
+}
+
+func TestResubscribeRotateUnsubscribesOldCallback(t *testing.T) {
+	h, hub, _ := newHubHandler(t)
+	h.Resubscribe = twitchhook.ResubscribeRotate
+	first := subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+	second := subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+
+	if second.ID == first.ID || second.Secret == first.Secret || second.CallbackURL == first.CallbackURL {
+		t.Fatal("rotate policy kept the id, secret or callback")
+	}
+	want := []string{
+		"subscribe " + first.CallbackURL,
+		"subscribe " + second.CallbackURL,
+		"unsubscribe " + first.CallbackURL,
+	}
+	if !reflect.DeepEqual(hub.requests(), want) {
+		t.Fatalf("hub requests = %q, want %q", hub.requests(), want)
+	}
+}
+
+func TestResubscribeNewCallbackBaseUnsubscribesOldCallback(t *testing.T) {
+	h, hub, _ := newHubHandler(t)
+	first := subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+	second := subscribe(t, h, twitchhook.SubscriptionRequest{
+		Topic:           testTopic,
+		CallbackBaseURL: "https://other.example.com/callback",
+	})
+
+	want := []string{
+		"subscribe " + first.CallbackURL,
+		"subscribe " + second.CallbackURL,
+		"unsubscribe " + first.CallbackURL,
+	}
+	if !reflect.DeepEqual(hub.requests(), want) {
+		t.Fatalf("hub requests = %q, want %q", hub.requests(), want)
+	}
+}
+
+func TestResubscribeKeepsExpiredCallbacks(t *testing.T) {
+	h, hub, clock := newHubHandler(t)
+	h.Resubscribe = twitchhook.ResubscribeRotate
+	first := subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+	if _, err := h.Manager.SetSubscriptionLease(testTopic, h.DefaultLease); err != nil {
+		t.Fatal(err)
+	}
+	// past the lease, without the renewal the timer would send
+	first.Renew = nil
+	if err := h.Manager.Save(testTopic, first); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * h.DefaultLease)
+
+	second := subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+	want := []string{"subscribe " + first.CallbackURL, "subscribe " + second.CallbackURL}
+	if !reflect.DeepEqual(hub.requests(), want) {
+		t.Fatalf("hub requests = %q, want %q", hub.requests(), want)
+	}
+}
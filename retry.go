@@ -0,0 +1,228 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Renewal retry defaults
+const (
+	DefaultRenewalRetries       = 8
+	DefaultRenewalRetryBackoff  = 30 * time.Second
+	DefaultRenewalRetryMaxDelay = 30 * time.Minute
+)
+
+// RetryItem is a failed renewal waiting to be retried
+type RetryItem struct {
+	Request     SubscriptionRequest `json:"request"`
+	Attempts    int                 `json:"attempts"`
+	NextAttempt time.Time           `json:"next_attempt"`
+	LastError   string              `json:"last_error"`
+}
+
+// RetryQueue persists failed renewals so retries survive restarts, see
+// ResumeRenewalRetries. Items are keyed by topic.
+type RetryQueue interface {
+	Put(item RetryItem) error
+	Remove(topic string) error
+	List() ([]RetryItem, error)
+}
+
+// InMemoryRetryQueue is a RetryQueue that doesn't survive restarts
+type InMemoryRetryQueue struct {
+	m     sync.Mutex
+	items map[string]RetryItem
+}
+
+// Put adds or replaces the item for its topic
+func (q *InMemoryRetryQueue) Put(item RetryItem) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.items == nil {
+		q.items = make(map[string]RetryItem)
+	}
+	q.items[item.Request.Topic] = item
+	return nil
+}
+
+// Remove removes the item for topic
+func (q *InMemoryRetryQueue) Remove(topic string) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	delete(q.items, topic)
+	return nil
+}
+
+// List returns every item ordered by next attempt
+func (q *InMemoryRetryQueue) List() ([]RetryItem, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return sortedRetryItems(q.items), nil
+}
+
+// FileRetryQueue is a RetryQueue stored as a json file, writes replace the
+// file atomically
+type FileRetryQueue struct {
+	Path string
+
+	f jsonFile[RetryItem]
+}
+
+// Put adds or replaces the item for its topic
+func (q *FileRetryQueue) Put(item RetryItem) error {
+	return q.f.put(q.Path, item.Request.Topic, item)
+}
+
+// Remove removes the item for topic
+func (q *FileRetryQueue) Remove(topic string) error {
+	return q.f.remove(q.Path, topic)
+}
+
+// List returns every item ordered by next attempt
+func (q *FileRetryQueue) List() ([]RetryItem, error) {
+	items, err := q.f.list(q.Path)
+	if err != nil {
+		return nil, err
+	}
+	return sortedRetryItems(items), nil
+}
+
+func sortedRetryItems(items map[string]RetryItem) []RetryItem {
+	list := make([]RetryItem, 0, len(items))
+	for _, item := range items {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].NextAttempt.Before(list[j].NextAttempt)
+	})
+	return list
+}
+
+// renewalRetryDelay is the exponential backoff before attempt, with up to 10%
+// jitter
+func (m *TwitchWebhookHandler) renewalRetryDelay(attempt int) time.Duration {
+	base := m.RenewalRetryBackoff
+	if base <= 0 {
+		base = DefaultRenewalRetryBackoff
+	}
+	max := m.RenewalRetryMaxDelay
+	if max <= 0 {
+		max = DefaultRenewalRetryMaxDelay
+	}
+
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/10+1))
+}
+
+func (m *TwitchWebhookHandler) renewalRetries() int {
+	if m.RenewalRetries == 0 {
+		return DefaultRenewalRetries
+	}
+	return m.RenewalRetries
+}
+
+// retryRenewal schedules another attempt at a failed renewal, or gives up
+// and calls OnRenewalExhausted once the retries are used up
+func (m *TwitchWebhookHandler) retryRenewal(request SubscriptionRequest, denialCallback func(reason string), attempts int, err error) {
+	if attempts > m.renewalRetries() {
+		m.logger().Error("giving up renewing webhook subscription", zap.String("topic", request.Topic), zap.Int("attempts", attempts), zap.Error(err))
+		m.removeRetry(request.Topic)
+		if m.OnRenewalExhausted != nil {
+			m.protect("renewal exhausted callback", func() error {
+				m.OnRenewalExhausted(request.Topic, err)
+				return nil
+			})
+		}
+		return
+	}
+
+	delay := m.renewalRetryDelay(attempts)
+	if m.RetryQueue != nil {
+		putErr := m.RetryQueue.Put(RetryItem{
+			Request:     request,
+			Attempts:    attempts,
+			NextAttempt: clockOrDefault(m.Clock).Now().Add(delay),
+			LastError:   err.Error(),
+		})
+		if putErr != nil {
+			m.logger().Error("error queueing renewal retry", zap.String("topic", request.Topic), zap.Error(putErr))
+		}
+	}
+	m.scheduleRenewalRetry(request, denialCallback, attempts, delay)
+}
+
+func (m *TwitchWebhookHandler) scheduleRenewalRetry(request SubscriptionRequest, denialCallback func(reason string), attempts int, delay time.Duration) {
+	clock := clockOrDefault(m.Clock)
+	m.hookRenewalScheduled(request.Topic, clock.Now().Add(delay))
+	m.renewalRetriesScheduled.Add(1)
+	clock.AfterFunc(delay, func() {
+		m.renewalRetriesScheduled.Add(-1)
+		var unsubscribed bool
+		err := m.protect("renew", func() error {
+			_, err := m.getSubscription(request.Topic)
+			if errors.Is(err, ErrSubscriptionNotFound) {
+				// unsubscribed while waiting to retry
+				unsubscribed = true
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return m.subscribe(context.Background(), request, denialCallback, true)
+		})
+		if err != nil {
+			m.logger().Error("unable to renew webhook subscription", zap.String("topic", request.Topic), zap.Int("attempts", attempts), zap.Error(err))
+			m.hookError("renew", err)
+			m.retryRenewal(request, denialCallback, attempts+1, err)
+			return
+		}
+		if unsubscribed {
+			m.logger().Info("dropping renewal retry of unsubscribed topic", zap.String("topic", request.Topic))
+		}
+		m.removeRetry(request.Topic)
+	})
+}
+
+func (m *TwitchWebhookHandler) removeRetry(topic string) {
+	if m.RetryQueue == nil {
+		return
+	}
+	err := m.RetryQueue.Remove(topic)
+	if err != nil {
+		m.logger().Error("error removing renewal retry", zap.String("topic", topic), zap.Error(err))
+	}
+}
+
+// ResumeRenewalRetries schedules the retries held in the RetryQueue, call it
+// on startup to pick up retries queued before a restart. Denial callbacks
+// aren't persisted, resumed retries have none.
+func (m *TwitchWebhookHandler) ResumeRenewalRetries() error {
+	if m.RetryQueue == nil {
+		return nil
+	}
+	items, err := m.RetryQueue.List()
+	if err != nil {
+		return err
+	}
+	now := clockOrDefault(m.Clock).Now()
+	for _, item := range items {
+		delay := item.NextAttempt.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		m.scheduleRenewalRetry(item.Request, nil, item.Attempts, delay)
+	}
+	return nil
+}
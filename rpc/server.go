@@ -0,0 +1,209 @@
+// Package rpc serves verified notifications to consumers over gRPC, see
+// proto/twitchhook/v1/notifications.proto for the service definition.
+package rpc
+
+//go:generate protoc -I ../proto --go_out=twitchhookv1 --go_opt=paths=source_relative --go-grpc_out=twitchhookv1 --go-grpc_opt=paths=source_relative twitchhook/v1/notifications.proto
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/rpc/twitchhookv1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// DefaultConsumerBuffer is how many notifications are queued per consumer
+// when ConsumerBuffer isn't set
+const DefaultConsumerBuffer = 64
+
+// Server is a twitchhook.NotificationHandler that fans notifications out to
+// gRPC consumers. Use it as, or chain it into, the handler's
+// NotificationHandler and register it with Register. Notifications for a
+// consumer whose buffer is full are dropped.
+type Server struct {
+	twitchhookv1.UnimplementedNotificationServiceServer
+
+	// ConsumerBuffer is the per consumer queue length
+	ConsumerBuffer int
+
+	Logger *zap.Logger
+
+	m         sync.RWMutex
+	consumers map[*consumer]struct{}
+}
+
+type consumer struct {
+	id       string
+	topics   map[string]bool
+	prefixes []string
+	c        chan *twitchhookv1.Notification
+}
+
+func (c *consumer) wants(topic string) bool {
+	if len(c.topics) == 0 && len(c.prefixes) == 0 {
+		return true
+	}
+	if c.topics[topic] {
+		return true
+	}
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Register registers the NotificationService on s
+func (srv *Server) Register(s grpc.ServiceRegistrar) {
+	twitchhookv1.RegisterNotificationServiceServer(s, srv)
+}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (srv *Server) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	msg, err := toProto(n)
+	if err != nil {
+		return err
+	}
+
+	srv.m.RLock()
+	defer srv.m.RUnlock()
+	for c := range srv.consumers {
+		if !c.wants(n.Topic) {
+			continue
+		}
+		select {
+		case c.c <- msg:
+		default:
+			srv.logger().Warn("dropping notification for slow consumer", zap.String("consumer", c.id), zap.String("topic", n.Topic))
+		}
+	}
+	return nil
+}
+
+// Subscribe implements twitchhookv1.NotificationServiceServer
+func (srv *Server) Subscribe(req *twitchhookv1.SubscribeRequest, stream grpc.ServerStreamingServer[twitchhookv1.Notification]) error {
+	buffer := srv.ConsumerBuffer
+	if buffer <= 0 {
+		buffer = DefaultConsumerBuffer
+	}
+	c := &consumer{
+		id:       req.GetConsumerId(),
+		topics:   make(map[string]bool, len(req.GetTopics())),
+		prefixes: req.GetTopicPrefixes(),
+		c:        make(chan *twitchhookv1.Notification, buffer),
+	}
+	for _, topic := range req.GetTopics() {
+		c.topics[topic] = true
+	}
+
+	srv.m.Lock()
+	if srv.consumers == nil {
+		srv.consumers = make(map[*consumer]struct{})
+	}
+	srv.consumers[c] = struct{}{}
+	srv.m.Unlock()
+	defer func() {
+		srv.m.Lock()
+		delete(srv.consumers, c)
+		srv.m.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case msg := <-c.c:
+			err := stream.Send(msg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (srv *Server) logger() *zap.Logger {
+	if srv.Logger == nil {
+		return zap.NewNop()
+	}
+	return srv.Logger
+}
+
+func toProto(n *twitchhook.Notification) (*twitchhookv1.Notification, error) {
+	msg := &twitchhookv1.Notification{
+		Id:             n.ID,
+		Topic:          n.Topic,
+		SubscriptionId: string(n.SubscriptionID),
+		ReceivedAt:     timestamppb.New(n.ReceivedAt),
+		Body:           n.Body,
+	}
+	if !n.Timestamp.IsZero() {
+		msg.Timestamp = timestamppb.New(n.Timestamp)
+	}
+
+	event := n.Event
+	if event == nil {
+		var err error
+		event, err = twitchhook.DecodeEvent(n.Topic, n.Body)
+		if err == twitchhook.ErrUnknownTopic {
+			return msg, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch event := event.(type) {
+	case *twitchhook.StreamChanged:
+		pb := &twitchhookv1.StreamChanged{}
+		for _, s := range event.Streams {
+			pb.Streams = append(pb.Streams, &twitchhookv1.Stream{
+				Id:           s.ID,
+				UserId:       s.UserID,
+				UserName:     s.UserName,
+				GameId:       s.GameID,
+				GameName:     s.GameName,
+				Type:         s.Type,
+				Title:        s.Title,
+				ViewerCount:  int64(s.ViewerCount),
+				StartedAt:    timestamppb.New(s.StartedAt),
+				Language:     s.Language,
+				ThumbnailUrl: s.ThumbnailURL,
+			})
+		}
+		msg.Event = &twitchhookv1.Notification_StreamChanged{StreamChanged: pb}
+	case *twitchhook.Follows:
+		pb := &twitchhookv1.Follows{}
+		for _, f := range event.Follows {
+			pb.Follows = append(pb.Follows, &twitchhookv1.Follow{
+				FromId:     f.FromID,
+				FromName:   f.FromName,
+				ToId:       f.ToID,
+				ToName:     f.ToName,
+				FollowedAt: timestamppb.New(f.FollowedAt),
+			})
+		}
+		msg.Event = &twitchhookv1.Notification_Follows{Follows: pb}
+	case *twitchhook.UserChanged:
+		pb := &twitchhookv1.UserChanged{}
+		for _, u := range event.Users {
+			pb.Users = append(pb.Users, &twitchhookv1.User{
+				Id:              u.ID,
+				Login:           u.Login,
+				DisplayName:     u.DisplayName,
+				Type:            u.Type,
+				BroadcasterType: u.BroadcasterType,
+				Description:     u.Description,
+				ProfileImageUrl: u.ProfileImageURL,
+				OfflineImageUrl: u.OfflineImageURL,
+				ViewCount:       int64(u.ViewCount),
+			})
+		}
+		msg.Event = &twitchhookv1.Notification_UserChanged{UserChanged: pb}
+	}
+	return msg, nil
+}
@@ -0,0 +1,813 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: twitchhook/v1/notifications.proto
+
+package twitchhookv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SubscribeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// topics are exact topic urls to receive.
+	Topics []string `protobuf:"bytes,1,rep,name=topics,proto3" json:"topics,omitempty"`
+	// topic_prefixes match topics starting with any of the prefixes. A
+	// request with no topics or prefixes receives every notification.
+	TopicPrefixes []string `protobuf:"bytes,2,rep,name=topic_prefixes,json=topicPrefixes,proto3" json:"topic_prefixes,omitempty"`
+	// consumer_id identifies the consumer in logs.
+	ConsumerId    string `protobuf:"bytes,3,opt,name=consumer_id,json=consumerId,proto3" json:"consumer_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_twitchhook_v1_notifications_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubscribeRequest) GetTopics() []string {
+	if x != nil {
+		return x.Topics
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetTopicPrefixes() []string {
+	if x != nil {
+		return x.TopicPrefixes
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetConsumerId() string {
+	if x != nil {
+		return x.ConsumerId
+	}
+	return ""
+}
+
+type Notification struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Topic          string                 `protobuf:"bytes,2,opt,name=topic,proto3" json:"topic,omitempty"`
+	SubscriptionId string                 `protobuf:"bytes,3,opt,name=subscription_id,json=subscriptionId,proto3" json:"subscription_id,omitempty"`
+	Timestamp      *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	ReceivedAt     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=received_at,json=receivedAt,proto3" json:"received_at,omitempty"`
+	// body is the raw json notification body.
+	Body []byte `protobuf:"bytes,6,opt,name=body,proto3" json:"body,omitempty"`
+	// event is the decoded body, unset for topics without an event type.
+	//
+	// Types that are valid to be assigned to Event:
+	//
+	//	*Notification_StreamChanged
+	//	*Notification_Follows
+	//	*Notification_UserChanged
+	Event         isNotification_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Notification) Reset() {
+	*x = Notification{}
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Notification) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Notification) ProtoMessage() {}
+
+func (x *Notification) ProtoReflect() protoreflect.Message {
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Notification.ProtoReflect.Descriptor instead.
+func (*Notification) Descriptor() ([]byte, []int) {
+	return file_twitchhook_v1_notifications_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Notification) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Notification) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *Notification) GetSubscriptionId() string {
+	if x != nil {
+		return x.SubscriptionId
+	}
+	return ""
+}
+
+func (x *Notification) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Notification) GetReceivedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReceivedAt
+	}
+	return nil
+}
+
+func (x *Notification) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+func (x *Notification) GetEvent() isNotification_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *Notification) GetStreamChanged() *StreamChanged {
+	if x != nil {
+		if x, ok := x.Event.(*Notification_StreamChanged); ok {
+			return x.StreamChanged
+		}
+	}
+	return nil
+}
+
+func (x *Notification) GetFollows() *Follows {
+	if x != nil {
+		if x, ok := x.Event.(*Notification_Follows); ok {
+			return x.Follows
+		}
+	}
+	return nil
+}
+
+func (x *Notification) GetUserChanged() *UserChanged {
+	if x != nil {
+		if x, ok := x.Event.(*Notification_UserChanged); ok {
+			return x.UserChanged
+		}
+	}
+	return nil
+}
+
+type isNotification_Event interface {
+	isNotification_Event()
+}
+
+type Notification_StreamChanged struct {
+	StreamChanged *StreamChanged `protobuf:"bytes,7,opt,name=stream_changed,json=streamChanged,proto3,oneof"`
+}
+
+type Notification_Follows struct {
+	Follows *Follows `protobuf:"bytes,8,opt,name=follows,proto3,oneof"`
+}
+
+type Notification_UserChanged struct {
+	UserChanged *UserChanged `protobuf:"bytes,9,opt,name=user_changed,json=userChanged,proto3,oneof"`
+}
+
+func (*Notification_StreamChanged) isNotification_Event() {}
+
+func (*Notification_Follows) isNotification_Event() {}
+
+func (*Notification_UserChanged) isNotification_Event() {}
+
+// StreamChanged has no streams when the stream went offline.
+type StreamChanged struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Streams       []*Stream              `protobuf:"bytes,1,rep,name=streams,proto3" json:"streams,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamChanged) Reset() {
+	*x = StreamChanged{}
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamChanged) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamChanged) ProtoMessage() {}
+
+func (x *StreamChanged) ProtoReflect() protoreflect.Message {
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamChanged.ProtoReflect.Descriptor instead.
+func (*StreamChanged) Descriptor() ([]byte, []int) {
+	return file_twitchhook_v1_notifications_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *StreamChanged) GetStreams() []*Stream {
+	if x != nil {
+		return x.Streams
+	}
+	return nil
+}
+
+type Stream struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	UserId        string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	UserName      string                 `protobuf:"bytes,3,opt,name=user_name,json=userName,proto3" json:"user_name,omitempty"`
+	GameId        string                 `protobuf:"bytes,4,opt,name=game_id,json=gameId,proto3" json:"game_id,omitempty"`
+	GameName      string                 `protobuf:"bytes,5,opt,name=game_name,json=gameName,proto3" json:"game_name,omitempty"`
+	Type          string                 `protobuf:"bytes,6,opt,name=type,proto3" json:"type,omitempty"`
+	Title         string                 `protobuf:"bytes,7,opt,name=title,proto3" json:"title,omitempty"`
+	ViewerCount   int64                  `protobuf:"varint,8,opt,name=viewer_count,json=viewerCount,proto3" json:"viewer_count,omitempty"`
+	StartedAt     *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	Language      string                 `protobuf:"bytes,10,opt,name=language,proto3" json:"language,omitempty"`
+	ThumbnailUrl  string                 `protobuf:"bytes,11,opt,name=thumbnail_url,json=thumbnailUrl,proto3" json:"thumbnail_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Stream) Reset() {
+	*x = Stream{}
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stream) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stream) ProtoMessage() {}
+
+func (x *Stream) ProtoReflect() protoreflect.Message {
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stream.ProtoReflect.Descriptor instead.
+func (*Stream) Descriptor() ([]byte, []int) {
+	return file_twitchhook_v1_notifications_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Stream) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Stream) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Stream) GetUserName() string {
+	if x != nil {
+		return x.UserName
+	}
+	return ""
+}
+
+func (x *Stream) GetGameId() string {
+	if x != nil {
+		return x.GameId
+	}
+	return ""
+}
+
+func (x *Stream) GetGameName() string {
+	if x != nil {
+		return x.GameName
+	}
+	return ""
+}
+
+func (x *Stream) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Stream) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Stream) GetViewerCount() int64 {
+	if x != nil {
+		return x.ViewerCount
+	}
+	return 0
+}
+
+func (x *Stream) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *Stream) GetLanguage() string {
+	if x != nil {
+		return x.Language
+	}
+	return ""
+}
+
+func (x *Stream) GetThumbnailUrl() string {
+	if x != nil {
+		return x.ThumbnailUrl
+	}
+	return ""
+}
+
+type Follows struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Follows       []*Follow              `protobuf:"bytes,1,rep,name=follows,proto3" json:"follows,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Follows) Reset() {
+	*x = Follows{}
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Follows) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Follows) ProtoMessage() {}
+
+func (x *Follows) ProtoReflect() protoreflect.Message {
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Follows.ProtoReflect.Descriptor instead.
+func (*Follows) Descriptor() ([]byte, []int) {
+	return file_twitchhook_v1_notifications_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Follows) GetFollows() []*Follow {
+	if x != nil {
+		return x.Follows
+	}
+	return nil
+}
+
+type Follow struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FromId        string                 `protobuf:"bytes,1,opt,name=from_id,json=fromId,proto3" json:"from_id,omitempty"`
+	FromName      string                 `protobuf:"bytes,2,opt,name=from_name,json=fromName,proto3" json:"from_name,omitempty"`
+	ToId          string                 `protobuf:"bytes,3,opt,name=to_id,json=toId,proto3" json:"to_id,omitempty"`
+	ToName        string                 `protobuf:"bytes,4,opt,name=to_name,json=toName,proto3" json:"to_name,omitempty"`
+	FollowedAt    *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=followed_at,json=followedAt,proto3" json:"followed_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Follow) Reset() {
+	*x = Follow{}
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Follow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Follow) ProtoMessage() {}
+
+func (x *Follow) ProtoReflect() protoreflect.Message {
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Follow.ProtoReflect.Descriptor instead.
+func (*Follow) Descriptor() ([]byte, []int) {
+	return file_twitchhook_v1_notifications_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Follow) GetFromId() string {
+	if x != nil {
+		return x.FromId
+	}
+	return ""
+}
+
+func (x *Follow) GetFromName() string {
+	if x != nil {
+		return x.FromName
+	}
+	return ""
+}
+
+func (x *Follow) GetToId() string {
+	if x != nil {
+		return x.ToId
+	}
+	return ""
+}
+
+func (x *Follow) GetToName() string {
+	if x != nil {
+		return x.ToName
+	}
+	return ""
+}
+
+func (x *Follow) GetFollowedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FollowedAt
+	}
+	return nil
+}
+
+type UserChanged struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Users         []*User                `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UserChanged) Reset() {
+	*x = UserChanged{}
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserChanged) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserChanged) ProtoMessage() {}
+
+func (x *UserChanged) ProtoReflect() protoreflect.Message {
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserChanged.ProtoReflect.Descriptor instead.
+func (*UserChanged) Descriptor() ([]byte, []int) {
+	return file_twitchhook_v1_notifications_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *UserChanged) GetUsers() []*User {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+type User struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Login           string                 `protobuf:"bytes,2,opt,name=login,proto3" json:"login,omitempty"`
+	DisplayName     string                 `protobuf:"bytes,3,opt,name=display_name,json=displayName,proto3" json:"display_name,omitempty"`
+	Type            string                 `protobuf:"bytes,4,opt,name=type,proto3" json:"type,omitempty"`
+	BroadcasterType string                 `protobuf:"bytes,5,opt,name=broadcaster_type,json=broadcasterType,proto3" json:"broadcaster_type,omitempty"`
+	Description     string                 `protobuf:"bytes,6,opt,name=description,proto3" json:"description,omitempty"`
+	ProfileImageUrl string                 `protobuf:"bytes,7,opt,name=profile_image_url,json=profileImageUrl,proto3" json:"profile_image_url,omitempty"`
+	OfflineImageUrl string                 `protobuf:"bytes,8,opt,name=offline_image_url,json=offlineImageUrl,proto3" json:"offline_image_url,omitempty"`
+	ViewCount       int64                  `protobuf:"varint,9,opt,name=view_count,json=viewCount,proto3" json:"view_count,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *User) Reset() {
+	*x = User{}
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *User) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*User) ProtoMessage() {}
+
+func (x *User) ProtoReflect() protoreflect.Message {
+	mi := &file_twitchhook_v1_notifications_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use User.ProtoReflect.Descriptor instead.
+func (*User) Descriptor() ([]byte, []int) {
+	return file_twitchhook_v1_notifications_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *User) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *User) GetLogin() string {
+	if x != nil {
+		return x.Login
+	}
+	return ""
+}
+
+func (x *User) GetDisplayName() string {
+	if x != nil {
+		return x.DisplayName
+	}
+	return ""
+}
+
+func (x *User) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *User) GetBroadcasterType() string {
+	if x != nil {
+		return x.BroadcasterType
+	}
+	return ""
+}
+
+func (x *User) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *User) GetProfileImageUrl() string {
+	if x != nil {
+		return x.ProfileImageUrl
+	}
+	return ""
+}
+
+func (x *User) GetOfflineImageUrl() string {
+	if x != nil {
+		return x.OfflineImageUrl
+	}
+	return ""
+}
+
+func (x *User) GetViewCount() int64 {
+	if x != nil {
+		return x.ViewCount
+	}
+	return 0
+}
+
+var File_twitchhook_v1_notifications_proto protoreflect.FileDescriptor
+
+const file_twitchhook_v1_notifications_proto_rawDesc = "" +
+	"\n" +
+	"!twitchhook/v1/notifications.proto\x12\rtwitchhook.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"r\n" +
+	"\x10SubscribeRequest\x12\x16\n" +
+	"\x06topics\x18\x01 \x03(\tR\x06topics\x12%\n" +
+	"\x0etopic_prefixes\x18\x02 \x03(\tR\rtopicPrefixes\x12\x1f\n" +
+	"\vconsumer_id\x18\x03 \x01(\tR\n" +
+	"consumerId\"\xad\x03\n" +
+	"\fNotification\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05topic\x18\x02 \x01(\tR\x05topic\x12'\n" +
+	"\x0fsubscription_id\x18\x03 \x01(\tR\x0esubscriptionId\x128\n" +
+	"\ttimestamp\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12;\n" +
+	"\vreceived_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"receivedAt\x12\x12\n" +
+	"\x04body\x18\x06 \x01(\fR\x04body\x12E\n" +
+	"\x0estream_changed\x18\a \x01(\v2\x1c.twitchhook.v1.StreamChangedH\x00R\rstreamChanged\x122\n" +
+	"\afollows\x18\b \x01(\v2\x16.twitchhook.v1.FollowsH\x00R\afollows\x12?\n" +
+	"\fuser_changed\x18\t \x01(\v2\x1a.twitchhook.v1.UserChangedH\x00R\vuserChangedB\a\n" +
+	"\x05event\"@\n" +
+	"\rStreamChanged\x12/\n" +
+	"\astreams\x18\x01 \x03(\v2\x15.twitchhook.v1.StreamR\astreams\"\xcd\x02\n" +
+	"\x06Stream\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\x12\x1b\n" +
+	"\tuser_name\x18\x03 \x01(\tR\buserName\x12\x17\n" +
+	"\agame_id\x18\x04 \x01(\tR\x06gameId\x12\x1b\n" +
+	"\tgame_name\x18\x05 \x01(\tR\bgameName\x12\x12\n" +
+	"\x04type\x18\x06 \x01(\tR\x04type\x12\x14\n" +
+	"\x05title\x18\a \x01(\tR\x05title\x12!\n" +
+	"\fviewer_count\x18\b \x01(\x03R\vviewerCount\x129\n" +
+	"\n" +
+	"started_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12\x1a\n" +
+	"\blanguage\x18\n" +
+	" \x01(\tR\blanguage\x12#\n" +
+	"\rthumbnail_url\x18\v \x01(\tR\fthumbnailUrl\":\n" +
+	"\aFollows\x12/\n" +
+	"\afollows\x18\x01 \x03(\v2\x15.twitchhook.v1.FollowR\afollows\"\xa9\x01\n" +
+	"\x06Follow\x12\x17\n" +
+	"\afrom_id\x18\x01 \x01(\tR\x06fromId\x12\x1b\n" +
+	"\tfrom_name\x18\x02 \x01(\tR\bfromName\x12\x13\n" +
+	"\x05to_id\x18\x03 \x01(\tR\x04toId\x12\x17\n" +
+	"\ato_name\x18\x04 \x01(\tR\x06toName\x12;\n" +
+	"\vfollowed_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"followedAt\"8\n" +
+	"\vUserChanged\x12)\n" +
+	"\x05users\x18\x01 \x03(\v2\x13.twitchhook.v1.UserR\x05users\"\xa7\x02\n" +
+	"\x04User\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05login\x18\x02 \x01(\tR\x05login\x12!\n" +
+	"\fdisplay_name\x18\x03 \x01(\tR\vdisplayName\x12\x12\n" +
+	"\x04type\x18\x04 \x01(\tR\x04type\x12)\n" +
+	"\x10broadcaster_type\x18\x05 \x01(\tR\x0fbroadcasterType\x12 \n" +
+	"\vdescription\x18\x06 \x01(\tR\vdescription\x12*\n" +
+	"\x11profile_image_url\x18\a \x01(\tR\x0fprofileImageUrl\x12*\n" +
+	"\x11offline_image_url\x18\b \x01(\tR\x0fofflineImageUrl\x12\x1d\n" +
+	"\n" +
+	"view_count\x18\t \x01(\x03R\tviewCount2b\n" +
+	"\x13NotificationService\x12K\n" +
+	"\tSubscribe\x12\x1f.twitchhook.v1.SubscribeRequest\x1a\x1b.twitchhook.v1.Notification0\x01B;Z9github.com/bsdlp/twitchhook/rpc/twitchhookv1;twitchhookv1b\x06proto3"
+
+var (
+	file_twitchhook_v1_notifications_proto_rawDescOnce sync.Once
+	file_twitchhook_v1_notifications_proto_rawDescData []byte
+)
+
+func file_twitchhook_v1_notifications_proto_rawDescGZIP() []byte {
+	file_twitchhook_v1_notifications_proto_rawDescOnce.Do(func() {
+		file_twitchhook_v1_notifications_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_twitchhook_v1_notifications_proto_rawDesc), len(file_twitchhook_v1_notifications_proto_rawDesc)))
+	})
+	return file_twitchhook_v1_notifications_proto_rawDescData
+}
+
+var file_twitchhook_v1_notifications_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_twitchhook_v1_notifications_proto_goTypes = []any{
+	(*SubscribeRequest)(nil),      // 0: twitchhook.v1.SubscribeRequest
+	(*Notification)(nil),          // 1: twitchhook.v1.Notification
+	(*StreamChanged)(nil),         // 2: twitchhook.v1.StreamChanged
+	(*Stream)(nil),                // 3: twitchhook.v1.Stream
+	(*Follows)(nil),               // 4: twitchhook.v1.Follows
+	(*Follow)(nil),                // 5: twitchhook.v1.Follow
+	(*UserChanged)(nil),           // 6: twitchhook.v1.UserChanged
+	(*User)(nil),                  // 7: twitchhook.v1.User
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_twitchhook_v1_notifications_proto_depIdxs = []int32{
+	8,  // 0: twitchhook.v1.Notification.timestamp:type_name -> google.protobuf.Timestamp
+	8,  // 1: twitchhook.v1.Notification.received_at:type_name -> google.protobuf.Timestamp
+	2,  // 2: twitchhook.v1.Notification.stream_changed:type_name -> twitchhook.v1.StreamChanged
+	4,  // 3: twitchhook.v1.Notification.follows:type_name -> twitchhook.v1.Follows
+	6,  // 4: twitchhook.v1.Notification.user_changed:type_name -> twitchhook.v1.UserChanged
+	3,  // 5: twitchhook.v1.StreamChanged.streams:type_name -> twitchhook.v1.Stream
+	8,  // 6: twitchhook.v1.Stream.started_at:type_name -> google.protobuf.Timestamp
+	5,  // 7: twitchhook.v1.Follows.follows:type_name -> twitchhook.v1.Follow
+	8,  // 8: twitchhook.v1.Follow.followed_at:type_name -> google.protobuf.Timestamp
+	7,  // 9: twitchhook.v1.UserChanged.users:type_name -> twitchhook.v1.User
+	0,  // 10: twitchhook.v1.NotificationService.Subscribe:input_type -> twitchhook.v1.SubscribeRequest
+	1,  // 11: twitchhook.v1.NotificationService.Subscribe:output_type -> twitchhook.v1.Notification
+	11, // [11:12] is the sub-list for method output_type
+	10, // [10:11] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
+}
+
+func init() { file_twitchhook_v1_notifications_proto_init() }
+func file_twitchhook_v1_notifications_proto_init() {
+	if File_twitchhook_v1_notifications_proto != nil {
+		return
+	}
+	file_twitchhook_v1_notifications_proto_msgTypes[1].OneofWrappers = []any{
+		(*Notification_StreamChanged)(nil),
+		(*Notification_Follows)(nil),
+		(*Notification_UserChanged)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_twitchhook_v1_notifications_proto_rawDesc), len(file_twitchhook_v1_notifications_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_twitchhook_v1_notifications_proto_goTypes,
+		DependencyIndexes: file_twitchhook_v1_notifications_proto_depIdxs,
+		MessageInfos:      file_twitchhook_v1_notifications_proto_msgTypes,
+	}.Build()
+	File_twitchhook_v1_notifications_proto = out.File
+	file_twitchhook_v1_notifications_proto_goTypes = nil
+	file_twitchhook_v1_notifications_proto_depIdxs = nil
+}
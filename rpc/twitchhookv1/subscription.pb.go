@@ -0,0 +1,247 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: twitchhook/v1/subscription.proto
+
+package twitchhookv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// SubscriptionRecord is the persisted state of a subscription, the proto
+// encoding of twitchhook.SubscriptionRecord.
+type SubscriptionRecord struct {
+	state                   protoimpl.MessageState `protogen:"open.v1"`
+	Id                      string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Topic                   string                 `protobuf:"bytes,2,opt,name=topic,proto3" json:"topic,omitempty"`
+	CallbackBaseUrl         string                 `protobuf:"bytes,3,opt,name=callback_base_url,json=callbackBaseUrl,proto3" json:"callback_base_url,omitempty"`
+	CallbackUrl             string                 `protobuf:"bytes,4,opt,name=callback_url,json=callbackUrl,proto3" json:"callback_url,omitempty"`
+	Lease                   *durationpb.Duration   `protobuf:"bytes,5,opt,name=lease,proto3" json:"lease,omitempty"`
+	Secret                  string                 `protobuf:"bytes,6,opt,name=secret,proto3" json:"secret,omitempty"`
+	SecretRef               string                 `protobuf:"bytes,7,opt,name=secret_ref,json=secretRef,proto3" json:"secret_ref,omitempty"`
+	Namespace               string                 `protobuf:"bytes,8,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	ExpiresAt               *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Metadata                map[string]string      `protobuf:"bytes,10,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	PreviousSecret          string                 `protobuf:"bytes,11,opt,name=previous_secret,json=previousSecret,proto3" json:"previous_secret,omitempty"`
+	PreviousSecretRef       string                 `protobuf:"bytes,12,opt,name=previous_secret_ref,json=previousSecretRef,proto3" json:"previous_secret_ref,omitempty"`
+	PreviousSecretExpiresAt *timestamppb.Timestamp `protobuf:"bytes,13,opt,name=previous_secret_expires_at,json=previousSecretExpiresAt,proto3" json:"previous_secret_expires_at,omitempty"`
+	unknownFields           protoimpl.UnknownFields
+	sizeCache               protoimpl.SizeCache
+}
+
+func (x *SubscriptionRecord) Reset() {
+	*x = SubscriptionRecord{}
+	mi := &file_twitchhook_v1_subscription_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscriptionRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscriptionRecord) ProtoMessage() {}
+
+func (x *SubscriptionRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_twitchhook_v1_subscription_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscriptionRecord.ProtoReflect.Descriptor instead.
+func (*SubscriptionRecord) Descriptor() ([]byte, []int) {
+	return file_twitchhook_v1_subscription_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubscriptionRecord) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *SubscriptionRecord) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *SubscriptionRecord) GetCallbackBaseUrl() string {
+	if x != nil {
+		return x.CallbackBaseUrl
+	}
+	return ""
+}
+
+func (x *SubscriptionRecord) GetCallbackUrl() string {
+	if x != nil {
+		return x.CallbackUrl
+	}
+	return ""
+}
+
+func (x *SubscriptionRecord) GetLease() *durationpb.Duration {
+	if x != nil {
+		return x.Lease
+	}
+	return nil
+}
+
+func (x *SubscriptionRecord) GetSecret() string {
+	if x != nil {
+		return x.Secret
+	}
+	return ""
+}
+
+func (x *SubscriptionRecord) GetSecretRef() string {
+	if x != nil {
+		return x.SecretRef
+	}
+	return ""
+}
+
+func (x *SubscriptionRecord) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *SubscriptionRecord) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *SubscriptionRecord) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *SubscriptionRecord) GetPreviousSecret() string {
+	if x != nil {
+		return x.PreviousSecret
+	}
+	return ""
+}
+
+func (x *SubscriptionRecord) GetPreviousSecretRef() string {
+	if x != nil {
+		return x.PreviousSecretRef
+	}
+	return ""
+}
+
+func (x *SubscriptionRecord) GetPreviousSecretExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.PreviousSecretExpiresAt
+	}
+	return nil
+}
+
+var File_twitchhook_v1_subscription_proto protoreflect.FileDescriptor
+
+const file_twitchhook_v1_subscription_proto_rawDesc = "" +
+	"\n" +
+	" twitchhook/v1/subscription.proto\x12\rtwitchhook.v1\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x86\x05\n" +
+	"\x12SubscriptionRecord\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05topic\x18\x02 \x01(\tR\x05topic\x12*\n" +
+	"\x11callback_base_url\x18\x03 \x01(\tR\x0fcallbackBaseUrl\x12!\n" +
+	"\fcallback_url\x18\x04 \x01(\tR\vcallbackUrl\x12/\n" +
+	"\x05lease\x18\x05 \x01(\v2\x19.google.protobuf.DurationR\x05lease\x12\x16\n" +
+	"\x06secret\x18\x06 \x01(\tR\x06secret\x12\x1d\n" +
+	"\n" +
+	"secret_ref\x18\a \x01(\tR\tsecretRef\x12\x1c\n" +
+	"\tnamespace\x18\b \x01(\tR\tnamespace\x129\n" +
+	"\n" +
+	"expires_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12K\n" +
+	"\bmetadata\x18\n" +
+	" \x03(\v2/.twitchhook.v1.SubscriptionRecord.MetadataEntryR\bmetadata\x12'\n" +
+	"\x0fprevious_secret\x18\v \x01(\tR\x0epreviousSecret\x12.\n" +
+	"\x13previous_secret_ref\x18\f \x01(\tR\x11previousSecretRef\x12W\n" +
+	"\x1aprevious_secret_expires_at\x18\r \x01(\v2\x1a.google.protobuf.TimestampR\x17previousSecretExpiresAt\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B;Z9github.com/bsdlp/twitchhook/rpc/twitchhookv1;twitchhookv1b\x06proto3"
+
+var (
+	file_twitchhook_v1_subscription_proto_rawDescOnce sync.Once
+	file_twitchhook_v1_subscription_proto_rawDescData []byte
+)
+
+func file_twitchhook_v1_subscription_proto_rawDescGZIP() []byte {
+	file_twitchhook_v1_subscription_proto_rawDescOnce.Do(func() {
+		file_twitchhook_v1_subscription_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_twitchhook_v1_subscription_proto_rawDesc), len(file_twitchhook_v1_subscription_proto_rawDesc)))
+	})
+	return file_twitchhook_v1_subscription_proto_rawDescData
+}
+
+var file_twitchhook_v1_subscription_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_twitchhook_v1_subscription_proto_goTypes = []any{
+	(*SubscriptionRecord)(nil),    // 0: twitchhook.v1.SubscriptionRecord
+	nil,                           // 1: twitchhook.v1.SubscriptionRecord.MetadataEntry
+	(*durationpb.Duration)(nil),   // 2: google.protobuf.Duration
+	(*timestamppb.Timestamp)(nil), // 3: google.protobuf.Timestamp
+}
+var file_twitchhook_v1_subscription_proto_depIdxs = []int32{
+	2, // 0: twitchhook.v1.SubscriptionRecord.lease:type_name -> google.protobuf.Duration
+	3, // 1: twitchhook.v1.SubscriptionRecord.expires_at:type_name -> google.protobuf.Timestamp
+	1, // 2: twitchhook.v1.SubscriptionRecord.metadata:type_name -> twitchhook.v1.SubscriptionRecord.MetadataEntry
+	3, // 3: twitchhook.v1.SubscriptionRecord.previous_secret_expires_at:type_name -> google.protobuf.Timestamp
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_twitchhook_v1_subscription_proto_init() }
+func file_twitchhook_v1_subscription_proto_init() {
+	if File_twitchhook_v1_subscription_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_twitchhook_v1_subscription_proto_rawDesc), len(file_twitchhook_v1_subscription_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_twitchhook_v1_subscription_proto_goTypes,
+		DependencyIndexes: file_twitchhook_v1_subscription_proto_depIdxs,
+		MessageInfos:      file_twitchhook_v1_subscription_proto_msgTypes,
+	}.Build()
+	File_twitchhook_v1_subscription_proto = out.File
+	file_twitchhook_v1_subscription_proto_goTypes = nil
+	file_twitchhook_v1_subscription_proto_depIdxs = nil
+}
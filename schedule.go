@@ -0,0 +1,120 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// MetadataPriority is the metadata key DefaultPriority reads a
+// subscription's priority from
+const MetadataPriority = "priority"
+
+// DefaultSubscribeConcurrency is how many subscriptions SubscribeAll sends
+// at once by default
+const DefaultSubscribeConcurrency = 1
+
+// DefaultPriority is the request's MetadataPriority as an integer, zero when
+// it's missing or not a number
+func DefaultPriority(req SubscriptionRequest) int {
+	p, err := strconv.Atoi(req.Metadata[MetadataPriority])
+	if err != nil {
+		return 0
+	}
+	return p
+}
+
+func (m *TwitchWebhookHandler) priority(req SubscriptionRequest) int {
+	if m.Priority == nil {
+		return DefaultPriority(req)
+	}
+	return m.Priority(req)
+}
+
+// prioritize orders requests by descending priority, keeping the order of
+// requests of the same priority
+func (m *TwitchWebhookHandler) prioritize(requests []SubscriptionRequest) {
+	priorities := make(map[string]int, len(requests))
+	for _, req := range requests {
+		priorities[req.Topic] = m.priority(req)
+	}
+	sort.SliceStable(requests, func(i, j int) bool {
+		return priorities[requests[i].Topic] > priorities[requests[j].Topic]
+	})
+}
+
+// prioritizeSubscriptions orders subscriptions like prioritize, for the
+// restores and migrations of stored subscriptions
+func (m *TwitchWebhookHandler) prioritizeSubscriptions(subs []*Subscription) {
+	priorities := make(map[*Subscription]int, len(subs))
+	for _, sub := range subs {
+		priorities[sub] = m.priority(SubscriptionRequest{
+			Topic:           sub.Topic,
+			CallbackBaseURL: sub.CallbackBaseURL,
+			Lease:           sub.Lease,
+			Metadata:        sub.Metadata,
+		})
+	}
+	sort.SliceStable(subs, func(i, j int) bool {
+		return priorities[subs[i]] > priorities[subs[j]]
+	})
+}
+
+// SubscribeAll subscribes every request, highest Priority first, with up to
+// SubscribeConcurrency requests in flight. Hub requests share the handler's
+// rate limited client, which holds them once the helix bucket is empty, so
+// the requests released when it refills are the most important ones left.
+// Denial callbacks are taken from Callbacks.
+//
+// Errors are returned once every request has been tried, requests not sent
+// before ctx is done fail with its error.
+func (m *TwitchWebhookHandler) SubscribeAll(ctx context.Context, requests []SubscriptionRequest) error {
+	ordered := append([]SubscriptionRequest(nil), requests...)
+	m.prioritize(ordered)
+
+	workers := m.SubscribeConcurrency
+	if workers <= 0 {
+		workers = DefaultSubscribeConcurrency
+	}
+	if workers > len(ordered) {
+		workers = len(ordered)
+	}
+
+	var (
+		mu   sync.Mutex
+		next int
+		errs []error
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				if next == len(ordered) {
+					mu.Unlock()
+					return
+				}
+				req := ordered[next]
+				next++
+				mu.Unlock()
+
+				err := ctx.Err()
+				if err == nil {
+					err = m.SubscribeContext(ctx, req, nil)
+				}
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: %w", req.Topic, err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,202 @@
+// Package schema validates notification bodies against JSON Schemas by topic
+// type, catching changes to twitch's payloads before they're decoded into
+// zero values. Schemas for the topics twitchhook decodes are embedded.
+package schema
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.uber.org/zap"
+)
+
+// Policy is what the Validator does with notifications that don't match
+// their schema
+type Policy string
+
+// Policies
+const (
+	// Reject drops the notification with a 422 response
+	Reject Policy = "reject"
+	// Warn logs the violation and dispatches the notification
+	Warn Policy = "warn"
+	// Pass dispatches the notification, violations are only counted and
+	// reported to OnInvalid
+	Pass Policy = "pass"
+)
+
+//go:embed schemas/*.json
+var embedded embed.FS
+
+// ValidationError describes a notification that doesn't match its schema
+type ValidationError struct {
+	Topic string
+	// Type is the schema's key, such as helix.streams
+	Type string
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("schema: %s notification doesn't match schema: %v", e.Type, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validator checks notifications against the schema of their type,
+// notifications of types without a schema pass.
+type Validator struct {
+	// Policy defaults to Warn
+	Policy Policy
+
+	// Schemas are JSON Schema documents by type, adding to or replacing
+	// the embedded ones
+	Schemas map[string]string
+
+	// Type picks a notification's schema, defaults to the
+	// twitchhook.TopicType of its topic
+	Type func(n *twitchhook.Notification) string
+
+	// Logger defaults to a no-op logger
+	Logger *zap.Logger
+
+	// Metrics counts violations as twitchhook_schema_violations_total
+	Metrics twitchhook.Metrics
+
+	// OnInvalid is called for every violation, whatever the Policy
+	OnInvalid func(n *twitchhook.Notification, err *ValidationError)
+
+	once    sync.Once
+	schemas map[string]*jsonschema.Schema
+	err     error
+}
+
+// Compile compiles the schemas, returning the first error. It's called by
+// Validate, call it on startup to report broken Schemas early.
+func (v *Validator) Compile() error {
+	v.once.Do(v.compile)
+	return v.err
+}
+
+func (v *Validator) compile() {
+	docs := make(map[string][]byte)
+	entries, err := embedded.ReadDir("schemas")
+	if err != nil {
+		v.err = err
+		return
+	}
+	for _, e := range entries {
+		bs, err := embedded.ReadFile(path.Join("schemas", e.Name()))
+		if err != nil {
+			v.err = err
+			return
+		}
+		docs[strings.TrimSuffix(e.Name(), ".json")] = bs
+	}
+	for typ, doc := range v.Schemas {
+		docs[typ] = []byte(doc)
+	}
+
+	c := jsonschema.NewCompiler()
+	c.AssertFormat()
+	v.schemas = make(map[string]*jsonschema.Schema, len(docs))
+	for typ, doc := range docs {
+		loc := "twitchhook:///schemas/" + typ + ".json"
+		parsed, err := jsonschema.UnmarshalJSON(bytes.NewReader(doc))
+		if err != nil {
+			v.err = fmt.Errorf("schema: parsing %s schema: %w", typ, err)
+			return
+		}
+		err = c.AddResource(loc, parsed)
+		if err != nil {
+			v.err = fmt.Errorf("schema: adding %s schema: %w", typ, err)
+			return
+		}
+		sch, err := c.Compile(loc)
+		if err != nil {
+			v.err = fmt.Errorf("schema: compiling %s schema: %w", typ, err)
+			return
+		}
+		v.schemas[typ] = sch
+	}
+}
+
+// Validate returns a *ValidationError when n's body doesn't match the schema
+// of its type
+func (v *Validator) Validate(n *twitchhook.Notification) error {
+	err := v.Compile()
+	if err != nil {
+		return err
+	}
+
+	typ := twitchhook.TopicType(n.Topic)
+	if v.Type != nil {
+		typ = v.Type(n)
+	}
+	sch, ok := v.schemas[typ]
+	if !ok {
+		return nil
+	}
+
+	body, err := jsonschema.UnmarshalJSON(bytes.NewReader(n.Body))
+	if err == nil {
+		err = sch.Validate(body)
+	}
+	if err != nil {
+		return &ValidationError{Topic: n.Topic, Type: typ, Err: err}
+	}
+	return nil
+}
+
+// Middleware validates notifications before calling next, applying the
+// Policy to violations. Apply it to the NotificationHandler rather than the
+// handler's Middleware so only verified notifications are validated.
+func (v *Validator) Middleware() twitchhook.Middleware {
+	return func(next twitchhook.NotificationHandler) twitchhook.NotificationHandler {
+		return twitchhook.NotificationHandlerFunc(func(ctx context.Context, n *twitchhook.Notification) error {
+			err := v.Validate(n)
+			vErr, ok := err.(*ValidationError)
+			if err != nil && !ok {
+				return err
+			}
+			if ok {
+				policy := v.policy()
+				if v.Metrics != nil {
+					v.Metrics.IncCounter("twitchhook_schema_violations_total", "type", vErr.Type, "policy", string(policy))
+				}
+				if v.OnInvalid != nil {
+					v.OnInvalid(n, vErr)
+				}
+				switch policy {
+				case Reject:
+					return twitchhook.Reject(vErr)
+				case Warn:
+					v.logger().Warn("notification doesn't match schema", zap.String("topic", n.Topic), zap.String("type", vErr.Type), zap.Error(vErr.Err))
+				}
+			}
+			return next.HandleNotification(ctx, n)
+		})
+	}
+}
+
+func (v *Validator) policy() Policy {
+	if v.Policy == "" {
+		return Warn
+	}
+	return v.Policy
+}
+
+func (v *Validator) logger() *zap.Logger {
+	if v.Logger == nil {
+		return zap.NewNop()
+	}
+	return v.Logger
+}
@@ -0,0 +1,227 @@
+package twitchhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultSecretBytes is the number of random bytes in generated secrets
+const DefaultSecretBytes = 64
+
+// Defaults for the cache of secrets resolved through a SecretProvider
+const (
+	DefaultSecretCacheTTL  = 5 * time.Minute
+	DefaultSecretCacheSize = 10000
+)
+
+// DefaultSecretOverlap is how long a secret rotated out by a renewal keeps
+// verifying notifications
+const DefaultSecretOverlap = 10 * time.Minute
+
+// Bounds on encoded secret length. The hub requires secrets shorter than 200
+// bytes, the minimum keeps secrets from being guessable.
+const (
+	MinSecretLength = 10
+	MaxSecretLength = 199
+)
+
+// SecretEncoding is how random secret bytes are encoded
+type SecretEncoding string
+
+// Secret encodings
+const (
+	// SecretHex encodes secrets as lower case hex, two characters per byte
+	SecretHex SecretEncoding = "hex"
+	// SecretBase64 encodes secrets as unpadded url-safe base64, four
+	// characters per three bytes
+	SecretBase64 SecretEncoding = "base64"
+)
+
+// encodedLen returns the length of n bytes in the encoding
+func (e SecretEncoding) encodedLen(n int) (int, error) {
+	switch e {
+	case "", SecretHex:
+		return hex.EncodedLen(n), nil
+	case SecretBase64:
+		return base64.RawURLEncoding.EncodedLen(n), nil
+	default:
+		return 0, fmt.Errorf("unknown secret encoding %q", e)
+	}
+}
+
+func (e SecretEncoding) encode(bs []byte) string {
+	if e == SecretBase64 {
+		return base64.RawURLEncoding.EncodeToString(bs)
+	}
+	return hex.EncodeToString(bs)
+}
+
+// checkSecretOptions validates SecretBytes and SecretEncoding against the
+// secret length bounds
+func (m *TwitchWebhookHandler) checkSecretOptions() error {
+	encoding := m.SecretEncoding
+	if encoding == "" {
+		encoding = SecretHex
+	}
+	n, err := encoding.encodedLen(m.secretBytes())
+	if err != nil {
+		return err
+	}
+	if n < MinSecretLength || n > MaxSecretLength {
+		return fmt.Errorf("%d byte %s secrets are %d characters, secrets must be %d to %d characters", m.secretBytes(), encoding, n, MinSecretLength, MaxSecretLength)
+	}
+	return nil
+}
+
+func (m *TwitchWebhookHandler) secretBytes() int {
+	if m.SecretBytes <= 0 {
+		return DefaultSecretBytes
+	}
+	return m.SecretBytes
+}
+
+// SecretProvider generates and resolves subscription secrets through an
+// external secret manager, so raw secrets aren't held in the
+// SubscriptionManager. GenerateSecret returns the secret sent to the hub and
+// an opaque reference, which is stored as Subscription.SecretRef and passed
+// to ResolveSecret when verifying notifications. Resolved secrets are cached
+// in memory by reference for the handler's SecretCacheTTL, so notifications
+// don't wait on the secret manager and keep verifying through short outages.
+type SecretProvider interface {
+	GenerateSecret(ctx context.Context, topic string) (secret, ref string, err error)
+	ResolveSecret(ctx context.Context, ref string) (string, error)
+}
+
+// ErrSecretUnavailable is returned when a subscription has neither a secret
+// nor a reference a SecretProvider can resolve
+var ErrSecretUnavailable = errors.New("subscription secret is unavailable")
+
+// newSecret generates a secret for topic, returning the secret sent to the
+// hub, the secret to store and the reference to store
+func (m *TwitchWebhookHandler) newSecret(ctx context.Context, topic string) (secret, stored, ref string, err error) {
+	if m.SecretProvider != nil {
+		secret, ref, err = m.SecretProvider.GenerateSecret(ctx, topic)
+		if err == nil && (len(secret) < MinSecretLength || len(secret) > MaxSecretLength) {
+			err = fmt.Errorf("SecretProvider returned a %d character secret, secrets must be %d to %d characters", len(secret), MinSecretLength, MaxSecretLength)
+		}
+		if err == nil {
+			m.cacheSecret(ref, secret)
+		}
+		return secret, "", ref, err
+	}
+
+	err = m.checkSecretOptions()
+	if err != nil {
+		return "", "", "", err
+	}
+	key := make([]byte, m.secretBytes())
+	_, err = rand.Read(key)
+	if err != nil {
+		return "", "", "", err
+	}
+	secret = m.SecretEncoding.encode(key)
+	return secret, secret, "", nil
+}
+
+// subscriptionSecret returns the secret for sub, resolving it through the
+// SecretProvider when only a reference is stored
+func (m *TwitchWebhookHandler) subscriptionSecret(ctx context.Context, sub *Subscription) (string, error) {
+	return m.resolveSecret(ctx, sub.Secret, sub.SecretRef)
+}
+
+// previousSubscriptionSecret returns the secret sub's last renewal rotated
+// out
+func (m *TwitchWebhookHandler) previousSubscriptionSecret(ctx context.Context, sub *Subscription) (string, error) {
+	return m.resolveSecret(ctx, sub.PreviousSecret, sub.PreviousSecretRef)
+}
+
+func (m *TwitchWebhookHandler) resolveSecret(ctx context.Context, secret, ref string) (string, error) {
+	if secret != "" || ref == "" {
+		return secret, nil
+	}
+	if m.SecretProvider == nil {
+		return "", ErrSecretUnavailable
+	}
+	if secret, ok := m.secretCache.get(ref, clockOrDefault(m.Clock).Now()); ok {
+		return secret, nil
+	}
+	secret, err := m.SecretProvider.ResolveSecret(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	m.cacheSecret(ref, secret)
+	return secret, nil
+}
+
+func (m *TwitchWebhookHandler) cacheSecret(ref, secret string) {
+	ttl := m.SecretCacheTTL
+	if ttl == 0 {
+		ttl = DefaultSecretCacheTTL
+	}
+	size := m.SecretCacheSize
+	if size <= 0 {
+		size = DefaultSecretCacheSize
+	}
+	if ttl < 0 || ref == "" {
+		return
+	}
+	m.secretCache.put(ref, secret, clockOrDefault(m.Clock).Now(), ttl, size)
+}
+
+// secretCache holds secrets resolved by reference until they expire
+type secretCache struct {
+	m       sync.Mutex
+	entries map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	secret    string
+	expiresAt time.Time
+}
+
+func (c *secretCache) get(ref string, now time.Time) (string, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	e, ok := c.entries[ref]
+	if !ok || !now.Before(e.expiresAt) {
+		return "", false
+	}
+	return e.secret, true
+}
+
+// put caches secret for ttl, making room past size by dropping expired
+// entries and then arbitrary ones
+func (c *secretCache) put(ref, secret string, now time.Time, ttl time.Duration, size int) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cachedSecret)
+	}
+	if _, ok := c.entries[ref]; !ok && len(c.entries) >= size {
+		for k, e := range c.entries {
+			if !now.Before(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < size {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[ref] = cachedSecret{secret: secret, expiresAt: now.Add(ttl)}
+}
+
+func (m *TwitchWebhookHandler) secretOverlap() time.Duration {
+	if m.SecretOverlap == 0 {
+		return DefaultSecretOverlap
+	}
+	return m.SecretOverlap
+}
@@ -0,0 +1,93 @@
+package twitchhook_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type countingProvider struct {
+	resolves int
+	err      error
+}
+
+func (p *countingProvider) GenerateSecret(ctx context.Context, topic string) (string, string, error) {
+	return testSecret, "ref-" + topic, nil
+}
+
+func (p *countingProvider) ResolveSecret(ctx context.Context, ref string) (string, error) {
+	p.resolves++
+	if p.err != nil {
+		return "", p.err
+	}
+	return testSecret, nil
+}
+
+func TestSecretProviderResolutionsAreCached(t *testing.T) {
+	h, clock := newTestHandler(t)
+	provider := &countingProvider{}
+	h.SecretProvider = provider
+	sub := saveSubscription(t, h, testTopic, "")
+	sub.SecretRef = "ref"
+
+	for i := 0; i < 3; i++ {
+		if !validate(t, h, signedRequest(t, sub, "sha256", testSecret, testBody)) {
+			t.Fatal("notification signed with the resolved secret didn't verify")
+		}
+	}
+	if provider.resolves != 1 {
+		t.Fatalf("resolved %d times for 3 notifications, want 1", provider.resolves)
+	}
+
+	// cached secrets outlive short outages
+	provider.err = errors.New("vault unavailable")
+	if !validate(t, h, signedRequest(t, sub, "sha256", testSecret, testBody)) {
+		t.Fatal("cached secret didn't verify during an outage")
+	}
+
+	clock.Advance(h.SecretCacheTTL + 5*time.Minute + time.Second)
+	if _, err := h.ValidateSignature(signedRequest(t, sub, "sha256", testSecret, testBody)); err == nil {
+		t.Fatal("expired cache entry was used instead of resolving again")
+	}
+	provider.err = nil
+	validate(t, h, signedRequest(t, sub, "sha256", testSecret, testBody))
+	if provider.resolves != 3 {
+		t.Fatalf("resolved %d times, want a resolution after expiry", provider.resolves)
+	}
+}
+
+func TestSecretCacheDisabled(t *testing.T) {
+	h, _ := newTestHandler(t)
+	provider := &countingProvider{}
+	h.SecretProvider = provider
+	h.SecretCacheTTL = -1
+	sub := saveSubscription(t, h, testTopic, "")
+	sub.SecretRef = "ref"
+
+	for i := 0; i < 3; i++ {
+		validate(t, h, signedRequest(t, sub, "sha256", testSecret, testBody))
+	}
+	if provider.resolves != 3 {
+		t.Fatalf("resolved %d times with the cache disabled, want 3", provider.resolves)
+	}
+}
+
+func TestSecretCacheIsBounded(t *testing.T) {
+	h, _ := newTestHandler(t)
+	provider := &countingProvider{}
+	h.SecretProvider = provider
+	h.SecretCacheSize = 1
+
+	first := saveSubscription(t, h, testTopic, "")
+	first.SecretRef = "first"
+	second := saveSubscription(t, h, testTopic+"2", "")
+	second.SecretRef = "second"
+
+	validate(t, h, signedRequest(t, first, "sha256", testSecret, testBody))
+	validate(t, h, signedRequest(t, second, "sha256", testSecret, testBody))
+	validate(t, h, signedRequest(t, first, "sha256", testSecret, testBody))
+	if provider.resolves != 3 {
+		t.Fatalf("resolved %d times, want the first secret evicted by the second", provider.resolves)
+	}
+}
@@ -0,0 +1,231 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// MinSecretEntropyBits is the estimated entropy below which CheckSecrets
+// reports a secret as weak
+const MinSecretEntropyBits = 64
+
+// SecretProblem is why CheckSecrets reports a subscription's secret
+type SecretProblem string
+
+// Secret problems
+const (
+	// SecretDuplicate secrets are shared with another subscription
+	SecretDuplicate SecretProblem = "duplicate"
+	// SecretLength secrets are outside MinSecretLength and MaxSecretLength
+	// or shorter than those the handler generates
+	SecretLength SecretProblem = "length"
+	// SecretWeak secrets repeat a pattern or have too little entropy to be
+	// random, such as secrets picked by hand
+	SecretWeak SecretProblem = "weak"
+)
+
+// SecretFinding is a stored subscription whose secret is out of policy
+type SecretFinding struct {
+	Topic    string
+	Problems []SecretProblem
+}
+
+// SecretAuditPolicy is what Start does with stored secrets that are out of
+// policy, such as those imported from older versions
+type SecretAuditPolicy string
+
+// Secret audit policies
+const (
+	// SecretAuditOff doesn't check secrets, it's the default
+	SecretAuditOff SecretAuditPolicy = ""
+	// SecretAuditLog logs the findings of CheckSecrets
+	SecretAuditLog SecretAuditPolicy = "log"
+	// SecretAuditRepair rotates the secrets of findings with RepairSecrets
+	SecretAuditRepair SecretAuditPolicy = "repair"
+)
+
+func (p SecretAuditPolicy) check() error {
+	switch p {
+	case SecretAuditOff, SecretAuditLog, SecretAuditRepair:
+		return nil
+	default:
+		return fmt.Errorf("unknown secret audit policy %q", p)
+	}
+}
+
+// CheckSecrets reports stored subscriptions whose secrets are duplicated,
+// of the wrong length or weak, ordered by topic. Secrets stored as
+// references are resolved through the SecretProvider. The Manager must be a
+// SubscriptionLister.
+func (m *TwitchWebhookHandler) CheckSecrets(ctx context.Context) ([]SecretFinding, error) {
+	_, findings, err := m.checkSecrets(ctx)
+	return findings, err
+}
+
+func (m *TwitchWebhookHandler) checkSecrets(ctx context.Context) (map[string]*Subscription, []SecretFinding, error) {
+	lister, ok := m.Manager.(SubscriptionLister)
+	if !ok {
+		return nil, nil, ErrNotSupported
+	}
+	subs, err := lister.List()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// generated secrets are as long as the handler's options make them,
+	// unless a SecretProvider picks them
+	minLength := MinSecretLength
+	if m.SecretProvider == nil {
+		if n, err := m.SecretEncoding.encodedLen(m.secretBytes()); err == nil && n > minLength {
+			minLength = n
+		}
+	}
+
+	var errs []error
+	byTopic := make(map[string]*Subscription, len(subs))
+	problems := make(map[string][]SecretProblem)
+	topicsBySecret := make(map[string][]string)
+	for _, sub := range subs {
+		secret, err := m.subscriptionSecret(ctx, sub)
+		if err == nil && secret == "" {
+			err = ErrSecretUnavailable
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sub.Topic, err))
+			continue
+		}
+		byTopic[sub.Topic] = sub
+		topicsBySecret[secret] = append(topicsBySecret[secret], sub.Topic)
+
+		if len(secret) < minLength || len(secret) > MaxSecretLength {
+			problems[sub.Topic] = append(problems[sub.Topic], SecretLength)
+		}
+		if weakSecret(secret) {
+			problems[sub.Topic] = append(problems[sub.Topic], SecretWeak)
+		}
+	}
+	for _, topics := range topicsBySecret {
+		if len(topics) < 2 {
+			continue
+		}
+		for _, topic := range topics {
+			problems[topic] = append([]SecretProblem{SecretDuplicate}, problems[topic]...)
+		}
+	}
+
+	findings := make([]SecretFinding, 0, len(problems))
+	for topic, p := range problems {
+		findings = append(findings, SecretFinding{Topic: topic, Problems: p})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Topic < findings[j].Topic
+	})
+	return byTopic, findings, errors.Join(errs...)
+}
+
+// RepairSecrets rotates the secrets of the subscriptions CheckSecrets
+// reports, returning the findings. Topics are subscribed again with a new id
+// and secret, once the hub confirms them the old callbacks are unsubscribed
+// like by MigrateCallbackBase. Topics are subscribed in Priority order, those
+// that aren't confirmed keep their old subscription.
+func (m *TwitchWebhookHandler) RepairSecrets(ctx context.Context) ([]SecretFinding, error) {
+	m.once.Do(m.setup)
+
+	subs, findings, err := m.checkSecrets(ctx)
+	if len(findings) == 0 {
+		return findings, err
+	}
+	errs := []error{err}
+
+	flagged := make([]*Subscription, len(findings))
+	problems := make(map[string][]SecretProblem, len(findings))
+	for i, f := range findings {
+		flagged[i] = subs[f.Topic]
+		problems[f.Topic] = f.Problems
+	}
+	m.prioritizeSubscriptions(flagged)
+
+	now := clockOrDefault(m.Clock).Now()
+	var migrations []*migration
+	for _, sub := range flagged {
+		m.logger().Info("rotating subscription secret", zap.String("topic", sub.Topic), zap.Any("problems", problems[sub.Topic]))
+		m.metrics().IncCounter("twitchhook_secret_rotations_total")
+		request := SubscriptionRequest{
+			Topic:           sub.Topic,
+			CallbackBaseURL: sub.CallbackBaseURL,
+			Lease:           sub.Lease,
+			Metadata:        sub.Metadata,
+		}
+		if sub.State(now) == SubscriptionExpired {
+			// the hub no longer delivers to the old callback
+			err = m.subscribeWith(ctx, request, m.denialCallback(sub), true, true)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", sub.Topic, err))
+			}
+			continue
+		}
+		mg := &migration{old: sub}
+		mg.err = m.subscribeWith(ctx, request, m.denialCallback(sub), true, true)
+		migrations = append(migrations, mg)
+	}
+	errs = append(errs, m.completeMigrations(ctx, migrations))
+	return findings, errors.Join(errs...)
+}
+
+// auditSecrets applies the SecretAudit policy on Start
+func (m *TwitchWebhookHandler) auditSecrets(ctx context.Context) error {
+	switch m.SecretAudit {
+	case SecretAuditLog:
+		findings, err := m.CheckSecrets(ctx)
+		for _, f := range findings {
+			m.logger().Warn("subscription secret is out of policy", zap.String("topic", f.Topic), zap.Any("problems", f.Problems))
+		}
+		return err
+	case SecretAuditRepair:
+		_, err := m.RepairSecrets(ctx)
+		return err
+	}
+	return nil
+}
+
+// weakSecret reports whether secret repeats a shorter pattern or has less
+// than MinSecretEntropyBits of estimated entropy. The estimate is the
+// secret's length times the Shannon entropy of its characters, which
+// random hex or base64 secrets of the default length far exceed.
+func weakSecret(secret string) bool {
+	n := len(secret)
+	if n == 0 {
+		return true
+	}
+	for period := 1; period <= n/2; period++ {
+		if n%period != 0 {
+			continue
+		}
+		repeated := true
+		for i := period; i < n; i++ {
+			if secret[i] != secret[i-period] {
+				repeated = false
+				break
+			}
+		}
+		if repeated {
+			return true
+		}
+	}
+
+	counts := make(map[byte]int)
+	for i := 0; i < n; i++ {
+		counts[secret[i]]++
+	}
+	var entropy float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy*float64(n) < MinSecretEntropyBits
+}
@@ -0,0 +1,140 @@
+// Package vault generates subscription secrets with the HashiCorp Vault
+// transit secrets engine.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultMount is the transit engine mount used when Mount is empty
+const DefaultMount = "transit"
+
+// DefaultSecretBits is the size of generated data keys
+const DefaultSecretBits = 256
+
+// Provider is a twitchhook.SecretProvider generating secrets from transit
+// data keys. The wrapped data key is stored as the secret reference, so the
+// raw secret is only ever held in memory, where the handler caches it for its
+// SecretCacheTTL rather than calling transit/decrypt for every notification.
+// It's also a twitchhook.KeyWrapper for use with EncryptedManager.
+type Provider struct {
+	// Address is the vault server address, e.g. https://vault:8200
+	Address   string
+	Token     string
+	Namespace string
+	// Mount is the transit engine mount, defaults to DefaultMount
+	Mount string
+	// Key is the name of the transit key
+	Key string
+
+	HTTPClient *http.Client
+}
+
+// Error is returned when vault responds with an error
+type Error struct {
+	Status int
+	Errors []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("vault: %d: %s", e.Status, strings.Join(e.Errors, ", "))
+}
+
+type transitData struct {
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+	Bits       int    `json:"bits,omitempty"`
+}
+
+// GenerateSecret implements twitchhook.SecretProvider
+func (p *Provider) GenerateSecret(ctx context.Context, topic string) (string, string, error) {
+	data, err := p.do(ctx, "datakey/plaintext", transitData{Bits: DefaultSecretBits})
+	if err != nil {
+		return "", "", err
+	}
+	key, err := base64.StdEncoding.DecodeString(data.Plaintext)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(key), data.Ciphertext, nil
+}
+
+// ResolveSecret implements twitchhook.SecretProvider
+func (p *Provider) ResolveSecret(ctx context.Context, ref string) (string, error) {
+	key, err := p.UnwrapKey(ctx, []byte(ref))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// WrapKey implements twitchhook.KeyWrapper
+func (p *Provider) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	data, err := p.do(ctx, "encrypt", transitData{Plaintext: base64.StdEncoding.EncodeToString(key)})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data.Ciphertext), nil
+}
+
+// UnwrapKey implements twitchhook.KeyWrapper
+func (p *Provider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	data, err := p.do(ctx, "decrypt", transitData{Ciphertext: string(wrapped)})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(data.Plaintext)
+}
+
+func (p *Provider) do(ctx context.Context, op string, in transitData) (*transitData, error) {
+	mount := p.Mount
+	if mount == "" {
+		mount = DefaultMount
+	}
+	u := strings.TrimSuffix(p.Address, "/") + "/v1/" + mount + "/" + op + "/" + url.PathEscape(p.Key)
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", p.Token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Data   transitData `json:"data"`
+		Errors []string    `json:"errors"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode/100 != 2 {
+		return nil, &Error{Status: resp.StatusCode, Errors: out.Errors}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
@@ -0,0 +1,132 @@
+package twitchhook
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCacheShards is the number of shards of a ShardedCache when Shards
+// isn't set
+const DefaultCacheShards = 32
+
+// ShardedCache is an in-memory SubscriptionManager that spreads topics over
+// several InMemoryCaches by hash so lookups for different topics rarely
+// contend on a lock. Its fields must be set before first use.
+type ShardedCache struct {
+	// Clock schedules lease renewals, defaults to SystemClock
+	Clock Clock
+
+	// Shards defaults to DefaultCacheShards
+	Shards int
+
+	// MaxSize caps the number of cached subscriptions, it's split evenly
+	// between shards and each shard evicts its least recently used
+	// subscription. Zero means no limit.
+	MaxSize int
+
+	// OnEvict is called with subscriptions evicted to stay within MaxSize
+	OnEvict func(sub *Subscription)
+
+	shards []*InMemoryCache
+	once   sync.Once
+}
+
+func (c *ShardedCache) setup() {
+	n := c.Shards
+	if n <= 0 {
+		n = DefaultCacheShards
+	}
+	var perShard int
+	if c.MaxSize > 0 {
+		perShard = (c.MaxSize + n - 1) / n
+	}
+
+	c.shards = make([]*InMemoryCache, n)
+	for i := range c.shards {
+		c.shards[i] = NewLRUCache(c.Clock, perShard, c.OnEvict)
+	}
+}
+
+func (c *ShardedCache) shard(topic string) *InMemoryCache {
+	c.once.Do(c.setup)
+
+	// fnv-1a, inlined so lookups don't go through hash.Hash32
+	h := uint32(2166136261)
+	for i := 0; i < len(topic); i++ {
+		h ^= uint32(topic[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
+// Get retrieves a subscription, returning ErrSubscriptionNotFound if there
+// isn't one for topic
+func (c *ShardedCache) Get(topic string) (*Subscription, error) {
+	return c.shard(topic).Get(topic)
+}
+
+// Save caches a subscription, replacing any existing subscription to topic
+func (c *ShardedCache) Save(topic string, sub *Subscription) error {
+	return c.shard(topic).Save(topic, sub)
+}
+
+// Delete removes a subscription
+func (c *ShardedCache) Delete(topic string) error {
+	return c.shard(topic).Delete(topic)
+}
+
+// SetSubscriptionLease sets a subscription's lease
+func (c *ShardedCache) SetSubscriptionLease(topic string, lease time.Duration) (bool, error) {
+	return c.shard(topic).SetSubscriptionLease(topic, lease)
+}
+
+// RenewalAt implements RenewalScheduler
+func (c *ShardedCache) RenewalAt(topic string) (time.Time, error) {
+	return c.shard(topic).RenewalAt(topic)
+}
+
+// GetByID retrieves a subscription by its id, ids don't name their shard so
+// every shard is searched
+func (c *ShardedCache) GetByID(id SubscriptionID) (*Subscription, error) {
+	c.once.Do(c.setup)
+
+	for _, shard := range c.shards {
+		sub, err := shard.GetByID(id)
+		if err == nil {
+			return sub, nil
+		}
+	}
+	return nil, ErrSubscriptionNotFound
+}
+
+// List returns every cached subscription
+func (c *ShardedCache) List() ([]*Subscription, error) {
+	c.once.Do(c.setup)
+
+	var subs []*Subscription
+	for _, shard := range c.shards {
+		shardSubs, _ := shard.List()
+		subs = append(subs, shardSubs...)
+	}
+	return subs, nil
+}
+
+// Len returns the number of cached subscriptions
+func (c *ShardedCache) Len() int {
+	c.once.Do(c.setup)
+
+	var n int
+	for _, shard := range c.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// NotifyEviction implements EvictionNotifier
+func (c *ShardedCache) NotifyEviction(f func(sub *Subscription)) {
+	c.once.Do(c.setup)
+
+	for _, shard := range c.shards {
+		shard.NotifyEviction(f)
+	}
+}
@@ -0,0 +1,97 @@
+package twitchhook_test
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+)
+
+const benchTopics = 1024
+
+func benchTopic(i int) string {
+	return "https://api.twitch.tv/helix/streams?user_id=" + strconv.Itoa(i)
+}
+
+func fillCache(b *testing.B, m twitchhook.SubscriptionManager) {
+	b.Helper()
+	for i := 0; i < benchTopics; i++ {
+		topic := benchTopic(i)
+		if err := m.Save(topic, &twitchhook.Subscription{Topic: topic, Lease: time.Hour}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkGet reads from m on every P, each goroutine walking the topics
+// from its own offset so they contend like concurrent callbacks do
+func benchmarkGet(b *testing.B, m twitchhook.SubscriptionManager) {
+	fillCache(b, m)
+	topics := make([]string, benchTopics)
+	for i := range topics {
+		topics[i] = benchTopic(i)
+	}
+	var offset atomic.Int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(offset.Add(97))
+		for pb.Next() {
+			if _, err := m.Get(topics[i%benchTopics]); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkInMemoryCacheGet(b *testing.B) {
+	benchmarkGet(b, twitchhook.NewInMemoryCache(clocktest.NewClock(epoch)))
+}
+
+// an LRU moves entries on every Get, so every lookup takes the write lock
+func BenchmarkInMemoryCacheGetLRU(b *testing.B) {
+	benchmarkGet(b, twitchhook.NewLRUCache(clocktest.NewClock(epoch), 2*benchTopics, nil))
+}
+
+func BenchmarkShardedCacheGet(b *testing.B) {
+	benchmarkGet(b, &twitchhook.ShardedCache{Clock: clocktest.NewClock(epoch)})
+}
+
+func BenchmarkShardedCacheGetLRU(b *testing.B) {
+	benchmarkGet(b, &twitchhook.ShardedCache{Clock: clocktest.NewClock(epoch), MaxSize: 2 * benchTopics})
+}
+
+func TestShardedCacheMaxSize(t *testing.T) {
+	var evicted int
+	cache := &twitchhook.ShardedCache{
+		Clock:   clocktest.NewClock(epoch),
+		Shards:  4,
+		MaxSize: 8,
+		OnEvict: func(*twitchhook.Subscription) { evicted++ },
+	}
+	for i := 0; i < 100; i++ {
+		topic := benchTopic(i)
+		if err := cache.Save(topic, &twitchhook.Subscription{Topic: topic, Lease: time.Hour}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := cache.Len(); n > 8 {
+		t.Fatalf("Len = %d, want at most MaxSize", n)
+	}
+	if evicted != 100-cache.Len() {
+		t.Fatalf("evicted %d of 100 subscriptions with %d cached", evicted, cache.Len())
+	}
+
+	last := benchTopic(99)
+	sub, err := cache.Get(last)
+	if err != nil || sub.Topic != last {
+		t.Fatalf("Get(most recent) = %v, %v", sub, err)
+	}
+	if _, err := cache.Get(benchTopic(-1)); err != twitchhook.ErrSubscriptionNotFound {
+		t.Fatalf("Get(unknown) error = %v, want ErrSubscriptionNotFound", err)
+	}
+}
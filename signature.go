@@ -0,0 +1,125 @@
+package twitchhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// HeaderSignature carries the notification signature as
+// "<algorithm>=<hex digest>"
+const HeaderSignature = "X-Hub-Signature"
+
+// UnsupportedAlgorithmError is returned for signatures made with an algorithm
+// other than sha1, sha256 or sha512
+type UnsupportedAlgorithmError struct {
+	Algorithm string
+}
+
+func (e *UnsupportedAlgorithmError) Error() string {
+	return fmt.Sprintf("unsupported signature algorithm %q", e.Algorithm)
+}
+
+var signatureAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Sign returns the HeaderSignature value of body signed with secret, as the
+// hub signs notifications. algorithm is sha1, sha256 or sha512.
+func Sign(algorithm, secret string, body []byte) (string, error) {
+	newHash, ok := signatureAlgorithms[algorithm]
+	if !ok {
+		return "", &UnsupportedAlgorithmError{Algorithm: algorithm}
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return algorithm + "=" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// signatureSizes are the digest sizes of signatureAlgorithms, looked up
+// without constructing a hash
+var signatureSizes = [...]struct {
+	algorithm string
+	size      int
+}{
+	{"sha1", sha1.Size},
+	{"sha256", sha256.Size},
+	{"sha512", sha512.Size},
+}
+
+// signature is a parsed signature header, it's kept off the heap
+type signature struct {
+	algorithm string
+	size      int
+	mac       [sha512.Size]byte
+}
+
+// digest returns the signature's HMAC
+func (s *signature) digest() []byte {
+	return s.mac[:s.size]
+}
+
+// parseSignature parses a signature header, returning ErrInvalidSignature
+// when it's missing or malformed. It doesn't allocate for well formed
+// headers.
+func parseSignature(header string) (signature, error) {
+	var sig signature
+	i := strings.IndexByte(header, '=')
+	if i < 0 {
+		return sig, ErrInvalidSignature
+	}
+
+	for _, a := range signatureSizes {
+		if strings.EqualFold(header[:i], a.algorithm) {
+			sig.algorithm, sig.size = a.algorithm, a.size
+			break
+		}
+	}
+	if sig.algorithm == "" {
+		return sig, &UnsupportedAlgorithmError{Algorithm: header[:i]}
+	}
+
+	if !decodeHex(sig.digest(), header[i+1:]) {
+		return sig, ErrInvalidSignature
+	}
+	return sig, nil
+}
+
+// decodeHex decodes s into dst, reporting whether s is the hex encoding of
+// exactly len(dst) bytes
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) {
+		return false
+	}
+	for i := range dst {
+		hi, ok := fromHexChar(s[2*i])
+		if !ok {
+			return false
+		}
+		lo, ok := fromHexChar(s[2*i+1])
+		if !ok {
+			return false
+		}
+		dst[i] = hi<<4 | lo
+	}
+	return true
+}
+
+func fromHexChar(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
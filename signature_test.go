@@ -0,0 +1,133 @@
+package twitchhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+	"testing"
+)
+
+func TestParseSignature(t *testing.T) {
+	body := []byte(`{"data":[]}`)
+	for _, tt := range []struct {
+		algorithm string
+		newHash   func() hash.Hash
+	}{
+		{"sha1", sha1.New},
+		{"sha256", sha256.New},
+		{"sha512", sha512.New},
+	} {
+		mac := hmac.New(tt.newHash, []byte("secret"))
+		mac.Write(body)
+		want := mac.Sum(nil)
+		digest := hex.EncodeToString(want)
+
+		for _, header := range []string{
+			tt.algorithm + "=" + digest,
+			strings.ToUpper(tt.algorithm) + "=" + digest,
+			tt.algorithm + "=" + strings.ToUpper(digest),
+		} {
+			sig, err := parseSignature(header)
+			if err != nil {
+				t.Fatalf("parseSignature(%q): %v", header, err)
+			}
+			if sig.algorithm != tt.algorithm || !bytes.Equal(sig.digest(), want) {
+				t.Fatalf("parseSignature(%q) = %s %x", header, sig.algorithm, sig.digest())
+			}
+			if !sig.matches("secret", body) {
+				t.Fatalf("%q doesn't match the body it signs", header)
+			}
+			if sig.matches("other", body) || sig.matches("secret", []byte(`{}`)) {
+				t.Fatalf("%q matches another secret or body", header)
+			}
+		}
+
+		// digests of the wrong length for the algorithm
+		for _, bad := range []string{digest[:len(digest)-2], digest + "00", digest[:len(digest)-1], ""} {
+			if _, err := parseSignature(tt.algorithm + "=" + bad); err != ErrInvalidSignature {
+				t.Errorf("%s digest of %d characters: err = %v, want ErrInvalidSignature", tt.algorithm, len(bad), err)
+			}
+		}
+	}
+
+	// a sha1 digest labelled as sha256 and the other way round
+	sha1Digest := strings.Repeat("ab", sha1.Size)
+	if _, err := parseSignature("sha256=" + sha1Digest); err != ErrInvalidSignature {
+		t.Errorf("sha1 sized sha256 digest: err = %v", err)
+	}
+	if _, err := parseSignature("sha1=" + strings.Repeat("ab", sha256.Size)); err != ErrInvalidSignature {
+		t.Errorf("sha256 sized sha1 digest: err = %v", err)
+	}
+}
+
+func TestParseSignatureMalformed(t *testing.T) {
+	digest := strings.Repeat("0", 2*sha256.Size)
+	for _, header := range []string{
+		"",
+		"sha256",
+		digest,
+		"=" + digest,
+		"sha256=" + digest[:len(digest)-1] + "g",
+		"sha256=" + digest[:len(digest)-1] + " ",
+		"sha256= " + digest[1:],
+		"sha256=0x" + digest[2:],
+		"sha256==" + digest[1:],
+	} {
+		var unsupported *UnsupportedAlgorithmError
+		if _, err := parseSignature(header); err != ErrInvalidSignature && !errors.As(err, &unsupported) {
+			t.Errorf("parseSignature(%q) = %v, want an error", header, err)
+		}
+	}
+}
+
+func TestParseSignatureUnsupportedAlgorithm(t *testing.T) {
+	for _, algorithm := range []string{"md5", "sha384", "sha", "sha2560", "hmac-sha256"} {
+		_, err := parseSignature(algorithm + "=" + strings.Repeat("00", 32))
+		var unsupported *UnsupportedAlgorithmError
+		if !errors.As(err, &unsupported) || unsupported.Algorithm != algorithm {
+			t.Errorf("parseSignature(%s=...) = %v, want UnsupportedAlgorithmError", algorithm, err)
+		}
+	}
+}
+
+func TestDecodeHex(t *testing.T) {
+	dst := make([]byte, 4)
+	for _, tt := range []struct {
+		s    string
+		want []byte
+		ok   bool
+	}{
+		{"00ff10Ab", []byte{0x00, 0xff, 0x10, 0xab}, true},
+		{"DEADBEEF", []byte{0xde, 0xad, 0xbe, 0xef}, true},
+		{"deadbee", nil, false},
+		{"deadbeef00", nil, false},
+		{"deadbeeg", nil, false},
+		{"dead beef", nil, false},
+		{"-eadbeef", nil, false},
+	} {
+		ok := decodeHex(dst, tt.s)
+		if ok != tt.ok || (ok && !bytes.Equal(dst, tt.want)) {
+			t.Errorf("decodeHex(%q) = %x, %v", tt.s, dst, ok)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"data":[]}`)
+	for _, algorithm := range []string{"sha1", "sha256", "sha512"} {
+		header, err := Sign(algorithm, "secret", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := parseSignature(header)
+		if err != nil || !sig.matches("secret", body) {
+			t.Fatalf("%s: Sign output %q doesn't verify: %v", algorithm, header, err)
+		}
+	}
+}
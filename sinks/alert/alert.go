@@ -0,0 +1,146 @@
+// Package alert picks the events worth telling people about out of
+// notifications: a stream going live, a new follower and a new subscriber.
+// It's shared by chat sinks such as discord and slack.
+package alert
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// Kinds of alerts
+const (
+	StreamOnline = "stream.online"
+	Follow       = "follow"
+	Subscribe    = "subscribe"
+)
+
+// Alert is an event picked out of a notification, only the field of its
+// Kind is set
+type Alert struct {
+	Kind          string
+	BroadcasterID string
+
+	Stream     *twitchhook.Stream
+	Follow     *twitchhook.Follow
+	Subscriber *twitchhook.Subscriber
+	Burst      *BurstSummary
+
+	Notification *twitchhook.Notification
+}
+
+// Detector returns the alerts of notifications. Streams notifications are
+// also sent when a live stream's title or game changes, so the Detector
+// remembers each broadcaster's live stream and only alerts when a new one
+// starts. That's held in memory, a restart alerts for streams already live
+// when they next change.
+type Detector struct {
+	m    sync.Mutex
+	live map[string]string
+}
+
+// Alerts returns n's alerts, decoding it with twitchhook.DecodeEvent unless
+// middleware already did. Notifications of topics DecodeEvent doesn't know
+// have no alerts.
+func (d *Detector) Alerts(n *twitchhook.Notification) ([]Alert, error) {
+	event := n.Event
+	if event == nil {
+		var err error
+		event, err = twitchhook.DecodeEvent(n.Topic, n.Body)
+		if err == twitchhook.ErrUnknownTopic {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var alerts []Alert
+	switch event := event.(type) {
+	case *twitchhook.StreamChanged:
+		alerts = d.streams(n, event)
+	case *twitchhook.Follows:
+		for i := range event.Follows {
+			f := &event.Follows[i]
+			alerts = append(alerts, Alert{Kind: Follow, BroadcasterID: f.ToID, Follow: f, Notification: n})
+		}
+	case *twitchhook.SubscriberEvents:
+		for i := range event.Events {
+			e := &event.Events[i]
+			if e.EventType != twitchhook.SubscriberEventSubscribe {
+				continue
+			}
+			alerts = append(alerts, Alert{Kind: Subscribe, BroadcasterID: e.EventData.BroadcasterID, Subscriber: &e.EventData, Notification: n})
+		}
+	}
+	return alerts, nil
+}
+
+// streams returns alerts for the streams that weren't live before
+func (d *Detector) streams(n *twitchhook.Notification, event *twitchhook.StreamChanged) []Alert {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.live == nil {
+		d.live = make(map[string]string)
+	}
+
+	if len(event.Streams) == 0 {
+		// offline notifications only name the broadcaster in the topic
+		if userID := topicUserID(n.Topic); userID != "" {
+			delete(d.live, userID)
+		}
+		return nil
+	}
+
+	var alerts []Alert
+	for i := range event.Streams {
+		s := &event.Streams[i]
+		if d.live[s.UserID] == s.ID {
+			continue
+		}
+		d.live[s.UserID] = s.ID
+		alerts = append(alerts, Alert{Kind: StreamOnline, BroadcasterID: s.UserID, Stream: s, Notification: n})
+	}
+	return alerts
+}
+
+// Forget makes the Detector alert for a's stream again, call it when a
+// StreamOnline alert couldn't be delivered so the hub's redelivery alerts
+func (d *Detector) Forget(a Alert) {
+	if a.Kind != StreamOnline || a.Stream == nil {
+		return
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.live[a.BroadcasterID] == a.Stream.ID {
+		delete(d.live, a.BroadcasterID)
+	}
+}
+
+// Select returns the alerts of the given kinds, all of them when kinds is
+// empty
+func Select(alerts []Alert, kinds []string) []Alert {
+	if len(kinds) == 0 {
+		return alerts
+	}
+	var selected []Alert
+	for _, a := range alerts {
+		for _, k := range kinds {
+			if a.Kind == k {
+				selected = append(selected, a)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+func topicUserID(topic string) string {
+	u, err := url.Parse(topic)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("user_id")
+}
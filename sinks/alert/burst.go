@@ -0,0 +1,216 @@
+package alert
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// Burst is the kind of alerts summarizing the alerts a Limiter held back
+const Burst = "burst"
+
+// Limiter defaults
+const (
+	DefaultBurstLimit  = 5
+	DefaultBurstWindow = time.Minute
+)
+
+// BurstSummary describes the alerts of a broadcaster and kind a Limiter held
+// back during a window
+type BurstSummary struct {
+	// Kind is the kind of the held back alerts, such as Follow
+	Kind  string
+	Count int
+	// Start is when the window started, End when it ended
+	Start time.Time
+	End   time.Time
+	// Last is the last alert held back
+	Last Alert
+}
+
+// Limiter rate limits alerts per broadcaster and kind, such as against
+// follow bots flooding a chat channel. Once a broadcaster's alerts of a kind
+// exceed Limit within a Window the rest of the window's alerts are held back
+// and summarized by a single alert of kind Burst with its Burst set.
+type Limiter struct {
+	// Limit is how many alerts of a broadcaster and kind pass per Window,
+	// defaults to DefaultBurstLimit
+	Limit int
+
+	// Window defaults to DefaultBurstWindow
+	Window time.Duration
+
+	// Kinds selects the kinds limited, all of them when it's empty
+	Kinds []string
+
+	// OnBurst is called when a broadcaster's alerts of a kind first exceed
+	// the Limit within a window
+	OnBurst func(broadcasterID, kind string)
+
+	// OnSummary is called with summaries when their window ends, from a
+	// timer's goroutine. Without it summaries are returned by the next call
+	// to Allow after their window ended.
+	OnSummary func(a Alert)
+
+	// Clock defaults to twitchhook.SystemClock
+	Clock twitchhook.Clock
+
+	m       sync.Mutex
+	windows map[burstKey]*burstWindow
+}
+
+type burstKey struct {
+	broadcasterID string
+	kind          string
+}
+
+type burstWindow struct {
+	start time.Time
+	count int
+	held  int
+	last  Alert
+	timer twitchhook.Timer
+}
+
+// Allow returns the alerts passing the limits, followed by summaries of
+// windows that ended unless OnSummary is set. A nil Limiter allows every
+// alert.
+func (l *Limiter) Allow(alerts []Alert) []Alert {
+	if l == nil {
+		return alerts
+	}
+
+	var bursts []burstKey
+	var allowed []Alert
+
+	l.m.Lock()
+	now := l.clock().Now()
+	if l.windows == nil {
+		l.windows = make(map[burstKey]*burstWindow)
+	}
+	if l.OnSummary == nil {
+		for k, w := range l.windows {
+			if !now.Before(w.start.Add(l.window())) {
+				if w.held > 0 {
+					allowed = append(allowed, l.summary(k, w))
+				}
+				delete(l.windows, k)
+			}
+		}
+	}
+	var summaries []Alert
+	for _, a := range alerts {
+		if !l.limits(a.Kind) {
+			allowed = append(allowed, a)
+			continue
+		}
+		k := burstKey{broadcasterID: a.BroadcasterID, kind: a.Kind}
+		w, ok := l.windows[k]
+		if ok && !now.Before(w.start.Add(l.window())) {
+			// the window's timer hasn't run yet
+			if w.timer != nil {
+				w.timer.Stop()
+			}
+			if w.held > 0 {
+				summaries = append(summaries, l.summary(k, w))
+			}
+			ok = false
+		}
+		if !ok {
+			w = &burstWindow{start: now}
+			l.windows[k] = w
+		}
+
+		w.count++
+		if w.count <= l.limit() {
+			allowed = append(allowed, a)
+			continue
+		}
+		w.held++
+		w.last = a
+		if w.held == 1 {
+			bursts = append(bursts, k)
+			if l.OnSummary != nil {
+				w.timer = l.clock().AfterFunc(w.start.Add(l.window()).Sub(now), func() {
+					l.expire(k, w)
+				})
+			}
+		}
+	}
+	l.m.Unlock()
+
+	for _, k := range bursts {
+		if l.OnBurst != nil {
+			l.OnBurst(k.broadcasterID, k.kind)
+		}
+	}
+	if l.OnSummary == nil {
+		return append(allowed, summaries...)
+	}
+	for _, s := range summaries {
+		l.OnSummary(s)
+	}
+	return allowed
+}
+
+// expire hands w's summary to OnSummary unless it was already taken
+func (l *Limiter) expire(k burstKey, w *burstWindow) {
+	l.m.Lock()
+	if l.windows[k] != w {
+		l.m.Unlock()
+		return
+	}
+	delete(l.windows, k)
+	s := l.summary(k, w)
+	l.m.Unlock()
+	l.OnSummary(s)
+}
+
+func (l *Limiter) summary(k burstKey, w *burstWindow) Alert {
+	return Alert{
+		Kind:          Burst,
+		BroadcasterID: k.broadcasterID,
+		Burst: &BurstSummary{
+			Kind:  k.kind,
+			Count: w.held,
+			Start: w.start,
+			End:   w.start.Add(l.window()),
+			Last:  w.last,
+		},
+		Notification: w.last.Notification,
+	}
+}
+
+func (l *Limiter) limits(kind string) bool {
+	if len(l.Kinds) == 0 {
+		return kind != Burst
+	}
+	for _, k := range l.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Limiter) limit() int {
+	if l.Limit <= 0 {
+		return DefaultBurstLimit
+	}
+	return l.Limit
+}
+
+func (l *Limiter) window() time.Duration {
+	if l.Window <= 0 {
+		return DefaultBurstWindow
+	}
+	return l.Window
+}
+
+func (l *Limiter) clock() twitchhook.Clock {
+	if l.Clock == nil {
+		return twitchhook.SystemClock
+	}
+	return l.Clock
+}
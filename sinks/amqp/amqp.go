@@ -0,0 +1,172 @@
+// Package amqp publishes verified notifications to an AMQP 0-9-1 broker such
+// as RabbitMQ, waiting for publisher confirms.
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/bsdlp/twitchhook"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// API is the subset of *amqp091.Channel used by Publisher
+type API interface {
+	Confirm(noWait bool) error
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) (*amqp091.DeferredConfirmation, error)
+}
+
+// DefaultRoutingKey routes notifications by their twitchhook.TopicType, such
+// as helix.users.follows
+const DefaultRoutingKey = "{{.Type}}"
+
+// Headers set on published messages
+const (
+	HeaderTopic          = "twitch-topic"
+	HeaderSubscriptionID = "twitch-subscription-id"
+)
+
+// Publisher is a twitchhook.NotificationHandler publishing every notification
+// as a persistent message and waiting for the broker to confirm it. Nacked
+// messages and publishing errors ask the hub to deliver the notification
+// again. Publisher doesn't reconnect, replace the Channel when it's closed and
+// the new one is put in confirm mode when it's first used.
+//
+// Exchange and RoutingKey are text/template templates executed with the
+// notification's
+//
+//	.Topic           the topic url
+//	.Type            the topic's twitchhook.TopicType
+//	.Query           the topic's query, such as {{.Query.Get "user_id"}}
+//	.SubscriptionID
+//	.NotificationID
+type Publisher struct {
+	Channel API
+
+	// Exchange defaults to the default exchange
+	Exchange string
+
+	// RoutingKey defaults to DefaultRoutingKey
+	RoutingKey string
+
+	// Mandatory publishes with the mandatory flag, listen for returns with
+	// the channel's NotifyReturn
+	Mandatory bool
+
+	once       sync.Once
+	err        error
+	exchange   *template.Template
+	routingKey *template.Template
+
+	m         sync.Mutex
+	confirmed API
+}
+
+// Fields passed to Exchange and RoutingKey
+type templateData struct {
+	Topic          string
+	Type           string
+	Query          url.Values
+	SubscriptionID string
+	NotificationID string
+}
+
+func (p *Publisher) setup() {
+	routingKey := p.RoutingKey
+	if routingKey == "" {
+		routingKey = DefaultRoutingKey
+	}
+	p.exchange, p.err = template.New("exchange").Parse(p.Exchange)
+	if p.err != nil {
+		return
+	}
+	p.routingKey, p.err = template.New("routing_key").Parse(routingKey)
+}
+
+// confirm puts the Channel in confirm mode the first time it's used
+func (p *Publisher) confirm() (API, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	ch := p.Channel
+	if ch == p.confirmed {
+		return ch, nil
+	}
+	err := ch.Confirm(false)
+	if err != nil {
+		return nil, fmt.Errorf("amqp: enabling publisher confirms: %w", err)
+	}
+	p.confirmed = ch
+	return ch, nil
+}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (p *Publisher) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	p.once.Do(p.setup)
+	if p.err != nil {
+		return twitchhook.Reject(fmt.Errorf("amqp: %w", p.err))
+	}
+	ch, err := p.confirm()
+	if err != nil {
+		return twitchhook.RetryLater(0, err)
+	}
+
+	data := templateData{
+		Topic:          n.Topic,
+		Type:           twitchhook.TopicType(n.Topic),
+		SubscriptionID: string(n.SubscriptionID),
+		NotificationID: n.ID,
+	}
+	if u, err := url.Parse(n.Topic); err == nil {
+		data.Query = u.Query()
+	}
+	exchange, err := execute(p.exchange, data)
+	if err != nil {
+		return twitchhook.Reject(err)
+	}
+	routingKey, err := execute(p.routingKey, data)
+	if err != nil {
+		return twitchhook.Reject(err)
+	}
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, p.Mandatory, false, amqp091.Publishing{
+		Headers: amqp091.Table{
+			HeaderTopic:          n.Topic,
+			HeaderSubscriptionID: string(n.SubscriptionID),
+		},
+		ContentType:   "application/json",
+		DeliveryMode:  amqp091.Persistent,
+		MessageId:     n.ID,
+		CorrelationId: n.RequestID,
+		Timestamp:     n.Timestamp,
+		Type:          data.Type,
+		Body:          n.Body,
+	})
+	if err != nil {
+		return twitchhook.RetryLater(0, fmt.Errorf("amqp: publishing: %w", err))
+	}
+	if confirm == nil {
+		return twitchhook.RetryLater(0, errors.New("amqp: channel isn't in confirm mode"))
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return twitchhook.RetryLater(0, fmt.Errorf("amqp: waiting for confirm: %w", err))
+	}
+	if !acked {
+		return twitchhook.RetryLater(0, errors.New("amqp: broker nacked notification"))
+	}
+	return nil
+}
+
+func execute(t *template.Template, data templateData) (string, error) {
+	var b strings.Builder
+	err := t.Execute(&b, data)
+	if err != nil {
+		return "", fmt.Errorf("amqp: %w", err)
+	}
+	return b.String(), nil
+}
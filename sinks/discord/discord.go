@@ -0,0 +1,253 @@
+// Package discord posts alerts, such as a stream going live, to a Discord
+// webhook as embeds.
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/sinks/alert"
+	"github.com/bsdlp/twitchhook/sinks/format"
+)
+
+// maxEmbeds is the most embeds Discord accepts in a message
+const maxEmbeds = 10
+
+// Template renders an alert as a message. Its fields are text/template
+// templates executed with the alert.Alert and format.Funcs, so
+// {{.Stream.Title}} is the title of a stream going live.
+type Template struct {
+	// Content is the message's text outside the embed, such as a role
+	// mention
+	Content string `json:"content" yaml:"content" toml:"content"`
+
+	Title        string `json:"title" yaml:"title" toml:"title"`
+	Description  string `json:"description" yaml:"description" toml:"description"`
+	URL          string `json:"url" yaml:"url" toml:"url"`
+	ThumbnailURL string `json:"thumbnail_url" yaml:"thumbnail_url" toml:"thumbnail_url"`
+
+	// Color is the embed's sidebar color as 0xRRGGBB
+	Color int `json:"color" yaml:"color" toml:"color"`
+}
+
+// DefaultTemplates are used for alert kinds Webhook has no template for
+var DefaultTemplates = map[string]Template{
+	alert.StreamOnline: {
+		Title:        "{{.Stream.UserName}} is live",
+		Description:  "{{.Stream.Title}}{{with .Stream.GameName}}\nPlaying {{.}}{{end}}",
+		URL:          "https://www.twitch.tv/{{lower .Stream.UserName}}",
+		ThumbnailURL: "{{thumbnail .Stream.ThumbnailURL 320 180}}",
+		Color:        0x9146ff,
+	},
+	alert.Follow: {
+		Title:       "New follower",
+		Description: "{{.Follow.FromName}} followed {{.Follow.ToName}}",
+		Color:       0x9146ff,
+	},
+	alert.Subscribe: {
+		Title:       "New subscriber",
+		Description: "{{.Subscriber.UserName}} subscribed to {{.Subscriber.BroadcasterName}}{{with .Subscriber.PlanName}} with {{.}}{{end}}{{if .Subscriber.IsGift}}, gifted by {{.Subscriber.GifterName}}{{end}}",
+		Color:       0x9146ff,
+	},
+	alert.Burst: {
+		Title:       "Burst of {{.Burst.Kind}} alerts",
+		Description: "{{.Burst.Count}} more {{.Burst.Kind}} alerts held back between {{.Burst.Start.UTC.Format \"15:04:05\"}} and {{.Burst.End.UTC.Format \"15:04:05\"}} UTC",
+		Color:       0xe91916,
+	},
+}
+
+// Webhook is a twitchhook.NotificationHandler posting the alerts of
+// notifications to a Discord webhook url. Rate limits and 5xx responses ask
+// the hub to deliver the notification again, other errors drop it.
+type Webhook struct {
+	URL string
+
+	// Client defaults to a client with a 10 second timeout
+	Client *http.Client
+
+	// Username and AvatarURL override the webhook's name and avatar
+	Username  string
+	AvatarURL string
+
+	// Kinds selects the alerts posted, all of them when it's empty
+	Kinds []string
+
+	// Templates overrides DefaultTemplates by alert kind
+	Templates map[string]Template
+
+	// Limiter holds back bursts of alerts, posting summaries of kind
+	// alert.Burst in their place. Summaries are posted with the next
+	// notification's alerts unless the Limiter's OnSummary is set.
+	Limiter *alert.Limiter
+
+	detector alert.Detector
+}
+
+type message struct {
+	Content   string  `json:"content,omitempty"`
+	Username  string  `json:"username,omitempty"`
+	AvatarURL string  `json:"avatar_url,omitempty"`
+	Embeds    []embed `json:"embeds"`
+}
+
+type embed struct {
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	URL         string     `json:"url,omitempty"`
+	Color       int        `json:"color,omitempty"`
+	Timestamp   string     `json:"timestamp,omitempty"`
+	Thumbnail   *thumbnail `json:"thumbnail,omitempty"`
+}
+
+type thumbnail struct {
+	URL string `json:"url"`
+}
+
+func init() {
+	twitchhook.RegisterSink("discord", Open)
+}
+
+// Open opens a Webhook for twitchhook bus configs from a discord:// url, such
+// as discord://discord.com/api/webhooks/ID/TOKEN, posting to the same url over https. A kinds
+// query parameter selects the alerts posted as a comma separated list.
+func Open(rawURL string) (twitchhook.NotificationHandler, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("discord: url must name a host")
+	}
+	w := &Webhook{}
+	q := u.Query()
+	if kinds := q.Get("kinds"); kinds != "" {
+		w.Kinds = strings.Split(kinds, ",")
+	}
+	q.Del("kinds")
+	u.Scheme = "https"
+	u.RawQuery = q.Encode()
+	w.URL = u.String()
+	return w, nil
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (w *Webhook) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	alerts, err := w.detector.Alerts(n)
+	if err != nil {
+		return twitchhook.Reject(err)
+	}
+	alerts = w.Limiter.Allow(alert.Select(alerts, w.Kinds))
+
+	for len(alerts) > 0 {
+		batch := alerts
+		if len(batch) > maxEmbeds {
+			batch = batch[:maxEmbeds]
+		}
+		alerts = alerts[len(batch):]
+
+		msg, err := w.message(batch)
+		if err != nil {
+			return twitchhook.Reject(fmt.Errorf("discord: rendering alert: %w", err))
+		}
+		err = w.post(ctx, msg)
+		if err != nil {
+			// unsent streams going live are alerted on redelivery
+			for _, a := range batch {
+				w.detector.Forget(a)
+			}
+			for _, a := range alerts {
+				w.detector.Forget(a)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Webhook) message(alerts []alert.Alert) (*message, error) {
+	msg := &message{Username: w.Username, AvatarURL: w.AvatarURL}
+	var contents []string
+	for _, a := range alerts {
+		t, ok := w.Templates[a.Kind]
+		if !ok {
+			t = DefaultTemplates[a.Kind]
+		}
+
+		var e embed
+		var content, thumbnailURL string
+		for _, field := range []struct {
+			text string
+			out  *string
+		}{
+			{t.Content, &content},
+			{t.Title, &e.Title},
+			{t.Description, &e.Description},
+			{t.URL, &e.URL},
+			{t.ThumbnailURL, &thumbnailURL},
+		} {
+			var err error
+			*field.out, err = format.Render(field.text, a)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if content != "" {
+			contents = append(contents, content)
+		}
+		if thumbnailURL != "" {
+			e.Thumbnail = &thumbnail{URL: thumbnailURL}
+		}
+		e.Color = t.Color
+		if ts := a.Notification.Timestamp; !ts.IsZero() {
+			e.Timestamp = ts.Format(time.RFC3339)
+		}
+		msg.Embeds = append(msg.Embeds, e)
+	}
+	msg.Content = strings.Join(contents, "\n")
+	return msg, nil
+}
+
+func (w *Webhook) post(ctx context.Context, msg *message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return twitchhook.Reject(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return twitchhook.Reject(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return twitchhook.RetryLater(0, fmt.Errorf("discord: posting alert: %w", err))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		retryAfter, _ := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+		return twitchhook.RetryLater(time.Duration(retryAfter*float64(time.Second)), fmt.Errorf("discord: %s", resp.Status))
+	default:
+		return twitchhook.Reject(fmt.Errorf("discord: webhook rejected alert: %s", resp.Status))
+	}
+}
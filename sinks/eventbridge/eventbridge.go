@@ -0,0 +1,119 @@
+// Package eventbridge forwards verified notifications onto an Amazon
+// EventBridge event bus, so they can be routed with rules.
+package eventbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/bsdlp/twitchhook"
+)
+
+// API is the subset of *eventbridge.Client used by Sink
+type API interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// DefaultSource is the source of events put by Sink
+const DefaultSource = "twitchhook"
+
+// Sink is a twitchhook.NotificationHandler putting every notification onto
+// an event bus. The event's detail is the decoded event when middleware such
+// as the helix enricher set one, otherwise the notification body, and its
+// detail-type is the topic's twitchhook.TopicType, such as
+// helix.users.follows.
+//
+// Errors putting the event and throttled or failed entries ask the hub to
+// deliver the notification again, other rejected entries drop it.
+type Sink struct {
+	Client API
+
+	// EventBusName is the name or arn of the bus, the account's default bus
+	// when it's empty
+	EventBusName string
+
+	// Source defaults to DefaultSource
+	Source string
+
+	// DetailType overrides the detail-type derived from the topic
+	DetailType func(n *twitchhook.Notification) string
+}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (s *Sink) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	detail, err := s.detail(n)
+	if err != nil {
+		return twitchhook.Reject(err)
+	}
+
+	entry := types.PutEventsRequestEntry{
+		Source:     aws.String(s.source()),
+		DetailType: aws.String(s.detailType(n)),
+		Detail:     aws.String(string(detail)),
+	}
+	if s.EventBusName != "" {
+		entry.EventBusName = aws.String(s.EventBusName)
+	}
+	if !n.Timestamp.IsZero() {
+		entry.Time = aws.Time(n.Timestamp)
+	}
+
+	out, err := s.Client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{entry},
+	})
+	if err != nil {
+		return twitchhook.RetryLater(0, fmt.Errorf("eventbridge: putting event: %w", err))
+	}
+	if out.FailedEntryCount == 0 || len(out.Entries) == 0 {
+		return nil
+	}
+
+	result := out.Entries[0]
+	err = fmt.Errorf("eventbridge: entry failed: %s: %s", aws.ToString(result.ErrorCode), aws.ToString(result.ErrorMessage))
+	switch aws.ToString(result.ErrorCode) {
+	case "InternalFailure", "ThrottlingException":
+		return twitchhook.RetryLater(0, err)
+	default:
+		return twitchhook.Reject(err)
+	}
+}
+
+// detail returns the event's detail, which EventBridge requires to be a
+// JSON object
+func (s *Sink) detail(n *twitchhook.Notification) ([]byte, error) {
+	if n.Event != nil {
+		detail, err := json.Marshal(n.Event)
+		if err != nil {
+			return nil, fmt.Errorf("eventbridge: encoding event: %w", err)
+		}
+		return detail, nil
+	}
+
+	var object map[string]json.RawMessage
+	if json.Unmarshal(n.Body, &object) != nil {
+		return nil, errors.New("eventbridge: notification body isn't a json object")
+	}
+	return n.Body, nil
+}
+
+func (s *Sink) source() string {
+	if s.Source == "" {
+		return DefaultSource
+	}
+	return s.Source
+}
+
+func (s *Sink) detailType(n *twitchhook.Notification) string {
+	if s.DetailType != nil {
+		return s.DetailType(n)
+	}
+	if t := twitchhook.TopicType(n.Topic); t != "" {
+		return t
+	}
+	return "notification"
+}
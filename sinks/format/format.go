@@ -0,0 +1,168 @@
+// Package format renders notifications into messages with text/template
+// templates supplied at runtime, such as from a config file. It's the
+// template engine of the chat and relay sinks.
+package format
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// Funcs are available to every template
+//
+//	lower      strings.ToLower
+//	upper      strings.ToUpper
+//	json       encodes a value as JSON, such as a string inside a JSON template
+//	mrkdwn     escapes &, < and > for Slack mrkdwn
+//	truncate   shortens a string to at most n runes, ending it with …
+//	thumbnail  fills in the width and height of a stream's thumbnail url
+var Funcs = template.FuncMap{
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"json":      jsonString,
+	"mrkdwn":    strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace,
+	"truncate":  truncate,
+	"thumbnail": thumbnail,
+}
+
+// templates caches parsed templates by their text
+var templates sync.Map
+
+// Parse parses text with Funcs, templates are cached so parsing the same text
+// again is cheap
+func Parse(text string) (*template.Template, error) {
+	if t, ok := templates.Load(text); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("format").Funcs(Funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	templates.Store(text, t)
+	return t, nil
+}
+
+// Render executes text with data, an empty text renders nothing
+func Render(text string, data interface{}) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	t, err := Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Data is what Formatter templates are executed with
+type Data struct {
+	Topic string
+	// Type is the topic's twitchhook.TopicType, such as helix.streams
+	Type string
+	// Query is the topic's query, such as {{.Query.Get "user_id"}}
+	Query url.Values
+
+	// Event is the decoded event, such as a *twitchhook.StreamChanged, or
+	// the body decoded into a generic value for topics twitchhook doesn't
+	// know
+	Event interface{}
+
+	Notification *twitchhook.Notification
+}
+
+// Formatter renders notifications with a template chosen by their topic's
+// twitchhook.TopicType
+type Formatter struct {
+	// Templates by topic type, such as helix.users.follows
+	Templates map[string]string `json:"templates" yaml:"templates" toml:"templates"`
+
+	// Default renders types without a template, notifications of those
+	// types render nothing when it's empty
+	Default string `json:"default" yaml:"default" toml:"default"`
+}
+
+// Format renders n, decoding it with twitchhook.DecodeEvent unless
+// middleware already did
+func (f *Formatter) Format(n *twitchhook.Notification) (string, error) {
+	data := Data{
+		Topic:        n.Topic,
+		Type:         twitchhook.TopicType(n.Topic),
+		Event:        n.Event,
+		Notification: n,
+	}
+	if u, err := url.Parse(n.Topic); err == nil {
+		data.Query = u.Query()
+	}
+
+	text, ok := f.Templates[data.Type]
+	if !ok {
+		text = f.Default
+	}
+	if text == "" {
+		return "", nil
+	}
+
+	if data.Event == nil {
+		event, err := twitchhook.DecodeEvent(n.Topic, n.Body)
+		switch {
+		case err == twitchhook.ErrUnknownTopic:
+			err = json.Unmarshal(n.Body, &data.Event)
+			if err != nil {
+				return "", err
+			}
+		case err != nil:
+			return "", err
+		default:
+			data.Event = event
+		}
+	}
+	return Render(text, data)
+}
+
+// Validate parses every template, so configuration errors are reported on
+// load rather than when a notification arrives
+func (f *Formatter) Validate() error {
+	for _, text := range f.Templates {
+		_, err := Parse(text)
+		if err != nil {
+			return err
+		}
+	}
+	if f.Default == "" {
+		return nil
+	}
+	_, err := Parse(f.Default)
+	return err
+}
+
+func jsonString(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
+
+func thumbnail(thumbnailURL string, width, height int) string {
+	return strings.NewReplacer("{width}", strconv.Itoa(width), "{height}", strconv.Itoa(height)).Replace(thumbnailURL)
+}
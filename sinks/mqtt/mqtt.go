@@ -0,0 +1,156 @@
+// Package mqtt publishes decoded events to MQTT topics per broadcaster, so
+// hardware such as lights and stream decks can react to them directly.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/bsdlp/twitchhook"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// API is the subset of paho.Client used by Publisher
+type API interface {
+	Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token
+}
+
+// Events published to <prefix>/<broadcaster id>/<event>
+const (
+	// EventFollow carries a twitchhook.Follow for every new follower
+	EventFollow = "follow"
+	// EventStream carries the twitchhook.Stream when a stream goes live or
+	// changes
+	EventStream = "stream"
+	// EventOffline carries an empty object when a stream goes offline
+	EventOffline = "offline"
+	// EventUser carries the twitchhook.User when a user changes
+	EventUser = "user"
+)
+
+// DefaultPrefix is the first level of published topics
+const DefaultPrefix = "twitch"
+
+// Publisher is a twitchhook.NotificationHandler decoding notifications with
+// twitchhook.DecodeEvent, unless middleware already did, and publishing
+// their events as JSON to topics such as twitch/1234/follow. Notifications
+// for topics DecodeEvent doesn't know are acknowledged without publishing.
+// Publishing errors ask the hub to deliver the notification again.
+type Publisher struct {
+	Client API
+
+	// Prefix defaults to DefaultPrefix
+	Prefix string
+
+	// Events selects which events are published, all of them when it's
+	// empty
+	Events []string
+
+	// QoS is the quality of service messages are published with
+	QoS byte
+
+	// Retain publishes stream, offline and user events as retained
+	// messages, so subscribers get the current state when they connect
+	Retain bool
+}
+
+// message is an event and the MQTT topic it's published to
+type message struct {
+	event   string
+	topic   string
+	payload interface{}
+}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (p *Publisher) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	event := n.Event
+	if event == nil {
+		var err error
+		event, err = twitchhook.DecodeEvent(n.Topic, n.Body)
+		if err == twitchhook.ErrUnknownTopic {
+			return nil
+		}
+		if err != nil {
+			return twitchhook.Reject(err)
+		}
+	}
+
+	for _, msg := range p.messages(n.Topic, event) {
+		if !p.selected(msg.event) {
+			continue
+		}
+		payload, err := json.Marshal(msg.payload)
+		if err != nil {
+			return twitchhook.Reject(fmt.Errorf("mqtt: encoding %s event: %w", msg.event, err))
+		}
+		retained := p.Retain && msg.event != EventFollow
+		err = wait(ctx, p.Client.Publish(msg.topic, p.QoS, retained, payload))
+		if err != nil {
+			return twitchhook.RetryLater(0, fmt.Errorf("mqtt: publishing to %s: %w", msg.topic, err))
+		}
+	}
+	return nil
+}
+
+// messages returns the messages published for event
+func (p *Publisher) messages(topic string, event interface{}) []message {
+	var msgs []message
+	switch event := event.(type) {
+	case *twitchhook.Follows:
+		for _, f := range event.Follows {
+			msgs = append(msgs, p.message(f.ToID, EventFollow, f))
+		}
+	case *twitchhook.StreamChanged:
+		if len(event.Streams) == 0 {
+			// offline notifications only name the broadcaster in the topic
+			u, err := url.Parse(topic)
+			if err == nil && u.Query().Get("user_id") != "" {
+				msgs = append(msgs, p.message(u.Query().Get("user_id"), EventOffline, struct{}{}))
+			}
+		}
+		for _, s := range event.Streams {
+			msgs = append(msgs, p.message(s.UserID, EventStream, s))
+		}
+	case *twitchhook.UserChanged:
+		for _, u := range event.Users {
+			msgs = append(msgs, p.message(u.ID, EventUser, u))
+		}
+	}
+	return msgs
+}
+
+func (p *Publisher) message(broadcasterID, event string, payload interface{}) message {
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return message{
+		event:   event,
+		topic:   prefix + "/" + broadcasterID + "/" + event,
+		payload: payload,
+	}
+}
+
+func (p *Publisher) selected(event string) bool {
+	if len(p.Events) == 0 {
+		return true
+	}
+	for _, e := range p.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// wait waits for token until ctx is done
+func wait(ctx context.Context, token paho.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,112 @@
+// Package redisstream publishes verified notifications to a Redis stream, so
+// workers can consume them through consumer groups with at least once
+// delivery.
+package redisstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/redis/go-redis/v9"
+)
+
+// API is the subset of redis.UniversalClient used by Sink
+type API interface {
+	XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd
+}
+
+// Fields of stream entries
+const (
+	FieldTopic          = "topic"
+	FieldType           = "type"
+	FieldNotificationID = "notification_id"
+	FieldSubscriptionID = "subscription_id"
+	FieldTimestamp      = "timestamp"
+	FieldRequestID      = "request_id"
+	FieldBody           = "body"
+)
+
+// DefaultStream is the stream notifications are added to
+const DefaultStream = "twitchhook:notifications"
+
+// Sink is a twitchhook.NotificationHandler adding every notification to a
+// stream with XADD. Entries carry the topic, its twitchhook.TopicType, the
+// notification and subscription ids, the timestamp, the request id and the
+// body. Redeliveries of a notification are added again, consumers should
+// deduplicate on the notification id.
+//
+// Errors adding the entry ask the hub to deliver the notification again.
+type Sink struct {
+	Client API
+
+	// Stream defaults to DefaultStream
+	Stream string
+
+	// MaxLen approximately caps the stream's length when it's set
+	MaxLen int64
+}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (s *Sink) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	values := []interface{}{
+		FieldTopic, n.Topic,
+		FieldType, twitchhook.TopicType(n.Topic),
+		FieldNotificationID, n.ID,
+		FieldSubscriptionID, string(n.SubscriptionID),
+		FieldBody, n.Body,
+	}
+	if !n.Timestamp.IsZero() {
+		values = append(values, FieldTimestamp, n.Timestamp.Format(time.RFC3339Nano))
+	}
+	if n.RequestID != "" {
+		values = append(values, FieldRequestID, n.RequestID)
+	}
+
+	err := s.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream(),
+		MaxLen: s.MaxLen,
+		Approx: s.MaxLen > 0,
+		Values: values,
+	}).Err()
+	if err != nil {
+		return twitchhook.RetryLater(0, fmt.Errorf("redisstream: adding entry: %w", err))
+	}
+	return nil
+}
+
+func (s *Sink) stream() string {
+	if s.Stream == "" {
+		return DefaultStream
+	}
+	return s.Stream
+}
+
+// Decode returns the notification a Sink added as msg, for consumers reading
+// the stream with XREADGROUP
+func Decode(msg redis.XMessage) (*twitchhook.Notification, error) {
+	field := func(name string) string {
+		v, _ := msg.Values[name].(string)
+		return v
+	}
+	n := &twitchhook.Notification{
+		Topic:          field(FieldTopic),
+		ID:             field(FieldNotificationID),
+		SubscriptionID: twitchhook.SubscriptionID(field(FieldSubscriptionID)),
+		Body:           []byte(field(FieldBody)),
+		RequestID:      field(FieldRequestID),
+	}
+	if n.Topic == "" {
+		return nil, errors.New("redisstream: entry has no topic")
+	}
+	if ts := field(FieldTimestamp); ts != "" {
+		timestamp, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return nil, fmt.Errorf("redisstream: invalid timestamp: %w", err)
+		}
+		n.Timestamp = timestamp
+	}
+	return n, nil
+}
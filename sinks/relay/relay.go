@@ -0,0 +1,307 @@
+// Package relay fans verified notifications out to internal services,
+// re-signing each delivery with the service's own secret.
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/sinks/format"
+)
+
+// Headers sent with relayed notifications
+const (
+	HeaderTopic          = "Twitchhook-Topic"
+	HeaderSubscriptionID = "Twitchhook-Subscription-Id"
+	HeaderNotificationID = "Twitchhook-Notification-Id"
+	HeaderTimestamp      = "Twitchhook-Timestamp"
+
+	// HeaderRequestID carries the notification's RequestID
+	HeaderRequestID = twitchhook.HeaderRequestID
+)
+
+// Defaults
+const (
+	DefaultAttempts   = 3
+	DefaultBackoff    = 500 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+	DefaultAlgorithm  = "sha256"
+)
+
+// Target is a service notifications are relayed to
+type Target struct {
+	URL string
+
+	// Secret signs the body in twitchhook.HeaderSignature the way the hub
+	// does, so the service can verify deliveries with twitchhook. Bodies
+	// aren't signed when it's empty.
+	Secret string
+
+	// Algorithm defaults to DefaultAlgorithm
+	Algorithm string
+
+	// Topics limits the target to these topics, it gets every topic when
+	// it's empty
+	Topics []string
+
+	// Format renders the body posted in place of the notification's, such
+	// as a JSON document built from the decoded event. Notifications it
+	// renders nothing for aren't relayed to the target.
+	Format *format.Formatter
+
+	// ContentType of formatted bodies, defaults to application/json
+	ContentType string
+
+	Headers map[string]string
+}
+
+// Relay is a twitchhook.NotificationHandler posting every notification's body,
+// or what a target's Format renders for it, to its Targets concurrently.
+// Failed posts are retried with exponential backoff, 4xx responses other than
+// 429 aren't retried. When a target still fails the hub is asked to deliver
+// the notification again, which relays it to every target again, so services
+// should deduplicate on the HeaderNotificationID.
+type Relay struct {
+	Targets []Target
+
+	// Client defaults to a client with a 10 second timeout
+	Client *http.Client
+
+	// Attempts is how many times a target is tried, defaults to
+	// DefaultAttempts
+	Attempts int
+
+	// Backoff is the delay before the second attempt, doubled for every
+	// attempt up to MaxBackoff. They default to DefaultBackoff and
+	// DefaultMaxBackoff. A longer Retry-After from the target, in seconds or
+	// as an http date, is waited for up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Clock defaults to twitchhook.SystemClock
+	Clock twitchhook.Clock
+}
+
+// TargetError is returned when relaying to a target failed
+type TargetError struct {
+	URL string
+	Err error
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("relay: %s: %v", e.URL, e.Err)
+}
+
+func (e *TargetError) Unwrap() error {
+	return e.Err
+}
+
+// errPermanent marks target errors that aren't retried
+type errPermanent struct {
+	error
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (r *Relay) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(r.Targets))
+	for i := range r.Targets {
+		t := &r.Targets[i]
+		if !t.wants(n.Topic) {
+			continue
+		}
+		body, contentType := n.Body, "application/json"
+		if t.Format != nil {
+			formatted, err := t.Format.Format(n)
+			if err != nil {
+				errs[i] = &TargetError{URL: t.URL, Err: errPermanent{fmt.Errorf("formatting notification: %w", err)}}
+				continue
+			}
+			if formatted == "" {
+				continue
+			}
+			body = []byte(formatted)
+			if t.ContentType != "" {
+				contentType = t.ContentType
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = r.relay(ctx, t, n, body, contentType)
+		}()
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if err == nil {
+		return nil
+	}
+	for _, targetErr := range errs {
+		var permanent errPermanent
+		if targetErr != nil && !errors.As(targetErr, &permanent) {
+			return twitchhook.RetryLater(0, err)
+		}
+	}
+	// every failed target rejected the notification, redelivering won't help
+	return twitchhook.Reject(err)
+}
+
+// relay posts body to t until it succeeds, fails permanently or runs out of
+// attempts
+func (r *Relay) relay(ctx context.Context, t *Target, n *twitchhook.Notification, body []byte, contentType string) error {
+	attempts := r.Attempts
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+	clock := r.clock()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = r.post(ctx, t, n, body, contentType)
+		var permanent errPermanent
+		if err == nil || errors.As(err, &permanent) || attempt >= attempts {
+			break
+		}
+
+		delay := r.backoff(attempt)
+		if retryAfter > delay {
+			// targets don't get to hold the hub's request open for longer
+			delay = min(retryAfter, r.maxBackoff())
+		}
+		elapsed := make(chan struct{})
+		timer := clock.AfterFunc(delay, func() { close(elapsed) })
+		select {
+		case <-elapsed:
+		case <-ctx.Done():
+			timer.Stop()
+			return &TargetError{URL: t.URL, Err: ctx.Err()}
+		}
+	}
+	if err != nil {
+		return &TargetError{URL: t.URL, Err: err}
+	}
+	return nil
+}
+
+// backoff is the delay after a failed attempt
+func (r *Relay) backoff(attempt int) time.Duration {
+	delay := r.Backoff
+	if delay <= 0 {
+		delay = DefaultBackoff
+	}
+	max := r.maxBackoff()
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+func (r *Relay) maxBackoff() time.Duration {
+	if r.MaxBackoff <= 0 {
+		return DefaultMaxBackoff
+	}
+	return r.MaxBackoff
+}
+
+func (r *Relay) clock() twitchhook.Clock {
+	if r.Clock == nil {
+		return twitchhook.SystemClock
+	}
+	return r.Clock
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or an http date,
+// as the delay from now. Anything else is no delay.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// post delivers body to t once, returning the delay the target asked for
+func (r *Relay) post(ctx context.Context, t *Target, n *twitchhook.Notification, body []byte, contentType string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, errPermanent{err}
+	}
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(HeaderTopic, n.Topic)
+	req.Header.Set(HeaderSubscriptionID, string(n.SubscriptionID))
+	req.Header.Set(HeaderNotificationID, n.ID)
+	if n.RequestID != "" {
+		req.Header.Set(HeaderRequestID, n.RequestID)
+	}
+	if !n.Timestamp.IsZero() {
+		req.Header.Set(HeaderTimestamp, n.Timestamp.Format(time.RFC3339Nano))
+	}
+	if t.Secret != "" {
+		algorithm := t.Algorithm
+		if algorithm == "" {
+			algorithm = DefaultAlgorithm
+		}
+		signature, err := twitchhook.Sign(algorithm, t.Secret, body)
+		if err != nil {
+			return 0, errPermanent{err}
+		}
+		req.Header.Set(twitchhook.HeaderSignature, signature)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return parseRetryAfter(resp.Header.Get("Retry-After"), r.clock().Now()), errors.New(resp.Status)
+	default:
+		return 0, errPermanent{errors.New(resp.Status)}
+	}
+}
+
+// wants reports whether t relays topic's notifications
+func (t *Target) wants(topic string) bool {
+	if len(t.Topics) == 0 {
+		return true
+	}
+	for _, want := range t.Topics {
+		if want == topic {
+			return true
+		}
+	}
+	return false
+}
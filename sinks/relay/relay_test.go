@@ -0,0 +1,62 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRelayClampsRetryAfter(t *testing.T) {
+	var posts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if posts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	r := &Relay{
+		Targets:    []Target{{URL: srv.URL}},
+		Backoff:    time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := r.HandleNotification(ctx, &twitchhook.Notification{
+		ID:    "1",
+		Topic: "https://api.twitch.tv/helix/streams?user_id=1",
+		Body:  []byte(`{"data":[]}`),
+	})
+	if err != nil {
+		t.Fatalf("HandleNotification = %v, want the retry past the clamped Retry-After to succeed", err)
+	}
+	if n := posts.Load(); n != 2 {
+		t.Fatalf("posted %d times, want 2", n)
+	}
+}
@@ -0,0 +1,205 @@
+// Package slack posts alerts, such as a stream going live, to Slack incoming
+// webhooks as Block Kit messages.
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/sinks/alert"
+	"github.com/bsdlp/twitchhook/sinks/format"
+)
+
+// Template renders an alert as a message. Its fields are text/template
+// templates executed with the alert.Alert and format.Funcs, use json to
+// encode strings inside Blocks and mrkdwn to escape them.
+type Template struct {
+	// Text is the message's plain text, shown in notifications and by
+	// clients that can't display blocks
+	Text string `json:"text" yaml:"text" toml:"text"`
+
+	// Blocks renders the message's JSON array of Block Kit blocks, the
+	// message only has Text when it's empty
+	Blocks string `json:"blocks" yaml:"blocks" toml:"blocks"`
+}
+
+// DefaultTemplates are used for alert kinds Webhook has no template for
+var DefaultTemplates = map[string]Template{
+	alert.StreamOnline: {
+		Text: "{{.Stream.UserName}} is live: {{.Stream.Title}}",
+		Blocks: `[{"type": "section",
+			"text": {"type": "mrkdwn", "text": {{json (printf "*<https://www.twitch.tv/%s|%s> is live*\n%s" (lower .Stream.UserName) (mrkdwn .Stream.UserName) (mrkdwn .Stream.Title))}}}
+			{{- with .Stream.ThumbnailURL}},
+			"accessory": {"type": "image", "image_url": {{json (thumbnail . 320 180)}}, "alt_text": "Stream thumbnail"}{{end}}}
+			{{- with .Stream.GameName}},
+			{"type": "context", "elements": [{"type": "mrkdwn", "text": {{json (printf "Playing %s" (mrkdwn .))}}}]}{{end}}]`,
+	},
+	alert.Follow: {
+		Text: "{{.Follow.FromName}} followed {{.Follow.ToName}}",
+		Blocks: `[{"type": "section",
+			"text": {"type": "mrkdwn", "text": {{json (printf "*New follower*\n%s followed %s" (mrkdwn .Follow.FromName) (mrkdwn .Follow.ToName))}}}}]`,
+	},
+	alert.Subscribe: {
+		Text: "{{.Subscriber.UserName}} subscribed to {{.Subscriber.BroadcasterName}}",
+		Blocks: `[{"type": "section",
+			"text": {"type": "mrkdwn", "text": {{json (printf "*New subscriber*\n%s subscribed to %s" (mrkdwn .Subscriber.UserName) (mrkdwn .Subscriber.BroadcasterName))}}}}
+			{{- if or .Subscriber.PlanName .Subscriber.IsGift}},
+			{"type": "context", "elements": [{"type": "mrkdwn", "text": {{json (printf "%s%s" .Subscriber.PlanName (or (and .Subscriber.IsGift (printf ", gifted by %s" .Subscriber.GifterName)) ""))}}}]}{{end}}]`,
+	},
+	alert.Burst: {
+		Text: "{{.Burst.Count}} more {{.Burst.Kind}} alerts held back",
+		Blocks: `[{"type": "section",
+			"text": {"type": "mrkdwn", "text": {{json (printf "*Burst of %s alerts*\n%d more held back between %s and %s UTC" .Burst.Kind .Burst.Count (.Burst.Start.UTC.Format "15:04:05") (.Burst.End.UTC.Format "15:04:05"))}}}}]`,
+	},
+}
+
+// Webhook is a twitchhook.NotificationHandler posting the alerts of
+// notifications to a Slack incoming webhook url, one message per alert. Rate
+// limits and 5xx responses ask the hub to deliver the notification again,
+// other errors drop it.
+type Webhook struct {
+	URL string
+
+	// Client defaults to a client with a 10 second timeout
+	Client *http.Client
+
+	// Kinds selects the alerts posted, all of them when it's empty
+	Kinds []string
+
+	// Templates overrides DefaultTemplates by alert kind
+	Templates map[string]Template
+
+	// Limiter holds back bursts of alerts, posting summaries of kind
+	// alert.Burst in their place. Summaries are posted with the next
+	// notification's alerts unless the Limiter's OnSummary is set.
+	Limiter *alert.Limiter
+
+	detector alert.Detector
+}
+
+type message struct {
+	Text   string            `json:"text"`
+	Blocks []json.RawMessage `json:"blocks,omitempty"`
+}
+
+func init() {
+	twitchhook.RegisterSink("slack", Open)
+}
+
+// Open opens a Webhook for twitchhook bus configs from a slack:// url, such
+// as slack://hooks.slack.com/services/T/B/X, posting to the same url over https. A kinds
+// query parameter selects the alerts posted as a comma separated list.
+func Open(rawURL string) (twitchhook.NotificationHandler, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("slack: url must name a host")
+	}
+	w := &Webhook{}
+	q := u.Query()
+	if kinds := q.Get("kinds"); kinds != "" {
+		w.Kinds = strings.Split(kinds, ",")
+	}
+	q.Del("kinds")
+	u.Scheme = "https"
+	u.RawQuery = q.Encode()
+	w.URL = u.String()
+	return w, nil
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (w *Webhook) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	alerts, err := w.detector.Alerts(n)
+	if err != nil {
+		return twitchhook.Reject(err)
+	}
+	alerts = w.Limiter.Allow(alert.Select(alerts, w.Kinds))
+
+	for i, a := range alerts {
+		msg, err := w.message(a)
+		if err != nil {
+			return twitchhook.Reject(fmt.Errorf("slack: rendering alert: %w", err))
+		}
+		err = w.post(ctx, msg)
+		if err != nil {
+			// unsent streams going live are alerted on redelivery
+			for _, unsent := range alerts[i:] {
+				w.detector.Forget(unsent)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Webhook) message(a alert.Alert) (*message, error) {
+	t, ok := w.Templates[a.Kind]
+	if !ok {
+		t = DefaultTemplates[a.Kind]
+	}
+
+	text, err := format.Render(t.Text, a)
+	if err != nil {
+		return nil, err
+	}
+	msg := &message{Text: text}
+	blocks, err := format.Render(t.Blocks, a)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(blocks) != "" {
+		err = json.Unmarshal([]byte(blocks), &msg.Blocks)
+		if err != nil {
+			return nil, fmt.Errorf("blocks aren't a json array: %w", err)
+		}
+	}
+	return msg, nil
+}
+
+func (w *Webhook) post(ctx context.Context, msg *message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return twitchhook.Reject(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return twitchhook.Reject(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return twitchhook.RetryLater(0, fmt.Errorf("slack: posting alert: %w", err))
+	}
+	// errors are described by a short plain text body
+	reason, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return twitchhook.RetryLater(time.Duration(retryAfter)*time.Second, fmt.Errorf("slack: %s: %s", resp.Status, reason))
+	default:
+		return twitchhook.Reject(fmt.Errorf("slack: webhook rejected alert: %s: %s", resp.Status, reason))
+	}
+}
@@ -0,0 +1,370 @@
+// Package warehouse inserts decoded events into an analytics table in
+// batches, one row per follow, subscription event, user update and stream
+// change, so follows, subscriptions and stream uptime can be queried at
+// scale. It writes through database/sql, register a ClickHouse driver such
+// as github.com/ClickHouse/clickhouse-go/v2 or use any other database.
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// Kinds of rows
+const (
+	Follow        = "follow"
+	Stream        = "stream"
+	StreamOffline = "stream.offline"
+	User          = "user"
+	// subscription event rows have the event's type as kind, such as
+	// twitchhook.SubscriberEventSubscribe
+)
+
+// ClickHouseSchema creates the events table with its default name in
+// ClickHouse. Rows are ordered for per broadcaster queries, stream rows'
+// event_time is when the stream started so uptime is the span between a
+// stream's first row and the broadcaster's next stream.offline row.
+const ClickHouseSchema = `
+CREATE TABLE IF NOT EXISTS twitchhook_events (
+	received_at     DateTime64(3, 'UTC'),
+	event_time      DateTime64(3, 'UTC'),
+	notification_id String,
+	topic           String,
+	kind            LowCardinality(String),
+	broadcaster_id  String,
+	user_id         String,
+	user_name       String,
+	stream_id       String,
+	game_id         String,
+	title           String,
+	viewer_count    UInt32,
+	tier            LowCardinality(String),
+	is_gift         Bool,
+	payload         String
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMM(event_time)
+ORDER BY (kind, broadcaster_id, event_time)
+`
+
+// Schema creates the events table with its default name in PostgreSQL,
+// SQLite and other databases understanding standard types
+const Schema = `
+CREATE TABLE IF NOT EXISTS twitchhook_events (
+	received_at     TIMESTAMP NOT NULL,
+	event_time      TIMESTAMP NOT NULL,
+	notification_id TEXT NOT NULL,
+	topic           TEXT NOT NULL,
+	kind            TEXT NOT NULL,
+	broadcaster_id  TEXT NOT NULL,
+	user_id         TEXT NOT NULL,
+	user_name       TEXT NOT NULL,
+	stream_id       TEXT NOT NULL,
+	game_id         TEXT NOT NULL,
+	title           TEXT NOT NULL,
+	viewer_count    INTEGER NOT NULL,
+	tier            TEXT NOT NULL,
+	is_gift         BOOLEAN NOT NULL,
+	payload         TEXT NOT NULL
+);
+`
+
+// Placeholders are how a database names query parameters
+type Placeholders int
+
+// Placeholders
+const (
+	// Dollar placeholders, $1, $2, are understood by PostgreSQL and SQLite
+	Dollar Placeholders = iota
+	// Question placeholders, ?, are understood by ClickHouse and MySQL
+	Question
+)
+
+// Row is a row of the events table, fields not applying to its Kind are
+// empty
+type Row struct {
+	ReceivedAt     time.Time
+	EventTime      time.Time
+	NotificationID string
+	Topic          string
+	Kind           string
+	BroadcasterID  string
+	UserID         string
+	UserName       string
+	StreamID       string
+	GameID         string
+	Title          string
+	ViewerCount    int
+	Tier           string
+	IsGift         bool
+	// Payload is the event as json
+	Payload string
+}
+
+var columns = []string{
+	"received_at", "event_time", "notification_id", "topic", "kind",
+	"broadcaster_id", "user_id", "user_name", "stream_id", "game_id",
+	"title", "viewer_count", "tier", "is_gift", "payload",
+}
+
+func (r *Row) values() []interface{} {
+	return []interface{}{
+		r.ReceivedAt.UTC(), r.EventTime.UTC(), r.NotificationID, r.Topic, r.Kind,
+		r.BroadcasterID, r.UserID, r.UserName, r.StreamID, r.GameID,
+		r.Title, r.ViewerCount, r.Tier, r.IsGift, r.Payload,
+	}
+}
+
+// Rows returns n's rows, decoding it with twitchhook.DecodeEvent unless
+// middleware already did. Notifications of topics DecodeEvent doesn't know
+// have no rows.
+func Rows(n *twitchhook.Notification) ([]Row, error) {
+	event := n.Event
+	if event == nil {
+		var err error
+		event, err = twitchhook.DecodeEvent(n.Topic, n.Body)
+		if err == twitchhook.ErrUnknownTopic {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	received := n.ReceivedAt
+	if received.IsZero() {
+		received = n.Timestamp
+	}
+	row := func(kind string, at time.Time, v interface{}) (Row, error) {
+		if at.IsZero() {
+			at = n.Timestamp
+		}
+		if at.IsZero() {
+			at = received
+		}
+		payload, err := json.Marshal(v)
+		if err != nil {
+			return Row{}, err
+		}
+		return Row{
+			ReceivedAt:     received,
+			EventTime:      at,
+			NotificationID: n.ID,
+			Topic:          n.Topic,
+			Kind:           kind,
+			Payload:        string(payload),
+		}, nil
+	}
+
+	var rows []Row
+	switch event := event.(type) {
+	case *twitchhook.StreamChanged:
+		if len(event.Streams) == 0 {
+			// offline notifications only name the broadcaster in the topic
+			r, err := row(StreamOffline, time.Time{}, event)
+			if err != nil {
+				return nil, err
+			}
+			r.BroadcasterID = topicUserID(n.Topic)
+			rows = append(rows, r)
+		}
+		for i := range event.Streams {
+			s := &event.Streams[i]
+			r, err := row(Stream, s.StartedAt, s)
+			if err != nil {
+				return nil, err
+			}
+			r.BroadcasterID = s.UserID
+			r.UserID = s.UserID
+			r.UserName = s.UserName
+			r.StreamID = s.ID
+			r.GameID = s.GameID
+			r.Title = s.Title
+			r.ViewerCount = s.ViewerCount
+			rows = append(rows, r)
+		}
+	case *twitchhook.Follows:
+		for i := range event.Follows {
+			f := &event.Follows[i]
+			r, err := row(Follow, f.FollowedAt, f)
+			if err != nil {
+				return nil, err
+			}
+			r.BroadcasterID = f.ToID
+			r.UserID = f.FromID
+			r.UserName = f.FromName
+			rows = append(rows, r)
+		}
+	case *twitchhook.UserChanged:
+		for i := range event.Users {
+			u := &event.Users[i]
+			r, err := row(User, time.Time{}, u)
+			if err != nil {
+				return nil, err
+			}
+			r.BroadcasterID = u.ID
+			r.UserID = u.ID
+			r.UserName = u.Login
+			rows = append(rows, r)
+		}
+	case *twitchhook.SubscriberEvents:
+		for i := range event.Events {
+			e := &event.Events[i]
+			r, err := row(e.EventType, e.EventTimestamp, e)
+			if err != nil {
+				return nil, err
+			}
+			r.BroadcasterID = e.EventData.BroadcasterID
+			r.UserID = e.EventData.UserID
+			r.UserName = e.EventData.UserName
+			r.Tier = e.EventData.Tier
+			r.IsGift = e.EventData.IsGift
+			rows = append(rows, r)
+		}
+	}
+	return rows, nil
+}
+
+// Sink is a twitchhook.NotificationHandler inserting the Rows of
+// notifications into the events table, documented by ClickHouseSchema and
+// Schema. Notifications are grouped by a twitchhook.Batcher and each batch
+// is inserted in one transaction, ClickHouse drivers send it as one block.
+//
+// Errors inserting a batch ask the hub to deliver its notifications again,
+// notifications that can't be decoded are dropped.
+type Sink struct {
+	DB *sql.DB
+
+	// Table defaults to twitchhook_events
+	Table string
+
+	// Placeholders defaults to Dollar, set Question for ClickHouse
+	Placeholders Placeholders
+
+	// MaxSize and MaxWait bound batches like the twitchhook.Batcher's
+	MaxSize int
+	MaxWait time.Duration
+
+	// Clock defaults to twitchhook.SystemClock
+	Clock twitchhook.Clock
+
+	once    sync.Once
+	batcher *twitchhook.Batcher
+}
+
+func (s *Sink) setup() {
+	s.once.Do(func() {
+		s.batcher = &twitchhook.Batcher{
+			Handler: s,
+			MaxSize: s.MaxSize,
+			MaxWait: s.MaxWait,
+			Clock:   s.Clock,
+		}
+	})
+}
+
+// HandleNotification implements twitchhook.NotificationHandler, returning
+// once the notification's batch was inserted
+func (s *Sink) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	s.setup()
+	return s.batcher.HandleNotification(ctx, n)
+}
+
+// Flush inserts the pending batch now, such as before shutting down
+func (s *Sink) Flush() error {
+	s.setup()
+	return s.batcher.Flush()
+}
+
+// HandleBatch implements twitchhook.BatchHandler, inserting the rows of
+// batch in one transaction. Use it directly to bring your own Batcher.
+func (s *Sink) HandleBatch(ctx context.Context, batch []*twitchhook.Notification) error {
+	errs := make([]error, len(batch))
+	var failed bool
+	var rows []Row
+	for i, n := range batch {
+		nRows, err := Rows(n)
+		if err != nil {
+			errs[i] = twitchhook.Reject(fmt.Errorf("warehouse: decoding notification: %w", err))
+			failed = true
+			continue
+		}
+		rows = append(rows, nRows...)
+	}
+
+	err := s.insert(ctx, rows)
+	if err != nil {
+		return twitchhook.RetryLater(0, fmt.Errorf("warehouse: inserting rows: %w", err))
+	}
+	if failed {
+		return &twitchhook.BatchError{Errs: errs}
+	}
+	return nil
+}
+
+func (s *Sink) insert(ctx context.Context, rows []Row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	query, err := s.query()
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i := range rows {
+		_, err = stmt.ExecContext(ctx, rows[i].values()...)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+func (s *Sink) query() (string, error) {
+	t := s.Table
+	if t == "" {
+		t = "twitchhook_events"
+	}
+	if !tableName.MatchString(t) {
+		return "", fmt.Errorf("warehouse: invalid table name %q", t)
+	}
+
+	params := make([]string, len(columns))
+	for i := range params {
+		if s.Placeholders == Question {
+			params[i] = "?"
+		} else {
+			params[i] = fmt.Sprintf("$%d", i+1)
+		}
+	}
+	return "INSERT INTO " + t + " (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(params, ", ") + ")", nil
+}
+
+func topicUserID(topic string) string {
+	u, err := url.Parse(topic)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("user_id")
+}
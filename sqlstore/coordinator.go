@@ -0,0 +1,74 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// RenewalCoordinator is a twitchhook.RenewalCoordinator keeping renewal
+// claims in a leases table:
+//
+//	CREATE TABLE twitchhook_renewal_leases (
+//		topic      TEXT PRIMARY KEY,
+//		owner      TEXT NOT NULL,
+//		expires_at TIMESTAMP NOT NULL
+//	);
+type RenewalCoordinator struct {
+	DB *sql.DB
+
+	// Table defaults to twitchhook_renewal_leases
+	Table string
+
+	// Owner identifies this instance, defaults to the hostname and pid
+	Owner string
+
+	once sync.Once
+}
+
+func (c *RenewalCoordinator) setup() {
+	if c.Owner == "" {
+		c.Owner = defaultOwner()
+	}
+}
+
+// Acquire claims the renewal of topic for ttl, succeeding when nobody holds
+// the claim, this instance holds it or the holder's claim has lapsed
+func (c *RenewalCoordinator) Acquire(ctx context.Context, topic string, ttl time.Duration) (bool, error) {
+	c.once.Do(c.setup)
+
+	t, err := table(c.Table, "twitchhook_renewal_leases")
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	res, err := c.DB.ExecContext(ctx, `
+INSERT INTO `+t+` (topic, owner, expires_at) VALUES ($1, $2, $3)
+ON CONFLICT (topic) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+WHERE `+t+`.owner = excluded.owner OR `+t+`.expires_at < $4`,
+		topic, c.Owner, now.Add(ttl), now)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Release gives up a claim held by this instance
+func (c *RenewalCoordinator) Release(ctx context.Context, topic string) error {
+	c.once.Do(c.setup)
+
+	t, err := table(c.Table, "twitchhook_renewal_leases")
+	if err != nil {
+		return err
+	}
+
+	_, err = c.DB.ExecContext(ctx, `DELETE FROM `+t+` WHERE topic = $1 AND owner = $2`, topic, c.Owner)
+	return err
+}
@@ -0,0 +1,87 @@
+package sqlstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const topic = "https://api.twitch.tv/helix/streams?user_id=1"
+
+func acquire(t *testing.T, c *RenewalCoordinator, ttl time.Duration) bool {
+	t.Helper()
+	ok, err := c.Acquire(context.Background(), topic, ttl)
+	if err != nil {
+		t.Fatalf("Acquire by %s: %v", c.Owner, err)
+	}
+	return ok
+}
+
+func TestRenewalCoordinator(t *testing.T) {
+	db := newDB(t)
+	a := &RenewalCoordinator{DB: db, Owner: "a"}
+	b := &RenewalCoordinator{DB: db, Owner: "b"}
+
+	if !acquire(t, a, time.Hour) {
+		t.Fatal("couldn't claim an unclaimed topic")
+	}
+	if !acquire(t, a, time.Hour) {
+		t.Fatal("the holder couldn't extend its claim")
+	}
+	if acquire(t, b, time.Hour) {
+		t.Fatal("claimed a topic held by another instance")
+	}
+	if row := rows(t, db, "twitchhook_renewal_leases")[topic]; row["owner"] != "a" {
+		t.Fatalf("claim held by %v, want a", row["owner"])
+	}
+
+	// releasing someone else's claim is a no-op
+	if err := b.Release(context.Background(), topic); err != nil {
+		t.Fatal(err)
+	}
+	if acquire(t, b, time.Hour) {
+		t.Fatal("claimed a topic after releasing someone else's claim")
+	}
+
+	if err := a.Release(context.Background(), topic); err != nil {
+		t.Fatal(err)
+	}
+	if !acquire(t, b, time.Hour) {
+		t.Fatal("couldn't claim a released topic")
+	}
+}
+
+func TestRenewalCoordinatorLapsedClaim(t *testing.T) {
+	db := newDB(t)
+	a := &RenewalCoordinator{DB: db, Owner: "a"}
+	b := &RenewalCoordinator{DB: db, Owner: "b"}
+
+	if !acquire(t, a, -time.Minute) {
+		t.Fatal("couldn't claim an unclaimed topic")
+	}
+	if !acquire(t, b, time.Hour) {
+		t.Fatal("couldn't take over a lapsed claim")
+	}
+	if acquire(t, a, time.Hour) {
+		t.Fatal("the previous holder took back a claim it lost")
+	}
+}
+
+func TestRenewalCoordinatorDefaults(t *testing.T) {
+	db := newDB(t)
+	c := &RenewalCoordinator{DB: db}
+	if !acquire(t, c, time.Hour) {
+		t.Fatal("couldn't claim an unclaimed topic")
+	}
+	if c.Owner == "" || rows(t, db, "twitchhook_renewal_leases")[topic]["owner"] != c.Owner {
+		t.Fatalf("claim isn't held by the default owner %q", c.Owner)
+	}
+
+	c = &RenewalCoordinator{DB: db, Table: "leases; DROP TABLE twitchhook_renewal_leases"}
+	if _, err := c.Acquire(context.Background(), topic, time.Hour); err == nil {
+		t.Fatal("Acquire accepted an invalid table name")
+	}
+	if err := c.Release(context.Background(), topic); err == nil {
+		t.Fatal("Release accepted an invalid table name")
+	}
+}
@@ -0,0 +1,108 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// DeadLetters is a twitchhook.DeadLetterStore keeping letters as json in a
+// dead letters table:
+//
+//	CREATE TABLE twitchhook_dead_letters (
+//		id        TEXT PRIMARY KEY,
+//		topic     TEXT NOT NULL,
+//		failed_at TIMESTAMP NOT NULL,
+//		letter    TEXT NOT NULL
+//	);
+type DeadLetters struct {
+	DB *sql.DB
+
+	// Table defaults to twitchhook_dead_letters
+	Table string
+}
+
+// Put adds or replaces the letter with l's ID
+func (s *DeadLetters) Put(ctx context.Context, l *twitchhook.DeadLetter) error {
+	t, err := table(s.Table, "twitchhook_dead_letters")
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.ExecContext(ctx, `
+INSERT INTO `+t+` (id, topic, failed_at, letter) VALUES ($1, $2, $3, $4)
+ON CONFLICT (id) DO UPDATE SET topic = excluded.topic, failed_at = excluded.failed_at, letter = excluded.letter`,
+		l.ID, l.Topic, l.FailedAt.UTC(), string(bs))
+	return err
+}
+
+// Get returns the letter id
+func (s *DeadLetters) Get(ctx context.Context, id string) (*twitchhook.DeadLetter, error) {
+	t, err := table(s.Table, "twitchhook_dead_letters")
+	if err != nil {
+		return nil, err
+	}
+
+	var letter string
+	err = s.DB.QueryRowContext(ctx, `SELECT letter FROM `+t+` WHERE id = $1`, id).Scan(&letter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, twitchhook.ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l twitchhook.DeadLetter
+	err = json.Unmarshal([]byte(letter), &l)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// List returns every letter ordered by failure time
+func (s *DeadLetters) List(ctx context.Context) ([]twitchhook.DeadLetter, error) {
+	t, err := table(s.Table, "twitchhook_dead_letters")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT letter FROM `+t+` ORDER BY failed_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []twitchhook.DeadLetter
+	for rows.Next() {
+		var letter string
+		err = rows.Scan(&letter)
+		if err != nil {
+			return nil, err
+		}
+		var l twitchhook.DeadLetter
+		err = json.Unmarshal([]byte(letter), &l)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, l)
+	}
+	return letters, rows.Err()
+}
+
+// Remove removes the letter id
+func (s *DeadLetters) Remove(ctx context.Context, id string) error {
+	t, err := table(s.Table, "twitchhook_dead_letters")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.ExecContext(ctx, `DELETE FROM `+t+` WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,111 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+func letter(id string, failedAt time.Time) *twitchhook.DeadLetter {
+	return &twitchhook.DeadLetter{
+		ID:             id,
+		Topic:          topic,
+		SubscriptionID: "v1.a",
+		NotificationID: "notification-" + id,
+		ReceivedAt:     failedAt.Add(-time.Second),
+		Header:         http.Header{"Content-Type": {"application/json"}},
+		Body:           []byte(`{"data":[]}`),
+		FailedAt:       failedAt,
+		Error:          "handler failed",
+		Retry:          2,
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	s := &DeadLetters{DB: newDB(t)}
+
+	if l, err := s.Get(ctx, "missing"); !errors.Is(err, twitchhook.ErrDeadLetterNotFound) {
+		t.Fatalf("Get(missing) = %v, %v, want ErrDeadLetterNotFound", l, err)
+	}
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b, a, c := letter("b", at), letter("a", at), letter("c", at.Add(-time.Minute))
+	for _, l := range []*twitchhook.DeadLetter{b, a, c} {
+		if err := s.Put(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, a) {
+		t.Fatalf("Get = %+v, want %+v", got, a)
+	}
+
+	// letters are ordered by failure time, then id
+	list, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, l := range list {
+		ids = append(ids, l.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"c", "a", "b"}) {
+		t.Fatalf("List = %v, want [c a b]", ids)
+	}
+
+	// Put replaces letters, a failed redrive moves the letter back
+	c.FailedAt = at.Add(time.Minute)
+	c.Redrives++
+	if err := s.Put(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	list, err = s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || !reflect.DeepEqual(&list[2], c) {
+		t.Fatalf("List after replacing c = %+v, want c last", list)
+	}
+
+	if err := s.Remove(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(ctx, "missing"); err != nil {
+		t.Fatalf("Remove(missing): %v", err)
+	}
+	if l, err := s.Get(ctx, "a"); !errors.Is(err, twitchhook.ErrDeadLetterNotFound) {
+		t.Fatalf("Get after Remove = %v, %v, want ErrDeadLetterNotFound", l, err)
+	}
+	if list, err = s.List(ctx); err != nil || len(list) != 2 {
+		t.Fatalf("List after Remove = %v, %v", list, err)
+	}
+}
+
+func TestDeadLettersTable(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS letters (id TEXT PRIMARY KEY);`); err != nil {
+		t.Fatal(err)
+	}
+	s := &DeadLetters{DB: db, Table: "letters"}
+	if err := s.Put(ctx, letter("a", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows(t, db, "letters")) != 1 || len(rows(t, db, "twitchhook_dead_letters")) != 0 {
+		t.Fatal("Put didn't write to Table")
+	}
+
+	s.Table = "letters where 1=1"
+	if _, err := s.List(ctx); err == nil {
+		t.Fatal("List accepted an invalid table name")
+	}
+}
@@ -0,0 +1,164 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// Notification states of the idempotency table
+const (
+	stateClaimed   = "claimed"
+	stateCompleted = "completed"
+)
+
+// Idempotency is a twitchhook.IdempotencyStore keeping notification ids in
+// a table:
+//
+//	CREATE TABLE twitchhook_notification_ids (
+//		id         TEXT PRIMARY KEY,
+//		state      TEXT NOT NULL,
+//		expires_at TIMESTAMP NOT NULL
+//	);
+//
+// Handlers writing to the same database can use Handler instead, which
+// records the id in the handler's transaction so the two commit together.
+type Idempotency struct {
+	DB *sql.DB
+
+	// Table defaults to twitchhook_notification_ids
+	Table string
+
+	// Retention is how long Handler remembers completed ids, defaults to
+	// twitchhook.DefaultIdempotencyRetention
+	Retention time.Duration
+}
+
+// Claim implements twitchhook.IdempotencyStore
+func (s *Idempotency) Claim(ctx context.Context, id string, expiry time.Time) (bool, error) {
+	t, err := table(s.Table, "twitchhook_notification_ids")
+	if err != nil {
+		return false, err
+	}
+
+	res, err := s.DB.ExecContext(ctx, `
+INSERT INTO `+t+` (id, state, expires_at) VALUES ($1, $2, $3)
+ON CONFLICT (id) DO UPDATE SET state = excluded.state, expires_at = excluded.expires_at
+WHERE `+t+`.expires_at < $4`,
+		id, stateClaimed, expiry.UTC(), time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	var state string
+	err = s.DB.QueryRowContext(ctx, `SELECT state FROM `+t+` WHERE id = $1`, id).Scan(&state)
+	if errors.Is(err, sql.ErrNoRows) {
+		// released since the insert, the redelivery can claim it
+		return false, twitchhook.ErrNotificationInProgress
+	}
+	if err != nil {
+		return false, err
+	}
+	if state == stateCompleted {
+		return true, nil
+	}
+	return false, twitchhook.ErrNotificationInProgress
+}
+
+// Complete implements twitchhook.IdempotencyStore
+func (s *Idempotency) Complete(ctx context.Context, id string, expiry time.Time) error {
+	t, err := table(s.Table, "twitchhook_notification_ids")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.ExecContext(ctx, `
+INSERT INTO `+t+` (id, state, expires_at) VALUES ($1, $2, $3)
+ON CONFLICT (id) DO UPDATE SET state = excluded.state, expires_at = excluded.expires_at`,
+		id, stateCompleted, expiry.UTC())
+	return err
+}
+
+// Release implements twitchhook.IdempotencyStore
+func (s *Idempotency) Release(ctx context.Context, id string) error {
+	t, err := table(s.Table, "twitchhook_notification_ids")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.ExecContext(ctx, `DELETE FROM `+t+` WHERE id = $1 AND state = $2`, id, stateClaimed)
+	return err
+}
+
+// Sweep deletes expired ids
+func (s *Idempotency) Sweep(ctx context.Context) error {
+	t, err := table(s.Table, "twitchhook_notification_ids")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.ExecContext(ctx, `DELETE FROM `+t+` WHERE expires_at < $1`, time.Now().UTC())
+	return err
+}
+
+// Handler runs fn in a transaction that also records the notification's id
+// as completed, so fn's writes commit exactly once per id. Completed
+// notifications are acknowledged without calling fn and fn's errors roll the
+// transaction back. Concurrent attempts wait on the first one's transaction.
+func (s *Idempotency) Handler(fn func(ctx context.Context, tx *sql.Tx, n *twitchhook.Notification) error) twitchhook.NotificationHandler {
+	return twitchhook.NotificationHandlerFunc(func(ctx context.Context, n *twitchhook.Notification) error {
+		t, err := table(s.Table, "twitchhook_notification_ids")
+		if err != nil {
+			return err
+		}
+
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return twitchhook.RetryLater(0, err)
+		}
+		defer tx.Rollback()
+
+		if n.ID != "" {
+			retention := s.Retention
+			if retention <= 0 {
+				retention = twitchhook.DefaultIdempotencyRetention
+			}
+			now := time.Now().UTC()
+			res, err := tx.ExecContext(ctx, `
+INSERT INTO `+t+` (id, state, expires_at) VALUES ($1, $2, $3)
+ON CONFLICT (id) DO UPDATE SET state = excluded.state, expires_at = excluded.expires_at
+WHERE `+t+`.state <> $2 OR `+t+`.expires_at < $4`,
+				n.ID, stateCompleted, now.Add(retention), now)
+			if err != nil {
+				return twitchhook.RetryLater(0, err)
+			}
+			recorded, err := res.RowsAffected()
+			if err != nil {
+				return twitchhook.RetryLater(0, err)
+			}
+			if recorded == 0 {
+				return nil
+			}
+		}
+
+		err = fn(ctx, tx, n)
+		if err != nil {
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return twitchhook.RetryLater(0, err)
+		}
+		return nil
+	})
+}
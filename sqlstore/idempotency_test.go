@@ -0,0 +1,171 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+func claim(t *testing.T, s *Idempotency, id string, expiry time.Time) (bool, error) {
+	t.Helper()
+	completed, err := s.Claim(context.Background(), id, expiry)
+	if err != nil && !errors.Is(err, twitchhook.ErrNotificationInProgress) {
+		t.Fatalf("Claim(%s): %v", id, err)
+	}
+	return completed, err
+}
+
+func TestIdempotency(t *testing.T) {
+	ctx := context.Background()
+	s := &Idempotency{DB: newDB(t)}
+	expiry := time.Now().Add(time.Hour)
+
+	if completed, err := claim(t, s, "a", expiry); completed || err != nil {
+		t.Fatalf("Claim of a new id = %v, %v", completed, err)
+	}
+	if _, err := claim(t, s, "a", expiry); !errors.Is(err, twitchhook.ErrNotificationInProgress) {
+		t.Fatalf("Claim of a claimed id = %v, want ErrNotificationInProgress", err)
+	}
+
+	// released claims can be attempted again
+	if err := s.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if completed, err := claim(t, s, "a", expiry); completed || err != nil {
+		t.Fatalf("Claim of a released id = %v, %v", completed, err)
+	}
+
+	if err := s.Complete(ctx, "a", expiry); err != nil {
+		t.Fatal(err)
+	}
+	if completed, err := claim(t, s, "a", expiry); !completed || err != nil {
+		t.Fatalf("Claim of a completed id = %v, %v, want completed", completed, err)
+	}
+	// Release only drops claims, a late release doesn't forget completed ids
+	if err := s.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if completed, _ := claim(t, s, "a", expiry); !completed {
+		t.Fatal("Release forgot a completed id")
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	s := &Idempotency{DB: db}
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+
+	// the claims of crashed attempts and completed ids expire
+	if _, err := claim(t, s, "crashed", past); err != nil {
+		t.Fatal(err)
+	}
+	if completed, err := claim(t, s, "crashed", future); completed || err != nil {
+		t.Fatalf("Claim of an expired claim = %v, %v", completed, err)
+	}
+	if err := s.Complete(ctx, "completed", past); err != nil {
+		t.Fatal(err)
+	}
+	if completed, err := claim(t, s, "completed", future); completed || err != nil {
+		t.Fatalf("Claim of an expired completed id = %v, %v", completed, err)
+	}
+
+	for _, id := range []string{"expired-claim", "expired-completion"} {
+		if _, err := claim(t, s, id, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Complete(ctx, "expired-completion", past); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ids := rows(t, db, "twitchhook_notification_ids")
+	if len(ids) != 2 || ids["crashed"] == nil || ids["completed"] == nil {
+		t.Fatalf("ids left after Sweep = %v, want the unexpired ones", ids)
+	}
+}
+
+func TestIdempotencyHandler(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	s := &Idempotency{DB: db, Retention: time.Hour}
+
+	var calls int
+	fail := errors.New("handler failed")
+	var result error
+	h := s.Handler(func(ctx context.Context, tx *sql.Tx, n *twitchhook.Notification) error {
+		calls++
+		_, txErr := tx.ExecContext(ctx, `
+INSERT INTO twitchhook_dead_letters (id, topic, failed_at, letter) VALUES ($1, $2, $3, $4)
+ON CONFLICT (id) DO UPDATE SET letter = excluded.letter`,
+			n.ID+"-"+time.Now().String(), n.Topic, time.Now(), "written by the handler")
+		if txErr != nil {
+			return txErr
+		}
+		return result
+	})
+	n := &twitchhook.Notification{ID: "a", Topic: topic}
+	writes := func() int { return len(rows(t, db, "twitchhook_dead_letters")) }
+
+	// failures roll back the handler's writes and the id
+	result = fail
+	if got := h.HandleNotification(ctx, n); !errors.Is(got, fail) {
+		t.Fatalf("HandleNotification = %v, want the handler's error", got)
+	}
+	if writes() != 0 || len(rows(t, db, "twitchhook_notification_ids")) != 0 {
+		t.Fatal("a failed attempt's writes were committed")
+	}
+
+	result = nil
+	for range 2 {
+		if got := h.HandleNotification(ctx, n); got != nil {
+			t.Fatal(got)
+		}
+	}
+	if calls != 2 || writes() != 1 {
+		t.Fatalf("handler called %d times with %d writes, want the redelivery acknowledged without calling it", calls, writes())
+	}
+	if row := rows(t, db, "twitchhook_notification_ids")["a"]; row["state"] != stateCompleted {
+		t.Fatalf("id recorded as %v, want completed", row["state"])
+	}
+	// the handler's ids are shared with Claim
+	if completed, _ := claim(t, s, "a", time.Now().Add(time.Hour)); !completed {
+		t.Fatal("Claim of an id completed by Handler isn't completed")
+	}
+
+	// notifications without an id aren't deduplicated
+	for range 2 {
+		if got := h.HandleNotification(ctx, &twitchhook.Notification{Topic: topic}); got != nil {
+			t.Fatal(got)
+		}
+	}
+	if calls != 4 {
+		t.Fatalf("handler called %d times, want notifications without an id handled every time", calls)
+	}
+}
+
+func TestIdempotencyHandlerTakesOverClaims(t *testing.T) {
+	ctx := context.Background()
+	s := &Idempotency{DB: newDB(t)}
+	if _, err := claim(t, s, "a", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	h := s.Handler(func(context.Context, *sql.Tx, *twitchhook.Notification) error {
+		calls++
+		return nil
+	})
+	if err := h.HandleNotification(ctx, &twitchhook.Notification{ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatal("Handler skipped an id that was claimed but never completed")
+	}
+}
@@ -0,0 +1,63 @@
+// Package sqlstore implements twitchhook storage interfaces on database/sql.
+//
+// Queries use $n placeholders and INSERT ... ON CONFLICT, which PostgreSQL
+// and SQLite understand. Each type documents the table it expects, Schema
+// holds statements creating all of them.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// Schema creates the tables used by this package with their default names
+const Schema = `
+CREATE TABLE IF NOT EXISTS twitchhook_renewal_leases (
+	topic      TEXT PRIMARY KEY,
+	owner      TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS twitchhook_dead_letters (
+	id        TEXT PRIMARY KEY,
+	topic     TEXT NOT NULL,
+	failed_at TIMESTAMP NOT NULL,
+	letter    TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS twitchhook_notification_ids (
+	id         TEXT PRIMARY KEY,
+	state      TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+`
+
+// CreateTables runs Schema
+func CreateTables(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, Schema)
+	return err
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+func table(name, def string) (string, error) {
+	if name == "" {
+		return def, nil
+	}
+	if !tableName.MatchString(name) {
+		return "", fmt.Errorf("sqlstore: invalid table name %q", name)
+	}
+	return name, nil
+}
+
+// defaultOwner identifies this process
+func defaultOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
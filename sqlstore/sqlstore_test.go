@@ -0,0 +1,386 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDB is a database/sql driver running the statements this package
+// sends against in-memory tables: CREATE TABLE, INSERT ... ON CONFLICT DO
+// UPDATE, DELETE and SELECT with comparisons of columns to placeholders.
+// Transactions run one at a time on a copy of the tables, which replaces
+// them on commit.
+type fakeDB struct {
+	m      sync.Mutex
+	tables map[string]*fakeTable
+
+	// tx is held for the duration of a transaction
+	tx sync.Mutex
+}
+
+type fakeTable struct {
+	rows map[string]fakeRow
+}
+
+// fakeRow maps column names to values, rows are keyed by the conflict column
+type fakeRow map[string]driver.Value
+
+func (t *fakeTable) clone() *fakeTable {
+	c := &fakeTable{rows: make(map[string]fakeRow, len(t.rows))}
+	for key, row := range t.rows {
+		c.rows[key] = row
+	}
+	return c
+}
+
+// newDB returns a database backed by a new fakeDB with Schema's tables
+func newDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(&fakeDB{tables: make(map[string]*fakeTable)})
+	t.Cleanup(func() { db.Close() })
+	if err := CreateTables(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: db}, nil
+}
+
+func (db *fakeDB) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+
+	// tables is the transaction's copy of the tables while one is open
+	tables map[string]*fakeTable
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fakedb: prepared statements aren't supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.db.tx.Lock()
+	c.db.m.Lock()
+	defer c.db.m.Unlock()
+
+	c.tables = make(map[string]*fakeTable, len(c.db.tables))
+	for name, t := range c.db.tables {
+		c.tables[name] = t.clone()
+	}
+	return &fakeTx{c}, nil
+}
+
+type fakeTx struct {
+	c *fakeConn
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.c.db.m.Lock()
+	tx.c.db.tables = tx.c.tables
+	tx.c.db.m.Unlock()
+	return tx.end()
+}
+
+func (tx *fakeTx) Rollback() error {
+	return tx.end()
+}
+
+func (tx *fakeTx) end() error {
+	if tx.c.tables == nil {
+		return sql.ErrTxDone
+	}
+	tx.c.tables = nil
+	tx.c.db.tx.Unlock()
+	return nil
+}
+
+// run runs f on the transaction's tables or, outside of one, the database's
+func (c *fakeConn) run(f func(tables map[string]*fakeTable) error) error {
+	if c.tables != nil {
+		return f(c.tables)
+	}
+	c.db.m.Lock()
+	defer c.db.m.Unlock()
+	return f(c.db.tables)
+}
+
+var (
+	createTable = regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+) \(`)
+	upsert      = regexp.MustCompile(`^INSERT INTO (\w+) \(([\w, ]+)\) VALUES \([$\d, ]+\) ON CONFLICT \((\w+)\) DO UPDATE SET ([\w., =]+?)(?: WHERE (.*))?$`)
+	deleteFrom  = regexp.MustCompile(`^DELETE FROM (\w+) WHERE (.*)$`)
+	selectFrom  = regexp.MustCompile(`^SELECT (\w+) FROM (\w+)(?: WHERE (.*?))?(?: ORDER BY ([\w, ]+))?$`)
+	condition   = regexp.MustCompile(`^(?:(\w+)\.)?(\w+) (=|<>|<) (excluded\.\w+|\$\d+)$`)
+)
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	var n int64
+	err := c.run(func(tables map[string]*fakeTable) error {
+		var err error
+		n, err = exec(tables, strings.Join(strings.Fields(query), " "), query, args)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func exec(tables map[string]*fakeTable, query, raw string, args []driver.NamedValue) (int64, error) {
+	if strings.HasPrefix(query, "CREATE TABLE") {
+		for _, m := range createTable.FindAllStringSubmatch(raw, -1) {
+			if _, ok := tables[m[1]]; ok {
+				continue
+			}
+			tables[m[1]] = &fakeTable{rows: make(map[string]fakeRow)}
+		}
+		return 0, nil
+	}
+
+	if m := upsert.FindStringSubmatch(query); m != nil {
+		t, ok := tables[m[1]]
+		if !ok {
+			return 0, fmt.Errorf("fakedb: no table %s", m[1])
+		}
+		columns := strings.Split(m[2], ", ")
+		excluded := make(fakeRow, len(columns))
+		for i, column := range columns {
+			excluded[column] = args[i].Value
+		}
+		key := fmt.Sprint(excluded[m[3]])
+		old, exists := t.rows[key]
+		if !exists {
+			t.rows[key] = excluded
+			return 1, nil
+		}
+		if m[5] != "" {
+			ok, err := eval(m[5], " OR ", old, excluded, args)
+			if err != nil || !ok {
+				return 0, err
+			}
+		}
+		row := make(fakeRow, len(old))
+		for column, value := range old {
+			row[column] = value
+		}
+		for _, set := range strings.Split(m[4], ", ") {
+			column, value, _ := strings.Cut(set, " = ")
+			row[column] = excluded[strings.TrimPrefix(value, "excluded.")]
+		}
+		t.rows[key] = row
+		return 1, nil
+	}
+
+	if m := deleteFrom.FindStringSubmatch(query); m != nil {
+		t, ok := tables[m[1]]
+		if !ok {
+			return 0, fmt.Errorf("fakedb: no table %s", m[1])
+		}
+		var n int64
+		for key, row := range t.rows {
+			ok, err := eval(m[2], " AND ", row, nil, args)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				delete(t.rows, key)
+				n++
+			}
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("fakedb: unsupported statement %q", query)
+}
+
+// eval evaluates conditions joined by op, comparing row's columns to
+// placeholders or the excluded row of an upsert
+func eval(conditions, op string, row, excluded fakeRow, args []driver.NamedValue) (bool, error) {
+	for _, cond := range strings.Split(conditions, op) {
+		m := condition.FindStringSubmatch(cond)
+		if m == nil {
+			return false, fmt.Errorf("fakedb: unsupported condition %q", cond)
+		}
+		var value driver.Value
+		if column, ok := strings.CutPrefix(m[4], "excluded."); ok {
+			value = excluded[column]
+		} else {
+			var i int
+			fmt.Sscanf(m[4], "$%d", &i)
+			value = args[i-1].Value
+		}
+		cmp := compare(row[m[2]], value)
+		ok := m[3] == "=" && cmp == 0 || m[3] == "<>" && cmp != 0 || m[3] == "<" && cmp < 0
+		if ok == (op == " OR ") {
+			return ok, nil
+		}
+	}
+	return op == " AND ", nil
+}
+
+func compare(a, b driver.Value) int {
+	switch a := a.(type) {
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+	panic(fmt.Sprintf("fakedb: can't compare %T", a))
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	m := selectFrom.FindStringSubmatch(query)
+	if m == nil {
+		return nil, fmt.Errorf("fakedb: unsupported query %q", query)
+	}
+
+	var rows []fakeRow
+	err := c.run(func(tables map[string]*fakeTable) error {
+		t, ok := tables[m[2]]
+		if !ok {
+			return fmt.Errorf("fakedb: no table %s", m[2])
+		}
+		for _, row := range t.rows {
+			if m[3] != "" {
+				ok, err := eval(m[3], " AND ", row, nil, args)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+			}
+			rows = append(rows, row)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if m[4] != "" {
+		order := strings.Split(m[4], ", ")
+		sort.Slice(rows, func(i, j int) bool {
+			for _, column := range order {
+				if cmp := compare(rows[i][column], rows[j][column]); cmp != 0 {
+					return cmp < 0
+				}
+			}
+			return false
+		})
+	}
+	values := make([]driver.Value, len(rows))
+	for i, row := range rows {
+		values[i] = row[m[1]]
+	}
+	return &fakeRows{column: m[1], values: values}, nil
+}
+
+// fakeRows are the values of a single column
+type fakeRows struct {
+	column string
+	values []driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{r.column}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+// rows returns the rows of table
+func rows(t *testing.T, db *sql.DB, table string) map[string]fakeRow {
+	t.Helper()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var rows map[string]fakeRow
+	err = conn.Raw(func(c any) error {
+		return c.(*fakeConn).run(func(tables map[string]*fakeTable) error {
+			if t, ok := tables[table]; ok {
+				rows = t.clone().rows
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestTable(t *testing.T) {
+	tests := []struct {
+		name, want string
+		err        bool
+	}{
+		{name: "", want: "default"},
+		{name: "renewals", want: "renewals"},
+		{name: "twitchhook.renewal_leases", want: "twitchhook.renewal_leases"},
+		{name: "_leases2", want: "_leases2"},
+		{name: "2leases", err: true},
+		{name: "leases; DROP TABLE users", err: true},
+		{name: "leases--", err: true},
+		{name: `"leases"`, err: true},
+	}
+	for _, tt := range tests {
+		got, err := table(tt.name, "default")
+		if tt.err {
+			if err == nil {
+				t.Errorf("table(%q) = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("table(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestCreateTables(t *testing.T) {
+	db := newDB(t)
+	// the tables are only created if they don't exist
+	if err := CreateTables(context.Background(), db); err != nil {
+		t.Fatalf("CreateTables of existing tables: %v", err)
+	}
+	for _, table := range []string{"twitchhook_renewal_leases", "twitchhook_dead_letters", "twitchhook_notification_ids"} {
+		if rows(t, db, table) == nil {
+			t.Errorf("Schema didn't create %s", table)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Package sse streams verified notifications to clients as server-sent
+// events.
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"go.uber.org/zap"
+)
+
+// Stream defaults
+const (
+	DefaultHistory      = 256
+	DefaultClientBuffer = 32
+	DefaultKeepAlive    = 30 * time.Second
+)
+
+// Message is the json data of each event
+type Message struct {
+	ID         string          `json:"id,omitempty"`
+	Topic      string          `json:"topic"`
+	ReceivedAt time.Time       `json:"received_at"`
+	Event      interface{}     `json:"event,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+}
+
+type event struct {
+	id    uint64
+	topic string
+	data  []byte
+}
+
+// Stream is a twitchhook.NotificationHandler serving notifications as
+// server-sent events. Clients may filter with repeatable topic query
+// parameters and resume with the Last-Event-ID header or last_event_id query
+// parameter, events still in the history ring are replayed. Events for a client whose buffer is full are
+// dropped.
+type Stream struct {
+	// History is how many events are kept for resuming
+	History int
+
+	// ClientBuffer is the per client queue length
+	ClientBuffer int
+
+	// KeepAlive is how often a comment is sent to keep idle connections
+	// open
+	KeepAlive time.Duration
+
+	Logger *zap.Logger
+
+	m sync.RWMutex
+	// epoch distinguishes ids from previous processes, whose events can't
+	// be resumed
+	epoch   string
+	next    uint64
+	ring    []event
+	clients map[*client]struct{}
+}
+
+type client struct {
+	topics map[string]bool
+	c      chan event
+}
+
+func (c *client) wants(topic string) bool {
+	return len(c.topics) == 0 || c.topics[topic]
+}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (s *Stream) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	msg := Message{ID: n.ID, Topic: n.Topic, ReceivedAt: n.ReceivedAt, Event: n.Event}
+	if msg.Event == nil {
+		decoded, err := twitchhook.DecodeEvent(n.Topic, n.Body)
+		if err != nil && err != twitchhook.ErrUnknownTopic {
+			return err
+		}
+		msg.Event = decoded
+	}
+	if msg.Event == nil {
+		msg.Body = n.Body
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.init()
+	s.next++
+	e := event{id: s.next, topic: n.Topic, data: data}
+	history := s.History
+	if history <= 0 {
+		history = DefaultHistory
+	}
+	if len(s.ring) < history {
+		s.ring = append(s.ring, e)
+	} else {
+		s.ring[(e.id-1)%uint64(history)] = e
+	}
+
+	for c := range s.clients {
+		if !c.wants(n.Topic) {
+			continue
+		}
+		select {
+		case c.c <- e:
+		default:
+			s.logger().Warn("dropping event for slow sse client", zap.String("topic", n.Topic))
+		}
+	}
+	return nil
+}
+
+// since returns the buffered events after id, oldest first. It must be
+// called with s.m held.
+func (s *Stream) since(id uint64) []event {
+	var events []event
+	for _, e := range s.ring {
+		if e.id > id {
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].id < events[j].id
+	})
+	return events
+}
+
+// ServeHTTP streams events until the client disconnects
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	buffer := s.ClientBuffer
+	if buffer <= 0 {
+		buffer = DefaultClientBuffer
+	}
+	c := &client{topics: make(map[string]bool), c: make(chan event, buffer)}
+	for _, topic := range r.URL.Query()["topic"] {
+		c.topics[topic] = true
+	}
+
+	var replay []event
+	s.m.Lock()
+	s.init()
+	epoch := s.epoch
+	lastID, resume := parseLastEventID(r, epoch)
+	if resume {
+		replay = s.since(lastID)
+	}
+	if s.clients == nil {
+		s.clients = make(map[*client]struct{})
+	}
+	s.clients[c] = struct{}{}
+	s.m.Unlock()
+	defer func() {
+		s.m.Lock()
+		delete(s.clients, c)
+		s.m.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, e := range replay {
+		if !c.wants(e.topic) {
+			continue
+		}
+		err := writeEvent(w, epoch, e)
+		if err != nil {
+			return
+		}
+		lastID = e.id
+	}
+	flusher.Flush()
+
+	interval := s.KeepAlive
+	if interval <= 0 {
+		interval = DefaultKeepAlive
+	}
+	keepAlive := time.NewTicker(interval)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case e := <-c.c:
+			if e.id <= lastID {
+				// already replayed
+				continue
+			}
+			err = writeEvent(w, epoch, e)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// init must be called with s.m held
+func (s *Stream) init() {
+	if s.epoch == "" {
+		s.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+}
+
+// parseLastEventID parses ids of the form epoch-sequence, ids from another
+// epoch can't be resumed
+func parseLastEventID(r *http.Request, epoch string) (uint64, bool) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	i := strings.LastIndex(v, "-")
+	if i < 0 || v[:i] != epoch {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(v[i+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func writeEvent(w http.ResponseWriter, epoch string, e event) error {
+	_, err := fmt.Fprintf(w, "id: %s-%d\nevent: notification\ndata: %s\n\n", epoch, e.id, e.data)
+	return err
+}
+
+func (s *Stream) logger() *zap.Logger {
+	if s.Logger == nil {
+		return zap.NewNop()
+	}
+	return s.Logger
+}
@@ -0,0 +1,136 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// Start restores the handler's subscriptions from the Manager after a
+// restart, call it once the callback handler is serving. It recovers the
+// Outbox, resumes queued renewal retries, syncs the Quota and checks every
+// stored subscription against the hub: active ones the hub still holds have
+// their renewal scheduled, the rest are subscribed again. Active subscriptions this process
+// already renews are left alone, then stored secrets are checked according
+// to SecretAudit. Subscriptions are handled in Priority order. The Manager
+// must be a SubscriptionLister.
+//
+// Subscriptions loaded without funcs are bound to the denial callbacks in
+// Callbacks by topic, register them before calling Start.
+//
+// When the hub's subscriptions can't be listed the stored expiries are
+// trusted. Errors are returned once every subscription has been tried,
+// failed subscriptions are retried like failed renewals.
+func (m *TwitchWebhookHandler) Start(ctx context.Context) error {
+	m.once.Do(m.setup)
+
+	lister, ok := m.Manager.(SubscriptionLister)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	var errs []error
+	err := m.RecoverOutbox(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("recovering outbox: %w", err))
+	}
+	err = m.ResumeRenewalRetries()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("resuming renewal retries: %w", err))
+	}
+	err = m.SyncQuota(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("syncing quota: %w", err))
+	}
+
+	subs, err := lister.List()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	if len(subs) == 0 {
+		return errors.Join(errs...)
+	}
+	held, err := m.hubCallbacks(ctx)
+	if err != nil {
+		m.logger().Error("unable to list hub subscriptions, trusting stored expiries", zap.Error(err))
+	}
+
+	m.prioritizeSubscriptions(subs)
+	now := clockOrDefault(m.Clock).Now()
+	for _, sub := range subs {
+		err = ctx.Err()
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+
+		request := SubscriptionRequest{
+			Topic:           sub.Topic,
+			CallbackBaseURL: sub.CallbackBaseURL,
+			Lease:           sub.Lease,
+			Metadata:        sub.Metadata,
+		}
+		active := sub.State(now) == SubscriptionActive && (held == nil || held[sub.Topic+" "+sub.CallbackURL])
+		if !active {
+			m.logger().Info("subscribing stored subscription again", zap.String("topic", sub.Topic))
+			err = m.subscribe(ctx, request, sub.DenialCallback, true)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", sub.Topic, err))
+				m.retryRenewal(request, sub.DenialCallback, 1, err)
+			}
+			continue
+		}
+
+		if sub.Renew != nil {
+			// already renewed by this process
+			continue
+		}
+		restored := m.newSubscription(OutboxEntry{
+			Renewal:         request,
+			ID:              sub.ID,
+			Topic:           sub.Topic,
+			CallbackBaseURL: sub.CallbackBaseURL,
+			CallbackURL:     sub.CallbackURL,
+			Lease:           sub.Lease,
+			Secret:          sub.Secret,
+			SecretRef:       sub.SecretRef,
+			Metadata:        sub.Metadata,
+
+			PreviousSecret:          sub.PreviousSecret,
+			PreviousSecretRef:       sub.PreviousSecretRef,
+			PreviousSecretExpiresAt: sub.PreviousSecretExpiresAt,
+		}, m.denialCallback(sub))
+		restored.ExpiresAt = sub.ExpiresAt
+		err = m.Manager.Save(sub.Topic, restored)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: scheduling renewal: %w", sub.Topic, err))
+			continue
+		}
+		m.hookRenewalScheduled(sub.Topic, restored.renewalAt(now))
+	}
+
+	err = m.auditSecrets(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("auditing secrets: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// hubCallbacks returns the topic and callback pairs of the hub's unexpired
+// subscriptions, joined by a space
+func (m *TwitchWebhookHandler) hubCallbacks(ctx context.Context) (map[string]bool, error) {
+	subs, err := m.HubSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := clockOrDefault(m.Clock).Now()
+	held := make(map[string]bool, len(subs))
+	for _, sub := range subs {
+		if sub.ExpiresAt.IsZero() || sub.ExpiresAt.After(now) {
+			held[sub.Topic+" "+sub.Callback] = true
+		}
+	}
+	return held, nil
+}
@@ -0,0 +1,65 @@
+package twitchhook_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+func TestStartKeepsPreviousSecret(t *testing.T) {
+	h, hub, clock := newHubHandler(t)
+	sub := saveSubscription(t, h, testTopic, testSecret)
+	sub.PreviousSecret = "previous"
+	sub.PreviousSecretExpiresAt = clock.Now().Add(time.Minute)
+	if _, err := h.Manager.SetSubscriptionLease(testTopic, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if requests := hub.requests(); len(requests) != 0 {
+		t.Fatalf("Start sent %q for an active subscription", requests)
+	}
+	restored, err := h.Manager.Get(testTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Renew == nil {
+		t.Fatal("Start didn't schedule the renewal")
+	}
+	if !validate(t, h, signedRequest(t, restored, "sha256", "previous", testBody)) {
+		t.Fatal("previous secret stopped verifying after Start")
+	}
+
+	clock.Advance(time.Minute)
+	if validate(t, h, signedRequest(t, restored, "sha256", "previous", testBody)) {
+		t.Fatal("previous secret verified past its overlap")
+	}
+}
+
+func TestStartResubscribesExpired(t *testing.T) {
+	h, hub, clock := newHubHandler(t)
+	sub := saveSubscription(t, h, testTopic, testSecret)
+	if _, err := h.Manager.SetSubscriptionLease(testTopic, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	clock.Set(clock.Now().Add(2 * time.Hour))
+
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if requests := hub.requests(); len(requests) == 0 || requests[0] != "subscribe "+sub.CallbackURL {
+		t.Fatalf("hub requests = %q, want the expired subscription renewed at its callback", requests)
+	}
+}
+
+func TestStartRequiresLister(t *testing.T) {
+	h, _, _ := newHubHandler(t)
+	h.Manager = struct{ twitchhook.SubscriptionManager }{h.Manager}
+	if err := h.Start(context.Background()); err != twitchhook.ErrNotSupported {
+		t.Fatalf("Start = %v, want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,103 @@
+package twitchhook
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TopicStats are delivery counters for a topic. Retries counts the
+// notifications the hub redelivered, a rising RetryRate usually means the
+// handler is too slow to answer within the hub's timeout.
+type TopicStats struct {
+	Notifications     int64     `json:"notifications"`
+	Retries           int64     `json:"retries"`
+	RetryRate         float64   `json:"retry_rate"`
+	SignatureFailures int64     `json:"signature_failures"`
+	LastDeliveryAt    time.Time `json:"last_delivery_at"`
+	AveragePayload    float64   `json:"average_payload_bytes"`
+}
+
+type topicCounters struct {
+	m                 sync.Mutex
+	notifications     int64
+	retries           int64
+	signatureFailures int64
+	payloadBytes      int64
+	lastDeliveryAt    time.Time
+}
+
+func (c *topicCounters) snapshot() TopicStats {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	s := TopicStats{
+		Notifications:     c.notifications,
+		Retries:           c.retries,
+		SignatureFailures: c.signatureFailures,
+		LastDeliveryAt:    c.lastDeliveryAt,
+	}
+	if c.notifications > 0 {
+		s.AveragePayload = float64(c.payloadBytes) / float64(c.notifications)
+		s.RetryRate = float64(c.retries) / float64(c.notifications)
+	}
+	return s
+}
+
+func (m *TwitchWebhookHandler) counters(topic string) *topicCounters {
+	v, ok := m.stats.Load(topic)
+	if !ok {
+		v, _ = m.stats.LoadOrStore(topic, &topicCounters{})
+	}
+	return v.(*topicCounters)
+}
+
+// recordDelivery counts a verified notification, retry is the hub's retry
+// count for it
+func (m *TwitchWebhookHandler) recordDelivery(topic string, size, retry int) {
+	if retry > 0 {
+		m.metrics().IncCounter("twitchhook_notification_retries_total", "topic", topic)
+		m.logger().Info("notification redelivered by the hub", zap.String("topic", topic), zap.Int("retry", retry))
+	}
+
+	c := m.counters(topic)
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.notifications++
+	if retry > 0 {
+		c.retries++
+	}
+	c.payloadBytes += int64(size)
+	c.lastDeliveryAt = clockOrDefault(m.Clock).Now()
+}
+
+func (m *TwitchWebhookHandler) recordSignatureFailure(topic string) {
+	c := m.counters(topic)
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.signatureFailures++
+}
+
+// TopicStats returns the delivery counters for a topic
+func (m *TwitchWebhookHandler) TopicStats(topic string) (TopicStats, bool) {
+	v, ok := m.stats.Load(topic)
+	if !ok {
+		return TopicStats{}, false
+	}
+	return v.(*topicCounters).snapshot(), true
+}
+
+// Stats returns the delivery counters for every topic that has received a
+// notification or a signature failure since the handler started. A topic's
+// counters are dropped when it's unsubscribed, denied or evicted.
+func (m *TwitchWebhookHandler) Stats() map[string]TopicStats {
+	stats := make(map[string]TopicStats)
+	m.stats.Range(func(k, v interface{}) bool {
+		stats[k.(string)] = v.(*topicCounters).snapshot()
+		return true
+	})
+	return stats
+}
@@ -0,0 +1,62 @@
+package twitchhook_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// deliver serves a notification for sub through h's callback handler
+func deliver(t *testing.T, h *twitchhook.TwitchWebhookHandler, sub *twitchhook.Subscription) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.SubscriptionCallbackHandler().ServeHTTP(w, signedRequest(t, sub, "sha256", testSecret, testBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("notification answered %d", w.Code)
+	}
+	if _, ok := h.TopicStats(sub.Topic); !ok {
+		t.Fatal("the notification wasn't counted")
+	}
+}
+
+func TestTopicStatsDropped(t *testing.T) {
+	h, _, _ := newHubHandler(t)
+	unsubscribed := saveSubscription(t, h, testTopic, testSecret)
+	denied := saveSubscription(t, h, testTopic+"2", testSecret)
+	kept := saveSubscription(t, h, testTopic+"3", testSecret)
+	for _, sub := range []*twitchhook.Subscription{unsubscribed, denied, kept} {
+		deliver(t, h, sub)
+	}
+
+	if err := h.Unsubscribe(unsubscribed.Topic); err != nil {
+		t.Fatal(err)
+	}
+	q := url.Values{"hub.mode": {"denied"}, "hub.topic": {denied.Topic}, "hub.reason": {"unauthorized"}}
+	req := httptest.NewRequest(http.MethodGet, testBaseURL+"?"+q.Encode(), nil)
+	h.SubscriptionCallbackHandler().ServeHTTP(httptest.NewRecorder(), req)
+
+	stats := h.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("Stats = %v, want only the subscribed topic's", stats)
+	}
+	if _, ok := stats[kept.Topic]; !ok {
+		t.Fatal("Stats dropped the subscribed topic")
+	}
+}
+
+func TestTopicStatsDroppedOnEviction(t *testing.T) {
+	h, _, clock := newHubHandler(t)
+	h.Manager = twitchhook.NewLRUCache(clock, 1, nil)
+	// the handler registers for evictions when it's set up
+	h.Client()
+
+	evicted := saveSubscription(t, h, testTopic, testSecret)
+	deliver(t, h, evicted)
+	saveSubscription(t, h, testTopic+"2", testSecret)
+	if _, ok := h.TopicStats(evicted.Topic); ok {
+		t.Fatal("TopicStats kept the evicted topic's counters")
+	}
+}
@@ -0,0 +1,304 @@
+// Package storetest checks twitchhook.SubscriptionManager implementations
+// against the behaviour the handler relies on.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+)
+
+// Epoch is the time the clocks given to NewManager start at, stores only
+// need to keep times to the second
+var Epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// NewManager returns an empty SubscriptionManager scheduling renewals with
+// clock
+type NewManager func(t *testing.T, clock twitchhook.Clock) twitchhook.SubscriptionManager
+
+// TestSubscriptionManager runs the contract tests against managers from
+// newManager, each subtest gets a new one. The optional SubscriptionLister,
+// SubscriptionIDIndex and Pinger are tested when they're implemented.
+func TestSubscriptionManager(t *testing.T, newManager NewManager) {
+	tests := []struct {
+		name string
+		f    func(t *testing.T, m twitchhook.SubscriptionManager, clock *clocktest.Clock)
+	}{
+		{"GetMissing", testGetMissing},
+		{"SaveGet", testSaveGet},
+		{"SaveReplaces", testSaveReplaces},
+		{"Delete", testDelete},
+		{"SetSubscriptionLease", testSetSubscriptionLease},
+		{"Renewals", testRenewals},
+		{"GetByID", testGetByID},
+		{"List", testList},
+		{"Ping", testPing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := clocktest.NewClock(Epoch)
+			tt.f(t, newManager(t, clock), clock)
+		})
+	}
+}
+
+// Subscription returns a subscription to topic with every persisted field
+// set
+func Subscription(topic string, id twitchhook.SubscriptionID) *twitchhook.Subscription {
+	return &twitchhook.Subscription{
+		ID:                      id,
+		Topic:                   topic,
+		CallbackBaseURL:         "https://example.com/callback",
+		CallbackURL:             "https://example.com/callback/" + string(id),
+		Lease:                   time.Hour,
+		Secret:                  "0123456789abcdef0123456789abcdef",
+		SecretRef:               "ref/" + string(id),
+		Namespace:               "tenant",
+		ExpiresAt:               Epoch.Add(time.Hour),
+		Metadata:                map[string]string{"channel": "1"},
+		PreviousSecret:          "fedcba9876543210fedcba9876543210",
+		PreviousSecretRef:       "ref/previous",
+		PreviousSecretExpiresAt: Epoch.Add(10 * time.Minute),
+	}
+}
+
+const (
+	topic      = "https://api.twitch.tv/helix/streams?user_id=1"
+	otherTopic = "https://api.twitch.tv/helix/users/follows?first=1&to_id=1"
+)
+
+// CheckSubscription fails t unless got has the persisted fields of want
+func CheckSubscription(t *testing.T, got, want *twitchhook.Subscription) {
+	t.Helper()
+	if got == nil {
+		t.Fatal("got a nil subscription")
+	}
+	g, w := normalize(got.Record()), normalize(want.Record())
+	if !reflect.DeepEqual(g, w) {
+		t.Fatalf("subscription = %+v, want %+v", g, w)
+	}
+}
+
+// normalize drops differences stores don't preserve: time zones, monotonic
+// readings and empty metadata maps
+func normalize(r twitchhook.SubscriptionRecord) twitchhook.SubscriptionRecord {
+	r.ExpiresAt = utc(r.ExpiresAt)
+	r.PreviousSecretExpiresAt = utc(r.PreviousSecretExpiresAt)
+	if len(r.Metadata) == 0 {
+		r.Metadata = nil
+	}
+	return r
+}
+
+func utc(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Time{}
+	}
+	return t.UTC().Round(0)
+}
+
+func save(t *testing.T, m twitchhook.SubscriptionManager, topic string, sub *twitchhook.Subscription) {
+	t.Helper()
+	if err := m.Save(topic, sub); err != nil {
+		t.Fatalf("Save(%q): %v", topic, err)
+	}
+}
+
+func get(t *testing.T, m twitchhook.SubscriptionManager, topic string) *twitchhook.Subscription {
+	t.Helper()
+	sub, err := m.Get(topic)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", topic, err)
+	}
+	return sub
+}
+
+func checkMissing(t *testing.T, m twitchhook.SubscriptionManager, topic string) {
+	t.Helper()
+	if sub, err := m.Get(topic); !errors.Is(err, twitchhook.ErrSubscriptionNotFound) {
+		t.Fatalf("Get(%q) = %v, %v, want ErrSubscriptionNotFound", topic, sub, err)
+	}
+}
+
+func testGetMissing(t *testing.T, m twitchhook.SubscriptionManager, _ *clocktest.Clock) {
+	checkMissing(t, m, topic)
+}
+
+func testSaveGet(t *testing.T, m twitchhook.SubscriptionManager, _ *clocktest.Clock) {
+	want := Subscription(topic, "v1.a")
+	save(t, m, topic, want)
+	CheckSubscription(t, get(t, m, topic), want)
+
+	// pending subscriptions have no expiry and optional fields are empty
+	pending := &twitchhook.Subscription{
+		ID:              "v1.b",
+		Topic:           otherTopic,
+		CallbackBaseURL: "https://example.com/callback",
+		CallbackURL:     "https://example.com/callback/v1.b",
+		Lease:           time.Hour,
+		Secret:          "0123456789abcdef0123456789abcdef",
+	}
+	save(t, m, otherTopic, pending)
+	CheckSubscription(t, get(t, m, otherTopic), pending)
+	CheckSubscription(t, get(t, m, topic), want)
+}
+
+func testSaveReplaces(t *testing.T, m twitchhook.SubscriptionManager, _ *clocktest.Clock) {
+	save(t, m, topic, Subscription(topic, "v1.old"))
+	replacement := Subscription(topic, "v1.new")
+	replacement.Secret = "replaced-secret-replaced-secret-"
+	replacement.Metadata = nil
+	save(t, m, topic, replacement)
+	CheckSubscription(t, get(t, m, topic), replacement)
+
+	if index, ok := m.(twitchhook.SubscriptionIDIndex); ok {
+		if sub, err := index.GetByID("v1.old"); !errors.Is(err, twitchhook.ErrSubscriptionNotFound) {
+			t.Fatalf("GetByID(replaced id) = %v, %v, want ErrSubscriptionNotFound", sub, err)
+		}
+	}
+}
+
+func testDelete(t *testing.T, m twitchhook.SubscriptionManager, _ *clocktest.Clock) {
+	if err := m.Delete(topic); err != nil {
+		t.Fatalf("Delete of a missing subscription: %v", err)
+	}
+
+	save(t, m, topic, Subscription(topic, "v1.a"))
+	save(t, m, otherTopic, Subscription(otherTopic, "v1.b"))
+	if err := m.Delete(topic); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	checkMissing(t, m, topic)
+	get(t, m, otherTopic)
+
+	if index, ok := m.(twitchhook.SubscriptionIDIndex); ok {
+		if sub, err := index.GetByID("v1.a"); !errors.Is(err, twitchhook.ErrSubscriptionNotFound) {
+			t.Fatalf("GetByID(deleted id) = %v, %v, want ErrSubscriptionNotFound", sub, err)
+		}
+	}
+}
+
+func testSetSubscriptionLease(t *testing.T, m twitchhook.SubscriptionManager, clock *clocktest.Clock) {
+	exists, err := m.SetSubscriptionLease(topic, time.Hour)
+	if err != nil || exists {
+		t.Fatalf("SetSubscriptionLease of a missing subscription = %v, %v", exists, err)
+	}
+	checkMissing(t, m, topic)
+
+	want := Subscription(topic, "v1.a")
+	want.ExpiresAt = time.Time{}
+	save(t, m, topic, want)
+
+	clock.Advance(time.Minute)
+	exists, err = m.SetSubscriptionLease(topic, 2*time.Hour)
+	if err != nil || !exists {
+		t.Fatalf("SetSubscriptionLease = %v, %v", exists, err)
+	}
+	want.Lease = 2 * time.Hour
+	want.ExpiresAt = clock.Now().Add(2 * time.Hour)
+	got := get(t, m, topic)
+	CheckSubscription(t, got, want)
+	if state := got.State(clock.Now()); state != twitchhook.SubscriptionActive {
+		t.Fatalf("state after SetSubscriptionLease = %s, want active", state)
+	}
+}
+
+func testRenewals(t *testing.T, m twitchhook.SubscriptionManager, clock *clocktest.Clock) {
+	var renewals int
+	sub := Subscription(topic, "v1.a")
+	sub.ExpiresAt = time.Time{}
+	sub.Renew = func() { renewals++ }
+	save(t, m, topic, sub)
+	if loaded := get(t, m, topic); loaded.Renew == nil {
+		t.Fatal("Get didn't attach the saving process's Renew")
+	}
+
+	if _, err := m.SetSubscriptionLease(topic, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if renewals != 0 {
+		t.Fatal("renewed at the lease SetSubscriptionLease replaced")
+	}
+	clock.Advance(time.Hour)
+	if renewals != 1 {
+		t.Fatalf("renewed %d times at the confirmed lease, want 1", renewals)
+	}
+
+	if err := m.Delete(topic); err != nil {
+		t.Fatal(err)
+	}
+	save(t, m, topic, &twitchhook.Subscription{ID: "v1.b", Topic: topic, Lease: time.Hour, Renew: func() { renewals++ }})
+	if err := m.Delete(topic); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Hour)
+	if renewals != 1 {
+		t.Fatal("renewed a deleted subscription")
+	}
+}
+
+func testGetByID(t *testing.T, m twitchhook.SubscriptionManager, _ *clocktest.Clock) {
+	index, ok := m.(twitchhook.SubscriptionIDIndex)
+	if !ok {
+		t.Skip("not a SubscriptionIDIndex")
+	}
+	if sub, err := index.GetByID("v1.missing"); !errors.Is(err, twitchhook.ErrSubscriptionNotFound) {
+		t.Fatalf("GetByID(missing) = %v, %v, want ErrSubscriptionNotFound", sub, err)
+	}
+
+	a, b := Subscription(topic, "v1.a"), Subscription(otherTopic, "v1.b")
+	save(t, m, topic, a)
+	save(t, m, otherTopic, b)
+	for _, want := range []*twitchhook.Subscription{a, b} {
+		got, err := index.GetByID(want.ID)
+		if err != nil {
+			t.Fatalf("GetByID(%s): %v", want.ID, err)
+		}
+		CheckSubscription(t, got, want)
+	}
+}
+
+func testList(t *testing.T, m twitchhook.SubscriptionManager, _ *clocktest.Clock) {
+	lister, ok := m.(twitchhook.SubscriptionLister)
+	if !ok {
+		t.Skip("not a SubscriptionLister")
+	}
+	subs, err := lister.List()
+	if err != nil || len(subs) != 0 {
+		t.Fatalf("List of an empty manager = %v, %v", subs, err)
+	}
+
+	want := map[string]*twitchhook.Subscription{
+		topic:      Subscription(topic, "v1.a"),
+		otherTopic: Subscription(otherTopic, "v1.b"),
+	}
+	for topic, sub := range want {
+		save(t, m, topic, sub)
+	}
+	subs, err = lister.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(subs) != len(want) {
+		t.Fatalf("List returned %d subscriptions, want %d", len(subs), len(want))
+	}
+	for _, sub := range subs {
+		CheckSubscription(t, sub, want[sub.Topic])
+	}
+}
+
+func testPing(t *testing.T, m twitchhook.SubscriptionManager, _ *clocktest.Clock) {
+	pinger, ok := m.(twitchhook.Pinger)
+	if !ok {
+		t.Skip("not a Pinger")
+	}
+	if err := pinger.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
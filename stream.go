@@ -0,0 +1,121 @@
+package twitchhook
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ErrInvalidSignature is returned when a notification's signature is missing
+// or doesn't match its body
+var ErrInvalidSignature = errors.New("invalid notification signature")
+
+// ValidateSignatureStream validates the notification without buffering the
+// body. The returned reader hashes the body as it's read and, once the body is
+// exhausted, returns ErrInvalidSignature instead of io.EOF if the signature
+// doesn't match. Callers must read to EOF before trusting anything they've
+// read and must close the reader.
+func (m *TwitchWebhookHandler) ValidateSignatureStream(r *http.Request) (io.ReadCloser, error) {
+	subscription, err := m.requestSubscription(r)
+	if err != nil {
+		r.Body.Close()
+		return nil, err
+	}
+
+	sig, err := parseSignature(r.Header.Get(HeaderSignature))
+	if err != nil {
+		r.Body.Close()
+		m.recordSignatureFailure(subscription.Topic)
+		return nil, err
+	}
+
+	secret, err := m.subscriptionSecret(r.Context(), subscription)
+	if err != nil {
+		r.Body.Close()
+		return nil, err
+	}
+
+	v := &verifyingReader{
+		body: r.Body,
+		mac:  sig.newMAC(secret),
+		want: sig.digest(),
+	}
+	if subscription.previousSecretAccepted(clockOrDefault(m.Clock).Now()) {
+		previous, err := m.previousSubscriptionSecret(r.Context(), subscription)
+		if err != nil {
+			v.Close()
+			return nil, err
+		}
+		v.previous = sig.newMAC(previous)
+	}
+	v.verify = func(valid bool, size int64) error {
+		if !valid {
+			m.recordSignatureFailure(subscription.Topic)
+			return ErrInvalidSignature
+		}
+		err := m.checkReplay(r.Header)
+		if err != nil {
+			return err
+		}
+		m.recordDelivery(subscription.Topic, int(size), notificationRetry(r.Header))
+		return nil
+	}
+	return v, nil
+}
+
+type verifyingReader struct {
+	body   io.ReadCloser
+	mac    *mac
+	want   []byte
+	verify func(valid bool, size int64) error
+	size   int64
+	err    error
+
+	// previous hashes the body with the secret a renewal rotated out
+	previous *mac
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+
+	n, err := v.body.Read(p)
+	v.mac.Write(p[:n])
+	if v.previous != nil {
+		v.previous.Write(p[:n])
+	}
+	v.size += int64(n)
+	if err == io.EOF {
+		v.err = io.EOF
+		valid := v.mac.equal(v.want)
+		if !valid && v.previous != nil {
+			valid = v.previous.equal(v.want)
+		}
+		v.release()
+		if verr := v.verify(valid, v.size); verr != nil {
+			v.err = verr
+		}
+		return n, v.err
+	}
+	if err != nil {
+		v.err = err
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	v.release()
+	return v.body.Close()
+}
+
+func (v *verifyingReader) release() {
+	if v.mac != nil {
+		v.mac.release()
+		v.mac = nil
+	}
+	if v.previous != nil {
+		v.previous.release()
+		v.previous = nil
+	}
+}
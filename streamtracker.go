@@ -0,0 +1,311 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultOfflineDebounce is how long a stream has to stay offline before
+// the StreamTracker reports it went offline
+const DefaultOfflineDebounce = 2 * time.Minute
+
+// WentLive is reported when a user's stream starts
+type WentLive struct {
+	UserID string
+	Stream Stream
+	// At is when the session started, the stream's started_at when twitch
+	// sent it
+	At time.Time
+}
+
+// WentOffline is reported once a user's stream stayed offline for the
+// tracker's OfflineDebounce
+type WentOffline struct {
+	UserID string
+	// StreamID is the session's last stream id
+	StreamID  string
+	StartedAt time.Time
+	// EndedAt is when the first offline notification of the session's end
+	// was sent
+	EndedAt  time.Time
+	Duration time.Duration
+}
+
+// StreamStatus is a tracked user's state
+type StreamStatus struct {
+	Live bool
+	// Stream is the last stream seen, it's kept while offline is debounced
+	Stream *Stream
+	// Since is when the session started while live, otherwise when it
+	// ended. It's zero for users not seen live since the tracker started.
+	Since time.Time
+}
+
+// StreamTracker follows the stream changed topics of UserIDs, turning
+// twitch's notifications into WentLive and WentOffline events. Twitch sends
+// offline and online notifications in quick succession when a stream's
+// connection drops, offline notifications are held for OfflineDebounce and
+// dropped when the stream comes back, keeping the session going. Repeated
+// online notifications, such as for title changes, aren't reported.
+//
+// Subscribe registers the tracker as the handler of its topics, it can also
+// be used as a NotificationHandler of stream changed notifications.
+type StreamTracker struct {
+	Handler *TwitchWebhookHandler
+	UserIDs []string
+
+	// CallbackBaseURL and Lease apply to every topic, they default to the
+	// handler's
+	CallbackBaseURL string
+	Lease           time.Duration
+
+	// OfflineDebounce defaults to DefaultOfflineDebounce
+	OfflineDebounce time.Duration
+
+	// OnLive and OnOffline are called with the tracker's events, outside of
+	// its lock. OnOffline is called from a timer's goroutine.
+	OnLive    func(e WentLive)
+	OnOffline func(e WentOffline)
+
+	// Clock defaults to the Handler's clock
+	Clock Clock
+
+	// Logger defaults to a no-op logger
+	Logger *zap.Logger
+
+	m     sync.Mutex
+	users map[string]*trackedStream
+}
+
+type trackedStream struct {
+	live    bool
+	stream  *Stream
+	started time.Time
+	ended   time.Time
+	// last is the timestamp of the latest notification, older ones
+	// delivered late are ignored
+	last time.Time
+
+	offline Timer
+}
+
+// Subscribe registers the tracker for the stream topic of every user and
+// subscribes them, unsubscribing them again when any subscription fails
+func (t *StreamTracker) Subscribe(ctx context.Context) error {
+	topics := t.topics()
+	for _, topic := range topics {
+		t.Handler.HandleTopic(topic, t)
+	}
+	g := &SubscriptionGroup{Handler: t.Handler, Topics: topics, CallbackBaseURL: t.CallbackBaseURL, Lease: t.Lease}
+	err := g.Subscribe(ctx)
+	if err != nil {
+		for _, topic := range topics {
+			t.Handler.topicHandlers.Delete(topic)
+		}
+		return err
+	}
+	return nil
+}
+
+// Unsubscribe unsubscribes every user's stream topic and stops pending
+// offline timers, continuing past errors
+func (t *StreamTracker) Unsubscribe(ctx context.Context) error {
+	g := &SubscriptionGroup{Handler: t.Handler, Topics: t.topics()}
+	err := g.Unsubscribe(ctx)
+	t.Stop()
+	return err
+}
+
+// Stop stops pending offline timers, their offline events aren't reported
+func (t *StreamTracker) Stop() {
+	t.m.Lock()
+	defer t.m.Unlock()
+	for _, u := range t.users {
+		if u.offline != nil {
+			u.offline.Stop()
+			u.offline = nil
+		}
+	}
+}
+
+// Status returns userID's state
+func (t *StreamTracker) Status(userID string) StreamStatus {
+	t.m.Lock()
+	defer t.m.Unlock()
+	u, ok := t.users[userID]
+	if !ok {
+		return StreamStatus{}
+	}
+	status := StreamStatus{Live: u.live, Stream: u.stream, Since: u.ended}
+	if u.live {
+		status.Since = u.started
+	}
+	return status
+}
+
+// HandleNotification implements NotificationHandler for stream changed
+// notifications, other topics are ignored
+func (t *StreamTracker) HandleNotification(ctx context.Context, n *Notification) error {
+	event := n.Event
+	if event == nil {
+		var err error
+		event, err = DecodeEvent(n.Topic, n.Body)
+		if errors.Is(err, ErrUnknownTopic) {
+			return nil
+		}
+		if err != nil {
+			return Reject(err)
+		}
+	}
+	changed, ok := event.(*StreamChanged)
+	if !ok {
+		return nil
+	}
+	userID := streamTopicUserID(n.Topic)
+	if userID == "" {
+		return nil
+	}
+
+	at := n.Timestamp
+	if at.IsZero() {
+		at = t.clock().Now()
+	}
+	if len(changed.Streams) == 0 {
+		t.wentOffline(userID, at)
+		return nil
+	}
+	t.wentLive(userID, changed.Streams[0], at)
+	return nil
+}
+
+func (t *StreamTracker) user(userID string) *trackedStream {
+	if t.users == nil {
+		t.users = make(map[string]*trackedStream)
+	}
+	u, ok := t.users[userID]
+	if !ok {
+		u = &trackedStream{}
+		t.users[userID] = u
+	}
+	return u
+}
+
+func (t *StreamTracker) wentLive(userID string, s Stream, at time.Time) {
+	t.m.Lock()
+	u := t.user(userID)
+	if at.Before(u.last) {
+		t.m.Unlock()
+		return
+	}
+	u.last = at
+	u.stream = &s
+	if u.offline != nil {
+		// the stream came back within the debounce, the session goes on
+		u.offline.Stop()
+		u.offline = nil
+		t.m.Unlock()
+		t.logger().Debug("stream flapped", zap.String("user_id", userID), zap.String("stream_id", s.ID))
+		return
+	}
+	if u.live {
+		t.m.Unlock()
+		return
+	}
+	u.live = true
+	u.started = s.StartedAt
+	if u.started.IsZero() {
+		u.started = at
+	}
+	e := WentLive{UserID: userID, Stream: s, At: u.started}
+	t.m.Unlock()
+
+	t.logger().Info("stream went live", zap.String("user_id", userID), zap.String("stream_id", s.ID))
+	if t.OnLive != nil {
+		t.OnLive(e)
+	}
+}
+
+func (t *StreamTracker) wentOffline(userID string, at time.Time) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	u := t.user(userID)
+	if at.Before(u.last) {
+		return
+	}
+	u.last = at
+	if !u.live || u.offline != nil {
+		return
+	}
+
+	var timer Timer
+	timer = t.clock().AfterFunc(t.offlineDebounce(), func() {
+		t.m.Lock()
+		if u.offline != timer {
+			// stopped or superseded
+			t.m.Unlock()
+			return
+		}
+		u.offline = nil
+		u.live = false
+		u.ended = at
+		e := WentOffline{
+			UserID:    userID,
+			StartedAt: u.started,
+			EndedAt:   at,
+			Duration:  at.Sub(u.started),
+		}
+		if u.stream != nil {
+			e.StreamID = u.stream.ID
+		}
+		t.m.Unlock()
+
+		t.logger().Info("stream went offline", zap.String("user_id", userID), zap.String("stream_id", e.StreamID), zap.Duration("duration", e.Duration))
+		if t.OnOffline != nil {
+			t.OnOffline(e)
+		}
+	})
+	u.offline = timer
+}
+
+func (t *StreamTracker) topics() []string {
+	topics := make([]string, len(t.UserIDs))
+	for i, userID := range t.UserIDs {
+		topics[i] = StreamTopic(userID)
+	}
+	return topics
+}
+
+func (t *StreamTracker) offlineDebounce() time.Duration {
+	if t.OfflineDebounce <= 0 {
+		return DefaultOfflineDebounce
+	}
+	return t.OfflineDebounce
+}
+
+func (t *StreamTracker) clock() Clock {
+	if t.Clock == nil && t.Handler != nil {
+		return clockOrDefault(t.Handler.Clock)
+	}
+	return clockOrDefault(t.Clock)
+}
+
+func (t *StreamTracker) logger() *zap.Logger {
+	if t.Logger == nil {
+		return nopLogger
+	}
+	return t.Logger
+}
+
+// streamTopicUserID returns the user of a stream changed topic
+func streamTopicUserID(topic string) string {
+	u, err := url.Parse(topic)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("user_id")
+}
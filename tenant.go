@@ -0,0 +1,204 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NamespacedManager stores subscriptions in a shared SubscriptionManager
+// under keys prefixed by Namespace, so several tenants can share one store
+type NamespacedManager struct {
+	Manager   SubscriptionManager
+	Namespace string
+}
+
+func (n *NamespacedManager) key(topic string) string {
+	return n.Namespace + "/" + topic
+}
+
+// Get retrieves a subscription
+func (n *NamespacedManager) Get(topic string) (*Subscription, error) {
+	return n.Manager.Get(n.key(topic))
+}
+
+// Save saves a subscription, setting its Namespace
+func (n *NamespacedManager) Save(topic string, sub *Subscription) error {
+	sub.Namespace = n.Namespace
+	return n.Manager.Save(n.key(topic), sub)
+}
+
+// Delete removes a subscription
+func (n *NamespacedManager) Delete(topic string) error {
+	return n.Manager.Delete(n.key(topic))
+}
+
+// SetSubscriptionLease sets a subscription's lease
+func (n *NamespacedManager) SetSubscriptionLease(topic string, lease time.Duration) (bool, error) {
+	return n.Manager.SetSubscriptionLease(n.key(topic), lease)
+}
+
+// List implements SubscriptionLister when Manager does, returning only the
+// namespace's subscriptions
+func (n *NamespacedManager) List() ([]*Subscription, error) {
+	lister, ok := n.Manager.(SubscriptionLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	all, err := lister.List()
+	if err != nil {
+		return nil, err
+	}
+	var subs []*Subscription
+	for _, sub := range all {
+		if sub.Namespace == n.Namespace {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+// GetByID implements SubscriptionIDIndex when Manager does
+func (n *NamespacedManager) GetByID(id SubscriptionID) (*Subscription, error) {
+	index, ok := n.Manager.(SubscriptionIDIndex)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	sub, err := index.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil || sub.Namespace != n.Namespace {
+		return nil, ErrSubscriptionNotFound
+	}
+	return sub, nil
+}
+
+// RenewalAt implements RenewalScheduler when Manager does
+func (n *NamespacedManager) RenewalAt(topic string) (time.Time, error) {
+	scheduler, ok := n.Manager.(RenewalScheduler)
+	if !ok {
+		return time.Time{}, ErrNotSupported
+	}
+	return scheduler.RenewalAt(n.key(topic))
+}
+
+// NotifyEviction implements EvictionNotifier when Manager does, f is only
+// called with the namespace's subscriptions
+func (n *NamespacedManager) NotifyEviction(f func(sub *Subscription)) {
+	notifier, ok := n.Manager.(EvictionNotifier)
+	if !ok {
+		return
+	}
+	notifier.NotifyEviction(func(sub *Subscription) {
+		if sub.Namespace == n.Namespace {
+			f(sub)
+		}
+	})
+}
+
+// Ping implements Pinger when Manager does
+func (n *NamespacedManager) Ping(ctx context.Context) error {
+	if pinger, ok := n.Manager.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// ErrUnknownTenant is returned for tenants that aren't in a HandlerPool
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// HandlerPool serves the callbacks of several handlers, one per tenant, each
+// with its own Twitch application credentials. Callbacks are routed by the
+// first path segment, which is the tenant.
+type HandlerPool struct {
+	// CallbackBaseURL is the url the pool is served on, handlers added
+	// without a CallbackBaseURL get CallbackBaseURL/{tenant}
+	CallbackBaseURL string
+
+	// Manager is shared storage, handlers added without a Manager get a
+	// NamespacedManager over it
+	Manager SubscriptionManager
+
+	m       sync.RWMutex
+	tenants map[string]*TwitchWebhookHandler
+}
+
+// Add adds a tenant's handler to the pool, replacing any existing handler for
+// the tenant
+func (p *HandlerPool) Add(tenant string, h *TwitchWebhookHandler) error {
+	if tenant == "" || strings.Contains(tenant, "/") {
+		return errors.New("tenant must be non-empty and must not contain /")
+	}
+
+	if h.CallbackBaseURL == "" && p.CallbackBaseURL != "" {
+		h.CallbackBaseURL = strings.TrimSuffix(p.CallbackBaseURL, "/") + "/" + url.PathEscape(tenant)
+	}
+	if h.Manager == nil {
+		if p.Manager == nil {
+			return errors.New("handler has no Manager and the pool has none to share")
+		}
+		h.Manager = &NamespacedManager{Manager: p.Manager, Namespace: tenant}
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.tenants == nil {
+		p.tenants = make(map[string]*TwitchWebhookHandler)
+	}
+	p.tenants[tenant] = h
+	return nil
+}
+
+// Remove removes a tenant from the pool, it doesn't unsubscribe its topics
+func (p *HandlerPool) Remove(tenant string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	delete(p.tenants, tenant)
+}
+
+// Get returns a tenant's handler
+func (p *HandlerPool) Get(tenant string) (*TwitchWebhookHandler, error) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	h, ok := p.tenants[tenant]
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	return h, nil
+}
+
+// Tenants returns the tenants in the pool
+func (p *HandlerPool) Tenants() []string {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	tenants := make([]string, 0, len(p.tenants))
+	for tenant := range p.tenants {
+		tenants = append(tenants, tenant)
+	}
+	return tenants
+}
+
+// ServeHTTP routes /{tenant}/... to the tenant's SubscriptionCallbackHandler
+func (p *HandlerPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	escaped := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	if i := strings.Index(escaped, "/"); i >= 0 {
+		escaped = escaped[:i]
+	}
+	tenant, err := url.PathUnescape(escaped)
+	if err != nil {
+		http.Error(w, "invalid tenant", http.StatusBadRequest)
+		return
+	}
+
+	h, err := p.Get(tenant)
+	if err != nil {
+		http.Error(w, "unknown tenant", http.StatusNotFound)
+		return
+	}
+	h.SubscriptionCallbackHandler().ServeHTTP(w, r)
+}
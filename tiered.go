@@ -0,0 +1,241 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TieredManager defaults
+const (
+	DefaultNegativeTTL  = 30 * time.Second
+	DefaultNegativeSize = 10000
+)
+
+// TieredManager stacks a fast Cache in front of a durable Store. Reads are
+// served from Cache, filling it from Store on a miss, and writes go to Store
+// before Cache. Topics and ids Store doesn't have are remembered for
+// NegativeTTL, so lookups of unknown subscriptions don't reach Store every
+// time.
+//
+// Store schedules renewals, subscriptions are written to Cache without their
+// Renew func and evictions from Cache aren't reported. Cache isn't told about
+// other replicas' writes, give it an expiry when Store is shared.
+type TieredManager struct {
+	Cache SubscriptionManager
+	Store SubscriptionManager
+
+	// NegativeTTL defaults to DefaultNegativeTTL, negative caching is off
+	// when it's negative
+	NegativeTTL time.Duration
+
+	// NegativeSize bounds the remembered misses, defaults to
+	// DefaultNegativeSize
+	NegativeSize int
+
+	// Clock defaults to SystemClock
+	Clock Clock
+
+	m      sync.Mutex
+	misses map[string]time.Time
+}
+
+// storageKey returns the topic sub was saved under, which is prefixed by its
+// namespace behind a NamespacedManager
+func storageKey(sub *Subscription) string {
+	if sub.Namespace == "" {
+		return sub.Topic
+	}
+	return (&NamespacedManager{Namespace: sub.Namespace}).key(sub.Topic)
+}
+
+// missed reports whether key was recently missing from Store
+func (t *TieredManager) missed(key string) bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	expires, ok := t.misses[key]
+	if !ok {
+		return false
+	}
+	if clockOrDefault(t.Clock).Now().After(expires) {
+		delete(t.misses, key)
+		return false
+	}
+	return true
+}
+
+func (t *TieredManager) miss(key string) {
+	if t.NegativeTTL < 0 {
+		return
+	}
+	ttl := t.NegativeTTL
+	if ttl == 0 {
+		ttl = DefaultNegativeTTL
+	}
+	size := t.NegativeSize
+	if size <= 0 {
+		size = DefaultNegativeSize
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.misses == nil || len(t.misses) >= size {
+		t.misses = make(map[string]time.Time)
+	}
+	t.misses[key] = clockOrDefault(t.Clock).Now().Add(ttl)
+}
+
+func (t *TieredManager) forget(keys ...string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	for _, key := range keys {
+		delete(t.misses, key)
+	}
+}
+
+// fill writes sub to Cache, dropping the topic from Cache if that fails so a
+// stale subscription isn't served
+func (t *TieredManager) fill(topic string, sub *Subscription) {
+	cached := *sub
+	cached.Renew = nil
+	if t.Cache.Save(topic, &cached) != nil {
+		t.Cache.Delete(topic)
+	}
+}
+
+// Get retrieves a subscription from Cache or Store. Cache errors fall back
+// to Store.
+func (t *TieredManager) Get(topic string) (*Subscription, error) {
+	if t.missed("t:" + topic) {
+		return nil, ErrSubscriptionNotFound
+	}
+	sub, err := t.Cache.Get(topic)
+	if err == nil && sub != nil {
+		return sub, nil
+	}
+
+	sub, err = t.Store.Get(topic)
+	if err == nil && sub == nil {
+		err = ErrSubscriptionNotFound
+	}
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		t.miss("t:" + topic)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.fill(topic, sub)
+	return sub, nil
+}
+
+// GetByID implements SubscriptionIDIndex when Store does, Cache is only
+// consulted when it's a SubscriptionIDIndex too
+func (t *TieredManager) GetByID(id SubscriptionID) (*Subscription, error) {
+	index, ok := t.Store.(SubscriptionIDIndex)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	if t.missed("i:" + string(id)) {
+		return nil, ErrSubscriptionNotFound
+	}
+	if cache, ok := t.Cache.(SubscriptionIDIndex); ok {
+		sub, err := cache.GetByID(id)
+		if err == nil && sub != nil {
+			return sub, nil
+		}
+	}
+
+	sub, err := index.GetByID(id)
+	if err == nil && sub == nil {
+		err = ErrSubscriptionNotFound
+	}
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		t.miss("i:" + string(id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.fill(storageKey(sub), sub)
+	return sub, nil
+}
+
+// Save writes a subscription to Store, then Cache
+func (t *TieredManager) Save(topic string, sub *Subscription) error {
+	err := t.Store.Save(topic, sub)
+	if err != nil {
+		return err
+	}
+	t.forget("t:"+topic, "i:"+string(sub.ID))
+	t.fill(topic, sub)
+	return nil
+}
+
+// Delete removes a subscription from Store and Cache
+func (t *TieredManager) Delete(topic string) error {
+	err := t.Store.Delete(topic)
+	if err != nil {
+		return err
+	}
+	return t.Cache.Delete(topic)
+}
+
+// SetSubscriptionLease sets the lease in Store, then Cache
+func (t *TieredManager) SetSubscriptionLease(topic string, lease time.Duration) (bool, error) {
+	ok, err := t.Store.SetSubscriptionLease(topic, lease)
+	if err != nil || !ok {
+		return ok, err
+	}
+	_, err = t.Cache.SetSubscriptionLease(topic, lease)
+	if err != nil {
+		t.Cache.Delete(topic)
+	}
+	return true, nil
+}
+
+// List implements SubscriptionLister when Store does
+func (t *TieredManager) List() ([]*Subscription, error) {
+	lister, ok := t.Store.(SubscriptionLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return lister.List()
+}
+
+// RenewalAt implements RenewalScheduler when Store does
+func (t *TieredManager) RenewalAt(topic string) (time.Time, error) {
+	scheduler, ok := t.Store.(RenewalScheduler)
+	if !ok {
+		return time.Time{}, ErrNotSupported
+	}
+	return scheduler.RenewalAt(topic)
+}
+
+// NotifyEviction implements EvictionNotifier when Store does, dropping
+// evicted subscriptions from Cache before calling f
+func (t *TieredManager) NotifyEviction(f func(sub *Subscription)) {
+	notifier, ok := t.Store.(EvictionNotifier)
+	if !ok {
+		return
+	}
+	notifier.NotifyEviction(func(sub *Subscription) {
+		t.Cache.Delete(storageKey(sub))
+		f(sub)
+	})
+}
+
+// Ping implements Pinger, pinging whichever of Cache and Store are Pingers
+func (t *TieredManager) Ping(ctx context.Context) error {
+	for _, m := range []SubscriptionManager{t.Cache, t.Store} {
+		if pinger, ok := m.(Pinger); ok {
+			err := pinger.Ping(ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package twitchhook
+
+import (
+	"sync"
+	"time"
+)
+
+// SubscriptionTimers schedules renewals for SubscriptionManagers backed by
+// external stores, which only hold a subscription's persistent fields. It
+// keeps the Renew and DenialCallback funcs of subscriptions saved by this
+// process. The zero value is ready to use.
+type SubscriptionTimers struct {
+	// Clock defaults to SystemClock
+	Clock Clock
+
+	m      sync.Mutex
+	timers map[string]*subscriptionTimer
+}
+
+type subscriptionTimer struct {
+	timer   Timer
+	at      time.Time
+	renew   func()
+	denied  func(reason string)
+	expired bool
+}
+
+// Save schedules sub's renewal for its expiry, or after its lease while it's
+// pending, replacing any renewal scheduled for topic
+func (t *SubscriptionTimers) Save(topic string, sub *Subscription) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.stop(topic)
+	if sub.Renew == nil && sub.DenialCallback == nil {
+		return
+	}
+	if t.timers == nil {
+		t.timers = make(map[string]*subscriptionTimer)
+	}
+	st := &subscriptionTimer{renew: sub.Renew, denied: sub.DenialCallback}
+	t.timers[topic] = st
+	now := clockOrDefault(t.Clock).Now()
+	t.schedule(st, sub.renewalAt(now).Sub(now))
+}
+
+// Extend reschedules topic's renewal after lease, it's a no-op for topics
+// this process didn't save
+func (t *SubscriptionTimers) Extend(topic string, lease time.Duration) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	st, ok := t.timers[topic]
+	if !ok {
+		return
+	}
+	st.timer.Stop()
+	t.schedule(st, lease)
+}
+
+// Delete cancels topic's renewal
+func (t *SubscriptionTimers) Delete(topic string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.stop(topic)
+}
+
+// Attach sets the Renew and DenialCallback funcs of a subscription loaded
+// from the store when this process saved it
+func (t *SubscriptionTimers) Attach(topic string, sub *Subscription) *Subscription {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if st, ok := t.timers[topic]; ok {
+		sub.Renew = st.renew
+		sub.DenialCallback = st.denied
+	}
+	return sub
+}
+
+// RenewalAt returns when topic's renewal runs, it's zero when none is
+// scheduled
+func (t *SubscriptionTimers) RenewalAt(topic string) time.Time {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	st, ok := t.timers[topic]
+	if !ok || st.expired {
+		return time.Time{}
+	}
+	return st.at
+}
+
+// schedule starts st's timer, the caller holds t.m
+func (t *SubscriptionTimers) schedule(st *subscriptionTimer, lease time.Duration) {
+	clock := clockOrDefault(t.Clock)
+	st.at = clock.Now().Add(lease)
+	st.expired = false
+	st.timer = clock.AfterFunc(lease, func() {
+		t.m.Lock()
+		st.expired = true
+		t.m.Unlock()
+
+		if st.renew != nil {
+			st.renew()
+		}
+	})
+}
+
+// stop cancels topic's timer, the caller holds t.m
+func (t *SubscriptionTimers) stop(topic string) {
+	if st, ok := t.timers[topic]; ok {
+		st.timer.Stop()
+		delete(t.timers, topic)
+	}
+}
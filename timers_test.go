@@ -0,0 +1,170 @@
+package twitchhook_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/clocktest"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestSubscriptionTimersRenewAtExpiry(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	timers := &twitchhook.SubscriptionTimers{Clock: clock}
+
+	var renewals int
+	timers.Save("topic", &twitchhook.Subscription{
+		Topic:     "topic",
+		Lease:     time.Hour,
+		ExpiresAt: epoch.Add(30 * time.Minute),
+		Renew:     func() { renewals++ },
+	})
+	if at := timers.RenewalAt("topic"); !at.Equal(epoch.Add(30 * time.Minute)) {
+		t.Fatalf("RenewalAt = %v, want the expiry", at)
+	}
+
+	clock.Advance(30*time.Minute - time.Second)
+	if renewals != 0 {
+		t.Fatalf("renewed %d times before the expiry", renewals)
+	}
+	clock.Advance(time.Second)
+	if renewals != 1 {
+		t.Fatalf("renewed %d times at the expiry, want 1", renewals)
+	}
+	if at := timers.RenewalAt("topic"); !at.IsZero() {
+		t.Fatalf("RenewalAt = %v after the renewal ran, want zero", at)
+	}
+}
+
+func TestSubscriptionTimersPendingRenewsAfterLease(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	timers := &twitchhook.SubscriptionTimers{Clock: clock}
+
+	var renewals int
+	timers.Save("topic", &twitchhook.Subscription{
+		Topic: "topic",
+		Lease: time.Hour,
+		Renew: func() { renewals++ },
+	})
+	clock.Advance(time.Hour)
+	if renewals != 1 {
+		t.Fatalf("renewed %d times a lease after saving, want 1", renewals)
+	}
+}
+
+func TestSubscriptionTimersExtend(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	timers := &twitchhook.SubscriptionTimers{Clock: clock}
+
+	var renewals int
+	timers.Save("topic", &twitchhook.Subscription{
+		Topic: "topic",
+		Lease: time.Hour,
+		Renew: func() { renewals++ },
+	})
+	clock.Advance(30 * time.Minute)
+	timers.Extend("topic", 2*time.Hour)
+
+	clock.Advance(time.Hour)
+	if renewals != 0 {
+		t.Fatal("renewed at the lease the confirmation replaced")
+	}
+	clock.Advance(time.Hour)
+	if renewals != 1 {
+		t.Fatalf("renewed %d times at the extended lease, want 1", renewals)
+	}
+}
+
+func TestSubscriptionTimersDelete(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	timers := &twitchhook.SubscriptionTimers{Clock: clock}
+
+	timers.Save("topic", &twitchhook.Subscription{
+		Topic: "topic",
+		Lease: time.Hour,
+		Renew: func() { t.Error("renewed a deleted subscription") },
+	})
+	timers.Delete("topic")
+	clock.Advance(2 * time.Hour)
+	if n := clock.Pending(); n != 0 {
+		t.Fatalf("%d timers pending after Delete", n)
+	}
+}
+
+func TestSubscriptionTimersSaveReplaces(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	timers := &twitchhook.SubscriptionTimers{Clock: clock}
+
+	var first, second int
+	timers.Save("topic", &twitchhook.Subscription{Lease: time.Hour, Renew: func() { first++ }})
+	timers.Save("topic", &twitchhook.Subscription{Lease: 2 * time.Hour, Renew: func() { second++ }})
+	clock.Advance(2 * time.Hour)
+	if first != 0 || second != 1 {
+		t.Fatalf("renewals = %d, %d, want only the replacement's", first, second)
+	}
+}
+
+func TestSubscriptionTimersAttach(t *testing.T) {
+	timers := &twitchhook.SubscriptionTimers{Clock: clocktest.NewClock(epoch)}
+
+	var denied string
+	timers.Save("topic", &twitchhook.Subscription{
+		Lease:          time.Hour,
+		Renew:          func() {},
+		DenialCallback: func(reason string) { denied = reason },
+	})
+
+	loaded := timers.Attach("topic", &twitchhook.Subscription{Topic: "topic"})
+	if loaded.Renew == nil || loaded.DenialCallback == nil {
+		t.Fatal("Attach didn't bind the saved funcs")
+	}
+	loaded.DenialCallback("reason")
+	if denied != "reason" {
+		t.Fatalf("denial callback got %q", denied)
+	}
+
+	other := timers.Attach("other", &twitchhook.Subscription{Topic: "other"})
+	if other.Renew != nil || other.DenialCallback != nil {
+		t.Fatal("Attach bound funcs to a topic this process didn't save")
+	}
+}
+
+func TestInMemoryCacheLease(t *testing.T) {
+	clock := clocktest.NewClock(epoch)
+	cache := &twitchhook.InMemoryCache{Clock: clock}
+
+	var renewals int
+	sub := &twitchhook.Subscription{Topic: "topic", Lease: time.Hour, Renew: func() { renewals++ }}
+	if err := cache.Save("topic", sub); err != nil {
+		t.Fatal(err)
+	}
+	if state := sub.State(clock.Now()); state != twitchhook.SubscriptionPending {
+		t.Fatalf("state = %s before confirmation, want pending", state)
+	}
+
+	exists, err := cache.SetSubscriptionLease("topic", 2*time.Hour)
+	if err != nil || !exists {
+		t.Fatalf("SetSubscriptionLease = %v, %v", exists, err)
+	}
+	if state := sub.State(clock.Now()); state != twitchhook.SubscriptionActive {
+		t.Fatalf("state = %s after confirmation, want active", state)
+	}
+
+	clock.Advance(time.Hour)
+	if renewals != 0 {
+		t.Fatal("renewed at the pending lease after confirmation")
+	}
+	clock.Advance(time.Hour)
+	if renewals != 1 {
+		t.Fatalf("renewed %d times at the confirmed lease, want 1", renewals)
+	}
+	if state := sub.State(clock.Now()); state != twitchhook.SubscriptionExpired {
+		t.Fatalf("state = %s past the lease, want expired", state)
+	}
+
+	if exists, _ := cache.SetSubscriptionLease("missing", time.Hour); exists {
+		t.Fatal("SetSubscriptionLease reported an unknown topic as existing")
+	}
+}
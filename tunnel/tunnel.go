@@ -0,0 +1,173 @@
+// Package tunnel exposes a local receiver at a public https url through a
+// tunnel agent such as ngrok or cloudflared, so real hub deliveries reach a
+// development machine.
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultStartTimeout bounds the wait for the agent to report its url
+const DefaultStartTimeout = 30 * time.Second
+
+// ErrNoURL is returned when the agent exits or times out before reporting its
+// public url
+var ErrNoURL = errors.New("tunnel: agent didn't report a public url")
+
+// Command runs a tunnel agent and reads its public url from the agent's
+// output
+type Command struct {
+	// Name is the agent's executable, looked up in PATH
+	Name string
+	Args []string
+
+	// URLPattern matches the public url in a line of output, its first
+	// submatch is used when it has one
+	URLPattern *regexp.Regexp
+
+	// StartTimeout defaults to DefaultStartTimeout
+	StartTimeout time.Duration
+
+	// Logger receives the agent's output at debug level
+	Logger *zap.Logger
+}
+
+// Ngrok tunnels to addr, such as localhost:8080, with the ngrok agent. The
+// agent reads its authtoken from its config file or NGROK_AUTHTOKEN.
+func Ngrok(addr string) *Command {
+	return &Command{
+		Name:       "ngrok",
+		Args:       []string{"http", addr, "--log", "stdout", "--log-format", "logfmt"},
+		URLPattern: regexp.MustCompile(`msg="started tunnel".*\burl=(https://\S+)`),
+	}
+}
+
+// Cloudflared tunnels to addr with a cloudflared quick tunnel, which needs no
+// account
+func Cloudflared(addr string) *Command {
+	return &Command{
+		Name:       "cloudflared",
+		Args:       []string{"tunnel", "--no-autoupdate", "--url", "http://" + addr},
+		URLPattern: regexp.MustCompile(`https://[a-z0-9-]+\.trycloudflare\.com`),
+	}
+}
+
+// Tunnel is a running agent
+type Tunnel struct {
+	// URL is the public https url forwarding to the local address
+	URL string
+
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+	once sync.Once
+}
+
+// Start runs the agent until ctx is done or the Tunnel is closed, returning
+// once it reports its public url
+func (c *Command) Start(ctx context.Context) (*Tunnel, error) {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Env = os.Environ()
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	// cloudflared reports on stderr, ngrok on stdout
+	cmd.Stderr = cmd.Stdout
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("tunnel: starting %s: %w", c.Name, err)
+	}
+
+	t := &Tunnel{cmd: cmd, done: make(chan struct{})}
+	urls := make(chan string, 1)
+	go func() {
+		// Wait closes the pipe, read the output to its end first
+		c.scan(out, urls)
+		t.err = cmd.Wait()
+		close(t.done)
+	}()
+
+	timeout := c.StartTimeout
+	if timeout <= 0 {
+		timeout = DefaultStartTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case t.URL = <-urls:
+		return t, nil
+	case <-t.done:
+		return nil, fmt.Errorf("%w: %s exited: %v", ErrNoURL, c.Name, t.err)
+	case <-timer.C:
+		t.Close()
+		return nil, fmt.Errorf("%w after %s", ErrNoURL, timeout)
+	case <-ctx.Done():
+		t.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// scan logs the agent's output and sends the first url matched to urls
+func (c *Command) scan(out io.Reader, urls chan<- string) {
+	logger := c.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	found := false
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		line := scanner.Text()
+		logger.Debug("tunnel agent", zap.String("agent", c.Name), zap.String("line", line))
+		if found {
+			continue
+		}
+		match := c.URLPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		url := match[0]
+		if len(match) > 1 {
+			url = match[1]
+		}
+		urls <- strings.TrimRight(url, `"`)
+		found = true
+	}
+	// keep draining so the agent never blocks on a full pipe
+	io.Copy(io.Discard, out)
+}
+
+// Close stops the agent
+func (t *Tunnel) Close() error {
+	t.once.Do(func() {
+		if t.cmd.Process != nil {
+			t.cmd.Process.Signal(os.Interrupt)
+		}
+		select {
+		case <-t.done:
+		case <-time.After(5 * time.Second):
+			t.cmd.Process.Kill()
+			<-t.done
+		}
+	})
+	return nil
+}
+
+// Done is closed once the agent has exited
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.done
+}
@@ -1,20 +1,18 @@
 package twitchhook
 
 import (
-	"bytes"
 	"context"
-	"crypto/hmac"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"path"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -29,11 +27,233 @@ type TwitchWebhookHandler struct {
 	OAuth2ClientID     string
 	OAuth2ClientSecret string
 
+	// HubURL defaults to the twitch websub hub
+	HubURL string
+
+	// CallbackBaseURL and DefaultLease are used for subscription requests
+	// that leave them unset
+	CallbackBaseURL string
+	DefaultLease    time.Duration
+
+	// AllowInsecureCallbacks permits http callback urls for local
+	// development, callbacks must otherwise use https
+	AllowInsecureCallbacks bool
+
+	// IDGenerator generates subscription ids, defaults to TopicIDGenerator.
+	// Generators that don't implement TopicDecoder need a Manager that
+	// implements SubscriptionIDIndex.
+	IDGenerator IDGenerator
+
+	// SecretBytes is the number of random bytes in generated secrets,
+	// defaults to DefaultSecretBytes
+	SecretBytes int
+
+	// SecretEncoding defaults to SecretHex, the encoded secret must be
+	// MinSecretLength to MaxSecretLength characters
+	SecretEncoding SecretEncoding
+
+	// SecretProvider generates and resolves subscription secrets, by default
+	// random secrets are generated and stored with the subscription
+	SecretProvider SecretProvider
+
+	// CallbackURLBuilder embeds subscription ids in callback urls and
+	// extracts them from notifications, defaults to PathCallbackURL
+	CallbackURLBuilder CallbackURLBuilder
+
+	// ProbeCallbacks makes Subscribe request the callback url and check
+	// it's answered by this handler before subscribing, catching callbacks
+	// that aren't publicly reachable
+	ProbeCallbacks bool
+
+	// DryRun makes Subscribe and Unsubscribe log the form they would post to
+	// the hub instead of posting it, leaving the Manager untouched. Requests
+	// are still validated and given ids, secrets and callback urls.
+	DryRun bool
+
+	// HTTPClient is the base client for token and hub requests, its transport
+	// is wrapped to add credentials. Defaults to a client using
+	// DefaultHTTPTimeout with dial and TLS handshake timeouts.
+	HTTPClient *http.Client
+
 	Logger *zap.Logger
 
-	hubURL string
-	client *http.Client
-	once   sync.Once
+	// Clock tells time for the handler, defaults to SystemClock
+	Clock Clock
+
+	// MaxNotificationBytes rejects notification bodies larger than the limit
+	// with ErrNotificationTooLarge, zero disables the check
+	MaxNotificationBytes int64
+
+	// MaxNotificationAge rejects notifications sent longer ago than the
+	// window, zero disables the check
+	MaxNotificationAge time.Duration
+
+	// HandlerTimeout is the budget for answering notifications, handlers
+	// still running when it runs out have their notification acknowledged
+	// and keep running in the background up to HandlerDeadline. Zero waits
+	// for handlers.
+	HandlerTimeout time.Duration
+
+	// HandlerDeadline is the deadline of the context handlers run with when
+	// HandlerTimeout is set, defaults to DefaultHandlerDeadline
+	HandlerDeadline time.Duration
+
+	// DeadLetters stores notifications dropped because their handler failed
+	// or panicked, for inspection and Redrive. Notifications the handler
+	// asked to be redelivered are stored and acknowledged once the hub has
+	// retried them DeadLetterRetries times, defaults to
+	// DefaultDeadLetterRetries.
+	DeadLetters       DeadLetterStore
+	DeadLetterRetries int
+
+	// Dedup rejects notifications whose id has already been delivered
+	Dedup DedupStore
+
+	// NotificationHandler handles notifications received by the
+	// SubscriptionCallbackHandler once they have been verified
+	NotificationHandler NotificationHandler
+
+	// RenewalRetries is how many times a failed renewal is retried before
+	// OnRenewalExhausted is called, defaults to DefaultRenewalRetries. A
+	// negative value disables retries.
+	RenewalRetries int
+
+	// RenewalRetryBackoff is the delay before the first retry, doubling for
+	// each attempt up to RenewalRetryMaxDelay
+	RenewalRetryBackoff  time.Duration
+	RenewalRetryMaxDelay time.Duration
+
+	// RetryQueue persists pending renewal retries, retries are only held in
+	// memory when it's nil
+	RetryQueue RetryQueue
+
+	// RetryDenial picks the denials that are retried with the renewal retry
+	// backoff, up to RenewalRetries times, instead of calling the
+	// subscription's DenialCallback and deleting it. No denials are retried
+	// when it's nil, see RetryTransientDenials.
+	RetryDenial func(reason DenialReason) bool
+
+	// OnRenewalExhausted is called when a subscription couldn't be renewed
+	// after every retry, the subscription lapses at the end of its lease
+	OnRenewalExhausted func(topic string, err error)
+
+	// CircuitBreaker pauses hub and helix requests made with the handler's
+	// client during twitch outages, its Base is set by the handler
+	CircuitBreaker *CircuitBreaker
+
+	// Metrics receives counters and gauges, they're dropped when it's nil
+	Metrics Metrics
+
+	// Resubscribe is what Subscribe does when the topic already has a
+	// subscription with the same callback base, defaults to
+	// ResubscribeReuse
+	Resubscribe ResubscribePolicy
+
+	// SecretCacheTTL is how long secrets resolved through the
+	// SecretProvider are cached, defaults to DefaultSecretCacheTTL. A
+	// negative value resolves them for every notification. SecretCacheSize
+	// bounds the cached secrets, defaults to DefaultSecretCacheSize.
+	SecretCacheTTL  time.Duration
+	SecretCacheSize int
+
+	// SecretOverlap is how long the secret a renewal rotates out keeps
+	// verifying notifications, defaults to DefaultSecretOverlap. A negative
+	// value rejects the old secret as soon as the renewal is saved.
+	SecretOverlap time.Duration
+
+	// Priority orders the subscriptions of SubscribeAll, Start,
+	// MigrateCallbackBase and RepairSecrets, higher priorities are sent to
+	// the hub first. It defaults to DefaultPriority.
+	Priority func(req SubscriptionRequest) int
+
+	// SubscribeConcurrency is how many subscriptions SubscribeAll sends at
+	// once, defaults to DefaultSubscribeConcurrency
+	SubscribeConcurrency int
+
+	// Quota limits the client ID's active subscriptions, Subscribe returns
+	// ErrQuotaExceeded or waits for room once it's reached
+	Quota *Quota
+
+	// SecretAudit is what Start does with stored secrets that are
+	// duplicated, of the wrong length or weak, see CheckSecrets
+	SecretAudit SecretAuditPolicy
+
+	// Outbox saves subscriptions before they're sent to the hub so
+	// RecoverOutbox can finish subscriptions interrupted by a crash
+	Outbox Outbox
+
+	// SourceAllowlist drops notifications from addresses outside the
+	// allowlist before their body is read or their signature verified
+	SourceAllowlist *IPAllowlist
+
+	// AuditSink receives an event for every request handled by the
+	// SubscriptionCallbackHandler
+	AuditSink AuditSink
+
+	// RequestIDHeader is the header callback requests' ids are read from
+	// and written to, defaults to HeaderRequestID. Requests without a well
+	// formed id get a NewRequestID.
+	RequestIDHeader string
+
+	// Middleware wraps verification and dispatch of notifications received
+	// by the SubscriptionCallbackHandler, the first middleware is outermost
+	Middleware []Middleware
+
+	// Coordinator elects the instance renewing each subscription when several
+	// instances share the Manager, when unset every instance renews the
+	// subscriptions it holds
+	Coordinator RenewalCoordinator
+
+	// RenewalClaimTTL is how long a renewal claim is held, defaults to
+	// DefaultRenewalClaimTTL. Instances that lose the claim check back after
+	// it and take over if the subscription wasn't renewed.
+	RenewalClaimTTL time.Duration
+
+	// Partitioner picks the callback base of the instance owning a topic for
+	// subscription requests without a CallbackBaseURL
+	Partitioner *Partitioner
+
+	// OnPanic is called with the value and stack of panics recovered from
+	// denial callbacks, renewals, middleware and the NotificationHandler
+	OnPanic func(recovered interface{}, stack []byte)
+
+	// Archiver stores every notification that passes ValidateSignature,
+	// notifications validated with ValidateSignatureStream aren't archived
+	Archiver Archiver
+
+	// Callbacks holds the denial callbacks passed to Subscribe, binding them
+	// to subscriptions the Manager loads without funcs
+	Callbacks *CallbackRegistry
+
+	// Hooks are called at each point of the subscription and notification
+	// lifecycles
+	Hooks Hooks
+
+	hubURL              string
+	hubSubscriptionsURL string
+	client              *http.Client
+	baseClient          *http.Client
+	tokenSource         oauth2.TokenSource
+	once                sync.Once
+
+	stats   sync.Map
+	probes  sync.Map
+	pending sync.Map
+	denials sync.Map
+
+	// renewals serializes renewals by topic
+	renewals sync.Map
+
+	topicHandlers sync.Map
+	topicFilters  sync.Map
+
+	leaseCheck  leaseCheck
+	secretCache secretCache
+	topics      topicCache
+
+	// renewalRetriesScheduled and recentErrors are reported by DebugState
+	renewalRetriesScheduled atomic.Int64
+	recentErrors            errorRing
 }
 
 func (m *TwitchWebhookHandler) setup() {
@@ -45,17 +265,86 @@ func (m *TwitchWebhookHandler) setup() {
 			AuthStyle:    oauth2.AuthStyleInParams,
 		}
 
-		m.client = cfg.Client(context.TODO())
+		base := m.HTTPClient
+		if base == nil {
+			base = NewHTTPClient()
+		}
+		m.baseClient = base
+
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, base)
+		m.tokenSource = cfg.TokenSource(ctx)
+		m.client = &http.Client{
+			Transport: &oauth2.Transport{
+				Source: m.tokenSource,
+				Base:   m.breakerTransport(&RateLimitTransport{Base: base.Transport, Clock: m.Clock}),
+			},
+			CheckRedirect: base.CheckRedirect,
+			Jar:           base.Jar,
+			Timeout:       base.Timeout,
+		}
+	}
+
+	if m.Clock == nil {
+		m.Clock = SystemClock
+	}
+	if m.Callbacks == nil {
+		m.Callbacks = &CallbackRegistry{}
 	}
 
+	if m.hubURL == "" {
+		m.hubURL = m.HubURL
+	}
 	if m.hubURL == "" {
 		m.hubURL = "https://api.twitch.tv/helix/webhooks/hub"
 	}
+
+	if m.hubSubscriptionsURL == "" {
+		m.hubSubscriptionsURL = "https://api.twitch.tv/helix/webhooks/subscriptions"
+	}
+
+	if notifier, ok := m.Manager.(EvictionNotifier); ok {
+		notifier.NotifyEviction(m.evicted)
+	}
+}
+
+// evicted unsubscribes subscriptions the Manager evicted while they were
+// still active so the hub stops delivering notifications nobody handles
+func (m *TwitchWebhookHandler) evicted(sub *Subscription) {
+	m.topicHandlers.Delete(sub.Topic)
+	m.topicFilters.Delete(sub.Topic)
+	m.Callbacks.Delete(sub.Topic)
+	m.stats.Delete(sub.Topic)
+	if sub.State(clockOrDefault(m.Clock).Now()) != SubscriptionActive {
+		return
+	}
+
+	m.logger().Info("unsubscribing evicted subscription", zap.String("topic", sub.Topic))
+	go func() {
+		err := m.UnsubscribeCallback(context.Background(), sub.Topic, sub.CallbackURL)
+		if err != nil {
+			m.logger().Error("error unsubscribing evicted subscription", zap.String("topic", sub.Topic), zap.Error(err))
+			m.hookError("unsubscribe evicted", err)
+		}
+	}()
 }
 
-// SubscriptionCallbackHandler handles websub requests
+// Client returns the handler's http client, it authenticates requests with
+// an app access token and shares the handler's rate limit
+func (m *TwitchWebhookHandler) Client() *http.Client {
+	m.once.Do(m.setup)
+	return m.client
+}
+
+// SubscriptionCallbackHandler handles websub requests, verifying and
+// dispatching notifications and answering subscription confirmations
 func (m *TwitchWebhookHandler) SubscriptionCallbackHandler() http.HandlerFunc {
-	return m.confirmationHandler
+	return m.withRequestID(m.audited(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			m.notificationHandler(w, r)
+			return
+		}
+		m.confirmationHandler(w, r)
+	}))
 }
 
 func (m *TwitchWebhookHandler) confirmationHandler(w http.ResponseWriter, r *http.Request) {
@@ -66,21 +355,35 @@ func (m *TwitchWebhookHandler) confirmationHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
+	if mode == probeMode {
+		m.probeHandler(w, kv.Get("hub.challenge"))
+		return
+	}
+
 	topic := kv.Get("hub.topic")
 	if topic == "" {
 		http.Error(w, "missing required hub.topic query parameter", http.StatusBadRequest)
 		return
 	}
 
+	annotateAudit(r.Context(), func(e *AuditEvent) {
+		e.Kind = AuditConfirmation
+		if mode == "denied" {
+			e.Kind = AuditDenial
+		}
+		e.Mode = mode
+		e.Topic = topic
+	})
+
 	switch mode {
 	case "denied":
-		m.deniedSubHandler(w, topic, kv.Get("hub.reason"))
+		m.deniedSubHandler(w, r, topic, kv.Get("hub.reason"))
 		return
 	case "subscribe":
-		m.subConfirmationHandler(w, topic, kv.Get("hub.challenge"), kv.Get("hub.lease"))
+		m.subConfirmationHandler(w, r, topic, kv.Get("hub.challenge"), kv.Get("hub.lease"))
 		return
 	case "unsubscribe":
-		m.unsubConfirmationHandler(w, topic, kv.Get("hub.challenge"))
+		m.unsubConfirmationHandler(w, r, topic, kv.Get("hub.challenge"))
 		return
 	default:
 		http.Error(w, "hub.mode must be subscribe or unsubscribe", http.StatusBadRequest)
@@ -88,30 +391,62 @@ func (m *TwitchWebhookHandler) confirmationHandler(w http.ResponseWriter, r *htt
 	return
 }
 
-func (m *TwitchWebhookHandler) deniedSubHandler(w http.ResponseWriter, topic, reason string) {
-	subscription, err := m.Manager.Get(topic)
-	if err != nil {
-		m.Logger.Error("error retrieving subscription from cache", zap.Error(err))
+func (m *TwitchWebhookHandler) deniedSubHandler(w http.ResponseWriter, r *http.Request, topic, reason string) {
+	logger := m.requestLogger(r.Context())
+	denial := ParseDenialReason(reason)
+	m.metrics().IncCounter("twitchhook_denials_total", "reason", string(denial))
+	m.hookDenial(topic, denial)
+
+	// denials can arrive before the hub responds, like confirmations
+	pending, isPending := m.pending.LoadAndDelete(topic)
+	current, err := m.getSubscription(topic)
+	if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+		logger.Error("error retrieving subscription from cache", zap.Error(err))
+		m.hookError("cache", err)
 		http.Error(w, "error retrieving subscription from cache", http.StatusInternalServerError)
 		return
 	}
-
+	subscription := current
+	if isPending {
+		subscription = pending.(*Subscription)
+	}
 	if subscription == nil {
 		http.Error(w, "subscription not found", http.StatusNotFound)
 		return
 	}
 
-	subscription.DenialCallback(reason)
+	if m.retryDenial(subscription, denial) {
+		if current == nil {
+			// keep the denied subscription until it's retried
+			err = m.Manager.Save(topic, subscription)
+			if err != nil {
+				logger.Error("error saving denied subscription", zap.Error(err))
+				m.hookError("cache", err)
+			}
+		}
+		return
+	}
+	if denied := m.denialCallback(subscription); denied != nil {
+		m.protect("denial callback", func() error {
+			denied(reason)
+			return nil
+		})
+	}
+	m.Callbacks.Delete(topic)
+	m.stats.Delete(topic)
+	m.releaseQuota(topic, subscription.CallbackURL)
 
 	err = m.Manager.Delete(topic)
 	if err != nil {
-		m.Logger.Error("error deleting subscription from cache", zap.Error(err))
+		logger.Error("error deleting subscription from cache", zap.Error(err))
+		m.hookError("cache", err)
 		http.Error(w, "error deleting subscription from cache", http.StatusInternalServerError)
 		return
 	}
 }
 
-func (m *TwitchWebhookHandler) subConfirmationHandler(w http.ResponseWriter, topic, challenge, lease string) {
+func (m *TwitchWebhookHandler) subConfirmationHandler(w http.ResponseWriter, r *http.Request, topic, challenge, lease string) {
+	logger := m.requestLogger(r.Context())
 	if challenge == "" {
 		http.Error(w, "missing required hub.challenge query parameter", http.StatusBadRequest)
 		return
@@ -119,14 +454,25 @@ func (m *TwitchWebhookHandler) subConfirmationHandler(w http.ResponseWriter, top
 
 	seconds, err := strconv.ParseInt(lease, 10, 64)
 	if err != nil || seconds <= 0 {
-		m.Logger.Info("received invalid lease from subscription confirmation")
+		logger.Info("received invalid lease from subscription confirmation")
 		http.Error(w, "invalid lease", http.StatusBadRequest)
 		return
 	}
 
+	if pending, ok := m.pending.LoadAndDelete(topic); ok {
+		err = m.Manager.Save(topic, pending.(*Subscription))
+		if err != nil {
+			logger.Error("error saving pending subscription", zap.Error(err))
+			m.hookError("cache", err)
+			http.Error(w, "error saving pending subscription", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	exists, err := m.Manager.SetSubscriptionLease(topic, time.Duration(seconds)*time.Second)
 	if err != nil {
-		m.Logger.Error("error fetching subscription from cache", zap.Error(err))
+		logger.Error("error fetching subscription from cache", zap.Error(err))
+		m.hookError("cache", err)
 		http.Error(w, "error fetching subscription from cache", http.StatusInternalServerError)
 		return
 	}
@@ -134,90 +480,296 @@ func (m *TwitchWebhookHandler) subConfirmationHandler(w http.ResponseWriter, top
 		http.Error(w, "subscription does not exist", http.StatusNotFound)
 		return
 	}
+	m.denials.Delete(topic)
+	confirmed := time.Duration(seconds) * time.Second
+	m.hookConfirm(topic, confirmed)
+	m.hookRenewalScheduled(topic, clockOrDefault(m.Clock).Now().Add(confirmed))
 
 	_, err = io.WriteString(w, challenge)
 	if err != nil {
-		m.Logger.Info("error responding with challenge", zap.Error(err))
+		logger.Info("error responding with challenge", zap.Error(err))
 	}
 	return
 }
 
-func (m *TwitchWebhookHandler) unsubConfirmationHandler(w http.ResponseWriter, topic, challenge string) {
-	err := m.Manager.Delete(topic)
-	if err != nil {
-		m.Logger.Error("error deleting subscription from cache", zap.Error(err))
-		http.Error(w, "error deleting subscription from cache", http.StatusInternalServerError)
-		return
+func (m *TwitchWebhookHandler) unsubConfirmationHandler(w http.ResponseWriter, r *http.Request, topic, challenge string) {
+	logger := m.requestLogger(r.Context())
+	// confirmations for a callback the topic has moved off of, like the old
+	// callbacks of MigrateCallbackBase, leave the current subscription alone
+	if !m.replacedCallback(r, topic) {
+		err := m.Manager.Delete(topic)
+		if err != nil {
+			logger.Error("error deleting subscription from cache", zap.Error(err))
+			m.hookError("cache", err)
+			http.Error(w, "error deleting subscription from cache", http.StatusInternalServerError)
+			return
+		}
 	}
 
 	if challenge == "" {
-		m.Logger.Info("unsub confirmation missing hub.challenge query parameter", zap.String("topic", topic))
+		logger.Info("unsub confirmation missing hub.challenge query parameter", zap.String("topic", topic))
 		http.Error(w, "missing required hub.challenge query parameter", http.StatusBadRequest)
 		return
 	}
 
-	_, err = io.WriteString(w, challenge)
+	_, err := io.WriteString(w, challenge)
 	if err != nil {
-		m.Logger.Info("error responding with challenge", zap.Error(err))
+		logger.Info("error responding with challenge", zap.Error(err))
 	}
 	return
 }
 
-func generateCallbackURL(baseURL string, subscriptionID SubscriptionID) (string, error) {
-	u, err := url.Parse(baseURL)
+// replacedCallback reports whether the request is for a callback other than
+// the one of the topic's current subscription
+func (m *TwitchWebhookHandler) replacedCallback(r *http.Request, topic string) bool {
+	id, err := m.extractSubscriptionID(r)
+	if err != nil {
+		return false
+	}
+	subscription, err := m.getSubscription(topic)
 	if err != nil {
-		return "", err
+		return false
 	}
-	return path.Join(u.EscapedPath(), string(subscriptionID)), nil
+	return subscription.ID != "" && subscription.ID != id
 }
 
 // Subscribe subscribes the webhook
-func (m *TwitchWebhookHandler) Subscribe(request SubscriptionRequest, denialCallback func(reason string)) (err error) {
+func (m *TwitchWebhookHandler) Subscribe(request SubscriptionRequest, denialCallback func(reason string)) error {
+	return m.SubscribeContext(context.Background(), request, denialCallback)
+}
+
+// SubscribeContext subscribes the webhook, ctx bounds the hub request
+func (m *TwitchWebhookHandler) SubscribeContext(ctx context.Context, request SubscriptionRequest, denialCallback func(reason string)) error {
+	return m.subscribe(ctx, request, denialCallback, false)
+}
+
+// subscribe subscribes the webhook, renewing is set for renewals which
+// always reach the hub whatever the Resubscribe policy
+func (m *TwitchWebhookHandler) subscribe(ctx context.Context, request SubscriptionRequest, denialCallback func(reason string), renewing bool) error {
+	return m.subscribeWith(ctx, request, denialCallback, renewing, false)
+}
+
+// subscribeWith is subscribe, rotate makes a new id and secret whatever the
+// Resubscribe policy
+func (m *TwitchWebhookHandler) subscribeWith(ctx context.Context, request SubscriptionRequest, denialCallback func(reason string), renewing, rotate bool) (err error) {
 	m.once.Do(m.setup)
 
+	// callbacks outlive the subscription's funcs, which stores don't keep
+	if denialCallback != nil {
+		m.Callbacks.SetDenialCallback(request.Topic, denialCallback)
+	} else {
+		denialCallback = m.Callbacks.DenialCallback(request.Topic)
+	}
+
+	// renewals resolve the callback base again in case the topic has moved
+	renewal := request
+	if request.CallbackBaseURL == "" && m.Partitioner != nil {
+		if owner, ok := m.Partitioner.Owner(request.Topic); ok {
+			request.CallbackBaseURL = owner.CallbackBaseURL
+		}
+	}
+	if request.CallbackBaseURL == "" {
+		request.CallbackBaseURL = m.CallbackBaseURL
+	}
+	if request.Lease == 0 {
+		request.Lease = m.DefaultLease
+	}
+
 	err = request.validate()
 	if err != nil {
 		return err
 	}
 
-	id, err := NewSubscriptionID(request.Topic)
+	err = m.Resubscribe.check()
 	if err != nil {
 		return err
 	}
 
-	key := make([]byte, 64)
-	_, err = rand.Read(key)
+	if renewing {
+		unlock := m.lockRenewal(request.Topic)
+		defer unlock()
+	}
+
+	var existing, replaced *Subscription
+	if !rotate {
+		var skip bool
+		existing, skip, err = m.existingSubscription(request, renewing)
+		if err != nil || skip {
+			return err
+		}
+	}
+	if existing == nil && !renewing && !rotate {
+		// renewals and rotate callers like MigrateCallbackBase unsubscribe
+		// the callbacks they replace themselves
+		replaced = m.replacedSubscription(request.Topic)
+	}
+
+	var (
+		id                              SubscriptionID
+		secret, storedSecret, secretRef string
+		callbackURL                     string
+		previous                        previousSecret
+	)
+	if existing != nil {
+		id, callbackURL = existing.ID, existing.CallbackURL
+		if renewing && m.Resubscribe.rotates() {
+			// the hub signs with the old secret until it confirms the
+			// renewal, so both verify for a while
+			secret, storedSecret, secretRef, err = m.newSecret(ctx, request.Topic)
+			if err != nil {
+				return err
+			}
+			if overlap := m.secretOverlap(); overlap > 0 {
+				previous = previousSecret{
+					secret:    existing.Secret,
+					ref:       existing.SecretRef,
+					expiresAt: clockOrDefault(m.Clock).Now().Add(overlap),
+				}
+			}
+		} else {
+			storedSecret, secretRef = existing.Secret, existing.SecretRef
+			secret, err = m.subscriptionSecret(ctx, existing)
+			if err != nil {
+				return err
+			}
+			if existing.previousSecretAccepted(clockOrDefault(m.Clock).Now()) {
+				previous = previousSecret{
+					secret:    existing.PreviousSecret,
+					ref:       existing.PreviousSecretRef,
+					expiresAt: existing.PreviousSecretExpiresAt,
+				}
+			}
+		}
+	} else {
+		err = m.checkIDGenerator()
+		if err != nil {
+			return err
+		}
+
+		id, err = m.idGenerator().NewSubscriptionID(request.Topic)
+		if err != nil {
+			return err
+		}
+
+		secret, storedSecret, secretRef, err = m.newSecret(ctx, request.Topic)
+		if err != nil {
+			return err
+		}
+
+		callbackURL, err = m.generateCallbackURL(request.CallbackBaseURL, id)
+		if err != nil {
+			return err
+		}
+
+		if m.ProbeCallbacks {
+			err = m.probeCallback(ctx, callbackURL)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	entry := OutboxEntry{
+		Renewal:         renewal,
+		ID:              id,
+		Topic:           request.Topic,
+		CallbackBaseURL: request.CallbackBaseURL,
+		CallbackURL:     callbackURL,
+		Lease:           request.Lease,
+		Secret:          storedSecret,
+		SecretRef:       secretRef,
+		Metadata:        request.Metadata,
+		CreatedAt:       clockOrDefault(m.Clock).Now(),
+
+		PreviousSecret:          previous.secret,
+		PreviousSecretRef:       previous.ref,
+		PreviousSecretExpiresAt: previous.expiresAt,
+	}
+	subscription := m.newSubscription(entry, denialCallback)
+	if m.DryRun {
+		m.logDryRun(subscriptionForm(subscription, secret))
+		return nil
+	}
+
+	counted, err := m.acquireQuota(ctx, subscription)
 	if err != nil {
 		return err
 	}
 
-	callbackURL, err := generateCallbackURL(request.CallbackBaseURL, id)
+	// confirmations can arrive before the hub responds, they save the
+	// pending subscription themselves
+	m.pending.Store(request.Topic, subscription)
+	if m.Outbox != nil {
+		var sealed OutboxEntry
+		sealed, err = m.sealOutboxEntry(ctx, entry)
+		if err == nil {
+			err = m.Outbox.Put(sealed)
+		}
+		if err != nil {
+			m.pending.CompareAndDelete(request.Topic, subscription)
+			if counted {
+				m.releaseQuota(request.Topic, callbackURL)
+			}
+			return err
+		}
+	}
+
+	err = m.postSubscription(ctx, subscription, secret)
+	m.hookSubscribe(request.Topic, renewing, err)
+	confirmed := !m.pending.CompareAndDelete(request.Topic, subscription)
+	var hErr *HubError
+	if errors.As(err, &hErr) {
+		m.removeOutboxEntry(request.Topic)
+		if counted {
+			m.releaseQuota(request.Topic, callbackURL)
+		}
+		return err
+	}
 	if err != nil {
+		// the hub may have the subscription, RecoverOutbox resolves it and
+		// its quota slot. Without an Outbox nothing would.
+		if counted && m.Outbox == nil {
+			m.releaseQuota(request.Topic, callbackURL)
+		}
 		return err
 	}
 
-	subscription := &Subscription{
-		Topic:          request.Topic,
-		CallbackURL:    callbackURL,
-		Lease:          request.Lease,
-		Secret:         hex.EncodeToString(key),
-		DenialCallback: denialCallback,
-		Renew: func() {
-			err := m.Subscribe(request, denialCallback)
-			if err != nil {
-				m.Logger.Error("unable to renew webhook subscription", zap.Error(err))
+	if !confirmed {
+		err = m.Manager.Save(request.Topic, subscription)
+		if err != nil {
+			if counted {
+				m.releaseQuota(request.Topic, callbackURL)
 			}
-		},
+			return err
+		}
 	}
+	m.removeOutboxEntry(request.Topic)
+	m.unsubscribeReplaced(ctx, replaced, subscription)
+	return nil
+}
 
-	data := url.Values{}
-	data.Set("hub.callback", subscription.CallbackURL)
-	data.Set("hub.topic", subscription.Topic)
-	data.Set("hub.lease_seconds", strconv.FormatInt(subscription.Lease.Milliseconds()/1000, 10))
-	data.Set("hub.secret", subscription.Secret)
-	data.Set("hub.mode", "subscribe")
+// previousSecret is the secret a renewal carries over for the overlap
+type previousSecret struct {
+	secret, ref string
+	expiresAt   time.Time
+}
 
-	resp, err := m.client.PostForm(m.hubURL, data)
+// lockRenewal keeps renewals of topic from racing each other, such as a
+// scheduled renewal and one asked for through RenewContext, and returns the
+// unlock func
+func (m *TwitchWebhookHandler) lockRenewal(topic string) func() {
+	v, ok := m.renewals.Load(topic)
+	if !ok {
+		v, _ = m.renewals.LoadOrStore(topic, new(sync.Mutex))
+	}
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// postSubscription sends a subscription request to the hub
+func (m *TwitchWebhookHandler) postSubscription(ctx context.Context, subscription *Subscription, secret string) error {
+	resp, err := m.postHub(ctx, subscriptionForm(subscription, secret))
 	if err != nil {
 		return err
 	}
@@ -229,87 +781,138 @@ func (m *TwitchWebhookHandler) Subscribe(request SubscriptionRequest, denialCall
 	}
 
 	if resp.StatusCode == http.StatusAccepted {
-		err = m.Manager.Save(request.Topic, subscription)
-		if err != nil {
-			return err
-		}
 		return nil
 	}
 
-	var tErr TwitchError
-	err = json.Unmarshal(bs, &tErr)
+	return newHubError(resp, bs)
+}
+
+// subscriptionForm is the hub request form subscribing subscription
+func subscriptionForm(subscription *Subscription, secret string) url.Values {
+	data := url.Values{}
+	data.Set("hub.callback", subscription.CallbackURL)
+	data.Set("hub.topic", subscription.Topic)
+	data.Set("hub.lease_seconds", strconv.FormatInt(subscription.Lease.Milliseconds()/1000, 10))
+	data.Set("hub.secret", secret)
+	data.Set("hub.mode", "subscribe")
+	return data
+}
+
+// logDryRun logs the form of a hub request DryRun keeps from being sent,
+// without its secret
+func (m *TwitchWebhookHandler) logDryRun(data url.Values) {
+	form := url.Values{}
+	for k, v := range data {
+		form[k] = v
+	}
+	if form.Has("hub.secret") {
+		form.Set("hub.secret", "REDACTED")
+	}
+	m.logger().Info("dry run, not sending hub request",
+		zap.String("hub_url", m.hubURL),
+		zap.String("form", form.Encode()),
+	)
+}
+
+// Renew resubscribes to a topic with the callback base and lease of its
+// current subscription
+func (m *TwitchWebhookHandler) Renew(topic string) error {
+	return m.RenewContext(context.Background(), topic)
+}
+
+// RenewContext resubscribes to a topic with the callback base and lease of
+// its current subscription, ctx bounds the hub request
+func (m *TwitchWebhookHandler) RenewContext(ctx context.Context, topic string) error {
+	subscription, err := m.getSubscription(topic)
 	if err != nil {
 		return err
 	}
-	return tErr
+
+	return m.subscribe(ctx, SubscriptionRequest{
+		Topic:           subscription.Topic,
+		CallbackBaseURL: subscription.CallbackBaseURL,
+		Lease:           subscription.Lease,
+		Metadata:        subscription.Metadata,
+	}, subscription.DenialCallback, true)
 }
 
 // Unsubscribe unsubscribes the webhook
 func (m *TwitchWebhookHandler) Unsubscribe(topic string) error {
+	return m.UnsubscribeContext(context.Background(), topic)
+}
+
+// UnsubscribeContext unsubscribes the webhook, ctx bounds the hub request
+func (m *TwitchWebhookHandler) UnsubscribeContext(ctx context.Context, topic string) error {
 	m.once.Do(m.setup)
 
-	subscription, err := m.Manager.Get(topic)
+	subscription, err := m.getSubscription(topic)
 	if err != nil {
 		return err
 	}
+	if m.DryRun {
+		return m.UnsubscribeCallback(ctx, topic, subscription.CallbackURL)
+	}
 
 	err = m.Manager.Delete(topic)
 	if err != nil {
 		return err
 	}
+	m.topicHandlers.Delete(topic)
+	m.topicFilters.Delete(topic)
+	m.Callbacks.Delete(topic)
+	m.renewals.Delete(topic)
+	m.stats.Delete(topic)
 
-	data := url.Values{}
-	data.Set("hub.mode", "unsubscribe")
-	data.Set("hub.topic", topic)
-	data.Set("hub.callback", subscription.CallbackURL)
-
-	_, err = m.client.PostForm(m.hubURL, data)
-	if err != nil {
-		return err
+	if m.Coordinator != nil {
+		err = m.Coordinator.Release(ctx, topic)
+		if err != nil {
+			m.logger().Info("error releasing renewal claim", zap.String("topic", topic), zap.Error(err))
+		}
 	}
-	return nil
-}
 
-// ValidateSignature validates the notification using the subscription's secret
-func (m *TwitchWebhookHandler) ValidateSignature(r *http.Request) (bool, io.Reader, error) {
-	defer r.Body.Close()
+	return m.UnsubscribeCallback(ctx, topic, subscription.CallbackURL)
+}
 
-	_, id := path.Split(r.URL.EscapedPath())
-	topic, err := SubscriptionIDToTopic(SubscriptionID(id))
-	if err != nil {
-		return false, nil, err
-	}
+// UnsubscribeCallback asks the hub to remove the subscription of a callback
+// to a topic without touching the Manager, for subscriptions the Manager
+// doesn't hold
+func (m *TwitchWebhookHandler) UnsubscribeCallback(ctx context.Context, topic, callbackURL string) error {
+	m.once.Do(m.setup)
 
-	subscription, err := m.Manager.Get(topic)
-	if err != nil {
-		return false, nil, err
+	data := url.Values{}
+	data.Set("hub.mode", "unsubscribe")
+	data.Set("hub.topic", topic)
+	data.Set("hub.callback", callbackURL)
+	if m.DryRun {
+		m.logDryRun(data)
+		return nil
 	}
 
-	bs, err := ioutil.ReadAll(r.Body)
+	resp, err := m.postHub(ctx, data)
 	if err != nil {
-		return false, nil, err
+		return err
 	}
-	body := bytes.NewReader(bs)
+	defer resp.Body.Close()
 
-	hasher := hmac.New(sha256.New, []byte(subscription.Secret))
-	_, err = hasher.Write(bs)
+	bs, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return false, nil, err
+		return err
 	}
-
-	mac := hasher.Sum(nil)
-
-	signature := r.Header.Get("X-Hub-Signature")
-	if signature == "" {
-		return false, body, nil
+	if resp.StatusCode == http.StatusAccepted {
+		m.releaseQuota(topic, callbackURL)
+		return nil
 	}
+	return newHubError(resp, bs)
+}
 
-	providedMac, err := hex.DecodeString(signature)
+func (m *TwitchWebhookHandler) postHub(ctx context.Context, data url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.hubURL, strings.NewReader(data.Encode()))
 	if err != nil {
-		return false, nil, err
+		return nil, err
 	}
-
-	return hmac.Equal(mac, providedMac), body, nil
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Client-Id", m.OAuth2ClientID)
+	return m.client.Do(req)
 }
 
 // TwitchError is the api message format for errors
@@ -322,3 +925,51 @@ type TwitchError struct {
 func (e TwitchError) Error() string {
 	return e.Message
 }
+
+// HubError is returned when the hub rejects a request. It keeps the raw
+// response for debugging, Twitch is nil when the body isn't a TwitchError.
+type HubError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Twitch     *TwitchError
+}
+
+func newHubError(resp *http.Response, body []byte) *HubError {
+	e := &HubError{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}
+	var tErr TwitchError
+	if json.Unmarshal(body, &tErr) == nil && tErr.Message != "" {
+		e.Twitch = &tErr
+	}
+	return e
+}
+
+func (e *HubError) Error() string {
+	if e.Twitch != nil {
+		return fmt.Sprintf("hub responded %d: %s", e.StatusCode, e.Twitch.Message)
+	}
+	return fmt.Sprintf("hub responded %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Unwrap returns the TwitchError from the body so errors.As finds it
+func (e *HubError) Unwrap() error {
+	if e.Twitch == nil {
+		return nil
+	}
+	return *e.Twitch
+}
+
+// RateLimit returns the response's rate limit headers, they're zero when
+// missing
+func (e *HubError) RateLimit() (limit, remaining int, reset time.Time) {
+	limit, _ = strconv.Atoi(e.Header.Get(HeaderRatelimitLimit))
+	remaining, _ = strconv.Atoi(e.Header.Get(HeaderRatelimitRemaining))
+	if secs, err := strconv.ParseInt(e.Header.Get(HeaderRatelimitReset), 10, 64); err == nil {
+		reset = time.Unix(secs, 0)
+	}
+	return limit, remaining, reset
+}
@@ -0,0 +1,115 @@
+package twitchhook_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+)
+
+// subscribeConfirmed subscribes to testTopic and confirms the lease
+func subscribeConfirmed(t *testing.T, h *twitchhook.TwitchWebhookHandler) *twitchhook.Subscription {
+	t.Helper()
+	sub := subscribe(t, h, twitchhook.SubscriptionRequest{Topic: testTopic})
+	if _, err := h.Manager.SetSubscriptionLease(testTopic, h.DefaultLease); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func renew(t *testing.T, h *twitchhook.TwitchWebhookHandler) *twitchhook.Subscription {
+	t.Helper()
+	if err := h.Renew(testTopic); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	sub, err := h.Manager.Get(testTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func TestRenewKeepsCallback(t *testing.T) {
+	h, hub, _ := newHubHandler(t)
+	first := subscribeConfirmed(t, h)
+	renewed := renew(t, h)
+
+	if renewed.ID != first.ID || renewed.CallbackURL != first.CallbackURL || renewed.Secret != first.Secret {
+		t.Fatal("renewal changed the id, callback or secret")
+	}
+	want := []string{"subscribe " + first.CallbackURL, "subscribe " + first.CallbackURL}
+	if !reflect.DeepEqual(hub.requests(), want) {
+		t.Fatalf("hub requests = %q, want %q", hub.requests(), want)
+	}
+}
+
+func TestRenewRotateOverlapsSecret(t *testing.T) {
+	h, hub, clock := newHubHandler(t)
+	h.Resubscribe = twitchhook.ResubscribeRotate
+	first := subscribeConfirmed(t, h)
+	renewed := renew(t, h)
+
+	if renewed.ID != first.ID || renewed.CallbackURL != first.CallbackURL {
+		t.Fatal("renewal changed the id or callback")
+	}
+	if renewed.Secret == first.Secret {
+		t.Fatal("renewal didn't rotate the secret")
+	}
+	want := []string{"subscribe " + first.CallbackURL, "subscribe " + first.CallbackURL}
+	if !reflect.DeepEqual(hub.requests(), want) {
+		t.Fatalf("hub requests = %q, want %q", hub.requests(), want)
+	}
+
+	if !validate(t, h, signedRequest(t, renewed, "sha256", renewed.Secret, testBody)) {
+		t.Fatal("new secret didn't verify")
+	}
+	if !validate(t, h, signedRequest(t, renewed, "sha256", first.Secret, testBody)) {
+		t.Fatal("old secret didn't verify during the overlap")
+	}
+
+	// a second renewal during the overlap keeps the original secret's expiry
+	h.Resubscribe = twitchhook.ResubscribeRenew
+	renewed = renew(t, h)
+	if renewed.PreviousSecret != first.Secret {
+		t.Fatal("renewal during the overlap dropped the previous secret")
+	}
+
+	clock.Advance(twitchhook.DefaultSecretOverlap)
+	if validate(t, h, signedRequest(t, renewed, "sha256", first.Secret, testBody)) {
+		t.Fatal("old secret verified past the overlap")
+	}
+	if !validate(t, h, signedRequest(t, renewed, "sha256", renewed.Secret, testBody)) {
+		t.Fatal("new secret stopped verifying")
+	}
+}
+
+func TestRenewRotateWithoutOverlap(t *testing.T) {
+	h, _, _ := newHubHandler(t)
+	h.Resubscribe = twitchhook.ResubscribeRotate
+	h.SecretOverlap = -1
+	first := subscribeConfirmed(t, h)
+	renewed := renew(t, h)
+
+	if renewed.PreviousSecret != "" || !renewed.PreviousSecretExpiresAt.IsZero() {
+		t.Fatal("renewal kept the old secret with the overlap disabled")
+	}
+	if validate(t, h, signedRequest(t, renewed, "sha256", first.Secret, testBody)) {
+		t.Fatal("old secret verified with the overlap disabled")
+	}
+}
+
+func TestSubscriptionRecordKeepsPreviousSecret(t *testing.T) {
+	sub := &twitchhook.Subscription{
+		Topic:                   testTopic,
+		Secret:                  testSecret,
+		PreviousSecret:          "previous",
+		PreviousSecretRef:       "ref",
+		PreviousSecretExpiresAt: epoch.Add(time.Minute),
+	}
+	record := sub.Record()
+	got := record.Subscription()
+	if got.PreviousSecret != sub.PreviousSecret || got.PreviousSecretRef != sub.PreviousSecretRef || !got.PreviousSecretExpiresAt.Equal(sub.PreviousSecretExpiresAt) {
+		t.Fatalf("round trip through a record = %+v", got)
+	}
+}
@@ -0,0 +1,44 @@
+package twitchhook
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// SubscribeTyped subscribes to a topic and registers handler for its
+// notifications, decoding bodies into T with encoding/json. Notifications for
+// the topic go to handler instead of the NotificationHandler until the topic
+// is unsubscribed.
+func SubscribeTyped[T any](ctx context.Context, m *TwitchWebhookHandler, req SubscriptionRequest, handler func(ctx context.Context, event T) error) error {
+	m.HandleTopic(req.Topic, NotificationHandlerFunc(func(ctx context.Context, n *Notification) error {
+		var event T
+		err := json.Unmarshal(n.Body, &event)
+		if err != nil {
+			return err
+		}
+		n.Event = event
+		return handler(ctx, event)
+	}))
+
+	err := m.SubscribeContext(ctx, req, nil)
+	if err != nil {
+		m.topicHandlers.Delete(req.Topic)
+		return err
+	}
+	return nil
+}
+
+// HandleTopic registers h for notifications of topic in place of the
+// NotificationHandler
+func (m *TwitchWebhookHandler) HandleTopic(topic string, h NotificationHandler) {
+	m.topicHandlers.Store(topic, h)
+}
+
+// notificationHandlerFor returns the handler registered for topic, falling
+// back to the NotificationHandler
+func (m *TwitchWebhookHandler) notificationHandlerFor(topic string) NotificationHandler {
+	if h, ok := m.topicHandlers.Load(topic); ok {
+		return h.(NotificationHandler)
+	}
+	return m.NotificationHandler
+}
@@ -0,0 +1,178 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrNotificationTooLarge is returned for notifications larger than
+// MaxNotificationBytes
+var ErrNotificationTooLarge = errors.New("notification body too large")
+
+// inlineBodySize fits most notification bodies, ValidateSignature reads
+// them without allocating separately
+const inlineBodySize = 1024
+
+// VerificationResult is the outcome of validating a notification
+type VerificationResult struct {
+	// Valid is set when the signature matched the body
+	Valid bool
+
+	Topic          string
+	Subscription   *Subscription
+	NotificationID string
+
+	// Algorithm is the signature's hash algorithm, set once the signature
+	// header has been parsed
+	Algorithm string
+
+	Body []byte
+}
+
+// ValidateSignature validates the notification using the subscription's
+// secret. A signature that doesn't match returns a result with Valid unset and
+// a nil error, errors are returned for notifications that couldn't be checked
+// or were rejected as replays.
+func (m *TwitchWebhookHandler) ValidateSignature(r *http.Request) (*VerificationResult, error) {
+	defer r.Body.Close()
+
+	// the notification, result and small bodies share an allocation
+	v := new(struct {
+		n      Notification
+		result VerificationResult
+		body   [inlineBodySize]byte
+	})
+	n := &v.n
+	err := m.readNotification(r, n, v.body[:])
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.verifyNotification(r.Context(), n)
+	result := &v.result
+	*result = VerificationResult{
+		Valid:          err == nil,
+		Topic:          n.Topic,
+		Subscription:   n.Subscription,
+		NotificationID: n.ID,
+		Algorithm:      n.SignatureAlgorithm,
+		Body:           n.Body,
+	}
+	if err != nil && err != ErrInvalidSignature {
+		return result, err
+	}
+	return result, nil
+}
+
+// newNotification reads an unverified notification from r
+func (m *TwitchWebhookHandler) newNotification(r *http.Request) (*Notification, error) {
+	n := new(Notification)
+	err := m.readNotification(r, n, nil)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// readNotification reads an unverified notification from r into n, its body
+// into buf when it fits
+func (m *TwitchWebhookHandler) readNotification(r *http.Request, n *Notification, buf []byte) error {
+	id, topic, err := m.requestSubscriptionID(r)
+	if err != nil {
+		return err
+	}
+
+	bs, err := readBody(r.Body, buf, r.ContentLength, m.MaxNotificationBytes)
+	if err != nil {
+		return err
+	}
+
+	*n = Notification{
+		Topic:          topic,
+		SubscriptionID: id,
+		ReceivedAt:     clockOrDefault(m.Clock).Now(),
+		RemoteAddr:     r.RemoteAddr,
+		Body:           bs,
+	}
+	n.ReadHeader(r.Header)
+	n.RequestID = RequestIDFromContext(r.Context())
+	return nil
+}
+
+// verifyNotification checks the signature of n against its subscription's
+// secret, returning ErrInvalidSignature on mismatch, and rejects replays. On
+// success n.Subscription is set.
+func (m *TwitchWebhookHandler) verifyNotification(ctx context.Context, n *Notification) (err error) {
+	defer func() {
+		if err != nil {
+			m.hookReject(n, err)
+			return
+		}
+		m.hookNotification(n)
+	}()
+
+	subscription, err := m.getSubscription(n.Topic)
+	if err != nil {
+		return err
+	}
+
+	sig, err := parseSignature(n.Header.Get(HeaderSignature))
+	if err != nil {
+		m.recordSignatureFailure(n.Topic)
+		return err
+	}
+
+	n.SignatureAlgorithm = sig.algorithm
+	secret, err := m.subscriptionSecret(ctx, subscription)
+	if err != nil {
+		return err
+	}
+	valid := sig.matches(secret, n.Body)
+	if !valid && subscription.previousSecretAccepted(n.ReceivedAt) {
+		secret, err = m.previousSubscriptionSecret(ctx, subscription)
+		if err != nil {
+			return err
+		}
+		valid = sig.matches(secret, n.Body)
+		if valid {
+			m.metrics().IncCounter("twitchhook_previous_secret_notifications_total", "topic", n.Topic)
+		}
+	}
+	if !valid {
+		m.recordSignatureFailure(n.Topic)
+		return ErrInvalidSignature
+	}
+
+	err = m.checkReplay(n.Header)
+	if err != nil {
+		return err
+	}
+
+	n.Subscription = subscription
+	m.recordDelivery(n.Topic, len(n.Body), n.Retry)
+	m.archive(ctx, n)
+	return nil
+}
+
+func (m *TwitchWebhookHandler) requestSubscription(r *http.Request) (*Subscription, error) {
+	_, topic, err := m.requestSubscriptionID(r)
+	if err != nil {
+		return nil, err
+	}
+	return m.getSubscription(topic)
+}
+
+// getSubscription retrieves the subscription for topic, returning
+// ErrSubscriptionNotFound if there isn't one. Managers that predate
+// ErrSubscriptionNotFound and return a nil subscription are handled the same.
+func (m *TwitchWebhookHandler) getSubscription(topic string) (*Subscription, error) {
+	sub, err := m.Manager.Get(topic)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return nil, ErrSubscriptionNotFound
+	}
+	return sub, nil
+}
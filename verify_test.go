@@ -0,0 +1,200 @@
+package twitchhook_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/bsdlp/twitchhook/loadtest"
+)
+
+func TestValidateSignature(t *testing.T) {
+	h, _ := newTestHandler(t)
+	sub := saveSubscription(t, h, testTopic, testSecret)
+
+	for _, algorithm := range []string{"sha1", "sha256", "sha512"} {
+		result, err := h.ValidateSignature(signedRequest(t, sub, algorithm, testSecret, testBody))
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if !result.Valid || result.Algorithm != algorithm || result.Topic != testTopic {
+			t.Fatalf("%s: result = %+v", algorithm, result)
+		}
+		if !bytes.Equal(result.Body, testBody) {
+			t.Fatalf("%s: body = %q", algorithm, result.Body)
+		}
+	}
+
+	result, err := h.ValidateSignature(signedRequest(t, sub, "sha256", "wrong", testBody))
+	if err != nil || result.Valid {
+		t.Fatalf("wrong secret: result = %+v, err = %v, want invalid", result, err)
+	}
+
+	tampered := bytes.ToUpper(testBody)
+	req := signedRequest(t, sub, "sha256", testSecret, testBody)
+	req.Body = io.NopCloser(bytes.NewReader(tampered))
+	result, err = h.ValidateSignature(req)
+	if err != nil || result.Valid {
+		t.Fatalf("tampered body: result = %+v, err = %v, want invalid", result, err)
+	}
+}
+
+func TestValidateSignatureHeader(t *testing.T) {
+	h, _ := newTestHandler(t)
+	sub := saveSubscription(t, h, testTopic, testSecret)
+
+	signature, err := twitchhook.Sign("sha256", testSecret, testBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := signedRequest(t, sub, "sha256", testSecret, testBody)
+	req.Header.Set(twitchhook.HeaderSignature, "SHA256"+signature[len("sha256"):])
+	if !validate(t, h, req) {
+		t.Fatal("upper case algorithm didn't verify")
+	}
+
+	for _, header := range []string{"", "sha256", "sha256=", "sha256=zz", "sha256=abcd", signature + "00"} {
+		req := signedRequest(t, sub, "sha256", testSecret, testBody)
+		req.Header.Set(twitchhook.HeaderSignature, header)
+		if validate(t, h, req) {
+			t.Errorf("signature %q verified", header)
+		}
+	}
+
+	req = signedRequest(t, sub, "sha256", testSecret, testBody)
+	req.Header.Set(twitchhook.HeaderSignature, "md5=00")
+	var unsupported *twitchhook.UnsupportedAlgorithmError
+	if _, err := h.ValidateSignature(req); !errors.As(err, &unsupported) || unsupported.Algorithm != "md5" {
+		t.Fatalf("md5 signature: err = %v, want UnsupportedAlgorithmError", err)
+	}
+}
+
+func TestValidateSignatureBodies(t *testing.T) {
+	h, _ := newTestHandler(t)
+	sub := saveSubscription(t, h, testTopic, testSecret)
+
+	for _, size := range []int{0, 1, 1023, 1024, 4096, 1 << 20} {
+		body := bytes.Repeat([]byte("a"), size)
+		result, err := h.ValidateSignature(signedRequest(t, sub, "sha256", testSecret, body))
+		if err != nil || !result.Valid {
+			t.Fatalf("%d byte body: result = %+v, err = %v", size, result, err)
+		}
+		if !bytes.Equal(result.Body, body) || cap(result.Body) != len(body) {
+			t.Fatalf("%d byte body: got %d bytes with capacity %d", size, len(result.Body), cap(result.Body))
+		}
+	}
+
+	// bodies of an unknown length
+	req := signedRequest(t, sub, "sha256", testSecret, testBody)
+	req.ContentLength = -1
+	req.Body = io.NopCloser(bytes.NewReader(testBody))
+	if !validate(t, h, req) {
+		t.Fatal("body of an unknown length didn't verify")
+	}
+}
+
+func TestValidateSignatureUnknownSubscription(t *testing.T) {
+	h, _ := newTestHandler(t)
+	sub := saveSubscription(t, h, testTopic, testSecret)
+	if err := h.Manager.Delete(testTopic); err != nil {
+		t.Fatal(err)
+	}
+	_, err := h.ValidateSignature(signedRequest(t, sub, "sha256", testSecret, testBody))
+	if !errors.Is(err, twitchhook.ErrSubscriptionNotFound) {
+		t.Fatalf("err = %v, want ErrSubscriptionNotFound", err)
+	}
+}
+
+func TestValidateSignatureTooLarge(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.MaxNotificationBytes = 8
+	sub := saveSubscription(t, h, testTopic, testSecret)
+	_, err := h.ValidateSignature(signedRequest(t, sub, "sha256", testSecret, testBody))
+	if !errors.Is(err, twitchhook.ErrNotificationTooLarge) {
+		t.Fatalf("err = %v, want ErrNotificationTooLarge", err)
+	}
+}
+
+func TestSignUnknownAlgorithm(t *testing.T) {
+	if _, err := twitchhook.Sign("md5", testSecret, testBody); err == nil {
+		t.Fatal("Sign accepted md5")
+	}
+}
+
+func BenchmarkValidateSignature(b *testing.B) {
+	h, req, body := benchRequest(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body.reset()
+		result, err := h.ValidateSignature(req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if !result.Valid {
+			b.Fatal("signature didn't verify")
+		}
+	}
+}
+
+// BenchmarkCallback serves notifications without a NotificationHandler
+func BenchmarkCallback(b *testing.B) {
+	h, req, body := benchRequest(b)
+	callback := h.SubscriptionCallbackHandler()
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body.reset()
+		w.Code = http.StatusOK
+		callback.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("callback answered %d", w.Code)
+		}
+	}
+}
+
+// benchBody replays the same body for every iteration
+type benchBody struct {
+	*bytes.Reader
+	body []byte
+}
+
+func (b *benchBody) reset() {
+	b.Reset(b.body)
+}
+
+func (b *benchBody) Close() error {
+	return nil
+}
+
+// benchRequest returns a handler with a subscription and a signed stream
+// changed notification for it, whose body is reset between iterations
+func benchRequest(b *testing.B) (*twitchhook.TwitchWebhookHandler, *http.Request, *benchBody) {
+	b.Helper()
+	h := &twitchhook.TwitchWebhookHandler{Manager: &twitchhook.InMemoryCache{}}
+	target, err := loadtest.InProcess(h, testTopic)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	body := &benchBody{body: loadtest.StreamChangedBody(1)}
+	body.Reader = bytes.NewReader(body.body)
+	signature, err := twitchhook.Sign(loadtest.DefaultAlgorithm, target.Secret, body.body)
+	if err != nil {
+		b.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, target.URL, body)
+	req.ContentLength = int64(len(body.body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(twitchhook.HeaderSignature, signature)
+	req.Header.Set(twitchhook.HeaderNotificationID, "bench")
+	req.Header.Set(twitchhook.HeaderNotificationTimestamp, time.Now().UTC().Format(time.RFC3339Nano))
+	req.Header.Set("Link", "<https://api.twitch.tv/helix/webhooks/hub>; rel=\"hub\", <"+target.Topic+">; rel=\"self\"")
+	return h, req, body
+}
@@ -0,0 +1,130 @@
+package twitchhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LeaseWatchdog defaults
+const (
+	DefaultWatchdogWindow   = 10 * time.Minute
+	DefaultWatchdogInterval = time.Minute
+)
+
+// LeaseAlert describes a subscription whose lease is running out without a
+// renewal scheduled in time
+type LeaseAlert struct {
+	Subscription *Subscription
+
+	// Remaining is the time left on the lease, negative once it's expired
+	Remaining time.Duration
+
+	// RenewalAt is when the renewal is scheduled, it's zero when none is or
+	// the Manager isn't a RenewalScheduler
+	RenewalAt time.Time
+}
+
+// LeaseWatchdog periodically scans the handler's Manager for confirmed
+// subscriptions expiring within Window that have no renewal scheduled before
+// they expire, catching renewal bugs before notifications are lost. The
+// Manager must be a SubscriptionLister. When it isn't a RenewalScheduler
+// every subscription within Window is reported.
+type LeaseWatchdog struct {
+	Handler *TwitchWebhookHandler
+
+	// Window defaults to DefaultWatchdogWindow
+	Window time.Duration
+
+	// Interval between scans, defaults to DefaultWatchdogInterval
+	Interval time.Duration
+
+	// OnAlert is called for every subscription found by a scan
+	OnAlert func(alert LeaseAlert)
+}
+
+// Run scans every Interval until ctx is done
+func (w *LeaseWatchdog) Run(ctx context.Context) error {
+	m := w.Handler
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultWatchdogInterval
+	}
+
+	for {
+		_, err := w.Check()
+		if err != nil {
+			m.logger().Error("error checking subscription leases", zap.Error(err))
+		}
+
+		tick := make(chan struct{})
+		timer := clockOrDefault(m.Clock).AfterFunc(interval, func() { close(tick) })
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-tick:
+		}
+	}
+}
+
+// Check scans the Manager once, reporting and returning the subscriptions
+// at risk
+func (w *LeaseWatchdog) Check() ([]LeaseAlert, error) {
+	m := w.Handler
+	lister, ok := m.Manager.(SubscriptionLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	subs, err := lister.List()
+	if err != nil {
+		return nil, err
+	}
+
+	window := w.Window
+	if window <= 0 {
+		window = DefaultWatchdogWindow
+	}
+	scheduler, _ := m.Manager.(RenewalScheduler)
+	now := clockOrDefault(m.Clock).Now()
+
+	var alerts []LeaseAlert
+	for _, sub := range subs {
+		if sub.ExpiresAt.IsZero() {
+			continue
+		}
+		remaining := sub.ExpiresAt.Sub(now)
+		if remaining > window {
+			continue
+		}
+
+		alert := LeaseAlert{Subscription: sub, Remaining: remaining}
+		if scheduler != nil {
+			alert.RenewalAt, err = scheduler.RenewalAt(sub.Topic)
+			if err != nil && !errors.Is(err, ErrNotSupported) && !errors.Is(err, ErrSubscriptionNotFound) {
+				return nil, err
+			}
+		}
+		if remaining > 0 && !alert.RenewalAt.IsZero() && !alert.RenewalAt.After(sub.ExpiresAt) {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+
+	m.metrics().SetGauge("twitchhook_leases_at_risk", float64(len(alerts)))
+	for _, alert := range alerts {
+		m.logger().Warn("subscription lease expiring without scheduled renewal",
+			zap.String("topic", alert.Subscription.Topic),
+			zap.Duration("remaining", alert.Remaining),
+		)
+		if w.OnAlert != nil {
+			m.protect("lease alert", func() error {
+				w.OnAlert(alert)
+				return nil
+			})
+		}
+	}
+	return alerts, nil
+}
@@ -0,0 +1,227 @@
+// Package wshub pushes verified notifications to websocket clients such as
+// stream overlays and dashboards.
+package wshub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bsdlp/twitchhook"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// Hub defaults
+const (
+	DefaultClientBuffer = 32
+	DefaultPingInterval = 30 * time.Second
+	writeTimeout        = 10 * time.Second
+
+	// maxReadSize bounds frames from clients, which only send control
+	// frames
+	maxReadSize = 512
+)
+
+// Message is the json sent to clients for each notification
+type Message struct {
+	ID         string          `json:"id,omitempty"`
+	Topic      string          `json:"topic"`
+	Type       string          `json:"type,omitempty"`
+	ReceivedAt time.Time       `json:"received_at"`
+	Event      interface{}     `json:"event,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+}
+
+// Hub is a twitchhook.NotificationHandler that pushes notifications to
+// websocket clients. Clients connect to ServeHTTP and may filter with topic
+// and broadcaster query parameters, each repeatable:
+//
+//	/events?broadcaster=1234&topic=https://api.twitch.tv/helix/streams?user_id=5678
+//
+// broadcaster matches topics whose user_id, to_id, from_id or id is the
+// user id. Messages for a client whose buffer is full are dropped.
+type Hub struct {
+	// CheckOrigin validates the Origin of upgrade requests, by default only
+	// same origin requests are allowed
+	CheckOrigin func(r *http.Request) bool
+
+	// ClientBuffer is the per client queue length
+	ClientBuffer int
+
+	// PingInterval is how often clients are pinged to keep connections
+	// alive through proxies. Clients that don't answer within two intervals
+	// are disconnected
+	PingInterval time.Duration
+
+	Logger *zap.Logger
+
+	m       sync.RWMutex
+	clients map[*client]struct{}
+}
+
+type client struct {
+	topics       map[string]bool
+	broadcasters map[string]bool
+	c            chan []byte
+}
+
+// topicUserParams are the topic query parameters matched by broadcaster
+var topicUserParams = []string{"user_id", "to_id", "from_id", "id"}
+
+func (c *client) wants(topic string) bool {
+	if len(c.topics) == 0 && len(c.broadcasters) == 0 {
+		return true
+	}
+	if c.topics[topic] {
+		return true
+	}
+	if len(c.broadcasters) == 0 {
+		return false
+	}
+	u, err := url.Parse(topic)
+	if err != nil {
+		return false
+	}
+	q := u.Query()
+	for _, param := range topicUserParams {
+		if v := q.Get(param); v != "" && c.broadcasters[v] {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleNotification implements twitchhook.NotificationHandler
+func (h *Hub) HandleNotification(ctx context.Context, n *twitchhook.Notification) error {
+	msg := Message{ID: n.ID, Topic: n.Topic, ReceivedAt: n.ReceivedAt, Event: n.Event}
+	if msg.Event == nil {
+		event, err := twitchhook.DecodeEvent(n.Topic, n.Body)
+		if err != nil && err != twitchhook.ErrUnknownTopic {
+			return err
+		}
+		msg.Event = event
+	}
+	switch msg.Event.(type) {
+	case *twitchhook.StreamChanged:
+		msg.Type = "stream_changed"
+	case *twitchhook.Follows:
+		msg.Type = "follows"
+	case *twitchhook.UserChanged:
+		msg.Type = "user_changed"
+	case nil:
+		msg.Body = n.Body
+	}
+
+	bs, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	h.m.RLock()
+	defer h.m.RUnlock()
+	for c := range h.clients {
+		if !c.wants(n.Topic) {
+			continue
+		}
+		select {
+		case c.c <- bs:
+		default:
+			h.logger().Warn("dropping message for slow websocket client", zap.String("topic", n.Topic))
+		}
+	}
+	return nil
+}
+
+// ServeHTTP upgrades the request to a websocket and streams messages until
+// the client disconnects
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: h.CheckOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has responded
+		return
+	}
+	defer conn.Close()
+
+	interval := h.PingInterval
+	if interval <= 0 {
+		interval = DefaultPingInterval
+	}
+	conn.SetReadLimit(maxReadSize)
+	conn.SetReadDeadline(time.Now().Add(2 * interval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * interval))
+	})
+
+	buffer := h.ClientBuffer
+	if buffer <= 0 {
+		buffer = DefaultClientBuffer
+	}
+	q := r.URL.Query()
+	c := &client{
+		topics:       make(map[string]bool),
+		broadcasters: make(map[string]bool),
+		c:            make(chan []byte, buffer),
+	}
+	for _, topic := range q["topic"] {
+		c.topics[topic] = true
+	}
+	for _, id := range q["broadcaster"] {
+		c.broadcasters[id] = true
+	}
+
+	h.m.Lock()
+	if h.clients == nil {
+		h.clients = make(map[*client]struct{})
+	}
+	h.clients[c] = struct{}{}
+	h.m.Unlock()
+	defer func() {
+		h.m.Lock()
+		delete(h.clients, c)
+		h.m.Unlock()
+	}()
+
+	// clients don't send anything, reading handles control frames and
+	// notices disconnects, oversized frames and missed pongs
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(interval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+		case bs := <-c.c:
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err = conn.WriteMessage(websocket.TextMessage, bs)
+		}
+		if err != nil {
+			h.logger().Info("error writing to websocket client", zap.Error(err))
+			return
+		}
+	}
+}
+
+func (h *Hub) logger() *zap.Logger {
+	if h.Logger == nil {
+		return zap.NewNop()
+	}
+	return h.Logger
+}
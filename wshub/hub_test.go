@@ -0,0 +1,62 @@
+package wshub
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dial(t *testing.T, h *Hub) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServeHTTPClosesOversizedFrames(t *testing.T) {
+	conn := dial(t, &Hub{})
+	if err := conn.WriteMessage(websocket.TextMessage, make([]byte, maxReadSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("read after an oversized frame = %v, want a message too big close", err)
+	}
+}
+
+func TestServeHTTPDisconnectsWithoutPongs(t *testing.T) {
+	conn := dial(t, &Hub{PingInterval: 20 * time.Millisecond})
+	// ignore pings so no pongs are sent, and watch for the server closing
+	conn.SetPingHandler(func(string) error { return nil })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+			t.Fatal("server kept a client that doesn't answer pings")
+		}
+		return
+	}
+}
+
+func TestServeHTTPKeepsClientsAnsweringPongs(t *testing.T) {
+	h := &Hub{PingInterval: 20 * time.Millisecond}
+	conn := dial(t, h)
+	// the default ping handler answers with pongs while reading
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err := conn.ReadMessage()
+	if ne, ok := err.(interface{ Timeout() bool }); !ok || !ne.Timeout() {
+		t.Fatalf("read = %v, want the client to stay connected until its deadline", err)
+	}
+}